@@ -6,18 +6,31 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"auth/internal/auditchain"
+	"auth/internal/auth"
+	"auth/internal/clientip"
 	"auth/internal/config"
 	"auth/internal/database"
+	"auth/internal/geoip"
 	"auth/internal/handlers"
 	"auth/internal/logger"
 	"auth/internal/middleware"
+	"auth/internal/middleware/ratelimit"
+	"auth/internal/notify"
+	"auth/internal/observability/metrics"
+	"auth/internal/observability/tracing"
 	"auth/internal/repository"
+	"auth/internal/repository/memory"
 	"auth/internal/repository/postgres"
+	"auth/internal/repository/sqlite"
+	"auth/internal/risk"
 	"auth/internal/services"
 	_ "auth/docs"
+	"github.com/go-redis/redis/v8"
 	"github.com/swaggo/http-swagger"
 )
 
@@ -41,6 +54,30 @@ import (
 // @in header
 // @name Authorization
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		if err := runAudit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Audit command failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "user" {
+		if err := runUser(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "User command failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Application failed to start: %v\n", err)
 		os.Exit(1)
@@ -52,37 +89,132 @@ func run() error {
 	cfg := config.Load()
 	
 	// Initialize logger
-	log := logger.New(os.Getenv("LOG_LEVEL"))
+	log, err := logger.NewWithRedaction(os.Getenv("LOG_LEVEL"), cfg.Redaction)
+	if err != nil {
+		return fmt.Errorf("invalid log redaction configuration: %w", err)
+	}
+	logger.SetDefault(log)
 	log.Info("starting application", "version", "1.0")
 
-	// Initialize database
-	db, err := database.New(cfg, log)
+	// Install the tracer before anything can start a span, and flush the
+	// spans still buffered once everything else has stopped
+	tracer, err := tracing.New(cfg.Tracing, log)
 	if err != nil {
-		return fmt.Errorf("failed to initialize database: %w", err)
+		return fmt.Errorf("invalid tracing configuration: %w", err)
 	}
-	defer db.Close()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			log.Error("failed to flush traces", "error", err)
+		}
+	}()
 
-	// Run migrations
-	if err := db.RunMigrations(); err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+	// Initialize storage
+	repo, closeStorage, err := newRepository(cfg, log)
+	if err != nil {
+		return err
 	}
-	log.Info("database migrations completed successfully")
+	defer closeStorage()
 
-	// Initialize repositories
-	userRepo := postgres.NewUserRepository(db.DB)
-	repo := repository.New(userRepo)
+	geo, err := loadGeoIP(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	riskEngine, err := loadRiskEngine(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	// Notifications are sent in the background; wait for the ones in flight
+	// before exiting
+	notifier := newNotifier(cfg, log)
+	defer notifier.Wait()
+	mailer := newMailer(cfg, log)
+	defer mailer.Wait()
 
 	// Initialize services
-	authService := services.NewAuthService(repo, cfg, log)
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, geo, cfg, log)
+	riskService := services.NewRiskService(repo, auditService, riskEngine, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, riskService, notifier, cfg, log)
+	passwordlessService := services.NewPasswordlessService(repo, authService, auditService, mailer, cfg, log)
+	privacyService := services.NewPrivacyService(repo, cfg, log)
+	// Exports are built in the background; finish them before the storage
+	// is closed
+	defer privacyService.Wait()
+	adminService := services.NewAdminService(repo, privacyService, auditService, cfg, log)
+
+	// Browser sessions in cookies, when enabled
+	cookies, err := middleware.NewCookies(cfg.Cookie, cfg.JWT.Secret)
+	if err != nil {
+		return fmt.Errorf("invalid cookie session configuration: %w", err)
+	}
+	cors, err := middleware.NewCORS(cfg.CORS, cfg.Tenants, corsRoutes(cfg))
+	if err != nil {
+		return fmt.Errorf("invalid CORS configuration: %w", err)
+	}
+	headers, err := middleware.NewSecurityHeaders(cfg.Headers)
+	if err != nil {
+		return fmt.Errorf("invalid security headers configuration: %w", err)
+	}
+	proxies, err := clientip.New(cfg.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxy configuration: %w", err)
+	}
+	limiter, closeLimiter, err := newRateLimiter(cfg, log)
+	if err != nil {
+		return err
+	}
+	defer closeLimiter()
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService, log)
+	authHandler := handlers.NewAuthHandler(authService, cookies, cfg.Cookie)
+	privacyHandler := handlers.NewPrivacyHandler(privacyService)
+	adminHandler := handlers.NewAdminHandler(adminService)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	sessionHandler := handlers.NewSessionHandler(sessionService)
+	quotaHandler := handlers.NewQuotaHandler(limiter)
+	rateLimitHandler := handlers.NewRateLimitHandler(limiter, authService, auditService)
+	passwordlessHandler := handlers.NewPasswordlessHandler(passwordlessService, cookies, cfg.Cookie, cfg.Passwordless.TTL)
 
 	// Initialize middleware
-	mw := middleware.New(cfg, log)
+	mw := middleware.New(cfg, log, authService, sessionService, riskService, cookies, cors, proxies)
+
+	// Start background jobs. They are stopped, and their current run
+	// finished, before the storage is closed.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	defer func() {
+		stopJobs()
+		jobs.Wait()
+	}()
+	purgeService := services.NewPurgeService(repo, privacyService, sessionService, cfg.Account, log)
+	metricsService := services.NewMetricsService(repo, cfg.Metrics, log)
+	jobs.Add(2)
+	go func() {
+		defer jobs.Done()
+		purgeService.Run(jobsCtx)
+	}()
+	go func() {
+		defer jobs.Done()
+		metricsService.Run(jobsCtx)
+	}()
 
 	// Setup HTTP server
-	server := setupServer(cfg, mw, authHandler, log)
+	server := setupServer(cfg, mw, tracer, headers, limiter, authHandler, privacyHandler, adminHandler, auditHandler, sessionHandler, passwordlessHandler, quotaHandler, rateLimitHandler, log)
+
+	// Metrics get their own listener when a port is configured for them
+	metricsServer := newMetricsServer(cfg)
+	if metricsServer != nil {
+		go func() {
+			log.Info("metrics server starting", "address", metricsServer.Addr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("metrics server failed to start", "error", err)
+			}
+		}()
+	}
 
 	// Channel to listen for interrupt signal to terminate server
 	quit := make(chan os.Signal, 1)
@@ -106,6 +238,11 @@ func run() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			log.Error("metrics server forced to shutdown", "error", err)
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		log.Error("server forced to shutdown", "error", err)
 		return err
@@ -115,7 +252,226 @@ func run() error {
 	return nil
 }
 
-func setupServer(cfg *config.Config, mw *middleware.Middleware, authHandler *handlers.AuthHandler, log *logger.Logger) *http.Server {
+// newMetricsServer returns the server for /metrics when it is served on its
+// own port, or nil when it is disabled or served on the API port
+func newMetricsServer(cfg *config.Config) *http.Server {
+	if !cfg.Metrics.Enabled || cfg.Metrics.Port == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.MetricsHandler())
+	return &http.Server{
+		Addr:              ":" + cfg.Metrics.Port,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.Server.ReadTimeout,
+	}
+}
+
+// newRepository builds the repositories for the configured database driver.
+// The returned function releases the underlying connection.
+func newRepository(cfg *config.Config, log *logger.Logger) (*repository.Repository, func(), error) {
+	if cfg.Database.Driver == database.DriverMemory {
+		log.Warn("using in-memory storage, data will be lost on restart")
+		repo := memory.NewRepository()
+		if err := chainAudit(repo, cfg, log); err != nil {
+			return nil, nil, err
+		}
+		return repo, func() {}, nil
+	}
+
+	// Initialize database
+	db, err := database.New(cfg, log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	// Run migrations
+	if cfg.Database.AutoMigrate {
+		if err := db.RunMigrations(context.Background(), log); err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("failed to run migrations: %w", err)
+		}
+		log.Info("database migrations completed successfully")
+	}
+
+	// Initialize repositories
+	var repo *repository.Repository
+	switch db.Driver {
+	case database.DriverSQLite:
+		repo = sqlite.NewRepository(db.DB)
+	default:
+		repo = postgres.NewRepository(db.DB)
+	}
+	if err := chainAudit(repo, cfg, log); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return repo, func() { db.Close() }, nil
+}
+
+// chainAudit hash-chains every audit event written through repo, including
+// those written inside its transactions
+func chainAudit(repo *repository.Repository, cfg *config.Config, log *logger.Logger) error {
+	key, err := auditchain.ParsePrivateKey(cfg.Audit.SigningKey)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		log.Warn("AUDIT_SIGNING_KEY is not set, the audit log will not have signed checkpoints")
+	}
+	chain := auditchain.New(repo.Audit, key, cfg.Audit.CheckpointEvery)
+	repo.Audit = chain
+	if repo.Transactor != nil {
+		repo.Transactor = chain.Transactor(repo.Transactor)
+	}
+	return nil
+}
+
+// loadGeoIP loads the GeoIP database used to locate sessions, if one is
+// configured
+func loadGeoIP(cfg *config.Config, log *logger.Logger) (*geoip.DB, error) {
+	if cfg.Session.GeoIPDatabase == "" {
+		log.Info("GEOIP_DATABASE is not set, sessions will not show a location")
+		return nil, nil
+	}
+	geo, err := geoip.Open(cfg.Session.GeoIPDatabase)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("GeoIP database loaded", "path", cfg.Session.GeoIPDatabase, "networks", geo.Len())
+	return geo, nil
+}
+
+// loadRiskEngine builds the risk engine from the configured rules and IP
+// lists
+func loadRiskEngine(cfg *config.Config, log *logger.Logger) (*risk.Engine, error) {
+	rules, err := risk.LoadRules(cfg.Risk)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("risk rules loaded",
+		"mfa_threshold", rules.MFAThreshold,
+		"deny_threshold", rules.DenyThreshold,
+		"tor_exits", rules.TorExits.Len(),
+		"datacenter_networks", rules.Datacenters.Len(),
+	)
+	return risk.New(rules), nil
+}
+
+// newNotifier builds the notifier for the configured delivery channels.
+// Without any, notifications are only logged.
+func newNotifier(cfg *config.Config, log *logger.Logger) *notify.Async {
+	var notifiers notify.Multi
+	if cfg.Notify.SMTPHost != "" {
+		notifiers = append(notifiers, notify.NewEmail(cfg.Notify.SMTPHost, cfg.Notify.SMTPPort, cfg.Notify.SMTPUsername, cfg.Notify.SMTPPassword, cfg.Notify.EmailFrom))
+	}
+	if cfg.Notify.WebhookURL != "" {
+		notifiers = append(notifiers, notify.NewWebhook(cfg.Notify.WebhookURL, cfg.Notify.WebhookSecret))
+	}
+	if len(notifiers) == 0 {
+		log.Warn("neither NOTIFY_SMTP_HOST nor NOTIFY_WEBHOOK_URL is set, login notifications will only be logged")
+		notifiers = append(notifiers, notify.NewLog(log))
+	}
+	return notify.NewAsync(notifiers, 30*time.Second, log)
+}
+
+// newMailer builds the notifier for passwordless logins. Their messages
+// carry the link or code, so they go by email only and never to the webhook
+// or the log.
+func newMailer(cfg *config.Config, log *logger.Logger) *notify.Async {
+	var mailer notify.Notifier = notify.NewLog(log)
+	if cfg.Notify.SMTPHost != "" {
+		mailer = notify.NewEmail(cfg.Notify.SMTPHost, cfg.Notify.SMTPPort, cfg.Notify.SMTPUsername, cfg.Notify.SMTPPassword, cfg.Notify.EmailFrom)
+	} else if passwordlessEnabled(cfg) {
+		log.Warn("passwordless login is enabled without NOTIFY_SMTP_HOST, login links and codes can't be delivered")
+	}
+	return notify.NewAsync(mailer, 30*time.Second, log)
+}
+
+// passwordlessEnabled reports whether any tenant can log in without a
+// password
+func passwordlessEnabled(cfg *config.Config) bool {
+	for _, tenant := range cfg.Tenants {
+		if tenant.Passwordless {
+			return true
+		}
+	}
+	return cfg.Passwordless.Enabled
+}
+
+// corsRoutes lists what cross-origin requests may call. The pages linked
+// from emails, the docs and the health check are same-origin only.
+func corsRoutes(cfg *config.Config) []middleware.CORSRoute {
+	public := []string{"Content-Type"}
+	authenticated := []string{"Content-Type", "Authorization"}
+	if cfg.Cookie.TokenSource != config.TokenSourceHeader {
+		authenticated = append(authenticated, cfg.Cookie.CSRFHeader)
+	}
+	return []middleware.CORSRoute{
+		{Path: "/signup", Methods: []string{"POST"}, Headers: public},
+		{Path: "/login", Methods: []string{"POST"}, Headers: public},
+		{Path: "/login/mfa", Methods: []string{"POST"}, Headers: public},
+		{Path: "/login/email", Methods: []string{"POST"}, Headers: public},
+		{Path: "/login/email/verify", Methods: []string{"POST"}, Headers: public},
+		{Path: "/login/step-up", Methods: []string{"POST"}, Headers: authenticated},
+		{Path: "/logout", Methods: []string{"POST"}, Headers: authenticated},
+		{Path: "/csrf", Methods: []string{"GET"}, Headers: authenticated},
+		{Path: "/profile", Methods: []string{"GET", "DELETE"}, Headers: authenticated},
+		{Path: "/profile/", Methods: []string{"GET", "POST", "PUT", "DELETE"}, Headers: authenticated},
+		{Path: "/sessions", Methods: []string{"GET"}, Headers: authenticated},
+		{Path: "/sessions/", Methods: []string{"POST", "DELETE"}, Headers: authenticated},
+		{Path: "/quota", Methods: []string{"GET"}, Headers: authenticated},
+		{Path: "/admin/", Methods: []string{"GET", "POST", "PUT", "DELETE"}, Headers: authenticated},
+	}
+}
+
+// newRateLimiter builds the rate limiter. Limits are kept in Redis when it is
+// configured, so all instances share them, and in memory otherwise.
+func newRateLimiter(cfg *config.Config, log *logger.Logger) (*ratelimit.RateLimiter, func(), error) {
+	if !cfg.RateLimit.Enabled {
+		log.Warn("rate limiting is disabled")
+		return nil, func() {}, nil
+	}
+	limits, err := ratelimit.LoadLimits(cfg.RateLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	plans, err := ratelimit.LoadPlans(cfg.RateLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.Redis.Host == "" {
+		log.Info("REDIS_HOST is not set, rate limits are kept in memory and not shared between instances")
+		return ratelimit.New(ratelimit.NewMemoryStore(), limits, plans, requestPrincipal, log), func() {}, nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Host + ":" + cfg.Redis.Port,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		// Each limit's fail policy decides what happens until it is back
+		log.Warn("failed to reach Redis, rate limits can't be checked until it is available", "error", err)
+	}
+	return ratelimit.New(ratelimit.NewRedisStore(client), limits, plans, requestPrincipal, log), func() { client.Close() }, nil
+}
+
+// requestPrincipal identifies authenticated requests to the rate limiter by
+// the user their token was issued to
+func requestPrincipal(r *http.Request) (ratelimit.Principal, bool) {
+	claims, ok := r.Context().Value(middleware.ClaimsKey).(*auth.Claims)
+	if !ok {
+		return ratelimit.Principal{}, false
+	}
+	return ratelimit.Principal{ID: ratelimit.UserPrincipal(claims.UserID), Plan: claims.Plan}, true
+}
+
+func setupServer(cfg *config.Config, mw *middleware.Middleware, tracer *tracing.Tracer, headers *middleware.SecurityHeaders, limiter *ratelimit.RateLimiter, authHandler *handlers.AuthHandler, privacyHandler *handlers.PrivacyHandler, adminHandler *handlers.AdminHandler, auditHandler *handlers.AuditHandler, sessionHandler *handlers.SessionHandler, passwordlessHandler *handlers.PasswordlessHandler, quotaHandler *handlers.QuotaHandler, rateLimitHandler *handlers.RateLimitHandler, log *logger.Logger) *http.Server {
 	mux := http.NewServeMux()
 
 	// Health check endpoint
@@ -125,17 +481,90 @@ func setupServer(cfg *config.Config, mw *middleware.Middleware, authHandler *han
 		w.Write([]byte(`{"status":"healthy","timestamp":"` + time.Now().UTC().Format(time.RFC3339) + `"}`))
 	})
 
+	// Anonymous routes are limited by client address. Authenticated routes
+	// are limited after authentication, by user and their plan, so users
+	// behind a shared address don't limit each other.
+	limit := func(limitType string, handler http.Handler) http.Handler {
+		return limiter.Middleware(limitType)(handler)
+	}
+
 	// API routes
-	mux.HandleFunc("/signup", authHandler.SignUp)
-	mux.HandleFunc("/login", authHandler.Login)
+	mux.Handle("/signup", limit("signup", http.HandlerFunc(authHandler.SignUp)))
+	mux.Handle("/login", limit("login", http.HandlerFunc(authHandler.Login)))
+	mux.Handle("GET /login/report", headers.HTML(http.HandlerFunc(authHandler.ReportLoginPage)))
+	mux.Handle("POST /login/report", headers.HTML(limit("auth", http.HandlerFunc(authHandler.ReportLogin))))
+	mux.Handle("POST /login/mfa", limit("auth", http.HandlerFunc(authHandler.CompleteMFA)))
+	mux.Handle("POST /login/email", limit("auth", http.HandlerFunc(passwordlessHandler.RequestEmailLogin)))
+	mux.Handle("POST /login/email/verify", limit("auth", http.HandlerFunc(passwordlessHandler.VerifyEmailLogin)))
+	mux.Handle("POST /login/step-up", mw.JWT(limit("auth", http.HandlerFunc(authHandler.StepUp))))
+	mux.Handle("POST /logout", mw.JWT(limit("api", http.HandlerFunc(authHandler.Logout))))
+	mux.Handle("GET /csrf", mw.JWT(limit("api", http.HandlerFunc(authHandler.CSRFToken))))
 	
-	// Protected routes
+	// Protected routes. Sensitive operations need recent authentication.
+	stepUp := func(operation string, handler http.HandlerFunc) http.Handler {
+		return mw.RequireStepUp(operation)(handler)
+	}
 	protectedMux := http.NewServeMux()
-	protectedMux.HandleFunc("/profile", authHandler.GetProfile)
-	mux.Handle("/profile", mw.JWT(protectedMux))
+	protectedMux.HandleFunc("GET /profile", authHandler.GetProfile)
+	protectedMux.Handle("DELETE /profile", stepUp(risk.OperationAccountDelete, authHandler.DeleteAccount))
+	protectedMux.HandleFunc("POST /profile/restore", authHandler.RestoreAccount)
+	protectedMux.HandleFunc("POST /profile/export", privacyHandler.RequestExport)
+	protectedMux.HandleFunc("GET /profile/export", privacyHandler.ListExports)
+	protectedMux.HandleFunc("GET /profile/export/{id}", privacyHandler.GetExport)
+	protectedMux.HandleFunc("GET /profile/export/{id}/download", privacyHandler.DownloadExport)
+	protectedMux.Handle("PUT /profile/password", stepUp(risk.OperationPasswordChange, authHandler.ChangePassword))
+	protectedMux.HandleFunc("POST /profile/mfa/totp", authHandler.EnrollTOTP)
+	protectedMux.HandleFunc("POST /profile/mfa/totp/confirm", authHandler.ConfirmTOTP)
+	protectedMux.Handle("DELETE /profile/mfa/totp", stepUp(risk.OperationMFADisable, authHandler.DisableTOTP))
+	mux.Handle("/profile", mw.JWT(limit("profile", metrics.Routes(protectedMux))))
+	mux.Handle("/profile/", mw.JWT(limit("profile", metrics.Routes(protectedMux))))
+
+	// Session routes
+	sessionMux := http.NewServeMux()
+	sessionMux.HandleFunc("GET /sessions", sessionHandler.ListSessions)
+	sessionMux.HandleFunc("DELETE /sessions/{id}", sessionHandler.RevokeSession)
+	sessionMux.HandleFunc("POST /sessions/revoke-others", sessionHandler.RevokeOtherSessions)
+	mux.Handle("/sessions", mw.JWT(limit("api", metrics.Routes(sessionMux))))
+	mux.Handle("/sessions/", mw.JWT(limit("api", metrics.Routes(sessionMux))))
+
+	// Quota route
+	mux.Handle("GET /quota", mw.JWT(limit("api", http.HandlerFunc(quotaHandler.GetQuota))))
+
+	// Admin routes
+	canRead := mw.RequirePermission(auth.PermUsersRead)
+	canWrite := mw.RequirePermission(auth.PermUsersWrite)
+	canErase := mw.RequirePermission(auth.PermUsersErase)
+	canAudit := mw.RequirePermission(auth.PermAuditRead)
+	canReadLimits := mw.RequirePermission(auth.PermRateLimitRead)
+	canWriteLimits := mw.RequirePermission(auth.PermRateLimitWrite)
+	adminMux := http.NewServeMux()
+	adminMux.Handle("GET /admin/users", canRead(http.HandlerFunc(adminHandler.ListUsers)))
+	adminMux.Handle("GET /admin/users/{id}", canRead(http.HandlerFunc(adminHandler.GetUser)))
+	adminMux.Handle("POST /admin/users/{id}/disable", canWrite(http.HandlerFunc(adminHandler.DisableUser)))
+	adminMux.Handle("POST /admin/users/{id}/enable", canWrite(http.HandlerFunc(adminHandler.EnableUser)))
+	adminMux.Handle("POST /admin/users/{id}/password-reset", canWrite(http.HandlerFunc(adminHandler.ForcePasswordReset)))
+	adminMux.Handle("PUT /admin/users/{id}/role", canWrite(http.HandlerFunc(adminHandler.SetUserRole)))
+	adminMux.Handle("PUT /admin/users/{id}/plan", canWrite(http.HandlerFunc(adminHandler.SetUserPlan)))
+	adminMux.Handle("DELETE /admin/users/{id}", canWrite(http.HandlerFunc(adminHandler.DeleteUser)))
+	adminMux.Handle("POST /admin/users/{id}/erase", canErase(http.HandlerFunc(adminHandler.EraseUser)))
+	adminMux.Handle("GET /admin/users/{id}/tombstone", canRead(http.HandlerFunc(adminHandler.GetTombstone)))
+	adminMux.Handle("GET /admin/audit", canAudit(http.HandlerFunc(auditHandler.ListEvents)))
+	adminMux.Handle("GET /admin/audit/export", canAudit(http.HandlerFunc(auditHandler.ExportEvents)))
+	adminMux.Handle("GET /admin/ratelimit", canReadLimits(http.HandlerFunc(rateLimitHandler.GetStats)))
+	adminMux.Handle("GET /admin/ratelimit/clients", canReadLimits(http.HandlerFunc(rateLimitHandler.InspectClient)))
+	adminMux.Handle("DELETE /admin/ratelimit/clients", canWriteLimits(http.HandlerFunc(rateLimitHandler.ResetClient)))
+	adminMux.Handle("GET /admin/ratelimit/rules", canReadLimits(http.HandlerFunc(rateLimitHandler.ListRules)))
+	adminMux.Handle("PUT /admin/ratelimit/rules", canWriteLimits(http.HandlerFunc(rateLimitHandler.SetRule)))
+	adminMux.Handle("DELETE /admin/ratelimit/rules", canWriteLimits(http.HandlerFunc(rateLimitHandler.DeleteRule)))
+	mux.Handle("/admin/", mw.JWT(limit("api", metrics.Routes(adminMux))))
+
+	// Prometheus metrics, unless they have their own port
+	if cfg.Metrics.Enabled && cfg.Metrics.Port == "" {
+		mux.Handle("GET /metrics", metrics.MetricsHandler())
+	}
 
 	// Swagger documentation
-	mux.Handle("/swagger/", httpSwagger.WrapHandler)
+	mux.Handle("/swagger/", headers.HTML(handlers.SwaggerUI(httpSwagger.WrapHandler)))
 
 	// Root endpoint
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -148,14 +577,22 @@ func setupServer(cfg *config.Config, mw *middleware.Middleware, authHandler *han
 		w.Write([]byte(`{"message":"User Auth API is running","version":"1.0"}`))
 	})
 
-	// Apply middleware chain
-	handler := mw.Recovery(
+	// Apply middleware chain. RequestID runs first so that every other
+	// middleware can read the request ID. Metrics and tracing wrap Recovery
+	// so that requests that panic are counted and traced with the status
+	// they failed with.
+	var handler http.Handler = mw.Recovery(
 		mw.Logging(
-			mw.RequestID(
-				mw.CORS(mux),
+			headers.API(
+				mw.CORS(metrics.Routes(mux)),
 			),
 		),
 	)
+	handler = tracer.HTTPMiddleware()(handler)
+	if cfg.Metrics.Enabled {
+		handler = metrics.HTTPMiddleware(handler)
+	}
+	handler = mw.RequestID(handler)
 
 	return &http.Server{
 		Addr:         ":" + cfg.Server.Port,
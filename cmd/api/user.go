@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"auth/internal/services"
+)
+
+const userUsage = `usage: user <command>
+
+commands:
+  set-role USERNAME ROLE    assign a role (user or admin) to an existing user
+  purge                     run the account deletion purge job once`
+
+// runUser implements the "user" subcommand for account maintenance, such as
+// bootstrapping the first administrator before the admin API can be reached
+func runUser(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", userUsage)
+	}
+
+	cfg := config.Load()
+	log := logger.New(os.Getenv("LOG_LEVEL"))
+
+	if cfg.Database.Driver == database.DriverMemory {
+		return fmt.Errorf("the memory driver does not persist users")
+	}
+
+	switch args[0] {
+	case "purge":
+		repo, closeStorage, err := newRepository(cfg, log)
+		if err != nil {
+			return err
+		}
+		defer closeStorage()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		privacy := services.NewPrivacyService(repo, cfg, log)
+		sessions := services.NewSessionService(repo, services.NewAuditService(repo, cfg, log), nil, cfg, log)
+		result, err := services.NewPurgeService(repo, privacy, sessions, cfg.Account, log).PurgeOnce(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %d accounts, purged %d accounts, removed %d expired exports and %d ended sessions\n",
+			result.Deleted, result.Purged, result.Exports, result.Sessions)
+		return nil
+	case "set-role":
+		if len(args) != 3 {
+			return fmt.Errorf("expected USERNAME and ROLE\n%s", userUsage)
+		}
+		username, role := args[1], args[2]
+		if !auth.ValidRole(role) {
+			return fmt.Errorf("invalid role %q", role)
+		}
+
+		repo, closeStorage, err := newRepository(cfg, log)
+		if err != nil {
+			return err
+		}
+		defer closeStorage()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		var userID, previousRole string
+		err = repo.WithTx(ctx, func(tx *repository.Repository) error {
+			user, err := tx.User.GetByUsername(ctx, username)
+			if err != nil {
+				return fmt.Errorf("failed to find user %q: %w", username, err)
+			}
+			userID, previousRole = user.ID, user.Role
+			user.Role = role
+			return tx.User.Update(ctx, user)
+		})
+		if userID != "" {
+			services.NewAuditService(repo, cfg, log).Record(ctx, models.AuditEvent{
+				ActorID:  "system",
+				Action:   models.AuditActionUserRoleChange,
+				TargetID: userID,
+				Details:  map[string]string{"role": role, "previous_role": previousRole, "source": "cli"},
+			}, err)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], userUsage)
+	}
+}
@@ -0,0 +1,250 @@
+// Package auditchain makes an audit log tamper-evident. Chain decorates any
+// repository.AuditRepository: every appended event stores the hash of the
+// event before it, and a signed checkpoint of the chain is appended at a
+// fixed interval. Verify walks the chain and reports the first broken link.
+package auditchain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+	"github.com/google/uuid"
+)
+
+// maxAppendAttempts bounds how often Append retries after another writer
+// extended the chain first
+const maxAppendAttempts = 5
+
+// Chain is an AuditRepository that links every appended event to the one
+// before it. Writers in other processes may share the underlying storage:
+// the storage rejects a second event with the same Seq, and Chain retries on
+// top of the new head.
+type Chain struct {
+	inner repository.AuditRepository
+	key   ed25519.PrivateKey
+	every int64
+	// inTx is set on the chain of a transaction, whose appends are not
+	// retried in place: after a conflict the transaction must start over
+	inTx bool
+
+	mu sync.Mutex
+	// head is the last event this Chain knows about, or nil if it must be
+	// reloaded from storage
+	head *models.AuditEvent
+}
+
+// New returns a Chain that stores events in inner. If key is not nil, a
+// checkpoint signed with it is appended after every checkpointEvery events.
+func New(inner repository.AuditRepository, key ed25519.PrivateKey, checkpointEvery int) *Chain {
+	return &Chain{
+		inner: inner,
+		key:   key,
+		every: int64(checkpointEvery),
+	}
+}
+
+// Append sets the event's Seq, PrevHash and Hash and stores it. OccurredAt is
+// rounded to microseconds, the precision every storage keeps, so the hash
+// still matches after a round trip.
+func (c *Chain) Append(ctx context.Context, event *models.AuditEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	event.OccurredAt = event.OccurredAt.UTC().Truncate(time.Microsecond)
+	if err := c.link(ctx, event, event.Tenant); err != nil {
+		return err
+	}
+	if err := c.link(ctx, nil, event.Tenant); err != nil {
+		return fmt.Errorf("failed to write audit checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Transactor wraps inner so the audit repository of each transaction it
+// runs is chained too. Events appended through it are linked to the chain,
+// and committed or rolled back with the rest of the transaction.
+func (c *Chain) Transactor(inner repository.Transactor) repository.Transactor {
+	return &chainTransactor{chain: c, inner: inner}
+}
+
+type chainTransactor struct {
+	chain *Chain
+	inner repository.Transactor
+}
+
+func (t *chainTransactor) WithTx(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	// The transaction may have moved the head this Chain knows about
+	defer t.chain.forgetHead()
+	return t.inner.WithTx(ctx, func(tx *repository.Repository) error {
+		tx.Audit = &Chain{inner: tx.Audit, key: t.chain.key, every: t.chain.every, inTx: true}
+		return fn(tx)
+	})
+}
+
+func (c *Chain) forgetHead() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.head = nil
+}
+
+func (c *Chain) List(ctx context.Context, filter repository.AuditFilter) (*repository.AuditPage, error) {
+	return c.inner.List(ctx, filter)
+}
+
+func (c *Chain) Head(ctx context.Context) (*models.AuditEvent, error) {
+	return c.inner.Head(ctx)
+}
+
+func (c *Chain) ListBySeq(ctx context.Context, afterSeq int64, limit int) ([]*models.AuditEvent, error) {
+	return c.inner.ListBySeq(ctx, afterSeq, limit)
+}
+
+// link appends event on top of the current head, or only the checkpoint of
+// the head if event is nil. A checkpoint that is due is always written first,
+// even one another writer left behind, so every checkpoint directly follows
+// the event it signs. Appends are retried on top of the new head if another
+// writer got there first.
+func (c *Chain) link(ctx context.Context, event *models.AuditEvent, tenant string) error {
+	for conflicts := 0; ; {
+		head, err := c.loadHead(ctx)
+		if err != nil {
+			return err
+		}
+
+		next := event
+		if c.key != nil && checkpointDue(head, c.every) {
+			next = newCheckpoint(head, tenant, c.key)
+		} else if event == nil {
+			return nil
+		}
+		next.Seq, next.PrevHash = 1, ""
+		if head != nil {
+			next.Seq, next.PrevHash = head.Seq+1, head.Hash
+		}
+		next.Hash = Hash(next)
+
+		err = c.inner.Append(ctx, next)
+		if err == nil {
+			c.head = next
+			if next == event {
+				return nil
+			}
+			continue
+		}
+		c.head = nil
+		if conflicts++; !errors.Is(err, repository.ErrConflict) || conflicts == maxAppendAttempts || c.inTx {
+			return err
+		}
+	}
+}
+
+// checkpointDue reports whether head must be followed by a checkpoint: it is
+// an event, not a checkpoint, whose Seq is a multiple of every
+func checkpointDue(head *models.AuditEvent, every int64) bool {
+	return head != nil && every > 0 && head.Action != models.AuditActionCheckpoint && head.Seq%every == 0
+}
+
+func (c *Chain) loadHead(ctx context.Context) (*models.AuditEvent, error) {
+	if c.head != nil {
+		return c.head, nil
+	}
+	head, err := c.inner.Head(ctx)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit chain head: %w", err)
+	}
+	c.head = head
+	return head, nil
+}
+
+// canonicalEvent fixes the field order and formats hashed by Hash
+type canonicalEvent struct {
+	Seq        int64             `json:"seq"`
+	PrevHash   string            `json:"prev_hash"`
+	ID         string            `json:"id"`
+	OccurredAt string            `json:"occurred_at"`
+	Tenant     string            `json:"tenant"`
+	ActorID    string            `json:"actor_id"`
+	Action     string            `json:"action"`
+	TargetID   string            `json:"target_id"`
+	Outcome    string            `json:"outcome"`
+	Reason     string            `json:"reason"`
+	IP         string            `json:"ip"`
+	UserAgent  string            `json:"user_agent"`
+	RequestID  string            `json:"request_id"`
+	Details    map[string]string `json:"details"`
+}
+
+// Hash returns the hex SHA-256 of the event's canonical encoding, which
+// covers every field except Hash itself
+func Hash(event *models.AuditEvent) string {
+	canonical := canonicalEvent{
+		Seq:        event.Seq,
+		PrevHash:   event.PrevHash,
+		ID:         event.ID,
+		OccurredAt: event.OccurredAt.UTC().Format(time.RFC3339Nano),
+		Tenant:     event.Tenant,
+		ActorID:    event.ActorID,
+		Action:     event.Action,
+		TargetID:   event.TargetID,
+		Outcome:    event.Outcome,
+		Reason:     event.Reason,
+		IP:         event.IP,
+		UserAgent:  event.UserAgent,
+		RequestID:  event.RequestID,
+	}
+	// Storage may return no details as nil or as an empty map
+	if len(event.Details) > 0 {
+		canonical.Details = event.Details
+	}
+
+	// Marshalling strings and a string map can't fail, and map keys are
+	// encoded in sorted order
+	data, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newCheckpoint returns a checkpoint event signing the chain up to head
+func newCheckpoint(head *models.AuditEvent, tenant string, key ed25519.PrivateKey) *models.AuditEvent {
+	signature := ed25519.Sign(key, checkpointMessage(head.Seq, head.Hash))
+	return &models.AuditEvent{
+		ID:         uuid.New().String(),
+		OccurredAt: time.Now().UTC().Truncate(time.Microsecond),
+		Tenant:     tenant,
+		ActorID:    "system",
+		Action:     models.AuditActionCheckpoint,
+		Outcome:    models.AuditOutcomeSuccess,
+		Details: map[string]string{
+			"seq":       strconv.FormatInt(head.Seq, 10),
+			"hash":      head.Hash,
+			"key_id":    KeyID(key.Public().(ed25519.PublicKey)),
+			"signature": base64.StdEncoding.EncodeToString(signature),
+		},
+	}
+}
+
+// checkpointMessage is the data a checkpoint signature covers
+func checkpointMessage(seq int64, hash string) []byte {
+	return []byte("audit-checkpoint/v1\n" + strconv.FormatInt(seq, 10) + "\n" + hash)
+}
+
+// KeyID returns a short fingerprint of a public key, recorded on checkpoints
+// so auditors can tell which key to verify them with
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
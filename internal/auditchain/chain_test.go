@@ -0,0 +1,395 @@
+package auditchain_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/auditchain"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"github.com/google/uuid"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() unexpected error: %v", err)
+	}
+	return public, private
+}
+
+func newEvent(action string) *models.AuditEvent {
+	return &models.AuditEvent{
+		ID:         uuid.New().String(),
+		OccurredAt: time.Now(),
+		Tenant:     "default",
+		ActorID:    uuid.New().String(),
+		Action:     action,
+		Outcome:    models.AuditOutcomeSuccess,
+		Details:    map[string]string{"source": "test"},
+	}
+}
+
+func appendEvents(t *testing.T, chain *auditchain.Chain, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := chain.Append(context.Background(), newEvent(models.AuditActionLogin)); err != nil {
+			t.Fatalf("Append() unexpected error: %v", err)
+		}
+	}
+}
+
+func TestChain_AppendAndVerify(t *testing.T) {
+	public, private := newKey(t)
+	inner := memory.NewRepository().Audit
+	chain := auditchain.New(inner, private, 3)
+	ctx := context.Background()
+
+	// Events written before chaining are skipped
+	if err := inner.Append(ctx, newEvent(models.AuditActionSignup)); err != nil {
+		t.Fatalf("Append() unexpected error: %v", err)
+	}
+	appendEvents(t, chain, 7)
+
+	report, err := auditchain.Verify(ctx, chain, public, 3)
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if report.Broken != nil {
+		t.Fatalf("Verify() broken = %v", report.Broken)
+	}
+	// Checkpoints follow seq 3, 6 and 9
+	if report.Events != 10 || report.Checkpoints != 3 || report.LastSeq != 10 || report.SignedSeq != 9 || report.Unsigned != 0 {
+		t.Errorf("Verify() = %+v, want 10 events and 3 checkpoints", report)
+	}
+
+	events, err := chain.ListBySeq(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("ListBySeq() unexpected error: %v", err)
+	}
+	checkpoint := events[3]
+	if checkpoint.Action != models.AuditActionCheckpoint || checkpoint.Details["seq"] != "3" ||
+		checkpoint.Details["hash"] != events[2].Hash || checkpoint.Details["key_id"] != auditchain.KeyID(public) {
+		t.Errorf("checkpoint = %+v", checkpoint)
+	}
+}
+
+func TestChain_NoSigningKey(t *testing.T) {
+	chain := auditchain.New(memory.NewRepository().Audit, nil, 2)
+	appendEvents(t, chain, 4)
+
+	report, err := auditchain.Verify(context.Background(), chain, nil, 0)
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if report.Broken != nil || report.Events != 4 || report.Checkpoints != 0 {
+		t.Errorf("Verify() = %+v, want 4 events and no checkpoints", report)
+	}
+}
+
+func TestChain_ConcurrentWriters(t *testing.T) {
+	public, private := newKey(t)
+	inner := memory.NewRepository().Audit
+	// Two chains over the same storage stand in for two API instances
+	chains := []*auditchain.Chain{
+		auditchain.New(inner, private, 5),
+		auditchain.New(inner, private, 5),
+	}
+
+	var wg sync.WaitGroup
+	for _, chain := range chains {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := chain.Append(context.Background(), newEvent(models.AuditActionLogin)); err != nil {
+					t.Errorf("Append() unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every checkpoint directly follows the event it signs, whichever
+	// writer wrote it
+	report, err := auditchain.Verify(context.Background(), inner, public, 5)
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if report.Broken != nil {
+		t.Fatalf("Verify() broken = %v", report.Broken)
+	}
+	if report.Events-report.Checkpoints != 20 {
+		t.Errorf("Verify() = %+v, want 20 events besides checkpoints", report)
+	}
+}
+
+func TestChain_Transactions(t *testing.T) {
+	public, private := newKey(t)
+	repo := memory.NewRepository()
+	chain := auditchain.New(repo.Audit, private, 2)
+	repo.Audit = chain
+	repo.Transactor = chain.Transactor(repo.Transactor)
+	ctx := context.Background()
+
+	appendEvents(t, chain, 1)
+	err := repo.WithTx(ctx, func(tx *repository.Repository) error {
+		return tx.Audit.Append(ctx, newEvent(models.AuditActionLogin))
+	})
+	if err != nil {
+		t.Fatalf("WithTx() unexpected error: %v", err)
+	}
+
+	// A rolled back event leaves no gap in the chain
+	rollback := errors.New("rollback")
+	err = repo.WithTx(ctx, func(tx *repository.Repository) error {
+		if err := tx.Audit.Append(ctx, newEvent(models.AuditActionLogin)); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("WithTx() error = %v, want the rollback", err)
+	}
+
+	// The chain outside the transactions continues from their head
+	appendEvents(t, chain, 1)
+
+	report, err := auditchain.Verify(ctx, chain, public, 2)
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if report.Broken != nil {
+		t.Fatalf("Verify() broken = %v", report.Broken)
+	}
+	// Seq 1 and 2, the checkpoint of 2 at 3, then 4 and its checkpoint
+	if report.Events != 5 || report.Checkpoints != 2 || report.LastSeq != 5 {
+		t.Errorf("Verify() = %+v, want 3 events and 2 checkpoints", report)
+	}
+}
+
+func TestChain_WritesDueCheckpointFirst(t *testing.T) {
+	public, private := newKey(t)
+	inner := memory.NewRepository().Audit
+	appendEvents(t, auditchain.New(inner, private, 2), 1)
+
+	// A writer that stopped between seq 2 and its checkpoint
+	head, err := inner.Head(context.Background())
+	if err != nil {
+		t.Fatalf("Head() unexpected error: %v", err)
+	}
+	event := newEvent(models.AuditActionLogin)
+	event.Seq, event.PrevHash = head.Seq+1, head.Hash
+	event.Hash = auditchain.Hash(event)
+	mustAppend(t, inner, event)
+
+	appendEvents(t, auditchain.New(inner, private, 2), 1)
+
+	report, err := auditchain.Verify(context.Background(), inner, public, 2)
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if report.Broken != nil {
+		t.Fatalf("Verify() broken = %v", report.Broken)
+	}
+	// Seq 3 is the checkpoint of 2, then the new event at 4 and its own
+	// checkpoint
+	if report.Checkpoints != 2 || report.LastSeq != 5 {
+		t.Errorf("Verify() = %+v, want a checkpoint before the new event", report)
+	}
+}
+
+func TestVerify_RequiresCheckpoints(t *testing.T) {
+	public, _ := newKey(t)
+	// A chain rewritten without checkpoints, which needs no key
+	chain := auditchain.New(memory.NewRepository().Audit, nil, 2)
+	appendEvents(t, chain, 3)
+
+	report, err := auditchain.Verify(context.Background(), chain, public, 2)
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if report.Broken == nil || report.Broken.Seq != 3 || !strings.Contains(report.Broken.Reason, "checkpoint of seq 2") {
+		t.Errorf("Verify() broken = %v, want the checkpoint of seq 2 missing", report.Broken)
+	}
+}
+
+func TestVerify_UnsignedTail(t *testing.T) {
+	public, private := newKey(t)
+	chain := auditchain.New(memory.NewRepository().Audit, private, 3)
+	// Seq 1 to 3, the checkpoint at 4, then 5
+	appendEvents(t, chain, 4)
+
+	report, err := auditchain.Verify(context.Background(), chain, public, 3)
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if report.Broken != nil {
+		t.Fatalf("Verify() broken = %v", report.Broken)
+	}
+	if report.SignedSeq != 3 || report.Unsigned != 1 || report.LastSeq != 5 {
+		t.Errorf("Verify() = %+v, want seq 5 unsigned after seq 3", report)
+	}
+}
+
+func TestVerify_Tampering(t *testing.T) {
+	public, private := newKey(t)
+	otherPublic, _ := newKey(t)
+
+	tests := []struct {
+		name string
+		// tamper appends to the storage behind the chain's back
+		tamper    func(t *testing.T, inner repository.AuditRepository, head *models.AuditEvent)
+		verifyKey ed25519.PublicKey
+		wantSeq   int64
+		wantErr   string
+	}{
+		{
+			name: "edited event",
+			tamper: func(t *testing.T, inner repository.AuditRepository, head *models.AuditEvent) {
+				forged := newEvent(models.AuditActionLogin)
+				forged.Seq, forged.PrevHash = head.Seq+1, head.Hash
+				forged.Hash = auditchain.Hash(forged)
+				forged.Outcome = models.AuditOutcomeFailure
+				mustAppend(t, inner, forged)
+			},
+			verifyKey: public,
+			wantSeq:   4,
+			wantErr:   "hash does not match",
+		},
+		{
+			name: "wrong previous hash",
+			tamper: func(t *testing.T, inner repository.AuditRepository, head *models.AuditEvent) {
+				forged := newEvent(models.AuditActionLogin)
+				forged.Seq, forged.PrevHash = head.Seq+1, strings.Repeat("0", 64)
+				forged.Hash = auditchain.Hash(forged)
+				mustAppend(t, inner, forged)
+			},
+			verifyKey: public,
+			wantSeq:   4,
+			wantErr:   "previous hash",
+		},
+		{
+			name: "missing events",
+			tamper: func(t *testing.T, inner repository.AuditRepository, head *models.AuditEvent) {
+				forged := newEvent(models.AuditActionLogin)
+				forged.Seq, forged.PrevHash = head.Seq+2, head.Hash
+				forged.Hash = auditchain.Hash(forged)
+				mustAppend(t, inner, forged)
+			},
+			verifyKey: public,
+			wantSeq:   5,
+			wantErr:   "expected seq 4",
+		},
+		{
+			name: "forged checkpoint",
+			tamper: func(t *testing.T, inner repository.AuditRepository, head *models.AuditEvent) {
+				checkpoint := newEvent(models.AuditActionCheckpoint)
+				checkpoint.Details = map[string]string{
+					"seq":       "3",
+					"hash":      head.Hash,
+					"key_id":    auditchain.KeyID(public),
+					"signature": "c2lnbmF0dXJl",
+				}
+				checkpoint.Seq, checkpoint.PrevHash = head.Seq+1, head.Hash
+				checkpoint.Hash = auditchain.Hash(checkpoint)
+				mustAppend(t, inner, checkpoint)
+			},
+			verifyKey: public,
+			wantSeq:   4,
+			wantErr:   "signature is invalid",
+		},
+		{
+			name: "missing checkpoint",
+			tamper: func(t *testing.T, inner repository.AuditRepository, head *models.AuditEvent) {
+				// Seq 4 is due a checkpoint, but 5 is another event
+				for i := 0; i < 2; i++ {
+					forged := newEvent(models.AuditActionLogin)
+					forged.Seq, forged.PrevHash = head.Seq+1, head.Hash
+					forged.Hash = auditchain.Hash(forged)
+					mustAppend(t, inner, forged)
+					head = forged
+				}
+			},
+			verifyKey: public,
+			wantSeq:   5,
+			wantErr:   "expected the checkpoint of seq 4",
+		},
+		{
+			name:      "wrong verify key",
+			tamper:    func(*testing.T, repository.AuditRepository, *models.AuditEvent) {},
+			verifyKey: otherPublic,
+			wantSeq:   3,
+			wantErr:   "signed with key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := memory.NewRepository().Audit
+			chain := auditchain.New(inner, private, 2)
+			// Seq 1 and 2 followed by a checkpoint at 3
+			appendEvents(t, chain, 2)
+
+			head, err := inner.Head(context.Background())
+			if err != nil {
+				t.Fatalf("Head() unexpected error: %v", err)
+			}
+			tt.tamper(t, inner, head)
+
+			report, err := auditchain.Verify(context.Background(), inner, tt.verifyKey, 2)
+			if err != nil {
+				t.Fatalf("Verify() unexpected error: %v", err)
+			}
+			if report.Broken == nil {
+				t.Fatal("Verify() found no broken link")
+			}
+			if report.Broken.Seq != tt.wantSeq || !strings.Contains(report.Broken.Reason, tt.wantErr) {
+				t.Errorf("Verify() broken = %v, want seq %d: %s", report.Broken, tt.wantSeq, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	private, public, err := auditchain.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() unexpected error: %v", err)
+	}
+	signingKey, err := auditchain.ParsePrivateKey(private)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey() unexpected error: %v", err)
+	}
+	verifyKey, err := auditchain.ParsePublicKey(public)
+	if err != nil {
+		t.Fatalf("ParsePublicKey() unexpected error: %v", err)
+	}
+	if !verifyKey.Equal(signingKey.Public()) {
+		t.Error("parsed public key does not match the signing key")
+	}
+
+	if key, err := auditchain.ParsePrivateKey(""); key != nil || err != nil {
+		t.Errorf("ParsePrivateKey(\"\") = %v, %v, want nil, nil", key, err)
+	}
+	if _, err := auditchain.ParsePrivateKey("c2hvcnQ="); err == nil {
+		t.Error("ParsePrivateKey() accepted a short key")
+	}
+	if _, err := auditchain.ParsePublicKey("not base64!"); err == nil {
+		t.Error("ParsePublicKey() accepted invalid base64")
+	}
+}
+
+func mustAppend(t *testing.T, repo repository.AuditRepository, event *models.AuditEvent) {
+	t.Helper()
+	if err := repo.Append(context.Background(), event); err != nil {
+		t.Fatalf("Append() unexpected error: %v", err)
+	}
+}
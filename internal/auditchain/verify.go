@@ -0,0 +1,127 @@
+package auditchain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+// verifyBatchSize is the number of events Verify reads at a time
+const verifyBatchSize = 500
+
+// Report is the result of walking the chain
+type Report struct {
+	// Events is the number of chained events that were checked
+	Events int64
+	// Checkpoints is the number of checkpoints that were checked
+	Checkpoints int64
+	// LastSeq is the Seq of the last event that passed verification
+	LastSeq int64
+	// SignedSeq is the Seq of the last event a checkpoint covers, or 0 if
+	// there is none
+	SignedSeq int64
+	// Unsigned is the number of events after the last checkpoint, which no
+	// checkpoint covers yet
+	Unsigned int64
+	// Broken is the first link that failed verification, or nil if the
+	// whole chain is intact
+	Broken *Break
+}
+
+// Break describes an event that failed verification
+type Break struct {
+	Seq     int64  `json:"seq"`
+	EventID string `json:"event_id"`
+	Reason  string `json:"reason"`
+}
+
+func (b *Break) Error() string {
+	return fmt.Sprintf("audit chain broken at seq %d (event %s): %s", b.Seq, b.EventID, b.Reason)
+}
+
+// Verify walks the chain in Seq order and stops at the first broken link.
+// Checkpoint signatures are checked with publicKey; when it is nil,
+// checkpoints are only checked against the chain. If checkpointEvery is
+// positive, the chain must have been written with that interval: an event
+// whose Seq is a multiple of it must be followed by its checkpoint, so
+// removing checkpoints breaks the chain. Events written before chaining was
+// enabled are not part of the chain and are skipped.
+func Verify(ctx context.Context, repo repository.AuditRepository, publicKey ed25519.PublicKey, checkpointEvery int) (*Report, error) {
+	report := &Report{}
+	var prev *models.AuditEvent
+	for {
+		events, err := repo.ListBySeq(ctx, report.LastSeq, verifyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if reason := checkLink(prev, event, publicKey, int64(checkpointEvery)); reason != "" {
+				report.Broken = &Break{Seq: event.Seq, EventID: event.ID, Reason: reason}
+				return report, nil
+			}
+			report.Events++
+			report.Unsigned++
+			if event.Action == models.AuditActionCheckpoint {
+				report.Checkpoints++
+				report.SignedSeq, report.Unsigned = prev.Seq, 0
+			}
+			report.LastSeq = event.Seq
+			prev = event
+		}
+		if len(events) < verifyBatchSize {
+			return report, nil
+		}
+	}
+}
+
+// checkLink returns why event doesn't follow prev, or "" if it does. prev is
+// nil for the first event of the chain.
+func checkLink(prev, event *models.AuditEvent, publicKey ed25519.PublicKey, checkpointEvery int64) string {
+	wantSeq, wantPrevHash := int64(1), ""
+	if prev != nil {
+		wantSeq, wantPrevHash = prev.Seq+1, prev.Hash
+	}
+
+	switch {
+	case event.Seq != wantSeq:
+		return fmt.Sprintf("expected seq %d, events are missing", wantSeq)
+	case event.PrevHash != wantPrevHash:
+		return "previous hash does not match the preceding event"
+	case event.Hash != Hash(event):
+		return "hash does not match the event's contents"
+	case checkpointDue(prev, checkpointEvery) && event.Action != models.AuditActionCheckpoint:
+		return fmt.Sprintf("expected the checkpoint of seq %d, checkpoints are missing", prev.Seq)
+	}
+
+	if event.Action == models.AuditActionCheckpoint {
+		return checkCheckpoint(prev, event, publicKey)
+	}
+	return ""
+}
+
+// checkCheckpoint verifies a checkpoint signs the event before it
+func checkCheckpoint(prev, event *models.AuditEvent, publicKey ed25519.PublicKey) string {
+	if prev == nil {
+		return "checkpoint has no preceding event"
+	}
+	seq, err := strconv.ParseInt(event.Details["seq"], 10, 64)
+	if err != nil || seq != prev.Seq || event.Details["hash"] != prev.Hash {
+		return "checkpoint does not match the preceding event"
+	}
+	if publicKey == nil {
+		return ""
+	}
+	if event.Details["key_id"] != KeyID(publicKey) {
+		return "checkpoint was signed with key " + event.Details["key_id"] + ", not " + KeyID(publicKey)
+	}
+	signature, err := base64.StdEncoding.DecodeString(event.Details["signature"])
+	if err != nil || !ed25519.Verify(publicKey, checkpointMessage(seq, prev.Hash), signature) {
+		return "checkpoint signature is invalid"
+	}
+	return ""
+}
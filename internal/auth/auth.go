@@ -1,21 +1,42 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var tracer = otel.Tracer("auth/internal/auth")
+
 // HashPassword hashes a password using bcrypt
-func HashPassword(password string) (string, error) {
+func HashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "bcrypt.hash")
+	defer span.End()
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 	return string(bytes), err
 }
 
 // CheckPasswordHash compares a password with a hash
-func CheckPasswordHash(password, hash string) bool {
+func CheckPasswordHash(ctx context.Context, password, hash string) bool {
+	_, span := tracer.Start(ctx, "bcrypt.compare")
+	defer span.End()
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	// A wrong password is the expected answer, not a failure of the span
+	if err != nil && !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.Bool("auth.password_match", err == nil))
 	return err == nil
 }
 
@@ -23,19 +44,33 @@ func CheckPasswordHash(password, hash string) bool {
 type Claims struct {
 	Username string `json:"username"`
 	UserID   string `json:"user_id"`
+	Role     string `json:"role,omitempty"`
+	// Plan is the rate limit plan of the account when the token was issued
+	Plan string `json:"plan,omitempty"`
+	// PasswordResetRequired limits the token to changing the password
+	PasswordResetRequired bool `json:"pwd_reset,omitempty"`
+	// SessionID identifies the login session the token was issued for
+	SessionID string `json:"sid,omitempty"`
+	// ACR and AMR record how strongly and by which methods the user
+	// authenticated; AuthTime is when they last did so
+	ACR      string           `json:"acr,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT generates a new JWT token with configurable secret and expiration
-func GenerateJWT(username, secret string, expiration time.Duration) (string, error) {
-	// Create the claims
-	claims := &Claims{
-		Username: username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
+// GenerateJWT signs claims with the configured secret, setting the issued-at,
+// not-before and expiry times. The authentication time defaults to now.
+func GenerateJWT(claims *Claims, secret string, expiration time.Duration) (string, error) {
+	now := time.Now()
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(expiration))
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+	if claims.Subject == "" {
+		claims.Subject = claims.UserID
+	}
+	if claims.AuthTime == nil {
+		claims.AuthTime = claims.IssuedAt
 	}
 
 	// Create the token
@@ -0,0 +1,37 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"auth/internal/auth"
+)
+
+func TestCSRFToken(t *testing.T) {
+	token, err := auth.GenerateCSRFToken("session-1", "secret")
+	if err != nil {
+		t.Fatalf("GenerateCSRFToken() unexpected error: %v", err)
+	}
+	if !auth.ValidateCSRFToken(token, "session-1", "secret") {
+		t.Error("ValidateCSRFToken() rejected a token for its session")
+	}
+
+	other, _ := auth.GenerateCSRFToken("session-1", "secret")
+	if other == token {
+		t.Error("GenerateCSRFToken() returned the same token twice")
+	}
+
+	nonce, _, _ := strings.Cut(token, ".")
+	for name, tt := range map[string]struct{ token, session, secret string }{
+		"other session": {token, "session-2", "secret"},
+		"other secret":  {token, "session-1", "other"},
+		"no session":    {token, "", "secret"},
+		"no mac":        {nonce, "session-1", "secret"},
+		"forged mac":    {nonce + ".forged", "session-1", "secret"},
+		"empty":         {"", "session-1", "secret"},
+	} {
+		if auth.ValidateCSRFToken(tt.token, tt.session, tt.secret) {
+			t.Errorf("ValidateCSRFToken() accepted %s", name)
+		}
+	}
+}
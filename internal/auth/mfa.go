@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Authentication context classes recorded in the acr claim, after the NIST
+// authenticator assurance levels
+const (
+	ACRPassword = "aal1"
+	ACRMFA      = "aal2"
+)
+
+// Authentication methods recorded in the amr claim, as registered in RFC 8176
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+)
+
+// AMREmail records a login with a link or code sent by email. RFC 8176 has no
+// value for it.
+const AMREmail = "email"
+
+// ACRSatisfies reports whether a token with acr meets required. Tokens
+// issued without an acr count as password logins.
+func ACRSatisfies(acr, required string) bool {
+	rank := func(acr string) int {
+		if acr == ACRMFA {
+			return 2
+		}
+		return 1
+	}
+	return rank(acr) >= rank(required)
+}
+
+// TOTP parameters. These are the defaults of RFC 6238 and the only ones most
+// authenticator apps support.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many steps a code may be off by, allowing for clock
+	// drift and codes entered just as they change
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random base32 TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps enroll from, usually
+// shown as a QR code
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPCode returns the code for secret at t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return totpCode(key, t.Unix()/totpPeriod), nil
+}
+
+// ValidateTOTP checks code against secret at t. Codes of time steps up to
+// lastStep were already used and are refused. On success it returns the step
+// the code belongs to, which becomes the new lastStep.
+func ValidateTOTP(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the HOTP value of RFC 4226 for counter step
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// mfaPurpose separates the key of MFA challenge tokens from other tokens
+const mfaPurpose = "login-mfa"
+
+// MFAClaims identify a login that passed its first factor and still has to
+// present a second one
+type MFAClaims struct {
+	UserID string `json:"user_id"`
+	// AMR lists the methods the user authenticated with so far
+	AMR []string `json:"amr,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateMFAToken signs the challenge returned by a login that needs MFA.
+// id identifies the challenge and amr lists the methods the user
+// authenticated with so far.
+func GenerateMFAToken(id, userID string, amr []string, secret string, expiration time.Duration) (string, error) {
+	now := time.Now()
+	return signPurpose(&MFAClaims{
+		UserID: userID,
+		AMR:    amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+		},
+	}, secret, mfaPurpose)
+}
+
+// ValidateMFAToken validates an MFA challenge token and returns its claims
+func ValidateMFAToken(tokenString, secret string) (*MFAClaims, error) {
+	claims := &MFAClaims{}
+	if err := parsePurpose(tokenString, secret, mfaPurpose, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
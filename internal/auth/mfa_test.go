@@ -0,0 +1,132 @@
+package auth_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/internal/auth"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := auth.TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode() unexpected error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := auth.TOTPCode(rfcSecret, now)
+
+	step, ok := auth.ValidateTOTP(rfcSecret, code, now, 0)
+	if !ok || step != now.Unix()/30 {
+		t.Fatalf("ValidateTOTP() = %d, %v, want %d, true", step, ok, now.Unix()/30)
+	}
+	if _, ok := auth.ValidateTOTP(rfcSecret, code, now, step); ok {
+		t.Error("ValidateTOTP() accepted a code that was already used")
+	}
+	if _, ok := auth.ValidateTOTP(rfcSecret, code, now.Add(30*time.Second), 0); !ok {
+		t.Error("ValidateTOTP() refused the previous code")
+	}
+	if _, ok := auth.ValidateTOTP(rfcSecret, code, now.Add(90*time.Second), 0); ok {
+		t.Error("ValidateTOTP() accepted a code three steps old")
+	}
+	for _, invalid := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := auth.ValidateTOTP(rfcSecret, invalid, now, 0); ok {
+			t.Errorf("ValidateTOTP(%q) = true", invalid)
+		}
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() unexpected error: %v", err)
+	}
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil || len(code) != 6 {
+		t.Errorf("TOTPCode() with a generated secret = %q, %v", code, err)
+	}
+
+	uri, err := url.Parse(auth.TOTPURI("Auth API", "alice", secret))
+	if err != nil {
+		t.Fatalf("TOTPURI() is not a URL: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || !strings.HasSuffix(uri.Path, "Auth API:alice") ||
+		uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != "Auth API" {
+		t.Errorf("TOTPURI() = %s", uri)
+	}
+}
+
+func TestPurposeTokens(t *testing.T) {
+	mfaToken, err := auth.GenerateMFAToken("challenge-1", "user-1", []string{auth.AMRPassword}, "secret", time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateMFAToken() unexpected error: %v", err)
+	}
+	reportToken, err := auth.GenerateReportToken("user-1", "session-1", "secret", time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateReportToken() unexpected error: %v", err)
+	}
+	accessToken, err := auth.GenerateJWT(&auth.Claims{UserID: "user-1"}, "secret", time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT() unexpected error: %v", err)
+	}
+
+	if claims, err := auth.ValidateMFAToken(mfaToken, "secret"); err != nil || claims.ID != "challenge-1" || claims.UserID != "user-1" || len(claims.AMR) != 1 || claims.AMR[0] != auth.AMRPassword {
+		t.Errorf("ValidateMFAToken() = %+v, %v", claims, err)
+	}
+	// No kind of token is accepted as another
+	if _, err := auth.ValidateMFAToken(reportToken, "secret"); err == nil {
+		t.Error("ValidateMFAToken() accepted a report token")
+	}
+	if _, err := auth.ValidateMFAToken(accessToken, "secret"); err == nil {
+		t.Error("ValidateMFAToken() accepted an access token")
+	}
+	if _, err := auth.ValidateReportToken(mfaToken, "secret"); err == nil {
+		t.Error("ValidateReportToken() accepted an MFA token")
+	}
+	if _, err := auth.ValidateJWT(mfaToken, "secret"); err == nil {
+		t.Error("ValidateJWT() accepted an MFA token")
+	}
+}
+
+func TestACRSatisfies(t *testing.T) {
+	tests := []struct {
+		acr, required string
+		want          bool
+	}{
+		{auth.ACRMFA, auth.ACRMFA, true},
+		{auth.ACRMFA, auth.ACRPassword, true},
+		{auth.ACRPassword, auth.ACRPassword, true},
+		{auth.ACRPassword, auth.ACRMFA, false},
+		{"", auth.ACRPassword, true},
+		{"", auth.ACRMFA, false},
+	}
+	for _, tt := range tests {
+		if got := auth.ACRSatisfies(tt.acr, tt.required); got != tt.want {
+			t.Errorf("ACRSatisfies(%q, %q) = %v, want %v", tt.acr, tt.required, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import "auth/internal/models"
+
+// Permission is an action a role may perform
+type Permission string
+
+const (
+	// PermUsersRead allows listing and viewing any user account
+	PermUsersRead Permission = "users:read"
+	// PermUsersWrite allows disabling, enabling, deleting and changing the
+	// role of any user account
+	PermUsersWrite Permission = "users:write"
+	// PermUsersErase allows irreversibly erasing a user's personal data
+	PermUsersErase Permission = "users:erase"
+	// PermAuditRead allows querying and exporting the audit log
+	PermAuditRead Permission = "audit:read"
+	// PermRateLimitRead allows viewing rate limits, client usage and rules
+	PermRateLimitRead Permission = "ratelimit:read"
+	// PermRateLimitWrite allows resetting clients and allowing or denying
+	// addresses, networks and users
+	PermRateLimitWrite Permission = "ratelimit:write"
+)
+
+var rolePermissions = map[string][]Permission{
+	models.RoleAdmin: {PermUsersRead, PermUsersWrite, PermUsersErase, PermAuditRead, PermRateLimitRead, PermRateLimitWrite},
+	models.RoleUser:  {},
+}
+
+// HasPermission reports whether role grants perm
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
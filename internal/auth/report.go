@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// reportPurpose separates the key of login report tokens from the key of
+// access tokens
+const reportPurpose = "login-report"
+
+// ReportClaims identify the login a "this wasn't me" link reports
+type ReportClaims struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// GenerateReportToken signs a token that lets whoever received the login
+// notification for sessionID report it, without being logged in
+func GenerateReportToken(userID, sessionID, secret string, expiration time.Duration) (string, error) {
+	now := time.Now()
+	claims := &ReportClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+		},
+	}
+	return signPurpose(claims, secret, reportPurpose)
+}
+
+// ValidateReportToken validates a login report token and returns its claims
+func ValidateReportToken(tokenString, secret string) (*ReportClaims, error) {
+	claims := &ReportClaims{}
+	if err := parsePurpose(tokenString, secret, reportPurpose, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// signPurpose signs claims with the key of a kind of token
+func signPurpose(claims jwt.Claims, secret, purpose string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(purposeKey(secret, purpose))
+}
+
+// parsePurpose validates a token signed by signPurpose into claims
+func parsePurpose(tokenString, secret, purpose string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return purposeKey(secret, purpose), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	return err
+}
+
+// purposeKey derives the signing key of a kind of token other than access
+// tokens from the JWT secret, so one kind is never accepted as another
+func purposeKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,158 @@
+// Package clientip resolves the address of the client a request came from.
+// Forwarding headers are only believed when the peer that sent them is a
+// configured trusted proxy, so clients can't choose the address they are
+// rate limited and audited by.
+//
+// The address is resolved once per request by the middleware and stored with
+// the request info, so logging, rate limiting, audit and tracing all agree.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"auth/internal/reqctx"
+)
+
+// Resolver resolves client addresses behind a set of trusted proxies. A nil
+// *Resolver trusts no proxy and returns the peer address.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New creates a resolver that trusts the forwarding headers of proxies in
+// trusted, each an address or a CIDR such as 10.0.0.0/8
+func New(trusted []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, entry := range trusted {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, want an address or CIDR", entry)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// Resolve returns the address of the client of r. Forwarded addresses are
+// read from the nearest hop outwards, and the first one that isn't a trusted
+// proxy is the client. The Forwarded header (RFC 7239) is used when present,
+// then X-Forwarded-For, then X-Real-IP.
+func (res *Resolver) Resolve(r *http.Request) string {
+	peer, ok := parseAddr(RemoteIP(r))
+	if !ok {
+		return RemoteIP(r)
+	}
+	if res == nil || !res.trusts(peer) {
+		return peer.String()
+	}
+
+	hops := forwarded(r.Header)
+	if hops == nil {
+		hops = split(r.Header.Values("X-Forwarded-For"))
+	}
+	if hops == nil {
+		hops = split(r.Header.Values("X-Real-IP"))
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			// Obfuscated or garbled hops can't be checked, so the last
+			// proxy that could be is as far as the chain is believed
+			break
+		}
+		client = addr
+		if !res.trusts(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// TrustsPeer reports whether the peer that sent r is a trusted proxy, whose
+// headers describing the request can be believed
+func (res *Resolver) TrustsPeer(r *http.Request) bool {
+	peer, ok := parseAddr(RemoteIP(r))
+	return ok && res != nil && res.trusts(peer)
+}
+
+func (res *Resolver) trusts(addr netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteIP returns the address of the directly connected peer
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// FromRequest returns the client address the middleware resolved for r, or
+// the peer address for requests that didn't pass through it
+func FromRequest(r *http.Request) string {
+	if ip := reqctx.FromContext(r.Context()).IP; ip != "" {
+		return ip
+	}
+	return RemoteIP(r)
+}
+
+// parseAddr parses an address with an optional port, brackets or IPv6 zone.
+// IPv4 addresses mapped into IPv6 are returned as IPv4.
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap().WithZone(""), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// split returns the comma-separated entries of header values, nil if there
+// are none
+func split(values []string) []string {
+	var entries []string
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			entries = append(entries, strings.TrimSpace(entry))
+		}
+	}
+	return entries
+}
+
+// forwarded returns the for= address of each element of the Forwarded
+// headers, nil if there are none. Elements without one are kept as empty
+// entries, which stop the chain being followed.
+func forwarded(header http.Header) []string {
+	var hops []string
+	for _, element := range split(header.Values("Forwarded")) {
+		var hop string
+		for _, pair := range strings.Split(element, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(key, "for") {
+				hop = strings.Trim(value, `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
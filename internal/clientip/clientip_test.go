@@ -0,0 +1,170 @@
+package clientip_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"auth/internal/clientip"
+	"auth/internal/reqctx"
+)
+
+func TestResolve(t *testing.T) {
+	resolver, err := clientip.New([]string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "198.51.100.7:5123",
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "headers from an untrusted peer are ignored",
+			remoteAddr: "198.51.100.7:5123",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.1", "X-Real-IP": "203.0.113.2"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.1.2.3:80",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.1"},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "spoofed entries before the client are skipped",
+			remoteAddr: "10.1.2.3:80",
+			headers:    map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.1, 10.9.9.9"},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "every hop trusted",
+			remoteAddr: "10.1.2.3:80",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.1, 192.0.2.10"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "garbled hop stops the chain",
+			remoteAddr: "10.1.2.3:80",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.1, nonsense"},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "X-Real-IP",
+			remoteAddr: "192.0.2.10:80",
+			headers:    map[string]string{"X-Real-IP": "203.0.113.1"},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "Forwarded is preferred",
+			remoteAddr: "10.1.2.3:80",
+			headers: map[string]string{
+				"Forwarded":       `for=203.0.113.5;proto=https, for="[2001:db8:ffff::1]:4711"`,
+				"X-Forwarded-For": "203.0.113.1",
+			},
+			want: "203.0.113.5",
+		},
+		{
+			name:       "Forwarded IPv6 client",
+			remoteAddr: "10.1.2.3:80",
+			headers:    map[string]string{"Forwarded": `For="[2001:db8:cafe::17]:4711"`},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "Forwarded obfuscated client",
+			remoteAddr: "10.1.2.3:80",
+			headers:    map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "IPv6 peer",
+			remoteAddr: "[2001:db8::1]:443",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "trusted IPv6 proxy",
+			remoteAddr: "[2001:db8:ffff::2]:443",
+			headers:    map[string]string{"X-Forwarded-For": "2001:db8:cafe::17"},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "IPv4-mapped peer",
+			remoteAddr: "[::ffff:10.1.2.3]:80",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.1"},
+			want:       "203.0.113.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := resolver.Resolve(req); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilResolver(t *testing.T) {
+	var resolver *clientip.Resolver
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:80"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	if got := resolver.Resolve(req); got != "10.1.2.3" {
+		t.Errorf("Resolve() = %q, want the peer address", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := clientip.New([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("New() accepted an invalid CIDR")
+	}
+	if _, err := clientip.New([]string{"proxy.internal"}); err == nil {
+		t.Error("New() accepted a host name")
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:80"
+	if got := clientip.FromRequest(req); got != "10.1.2.3" {
+		t.Errorf("FromRequest() without request info = %q, want the peer address", got)
+	}
+
+	req = req.WithContext(reqctx.WithInfo(context.Background(), reqctx.Info{IP: "203.0.113.1"}))
+	if got := clientip.FromRequest(req); got != "203.0.113.1" {
+		t.Errorf("FromRequest() = %q, want the resolved address", got)
+	}
+}
+
+func TestTrustsPeer(t *testing.T) {
+	resolver, err := clientip.New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	for peer, want := range map[string]bool{
+		"10.1.2.3:80":    true,
+		"203.0.113.1:80": false,
+		"garbage":        false,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = peer
+		if got := resolver.TrustsPeer(req); got != want {
+			t.Errorf("TrustsPeer() from %s = %v, want %v", peer, got, want)
+		}
+		var nilResolver *clientip.Resolver
+		if nilResolver.TrustsPeer(req) {
+			t.Errorf("nil resolver TrustsPeer() from %s = true, want false", peer)
+		}
+	}
+}
@@ -3,13 +3,32 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Server   ServerConfig
-	JWT      JWTConfig
-	Database DatabaseConfig
+	Server       ServerConfig
+	JWT          JWTConfig
+	Database     DatabaseConfig
+	Account      AccountConfig
+	Privacy      PrivacyConfig
+	Audit        AuditConfig
+	Session      SessionConfig
+	Notify       NotifyConfig
+	Risk         RiskConfig
+	Passwordless PasswordlessConfig
+	Cookie       CookieConfig
+	CORS         CORSConfig
+	Headers      SecurityHeadersConfig
+	RateLimit    RateLimitConfig
+	Redis        RedisConfig
+	Metrics      MetricsConfig
+	Tracing      TracingConfig
+	Redaction    RedactionConfig
+	// Tenants are the tenants served besides Audit.Tenant, which requests
+	// that match none of them belong to
+	Tenants []TenantConfig
 }
 
 type ServerConfig struct {
@@ -18,6 +37,9 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
+	// TrustedProxies are the addresses and CIDRs of the proxies in front of
+	// the service, whose forwarding headers are believed
+	TrustedProxies []string
 }
 
 type JWTConfig struct {
@@ -26,33 +48,457 @@ type JWTConfig struct {
 }
 
 type DatabaseConfig struct {
+	// Driver selects the storage backend: postgres, sqlite or memory
+	Driver   string
 	Host     string
 	Port     string
 	Username string
 	Password string
 	Database string
+	// SQLitePath is the database file used by the sqlite driver
+	SQLitePath string
+	// AutoMigrate applies pending migrations when the server starts
+	AutoMigrate bool
+}
+
+// Account deletion purge modes
+const (
+	PurgeModeAnonymize = "anonymize"
+	PurgeModeRemove    = "remove"
+)
+
+type AccountConfig struct {
+	// DeletionGracePeriod is how long a user can cancel a requested deletion
+	DeletionGracePeriod time.Duration
+	// RetentionPeriod is how long a deleted account is kept before its
+	// personal data is purged
+	RetentionPeriod time.Duration
+	// PurgeInterval is how often the purge job runs; zero disables it
+	PurgeInterval time.Duration
+	// PurgeMode is anonymize (keep a scrubbed row) or remove (delete the row)
+	PurgeMode string
+}
+
+type PrivacyConfig struct {
+	// ExportTTL is how long a finished data export can be downloaded
+	ExportTTL time.Duration
+}
+
+type AuditConfig struct {
+	// Tenant is the default tenant, recorded in audit events of requests
+	// that match no other tenant and of background jobs
+	Tenant string
+	// SigningKey is the base64 Ed25519 key that signs hash chain
+	// checkpoints; without it no checkpoints are written
+	SigningKey string
+	// VerifyKey is the base64 Ed25519 public key "audit verify" checks
+	// checkpoints with. It defaults to the public half of SigningKey.
+	VerifyKey string
+	// CheckpointEvery is the number of events between signed checkpoints
+	CheckpointEvery int
+}
+
+type SessionConfig struct {
+	// GeoIPDatabase is the path of the CSV file used to show the approximate
+	// location of a session; empty disables location lookup
+	GeoIPDatabase string
+	// TouchInterval is how often a session's last-seen time is updated while
+	// it is in use
+	TouchInterval time.Duration
+	// HistoryRetention is how long ended sessions stay in the login history
+	HistoryRetention time.Duration
+	// MaxTravelSpeed is the fastest a user can plausibly travel between two
+	// logins, in km/h; logins further apart are flagged as impossible travel
+	MaxTravelSpeed int
+}
+
+type NotifyConfig struct {
+	// SMTPHost is the mail server login notifications are sent through;
+	// empty disables email
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// EmailFrom is the sender address of notification emails
+	EmailFrom string
+	// WebhookURL receives every notification as JSON; empty disables it
+	WebhookURL string
+	// WebhookSecret signs webhook requests in the X-Signature header
+	WebhookSecret string
+	// ReportURL is the page a user opens to report a login that wasn't
+	// them. The report token is appended to it.
+	ReportURL string
+	// ReportTTL is how long the link in a login notification can be used
+	ReportTTL time.Duration
+}
+
+type RiskConfig struct {
+	// MFAThreshold is the risk score at which a login needs a second factor
+	// and a sensitive operation needs step-up authentication with one
+	MFAThreshold int
+	// DenyThreshold is the risk score at which a login or operation is
+	// refused
+	DenyThreshold int
+	// VelocityWindow is how far back failed logins are counted
+	VelocityWindow time.Duration
+	// VelocityThreshold is the number of failed logins in the window at which
+	// VelocityScore is added
+	VelocityThreshold int
+	VelocityScore     int
+	// Scores added for the login signals of the session history
+	NewDeviceScore        int
+	NewCountryScore       int
+	ImpossibleTravelScore int
+	// TorExitList is a file of Tor exit node addresses, one per line
+	TorExitList  string
+	TorExitScore int
+	// DatacenterList is a file of hosting provider networks in CIDR notation
+	DatacenterList  string
+	DatacenterScore int
+	// ActiveHours is the range of hours, such as 07-22, in which activity is
+	// expected; empty disables the off-hours rule
+	ActiveHours   string
+	Timezone      string
+	OffHoursScore int
+	// StepUpMaxAge is how recently a user must have authenticated to perform
+	// a sensitive operation
+	StepUpMaxAge time.Duration
+	// MFAIssuer is the name authenticator apps show for enrolled accounts
+	MFAIssuer string
+}
+
+// PasswordlessConfig controls login with a magic link or one-time code sent
+// by email
+type PasswordlessConfig struct {
+	// Enabled applies to requests outside the configured tenants and is the
+	// default of TenantConfig.Passwordless
+	Enabled bool
+	// TTL is how long a link or code can be used
+	TTL time.Duration
+	// LinkURL is the page a magic link opens. The link token is appended to
+	// it; the page posts it to /login/email/verify from the same browser.
+	LinkURL string
+	// MaxAttempts is how many tries to redeem a challenge are checked before
+	// it is locked. Each try is counted before the code is compared.
+	MaxAttempts int
+	// MaxSends is how many links or codes can be sent to an account within
+	// SendWindow
+	MaxSends   int
+	SendWindow time.Duration
+}
+
+// Where the JWT middleware takes the access token from
+const (
+	TokenSourceHeader = "header"
+	TokenSourceCookie = "cookie"
+	TokenSourceBoth   = "both"
+)
+
+// How requests authenticated by cookie prove they are not cross-site forgeries
+const (
+	// CSRFModeDoubleSubmit echoes the CSRF cookie in the CSRF header
+	CSRFModeDoubleSubmit = "double_submit"
+	// CSRFModeSynchronizer sends the token the login returned in the CSRF
+	// header; no cookie is set
+	CSRFModeSynchronizer = "synchronizer"
+)
+
+// CookieConfig controls browser sessions kept in cookies instead of bearer
+// tokens
+type CookieConfig struct {
+	// TokenSource is header, cookie or both. Logins set the session cookie
+	// unless it is header, and with cookie the token is left out of the
+	// response body.
+	TokenSource string
+	Name        string
+	Domain      string
+	Path        string
+	Secure      bool
+	// SameSite is strict, lax or none
+	SameSite   string
+	CSRFMode   string
+	CSRFCookie string
+	CSRFHeader string
+}
+
+// CORSConfig controls which browser origins may call the API. The methods
+// and headers each route accepts are set where the routes are.
+type CORSConfig struct {
+	// AllowedOrigins are exact origins such as https://app.example.com,
+	// wildcard subdomains such as https://*.example.com, or * for any origin,
+	// allowed for every tenant. Empty allows no cross-origin requests besides
+	// the tenants' own.
+	AllowedOrigins []string
+	// AllowCredentials lets browsers send cookies cross-origin. It can't be
+	// combined with *.
+	AllowCredentials bool
+	// AllowedHeaders are request headers accepted on every route in addition
+	// to the route's own
+	AllowedHeaders []string
+	// ExposedHeaders are response headers scripts may read
+	ExposedHeaders []string
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// TenantConfig is a tenant served by this deployment. A request belongs to
+// the tenant whose Hosts include the host it was sent to.
+type TenantConfig struct {
+	Name  string
+	Hosts []string
+	// CORSOrigins are browser origins allowed to call the API on the
+	// tenant's hosts, in addition to CORSConfig.AllowedOrigins
+	CORSOrigins []string
+	// Passwordless enables passwordless login on the tenant's hosts
+	Passwordless bool
+}
+
+// CSPNonce is replaced in a Content-Security-Policy by a new nonce for every
+// response, such as script-src 'self' {nonce}
+const CSPNonce = "{nonce}"
+
+// SecurityHeadersConfig sets the security headers of every response. API
+// responses and HTML pages such as the Swagger UI get separate policies.
+type SecurityHeadersConfig struct {
+	Enabled bool
+	// HSTSMaxAge is how long browsers only use HTTPS; zero sends no HSTS
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	// HSTSPreload asks to be put on the browsers' preload lists, which
+	// requires a max age of a year and includeSubDomains
+	HSTSPreload       bool
+	APICSP            string
+	HTMLCSP           string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+	// CrossOriginOpenerPolicy and CrossOriginEmbedderPolicy isolate pages
+	// from other origins' windows and resources
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+}
+
+// RateLimitConfig controls how often clients may call each group of routes
+type RateLimitConfig struct {
+	Enabled bool
+	// Limits override the built-in limits by name, such as login. Each is
+	// requests per window with an optional burst and policy for when the
+	// store fails, such as 10/1m,burst=15,fail=closed. Empty keeps the
+	// built-in limit.
+	Limits map[string]string
+	// Plans set the quotas and limits of each account plan, by name. Each is
+	// a semicolon-separated list of daily and monthly request quotas and
+	// limit overrides, such as daily=10000;monthly=200000;api=1000/1m. A
+	// quota of 0 is unlimited.
+	Plans map[string]string
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint
+type MetricsConfig struct {
+	Enabled bool
+	// Port serves /metrics on its own listener, so it can be kept off the
+	// public network. Empty serves it on the API port.
+	Port string
+	// RefreshInterval is how often gauges that are counted rather than
+	// tracked, such as active sessions, are updated
+	RefreshInterval time.Duration
+}
+
+// TracingConfig selects where OpenTelemetry traces are sent
+type TracingConfig struct {
+	// Exporter is otlp-grpc, otlp-http, stdout, or none to disable tracing
+	Exporter string
+	// Endpoint is the host:port of the OTLP collector. Empty uses the
+	// OTEL_EXPORTER_OTLP_ENDPOINT variable, or the local default port.
+	Endpoint string
+	// Insecure sends OTLP without TLS, for collectors on the same network
+	Insecure bool
+	// File is where the stdout exporter writes; empty writes to stdout
+	File string
+	// SampleRatio is the share of new traces recorded; traces continued
+	// from a caller follow its decision
+	SampleRatio    float64
+	ServiceName    string
+	ServiceVersion string
+	Environment    string
+}
+
+// RedactionConfig controls how sensitive values are kept out of the logs.
+// Passwords and other secrets are always dropped; Mode applies to the rest.
+type RedactionConfig struct {
+	// Mode is mask, which replaces values with [REDACTED], hash, which
+	// replaces them with a keyed HMAC so lines about the same value can be
+	// correlated, or drop, which leaves them out
+	Mode string
+	// Key is the HMAC key of the hash mode
+	Key string
+	// Fields are attribute names to redact besides the built-in ones
+	Fields []string
+}
+
+// RedisConfig locates the Redis server shared by all instances. Without a
+// host, state that would live there is kept in memory.
+type RedisConfig struct {
+	Host     string
+	Port     string
+	Password string
+	DB       int
 }
 
 func Load() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Host:         getEnv("SERVER_HOST", "localhost"),
-			Port:         getEnv("SERVER_PORT", "8081"),
-			ReadTimeout:  getDurationEnv("SERVER_READ_TIMEOUT", 10*time.Second),
-			WriteTimeout: getDurationEnv("SERVER_WRITE_TIMEOUT", 10*time.Second),
-			IdleTimeout:  getDurationEnv("SERVER_IDLE_TIMEOUT", 60*time.Second),
+			Host:           getEnv("SERVER_HOST", "localhost"),
+			Port:           getEnv("SERVER_PORT", "8081"),
+			ReadTimeout:    getDurationEnv("SERVER_READ_TIMEOUT", 10*time.Second),
+			WriteTimeout:   getDurationEnv("SERVER_WRITE_TIMEOUT", 10*time.Second),
+			IdleTimeout:    getDurationEnv("SERVER_IDLE_TIMEOUT", 60*time.Second),
+			TrustedProxies: getListEnv("TRUSTED_PROXIES", nil),
 		},
 		JWT: JWTConfig{
 			Secret:     getEnv("JWT_SECRET", "your-256-bit-secret"),
 			Expiration: getDurationEnv("JWT_EXPIRATION", 24*time.Hour),
 		},
 		Database: DatabaseConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnv("DB_PORT", "5432"),
-			Username: getEnv("DB_USERNAME", "postgres"),
-			Password: getEnv("DB_PASSWORD", ""),
-			Database: getEnv("DB_DATABASE", "auth_db"),
+			Driver:      getEnv("DB_DRIVER", "postgres"),
+			Host:        getEnv("DB_HOST", "localhost"),
+			Port:        getEnv("DB_PORT", "5432"),
+			Username:    getEnv("DB_USERNAME", "postgres"),
+			Password:    getEnv("DB_PASSWORD", ""),
+			Database:    getEnv("DB_DATABASE", "auth_db"),
+			SQLitePath:  getEnv("DB_SQLITE_PATH", "auth.db"),
+			AutoMigrate: getBoolEnv("DB_AUTO_MIGRATE", true),
+		},
+		Account: AccountConfig{
+			DeletionGracePeriod: getDurationEnv("ACCOUNT_DELETION_GRACE_PERIOD", 14*24*time.Hour),
+			RetentionPeriod:     getDurationEnv("ACCOUNT_RETENTION_PERIOD", 30*24*time.Hour),
+			PurgeInterval:       getDurationEnv("ACCOUNT_PURGE_INTERVAL", time.Hour),
+			PurgeMode:           getEnv("ACCOUNT_PURGE_MODE", PurgeModeAnonymize),
+		},
+		Privacy: PrivacyConfig{
+			ExportTTL: getDurationEnv("PRIVACY_EXPORT_TTL", 24*time.Hour),
+		},
+		Audit: AuditConfig{
+			Tenant:          getEnv("AUDIT_TENANT", "default"),
+			SigningKey:      getEnv("AUDIT_SIGNING_KEY", ""),
+			VerifyKey:       getEnv("AUDIT_VERIFY_KEY", ""),
+			CheckpointEvery: getIntEnv("AUDIT_CHECKPOINT_EVERY", 1000),
+		},
+		Session: SessionConfig{
+			GeoIPDatabase:    getEnv("GEOIP_DATABASE", ""),
+			TouchInterval:    getDurationEnv("SESSION_TOUCH_INTERVAL", time.Minute),
+			HistoryRetention: getDurationEnv("SESSION_HISTORY_RETENTION", 90*24*time.Hour),
+			MaxTravelSpeed:   getIntEnv("SESSION_MAX_TRAVEL_SPEED", 1000),
+		},
+		Notify: NotifyConfig{
+			SMTPHost:      getEnv("NOTIFY_SMTP_HOST", ""),
+			SMTPPort:      getIntEnv("NOTIFY_SMTP_PORT", 587),
+			SMTPUsername:  getEnv("NOTIFY_SMTP_USERNAME", ""),
+			SMTPPassword:  getEnv("NOTIFY_SMTP_PASSWORD", ""),
+			EmailFrom:     getEnv("NOTIFY_EMAIL_FROM", "security@localhost"),
+			WebhookURL:    getEnv("NOTIFY_WEBHOOK_URL", ""),
+			WebhookSecret: getEnv("NOTIFY_WEBHOOK_SECRET", ""),
+			ReportURL:     getEnv("NOTIFY_REPORT_URL", "http://localhost:8081/login/report?token="),
+			ReportTTL:     getDurationEnv("NOTIFY_REPORT_TTL", 7*24*time.Hour),
+		},
+		Risk: RiskConfig{
+			MFAThreshold:          getIntEnv("RISK_MFA_THRESHOLD", 30),
+			DenyThreshold:         getIntEnv("RISK_DENY_THRESHOLD", 80),
+			VelocityWindow:        getDurationEnv("RISK_VELOCITY_WINDOW", 15*time.Minute),
+			VelocityThreshold:     getIntEnv("RISK_VELOCITY_THRESHOLD", 3),
+			VelocityScore:         getIntEnv("RISK_VELOCITY_SCORE", 40),
+			NewDeviceScore:        getIntEnv("RISK_NEW_DEVICE_SCORE", 20),
+			NewCountryScore:       getIntEnv("RISK_NEW_COUNTRY_SCORE", 30),
+			ImpossibleTravelScore: getIntEnv("RISK_IMPOSSIBLE_TRAVEL_SCORE", 60),
+			TorExitList:           getEnv("RISK_TOR_EXIT_LIST", ""),
+			TorExitScore:          getIntEnv("RISK_TOR_EXIT_SCORE", 50),
+			DatacenterList:        getEnv("RISK_DATACENTER_LIST", ""),
+			DatacenterScore:       getIntEnv("RISK_DATACENTER_SCORE", 30),
+			ActiveHours:           getEnv("RISK_ACTIVE_HOURS", ""),
+			Timezone:              getEnv("RISK_TIMEZONE", "UTC"),
+			OffHoursScore:         getIntEnv("RISK_OFF_HOURS_SCORE", 15),
+			StepUpMaxAge:          getDurationEnv("RISK_STEP_UP_MAX_AGE", 10*time.Minute),
+			MFAIssuer:             getEnv("MFA_ISSUER", "Auth API"),
+		},
+		Passwordless: PasswordlessConfig{
+			Enabled:     getBoolEnv("PASSWORDLESS_ENABLED", false),
+			TTL:         getDurationEnv("PASSWORDLESS_TTL", 10*time.Minute),
+			LinkURL:     getEnv("PASSWORDLESS_LINK_URL", "http://localhost:3000/login/email?token="),
+			MaxAttempts: getIntEnv("PASSWORDLESS_MAX_ATTEMPTS", 5),
+			MaxSends:    getIntEnv("PASSWORDLESS_MAX_SENDS", 5),
+			SendWindow:  getDurationEnv("PASSWORDLESS_SEND_WINDOW", time.Hour),
+		},
+		Cookie: CookieConfig{
+			TokenSource: getEnv("AUTH_TOKEN_SOURCE", TokenSourceHeader),
+			Name:        getEnv("AUTH_COOKIE_NAME", "access_token"),
+			Domain:      getEnv("AUTH_COOKIE_DOMAIN", ""),
+			Path:        getEnv("AUTH_COOKIE_PATH", "/"),
+			Secure:      getBoolEnv("AUTH_COOKIE_SECURE", true),
+			SameSite:    getEnv("AUTH_COOKIE_SAMESITE", "lax"),
+			CSRFMode:    getEnv("CSRF_MODE", CSRFModeDoubleSubmit),
+			CSRFCookie:  getEnv("CSRF_COOKIE_NAME", "csrf_token"),
+			CSRFHeader:  getEnv("CSRF_HEADER_NAME", "X-CSRF-Token"),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   getListEnv("CORS_ALLOWED_ORIGINS", nil),
+			AllowCredentials: getBoolEnv("CORS_ALLOW_CREDENTIALS", false),
+			AllowedHeaders:   getListEnv("CORS_ALLOWED_HEADERS", nil),
+			ExposedHeaders:   getListEnv("CORS_EXPOSED_HEADERS", []string{"X-Request-ID"}),
+			MaxAge:           getDurationEnv("CORS_MAX_AGE", 10*time.Minute),
+		},
+		Headers: SecurityHeadersConfig{
+			Enabled:               getBoolEnv("SECURITY_HEADERS_ENABLED", true),
+			HSTSMaxAge:            getDurationEnv("HSTS_MAX_AGE", 365*24*time.Hour),
+			HSTSIncludeSubdomains: getBoolEnv("HSTS_INCLUDE_SUBDOMAINS", true),
+			HSTSPreload:           getBoolEnv("HSTS_PRELOAD", false),
+			APICSP:                getEnv("CSP_API", "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"),
+			HTMLCSP: getEnv("CSP_HTML", "default-src 'self'; script-src 'self' "+CSPNonce+"; style-src 'self' 'unsafe-inline'; "+
+				"img-src 'self' data:; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"),
+			ReferrerPolicy:            getEnv("REFERRER_POLICY", "no-referrer"),
+			PermissionsPolicy:         getEnv("PERMISSIONS_POLICY", "accelerometer=(), camera=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), payment=(), usb=()"),
+			CrossOriginOpenerPolicy:   getEnv("CROSS_ORIGIN_OPENER_POLICY", "same-origin"),
+			CrossOriginEmbedderPolicy: getEnv("CROSS_ORIGIN_EMBEDDER_POLICY", "require-corp"),
 		},
+		RateLimit: RateLimitConfig{
+			Enabled: getBoolEnv("RATE_LIMIT_ENABLED", true),
+			Limits: map[string]string{
+				"auth":    getEnv("RATE_LIMIT_AUTH", ""),
+				"api":     getEnv("RATE_LIMIT_API", ""),
+				"signup":  getEnv("RATE_LIMIT_SIGNUP", ""),
+				"login":   getEnv("RATE_LIMIT_LOGIN", ""),
+				"profile": getEnv("RATE_LIMIT_PROFILE", ""),
+			},
+			Plans: getPlansEnv(map[string]string{
+				"free":       "daily=10000;monthly=100000",
+				"enterprise": "daily=0;monthly=0;api=1000/1m,burst=1500;profile=600/1m,burst=800",
+			}),
+		},
+		Redis: RedisConfig{
+			Host:     getEnv("REDIS_HOST", ""),
+			Port:     getEnv("REDIS_PORT", "6379"),
+			Password: getEnv("REDIS_PASSWORD", ""),
+			DB:       getIntEnv("REDIS_DB", 0),
+		},
+		Metrics: MetricsConfig{
+			Enabled:         getBoolEnv("METRICS_ENABLED", true),
+			Port:            getEnv("METRICS_PORT", ""),
+			RefreshInterval: getDurationEnv("METRICS_REFRESH_INTERVAL", 30*time.Second),
+		},
+		Tracing: TracingConfig{
+			Exporter:       getEnv("TRACING_EXPORTER", "none"),
+			Endpoint:       getEnv("TRACING_ENDPOINT", ""),
+			Insecure:       getBoolEnv("TRACING_INSECURE", false),
+			File:           getEnv("TRACING_FILE", ""),
+			SampleRatio:    getFloatEnv("TRACING_SAMPLE_RATIO", 1),
+			ServiceName:    getEnv("TRACING_SERVICE_NAME", "go-auth-api"),
+			ServiceVersion: getEnv("SERVICE_VERSION", "1.0"),
+			Environment:    getEnv("GO_ENV", "development"),
+		},
+		Redaction: RedactionConfig{
+			Mode:   getEnv("LOG_REDACT_MODE", "mask"),
+			Key:    getEnv("LOG_REDACT_KEY", ""),
+			Fields: getListEnv("LOG_REDACT_FIELDS", nil),
+		},
+		Tenants: getTenantsEnv(),
 	}
 }
 
@@ -79,4 +525,66 @@ func getIntEnv(key string, defaultValue int) int {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return defaultValue
+}
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return defaultValue
+}
+
+// getListEnv reads a comma-separated list, ignoring empty entries
+func getListEnv(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getPlansEnv reads the plans named in RATE_LIMIT_PLANS, each from
+// RATE_LIMIT_PLAN_<NAME>. Plans left unset use their entry in defaults.
+func getPlansEnv(defaults map[string]string) map[string]string {
+	names := getListEnv("RATE_LIMIT_PLANS", []string{"free", "enterprise"})
+	plans := make(map[string]string, len(names))
+	for _, name := range names {
+		plans[name] = getEnv("RATE_LIMIT_PLAN_"+strings.ToUpper(name), defaults[name])
+	}
+	return plans
+}
+
+// getTenantsEnv reads the tenants named in TENANTS, each configured with
+// TENANT_<NAME>_* variables
+func getTenantsEnv() []TenantConfig {
+	names := getListEnv("TENANTS", nil)
+	passwordless := getBoolEnv("PASSWORDLESS_ENABLED", false)
+	tenants := make([]TenantConfig, len(names))
+	for i, name := range names {
+		prefix := "TENANT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		tenants[i] = TenantConfig{
+			Name:         name,
+			Hosts:        getListEnv(prefix+"HOSTS", nil),
+			CORSOrigins:  getListEnv(prefix+"CORS_ORIGINS", nil),
+			Passwordless: getBoolEnv(prefix+"PASSWORDLESS_ENABLED", passwordless),
+		}
+	}
+	return tenants
+}
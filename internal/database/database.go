@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
@@ -8,13 +9,33 @@ import (
 	"auth/internal/config"
 	"auth/internal/logger"
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
 )
 
 type DB struct {
 	*sql.DB
+	Driver string
 }
 
+// New opens the database selected by cfg.Database.Driver
 func New(cfg *config.Config, logger *logger.Logger) (*DB, error) {
+	switch cfg.Database.Driver {
+	case DriverPostgres, "":
+		return newPostgres(cfg, logger)
+	case DriverSQLite:
+		return newSQLite(cfg, logger)
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Database.Driver)
+	}
+}
+
+func newPostgres(cfg *config.Config, logger *logger.Logger) (*DB, error) {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.Database.Host,
 		cfg.Database.Port,
@@ -39,33 +60,49 @@ func New(cfg *config.Config, logger *logger.Logger) (*DB, error) {
 	}
 
 	logger.Info("database connection established successfully")
-	return &DB{db}, nil
+	return &DB{DB: db, Driver: DriverPostgres}, nil
 }
 
-func (db *DB) Close() error {
-	return db.DB.Close()
-}
+// OpenSQLite opens (or creates) the SQLite database file at path
+func OpenSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
 
-// RunMigrations executes database migrations
-func (db *DB) RunMigrations() error {
-	migrations := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id UUID PRIMARY KEY,
-			username VARCHAR(50) UNIQUE NOT NULL,
-			email VARCHAR(255) UNIQUE NOT NULL,
-			password VARCHAR(255) NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_users_username ON users(username)`,
-		`CREATE INDEX IF NOT EXISTS idx_users_email ON users(email)`,
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	for _, migration := range migrations {
-		if _, err := db.Exec(migration); err != nil {
-			return fmt.Errorf("failed to run migration: %w", err)
-		}
+	// SQLite allows a single writer; sharing one connection avoids
+	// SQLITE_BUSY errors when a read transaction is upgraded to a write.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return nil
-}
\ No newline at end of file
+	return db, nil
+}
+
+func newSQLite(cfg *config.Config, logger *logger.Logger) (*DB, error) {
+	db, err := OpenSQLite(cfg.Database.SQLitePath)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("database connection established successfully", "driver", DriverSQLite, "path", cfg.Database.SQLitePath)
+	return &DB{DB: db, Driver: DriverSQLite}, nil
+}
+
+func (db *DB) Close() error {
+	return db.DB.Close()
+}
+
+// RunMigrations applies all pending versioned migrations
+func (db *DB) RunMigrations(ctx context.Context, logger *logger.Logger) error {
+	migrator, err := db.Migrator(logger)
+	if err != nil {
+		return err
+	}
+	return migrator.Up(ctx)
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"auth/internal/observability/metrics"
+	"auth/internal/observability/tracing"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("auth/internal/database")
+
+// Querier runs queries. *sql.DB and *sql.Tx satisfy it.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Instrument times and traces every query run through db, labeled by its
+// operation and the table it reads or writes. system names the database, such
+// as postgresql. Queries are timed until they return, which doesn't include
+// reading the rows of QueryContext.
+func Instrument(db Querier, system string) Querier {
+	return instrumented{db: db, system: system}
+}
+
+type instrumented struct {
+	db     Querier
+	system string
+}
+
+func (i instrumented) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	ctx, span := i.start(ctx, query)
+	defer func() { tracing.End(span, err) }()
+	defer record(query, time.Now())
+	return i.db.ExecContext(ctx, query, args...)
+}
+
+func (i instrumented) QueryContext(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	ctx, span := i.start(ctx, query)
+	defer func() { tracing.End(span, err) }()
+	defer record(query, time.Now())
+	return i.db.QueryContext(ctx, query, args...)
+}
+
+func (i instrumented) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := i.start(ctx, query)
+	defer record(query, time.Now())
+	row := i.db.QueryRowContext(ctx, query, args...)
+	// sql.ErrNoRows is an answer, not a failure
+	if err := row.Err(); err != nil && err != sql.ErrNoRows {
+		tracing.End(span, err)
+	} else {
+		span.End()
+	}
+	return row
+}
+
+// start starts the span of query, named by its operation and table such as
+// "select users"
+func (i instrumented) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	l := labelsOf(query)
+	return tracer.Start(ctx, l.operation+" "+l.table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemKey.String(i.system),
+			semconv.DBOperation(l.operation),
+			semconv.DBSQLTable(l.table),
+			semconv.DBStatement(query),
+		),
+	)
+}
+
+// queryLabels caches the labels of each query. Repositories build their
+// queries from a fixed set of parts, so it stays small.
+var queryLabels sync.Map
+
+type labels struct {
+	operation, table string
+}
+
+var tablePattern = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+([a-z_][a-z0-9_]*)`)
+
+func labelsOf(query string) labels {
+	l, ok := queryLabels.Load(query)
+	if !ok {
+		l, _ = queryLabels.LoadOrStore(query, parseLabels(query))
+	}
+	return l.(labels)
+}
+
+func record(query string, start time.Time) {
+	l := labelsOf(query)
+	metrics.RecordDatabaseQuery(l.operation, l.table, time.Since(start))
+}
+
+// parseLabels returns the statement and first table of query, such as select
+// and users
+func parseLabels(query string) labels {
+	l := labels{operation: "other", table: "unknown"}
+	if fields := strings.Fields(query); len(fields) > 0 {
+		switch op := strings.ToLower(fields[0]); op {
+		case "select", "insert", "update", "delete":
+			l.operation = op
+		}
+	}
+	if match := tablePattern.FindStringSubmatch(query); match != nil {
+		l.table = strings.ToLower(match[1])
+	}
+	return l
+}
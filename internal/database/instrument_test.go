@@ -0,0 +1,121 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"auth/internal/database"
+	"auth/internal/observability/metrics"
+	"auth/internal/observability/tracing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	_ "modernc.org/sqlite"
+)
+
+func TestInstrument(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() unexpected error: %v", err)
+	}
+	defer sqlDB.Close()
+	db := database.Instrument(sqlDB, "sqlite")
+	ctx := context.Background()
+
+	queries := func(operation, table string) float64 {
+		return testutil.ToFloat64(metrics.DatabaseQueriesTotal.WithLabelValues(operation, table))
+	}
+	inserts, selects, other := queries("insert", "widgets"), queries("select", "widgets"), queries("other", "unknown")
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE widgets (id INTEGER)`); err != nil {
+		t.Fatalf("ExecContext() unexpected error: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO widgets (id) VALUES (1)`); err != nil {
+		t.Fatalf("ExecContext() unexpected error: %v", err)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM widgets`).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("QueryRowContext() = %d, %v, want 1 row", count, err)
+	}
+	rows, err := db.QueryContext(ctx, `select id from Widgets where id = $1`, 1)
+	if err != nil {
+		t.Fatalf("QueryContext() unexpected error: %v", err)
+	}
+	rows.Close()
+
+	if got := queries("insert", "widgets") - inserts; got != 1 {
+		t.Errorf("insert queries = %v, want 1", got)
+	}
+	if got := queries("select", "widgets") - selects; got != 2 {
+		t.Errorf("select queries = %v, want 2", got)
+	}
+	if got := queries("other", "unknown") - other; got != 1 {
+		t.Errorf("other queries = %v, want the CREATE TABLE", got)
+	}
+}
+
+func TestInstrumentSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracing.Install(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)))
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() unexpected error: %v", err)
+	}
+	defer sqlDB.Close()
+	db := database.Instrument(sqlDB, "sqlite")
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	if _, err := db.ExecContext(ctx, `CREATE TABLE widgets (id INTEGER)`); err != nil {
+		t.Fatalf("ExecContext() unexpected error: %v", err)
+	}
+	var id int
+	if err := db.QueryRowContext(ctx, `SELECT id FROM widgets WHERE id = $1`, 1).Scan(&id); err != sql.ErrNoRows {
+		t.Fatalf("QueryRowContext() error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := db.QueryContext(ctx, `SELECT id FROM missing`); err == nil {
+		t.Fatal("QueryContext() expected an error for a missing table")
+	}
+	parent.End()
+
+	want := []struct {
+		name   string
+		status codes.Code
+	}{
+		{"other unknown", codes.Unset},
+		{"select widgets", codes.Unset},
+		{"select missing", codes.Error},
+	}
+	spans := recorder.Ended()
+	if len(spans) != len(want)+1 {
+		t.Fatalf("ended %d spans, want %d", len(spans), len(want)+1)
+	}
+	for i, w := range want {
+		span := spans[i]
+		if span.Name() != w.name {
+			t.Errorf("span %d name = %q, want %q", i, span.Name(), w.name)
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of the parent span", span.Name())
+		}
+		if span.Status().Code != w.status {
+			t.Errorf("span %q status = %v, want %v", span.Name(), span.Status().Code, w.status)
+		}
+	}
+	attrs := attribute.NewSet(spans[1].Attributes()...)
+	for key, want := range map[attribute.Key]string{
+		"db.system":    "sqlite",
+		"db.operation": "select",
+		"db.sql.table": "widgets",
+		"db.statement": `SELECT id FROM widgets WHERE id = $1`,
+	} {
+		if got, _ := attrs.Value(key); got.AsString() != want {
+			t.Errorf("attribute %s = %q, want %q", key, got.AsString(), want)
+		}
+	}
+}
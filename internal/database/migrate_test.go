@@ -0,0 +1,87 @@
+package database_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"auth/internal/database"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_index.up.sql":      {Data: []byte("CREATE INDEX idx ON users(email);")},
+		"0002_add_index.down.sql":    {Data: []byte("DROP INDEX idx;")},
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id UUID);")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	}
+
+	migrations, err := database.LoadMigrations(fsys)
+	if err != nil {
+		t.Fatalf("LoadMigrations() unexpected error: %v", err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("LoadMigrations() returned %d migrations, want 2", len(migrations))
+	}
+
+	if migrations[0].Version != 1 || migrations[0].Name != "create_users" {
+		t.Errorf("first migration = %d_%s, want 1_create_users", migrations[0].Version, migrations[0].Name)
+	}
+
+	if migrations[1].Version != 2 || migrations[1].Down != "DROP INDEX idx;" {
+		t.Errorf("second migration = %+v", migrations[1])
+	}
+
+	if migrations[0].Checksum == "" || migrations[0].Checksum == migrations[1].Checksum {
+		t.Errorf("LoadMigrations() checksums not computed per migration")
+	}
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "missing down script",
+			fsys: fstest.MapFS{
+				"0001_create_users.up.sql": {Data: []byte("CREATE TABLE users (id UUID);")},
+			},
+		},
+		{
+			name: "duplicate version",
+			fsys: fstest.MapFS{
+				"0001_create_users.up.sql":   {Data: []byte("SELECT 1;")},
+				"0001_create_users.down.sql": {Data: []byte("SELECT 1;")},
+				"0001_other.up.sql":          {Data: []byte("SELECT 1;")},
+				"0001_other.down.sql":        {Data: []byte("SELECT 1;")},
+			},
+		},
+		{
+			name: "invalid file name",
+			fsys: fstest.MapFS{
+				"create_users.sql": {Data: []byte("SELECT 1;")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := database.LoadMigrations(tt.fsys); err == nil {
+				t.Errorf("LoadMigrations() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	db := &database.DB{}
+	migrator, err := db.Migrator(nil)
+	if err != nil {
+		t.Fatalf("embedded migrations are invalid: %v", err)
+	}
+
+	if migrator.Latest() == 0 {
+		t.Errorf("no embedded migrations found")
+	}
+}
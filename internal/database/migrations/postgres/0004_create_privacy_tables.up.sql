@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    format VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    data BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_data_exports_expires_at ON data_exports(expires_at);
+
+CREATE TABLE IF NOT EXISTS erasure_tombstones (
+    id UUID PRIMARY KEY,
+    user_id UUID UNIQUE NOT NULL,
+    requested_by VARCHAR(64) NOT NULL,
+    reason TEXT NOT NULL,
+    sections TEXT NOT NULL,
+    erased_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
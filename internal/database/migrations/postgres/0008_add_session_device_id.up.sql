@@ -0,0 +1,3 @@
+-- device_id is the device cookie presented at login; sessions created before
+-- it was recorded have none
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_id VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- mfa_secret holds the TOTP secret, which is pending until mfa_enabled_at is
+-- set; mfa_last_step is the last TOTP time step used, so a code can't be
+-- replayed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_secret VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_enabled_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_last_step BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- plan names the rate limit tier of the account, one of those configured
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan VARCHAR(32) NOT NULL DEFAULT 'free';
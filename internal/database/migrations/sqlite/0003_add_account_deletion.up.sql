@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN purged_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users(deletion_scheduled_at)
+    WHERE status = 'pending_deletion';
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at)
+    WHERE status = 'deleted' AND purged_at IS NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_audit_events_seq;
+
+ALTER TABLE audit_events DROP COLUMN hash;
+ALTER TABLE audit_events DROP COLUMN prev_hash;
+ALTER TABLE audit_events DROP COLUMN seq;
@@ -0,0 +1,6 @@
+-- Events written before the hash chain was enabled keep seq 0 and no hashes
+ALTER TABLE audit_events ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE audit_events ADD COLUMN prev_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_events ADD COLUMN hash TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_events_seq ON audit_events(seq) WHERE seq > 0;
@@ -0,0 +1,6 @@
+-- mfa_secret holds the TOTP secret, which is pending until mfa_enabled_at is
+-- set; mfa_last_step is the last TOTP time step used, so a code can't be
+-- replayed
+ALTER TABLE users ADD COLUMN mfa_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN mfa_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN mfa_last_step INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- plan names the rate limit tier of the account, one of those configured
+ALTER TABLE users ADD COLUMN plan TEXT NOT NULL DEFAULT 'free';
@@ -0,0 +1,224 @@
+// Package geoip resolves IP addresses to an approximate location using a
+// database file on local disk, so client addresses are never sent to a
+// third-party service.
+//
+// The database is a CSV file with one network per line:
+//
+//	network,country,region,city,latitude,longitude,asn
+//	192.0.2.0/24,Germany,Berlin,Berlin,52.52,13.40,3320
+//	2001:db8::/32,France,,
+//
+// The header line is optional, lines starting with # are ignored, and every
+// column after the country may be empty or left out. The coordinates are
+// needed to measure how far apart two logins were, and the ASN identifies the
+// network operator. Networks must not overlap, as in the GeoLite2 city and ASN
+// blocks this format is usually exported from.
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Location is the approximate location of an address
+type Location struct {
+	Country string
+	Region  string
+	City    string
+	// Latitude and Longitude are only meaningful when HasCoordinates is set
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+	// ASN is the autonomous system number of the network, zero when unknown
+	ASN uint32
+}
+
+// String returns the location as "City, Region, Country", leaving out parts
+// that are unknown or repeated
+func (l Location) String() string {
+	var parts []string
+	for _, part := range []string{l.City, l.Region, l.Country} {
+		if part != "" && (len(parts) == 0 || parts[len(parts)-1] != part) {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// network is an address range of the database, with both ends in their
+// 16-byte form so IPv4 and IPv6 ranges sort together
+type network struct {
+	first, last netip.Addr
+	location    Location
+}
+
+// DB is a loaded location database. A nil *DB finds no locations, so callers
+// need not check whether a database was configured.
+type DB struct {
+	networks []network
+}
+
+// Open loads the database file at path
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	defer f.Close()
+
+	db, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GeoIP database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Parse reads a database in the CSV format described in the package docs
+func Parse(r io.Reader) (*DB, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	db := &DB{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record[0] == "network" {
+			continue
+		}
+		if len(record) < 2 {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: expected at least a network and a country", line)
+		}
+
+		prefix, err := netip.ParsePrefix(record[0])
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		n := network{location: Location{Country: record[1]}}
+		if len(record) > 2 {
+			n.location.Region = record[2]
+		}
+		if len(record) > 3 {
+			n.location.City = record[3]
+		}
+		if err := parseExtra(&n.location, record); err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		n.first, n.last = bounds(prefix)
+		db.networks = append(db.networks, n)
+	}
+
+	sort.Slice(db.networks, func(i, j int) bool {
+		return db.networks[i].first.Less(db.networks[j].first)
+	})
+	return db, nil
+}
+
+// parseExtra fills in the optional coordinates and ASN of a record
+func parseExtra(location *Location, record []string) error {
+	if len(record) > 5 && record[4] != "" && record[5] != "" {
+		lat, err := strconv.ParseFloat(record[4], 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return fmt.Errorf("invalid latitude %q", record[4])
+		}
+		lon, err := strconv.ParseFloat(record[5], 64)
+		if err != nil || lon < -180 || lon > 180 {
+			return fmt.Errorf("invalid longitude %q", record[5])
+		}
+		location.Latitude, location.Longitude, location.HasCoordinates = lat, lon, true
+	}
+	if len(record) > 6 && record[6] != "" {
+		asn, err := strconv.ParseUint(strings.TrimPrefix(record[6], "AS"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid ASN %q", record[6])
+		}
+		location.ASN = uint32(asn)
+	}
+	return nil
+}
+
+// earthRadius is the mean radius of the Earth in kilometres
+const earthRadius = 6371.0
+
+// Distance returns the great-circle distance between a and b in kilometres.
+// It returns false when either location has no coordinates.
+func Distance(a, b Location) (float64, bool) {
+	if !a.HasCoordinates || !b.HasCoordinates {
+		return 0, false
+	}
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h)), true
+}
+
+// Len returns the number of networks in the database
+func (db *DB) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.networks)
+}
+
+// Lookup returns the location of ip, which may be an IPv4 or IPv6 address.
+// It returns false when the address is invalid or not in the database.
+func (db *DB) Lookup(ip string) (Location, bool) {
+	if db == nil {
+		return Location{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}, false
+	}
+	addr = as16(addr)
+
+	// The last network starting at or before addr is the only one that can
+	// contain it
+	i := sort.Search(len(db.networks), func(i int) bool {
+		return addr.Less(db.networks[i].first)
+	}) - 1
+	if i < 0 || db.networks[i].last.Less(addr) {
+		return Location{}, false
+	}
+	return db.networks[i].location, true
+}
+
+// bounds returns the first and last address of prefix in 16-byte form
+func bounds(prefix netip.Prefix) (first, last netip.Addr) {
+	prefix = prefix.Masked()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+
+	bytes := as16(prefix.Addr()).As16()
+	first = netip.AddrFrom16(bytes)
+	for i := bits; i < 128; i++ {
+		bytes[i/8] |= 1 << (7 - i%8)
+	}
+	return first, netip.AddrFrom16(bytes)
+}
+
+// as16 maps IPv4 addresses into IPv6 so every address compares in one space
+func as16(addr netip.Addr) netip.Addr {
+	return netip.AddrFrom16(addr.Unmap().As16())
+}
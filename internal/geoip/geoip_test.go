@@ -0,0 +1,104 @@
+package geoip_test
+
+import (
+	"strings"
+	"testing"
+
+	"auth/internal/geoip"
+)
+
+const testDatabase = `network,country,region,city
+# Documentation ranges
+192.0.2.0/24,Germany,Berlin,Berlin
+198.51.100.128/25,United States,California,San Francisco
+203.0.113.0/24,Japan
+2001:db8::/32,France,Ile-de-France,Paris
+`
+
+func TestLookup(t *testing.T) {
+	db, err := geoip.Parse(strings.NewReader(testDatabase))
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if db.Len() != 4 {
+		t.Fatalf("Len() = %d, want 4", db.Len())
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+		ok   bool
+	}{
+		{"192.0.2.1", "Berlin, Germany", true},
+		{"192.0.2.255", "Berlin, Germany", true},
+		{"192.0.3.0", "", false},
+		{"198.51.100.200", "San Francisco, California, United States", true},
+		{"198.51.100.1", "", false},
+		{"203.0.113.7", "Japan", true},
+		{"::ffff:203.0.113.7", "Japan", true},
+		{"2001:db8:1::1", "Paris, Ile-de-France, France", true},
+		{"2001:db9::1", "", false},
+		{"10.0.0.1", "", false},
+		{"not an ip", "", false},
+	}
+	for _, tt := range tests {
+		location, ok := db.Lookup(tt.ip)
+		if ok != tt.ok || location.String() != tt.want {
+			t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.ip, location, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLookup_NilDB(t *testing.T) {
+	var db *geoip.DB
+	if _, ok := db.Lookup("192.0.2.1"); ok {
+		t.Error("Lookup() on a nil DB found a location")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, input := range []string{
+		"192.0.2.0/24\n",
+		"192.0.2.0/33,Germany\n",
+		"not-a-network,Germany\n",
+		"192.0.2.0/24,Germany,,,91,0\n",
+		"192.0.2.0/24,Germany,,,52.5,east\n",
+		"192.0.2.0/24,Germany,,,,,ASX\n",
+	} {
+		if _, err := geoip.Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestLookup_CoordinatesAndASN(t *testing.T) {
+	db, err := geoip.Parse(strings.NewReader(`
+192.0.2.0/24,Germany,Berlin,Berlin,52.52,13.405,3320
+198.51.100.0/24,United States,New York,New York,40.7128,-74.006,AS7018
+203.0.113.0/24,Japan,,,,,2516
+`))
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+
+	berlin, _ := db.Lookup("192.0.2.1")
+	newYork, _ := db.Lookup("198.51.100.1")
+	japan, _ := db.Lookup("203.0.113.1")
+	if berlin.ASN != 3320 || newYork.ASN != 7018 || japan.ASN != 2516 {
+		t.Errorf("ASNs = %d, %d, %d, want 3320, 7018, 2516", berlin.ASN, newYork.ASN, japan.ASN)
+	}
+	if !berlin.HasCoordinates || japan.HasCoordinates {
+		t.Errorf("HasCoordinates = %v, %v, want true, false", berlin.HasCoordinates, japan.HasCoordinates)
+	}
+
+	// Berlin to New York is about 6,385 km
+	if km, ok := geoip.Distance(berlin, newYork); !ok || km < 6300 || km > 6450 {
+		t.Errorf("Distance(Berlin, New York) = %.0f, %v, want about 6385", km, ok)
+	}
+	if km, ok := geoip.Distance(berlin, berlin); !ok || km != 0 {
+		t.Errorf("Distance(Berlin, Berlin) = %.0f, %v, want 0", km, ok)
+	}
+	if _, ok := geoip.Distance(berlin, japan); ok {
+		t.Error("Distance() to a location without coordinates succeeded")
+	}
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auth/internal/middleware"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"auth/internal/services"
+)
+
+type AdminHandler struct {
+	responder
+	adminService *services.AdminService
+}
+
+func NewAdminHandler(adminService *services.AdminService) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+	}
+}
+
+// ListUsers lists users
+// @Summary List users
+// @Description List users with filtering, sorting, prefix search and cursor pagination
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param q query string false "Username or email prefix"
+// @Param email_domain query string false "Email domain, e.g. example.com"
+// @Param status query string false "Account status" Enums(active, disabled, pending_deletion, deleted)
+// @Param role query string false "Role" Enums(user, admin)
+// @Param created_after query string false "RFC 3339 timestamp"
+// @Param created_before query string false "RFC 3339 timestamp"
+// @Param sort query string false "Sort field" Enums(created_at, username, email)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param limit query int false "Page size (max 200)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Success 200 {object} services.ListUsersResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 403 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/users [get]
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	filter, validationErr := parseUserFilter(r)
+	if len(validationErr) > 0 {
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+		return
+	}
+
+	response, err := h.adminService.ListUsers(r.Context(), actorID(r), filter)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+// GetUser returns a user
+// @Summary Get user
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 401 {object} models.APIError
+// @Failure 403 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Router /admin/users/{id} [get]
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.adminService.GetUser(r.Context(), actorID(r), r.PathValue("id"))
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusOK)
+}
+
+// DisableUser disables a user account
+// @Summary Disable user
+// @Description Prevent the user from logging in
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Router /admin/users/{id}/disable [post]
+func (h *AdminHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.adminService.DisableUser(r.Context(), actorID(r), r.PathValue("id"))
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusOK)
+}
+
+// EnableUser re-enables a user account
+// @Summary Enable user
+// @Description Re-enable a disabled user, or restore a deleted account that has not been purged yet
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 404 {object} models.APIError
+// @Failure 409 {object} models.APIError
+// @Router /admin/users/{id}/enable [post]
+func (h *AdminHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.adminService.EnableUser(r.Context(), actorID(r), r.PathValue("id"))
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusOK)
+}
+
+// ForcePasswordReset requires the user to change their password
+// @Summary Force password reset
+// @Description The user's tokens only grant access to the password change endpoint until the password is changed
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 404 {object} models.APIError
+// @Router /admin/users/{id}/password-reset [post]
+func (h *AdminHandler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, err := h.adminService.ForcePasswordReset(r.Context(), actorID(r), r.PathValue("id"))
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusOK)
+}
+
+// SetUserRole changes a user's role
+// @Summary Set user role
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param request body models.UpdateRoleRequest true "New role"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Router /admin/users/{id}/role [put]
+func (h *AdminHandler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := h.adminService.SetUserRole(r.Context(), actorID(r), r.PathValue("id"), req.Role)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusOK)
+}
+
+// SetUserPlan changes a user's rate limit plan
+// @Summary Set user plan
+// @Description Move the user to a configured rate limit plan. It applies from the user's next login.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param request body models.UpdatePlanRequest true "New plan"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Router /admin/users/{id}/plan [put]
+func (h *AdminHandler) SetUserPlan(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdatePlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := h.adminService.SetUserPlan(r.Context(), actorID(r), r.PathValue("id"), req.Plan)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusOK)
+}
+
+// DeleteUser deletes a user account
+// @Summary Delete user
+// @Description Delete the account immediately, without a grace period. Personal data is purged after the retention period.
+// @Tags admin
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Router /admin/users/{id} [delete]
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := h.adminService.DeleteUser(r.Context(), actorID(r), r.PathValue("id")); err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EraseUser erases a user's personal data
+// @Summary Erase user data
+// @Description Immediately and irreversibly erase all personal data of the user, skipping the grace and retention periods, and return the tombstone recording the erasure
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param request body models.EraseUserRequest true "Reason for the erasure"
+// @Success 200 {object} models.ErasureTombstone
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 409 {object} models.APIError
+// @Router /admin/users/{id}/erase [post]
+func (h *AdminHandler) EraseUser(w http.ResponseWriter, r *http.Request) {
+	var req models.EraseUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	tombstone, err := h.adminService.EraseUser(r.Context(), actorID(r), r.PathValue("id"), &req)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, tombstone, http.StatusOK)
+}
+
+// GetTombstone returns the erasure record of a user
+// @Summary Get erasure tombstone
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.ErasureTombstone
+// @Failure 404 {object} models.APIError
+// @Router /admin/users/{id}/tombstone [get]
+func (h *AdminHandler) GetTombstone(w http.ResponseWriter, r *http.Request) {
+	tombstone, err := h.adminService.GetTombstone(r.Context(), actorID(r), r.PathValue("id"))
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, tombstone, http.StatusOK)
+}
+
+func (h *AdminHandler) writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErr):
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+	case errors.Is(err, services.ErrUserNotFound):
+		h.writeErrorResponse(w, r, "User not found", "USER_NOT_FOUND", http.StatusNotFound, nil)
+	case errors.Is(err, services.ErrSelfAction):
+		h.writeErrorResponse(w, r, err.Error(), "SELF_ACTION", http.StatusBadRequest, nil)
+	case errors.Is(err, services.ErrInvalidRole):
+		h.writeErrorResponse(w, r, "Invalid role", "INVALID_ROLE", http.StatusBadRequest, nil)
+	case errors.Is(err, services.ErrInvalidPlan):
+		h.writeErrorResponse(w, r, "Invalid plan", "INVALID_PLAN", http.StatusBadRequest, nil)
+	case errors.Is(err, services.ErrAlreadyErased):
+		h.writeErrorResponse(w, r, "User data already erased", "ALREADY_ERASED", http.StatusConflict, nil)
+	case errors.Is(err, services.ErrAccountDeleted):
+		h.writeErrorResponse(w, r, "Account is deleted", "ACCOUNT_DELETED", http.StatusConflict, nil)
+	default:
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+	}
+}
+
+// actorID returns the ID of the authenticated administrator
+func actorID(r *http.Request) string {
+	id, _ := r.Context().Value(middleware.UserIDKey).(string)
+	return id
+}
+
+// parseUserFilter reads the list query parameters
+func parseUserFilter(r *http.Request) (repository.UserFilter, models.ValidationErrors) {
+	q := r.URL.Query()
+	errs := make(models.ValidationErrors)
+
+	filter := repository.UserFilter{
+		EmailDomain: q.Get("email_domain"),
+		Status:      q.Get("status"),
+		Role:        q.Get("role"),
+		Search:      q.Get("q"),
+		Sort:        repository.UserSort(q.Get("sort")),
+		Cursor:      q.Get("cursor"),
+	}
+
+	if filter.Sort != "" && !filter.Sort.Valid() {
+		errs["sort"] = "must be one of created_at, username, email"
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		errs["order"] = "must be asc or desc"
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > repository.MaxPageSize {
+			errs["limit"] = "must be between 1 and " + strconv.Itoa(repository.MaxPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	for name, dst := range map[string]*time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				errs[name] = "must be an RFC 3339 timestamp"
+			}
+			*dst = t
+		}
+	}
+
+	return filter, errs
+}
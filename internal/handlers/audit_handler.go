@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"auth/internal/services"
+)
+
+type AuditHandler struct {
+	responder
+	auditService *services.AuditService
+}
+
+func NewAuditHandler(auditService *services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+	}
+}
+
+// ListEvents lists audit events
+// @Summary Query the audit log
+// @Description List audit events, newest first, with filtering and cursor pagination
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param actor_id query string false "User who performed the action"
+// @Param target_id query string false "User the action was performed on"
+// @Param user_id query string false "User who is either the actor or the target"
+// @Param action query string false "Action, e.g. auth.login"
+// @Param outcome query string false "Outcome" Enums(success, failure)
+// @Param request_id query string false "Request ID"
+// @Param since query string false "RFC 3339 timestamp (inclusive)"
+// @Param until query string false "RFC 3339 timestamp (exclusive)"
+// @Param limit query int false "Page size (max 200)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Success 200 {object} services.ListAuditResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 403 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/audit [get]
+func (h *AuditHandler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	filter, validationErr := parseAuditFilter(r)
+	if len(validationErr) > 0 {
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+		return
+	}
+
+	response, err := h.auditService.Query(r.Context(), actorID(r), filter)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+// ExportEvents downloads audit events
+// @Summary Export the audit log
+// @Description Download every audit event matching the filters as CSV or JSON, newest first. Accepts the same filters as GET /admin/audit, except limit and cursor.
+// @Tags admin
+// @Produce json
+// @Produce text/csv
+// @Security ApiKeyAuth
+// @Param format query string false "Export format" Enums(csv, json) default(csv)
+// @Success 200 {array} models.AuditEvent
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 403 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/audit/export [get]
+func (h *AuditHandler) ExportEvents(w http.ResponseWriter, r *http.Request) {
+	filter, validationErr := parseAuditFilter(r)
+	if len(validationErr) > 0 {
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = services.AuditFormatCSV
+	}
+	contentType := "text/csv"
+	if format == services.AuditFormatJSON {
+		contentType = "application/json"
+	}
+	filename := "audit-" + time.Now().UTC().Format("20060102T150405Z") + "." + format
+
+	// Headers only take effect once the service starts writing, so a
+	// validation error can still be reported as JSON
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := h.auditService.Export(r.Context(), actorID(r), filter, format, w); err != nil {
+		var validationErr models.ValidationErrors
+		if errors.As(err, &validationErr) {
+			w.Header().Del("Content-Disposition")
+			h.writeServiceError(w, r, err)
+			return
+		}
+		// The response has already started, so the client sees a truncated file
+		logger.FromContext(r.Context()).Error("audit export failed", "error", err)
+	}
+}
+
+func (h *AuditHandler) writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr models.ValidationErrors
+	if errors.As(err, &validationErr) {
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+		return
+	}
+	h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+}
+
+// parseAuditFilter reads the audit query parameters
+func parseAuditFilter(r *http.Request) (repository.AuditFilter, models.ValidationErrors) {
+	q := r.URL.Query()
+	errs := make(models.ValidationErrors)
+
+	filter := repository.AuditFilter{
+		ActorID:   q.Get("actor_id"),
+		TargetID:  q.Get("target_id"),
+		UserID:    q.Get("user_id"),
+		Action:    q.Get("action"),
+		Outcome:   q.Get("outcome"),
+		RequestID: q.Get("request_id"),
+		Cursor:    q.Get("cursor"),
+	}
+
+	switch filter.Outcome {
+	case "", models.AuditOutcomeSuccess, models.AuditOutcomeFailure:
+	default:
+		errs["outcome"] = "must be success or failure"
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > repository.MaxPageSize {
+			errs["limit"] = "must be between 1 and " + strconv.Itoa(repository.MaxPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	for name, dst := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				errs[name] = "must be an RFC 3339 timestamp"
+			}
+			*dst = t
+		}
+	}
+
+	return filter, errs
+}
@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
+	"auth/internal/config"
 	"auth/internal/middleware"
 	"auth/internal/models"
 	"auth/internal/services"
@@ -11,14 +14,20 @@ import (
 )
 
 type AuthHandler struct {
-	authService *services.AuthService
-	logger      *logger.Logger
+	responder
+	authService  *services.AuthService
+	cookies      *middleware.Cookies
+	cookieConfig config.CookieConfig
 }
 
-func NewAuthHandler(authService *services.AuthService, logger *logger.Logger) *AuthHandler {
+// NewAuthHandler creates the handler. cookies may be nil, in which case
+// logins only return the token. cookieConfig applies to the device cookie
+// either way.
+func NewAuthHandler(authService *services.AuthService, cookies *middleware.Cookies, cookieConfig config.CookieConfig) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
-		logger:      logger,
+		authService:  authService,
+		cookies:      cookies,
+		cookieConfig: cookieConfig,
 	}
 }
 
@@ -38,33 +47,33 @@ func (h *AuthHandler) SignUp(w http.ResponseWriter, r *http.Request) {
 	var req models.SignUpRequest
 	
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeErrorResponse(w, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
 		return
 	}
 
 	user, err := h.authService.SignUp(r.Context(), &req)
 	if err != nil {
 		if validationErr, ok := err.(models.ValidationErrors); ok {
-			h.writeErrorResponse(w, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+			h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
 			return
 		}
 		
-		if err.Error() == "user already exists" {
-			h.writeErrorResponse(w, "User already exists", "USER_EXISTS", http.StatusConflict, nil)
+		if errors.Is(err, services.ErrUserExists) {
+			h.writeErrorResponse(w, r, "User already exists", "USER_EXISTS", http.StatusConflict, nil)
 			return
 		}
 		
-		h.logger.Error("signup failed", "error", err)
-		h.writeErrorResponse(w, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		logger.FromContext(r.Context()).Error("signup failed", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
 		return
 	}
 
-	h.writeJSONResponse(w, user, http.StatusCreated)
+	h.writeJSONResponse(w, r, user, http.StatusCreated)
 }
 
 // Login handles user login
 // @Summary Login a user
-// @Description Authenticate user and return JWT token
+// @Description Authenticate user and return JWT token. Sets a device_id cookie so later logins from the same browser are recognized; logins from new devices or unusual places notify the user. Risky logins to accounts with MFA fail with MFA_REQUIRED and an mfa_token to finish the login at /login/mfa; logins the risk policy refuses fail with LOGIN_DENIED.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -72,34 +81,85 @@ func (h *AuthHandler) SignUp(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} services.AuthTokenResponse
 // @Failure 400 {object} models.APIError
 // @Failure 401 {object} models.APIError
+// @Failure 403 {object} models.APIError
 // @Failure 500 {object} models.APIError
 // @Router /login [post]
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.LoginRequest
 	
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeErrorResponse(w, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
 		return
 	}
 
 	response, err := h.authService.Login(r.Context(), &req)
 	if err != nil {
 		if validationErr, ok := err.(models.ValidationErrors); ok {
-			h.writeErrorResponse(w, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+			h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
 			return
 		}
 		
-		if err.Error() == "invalid credentials" {
-			h.writeErrorResponse(w, "Invalid credentials", "INVALID_CREDENTIALS", http.StatusUnauthorized, nil)
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			h.writeErrorResponse(w, r, "Invalid credentials", "INVALID_CREDENTIALS", http.StatusUnauthorized, nil)
+			return
+		}
+
+		if errors.Is(err, services.ErrAccountDisabled) {
+			h.writeErrorResponse(w, r, "Account disabled", "ACCOUNT_DISABLED", http.StatusForbidden, nil)
+			return
+		}
+
+		if errors.Is(err, services.ErrAccountLocked) {
+			h.writeErrorResponse(w, r, "Account locked", "ACCOUNT_LOCKED", http.StatusForbidden, nil)
+			return
+		}
+
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			h.writeErrorResponse(w, r, "Second factor required", "MFA_REQUIRED", http.StatusUnauthorized, map[string]string{
+				"mfa_token":  mfaErr.Token,
+				"expires_at": mfaErr.ExpiresAt.UTC().Format(time.RFC3339),
+			})
+			return
+		}
+
+		if errors.Is(err, services.ErrLoginDenied) {
+			h.writeErrorResponse(w, r, "Login denied", "LOGIN_DENIED", http.StatusForbidden, nil)
 			return
 		}
 		
-		h.logger.Error("login failed", "error", err)
-		h.writeErrorResponse(w, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		logger.FromContext(r.Context()).Error("login failed", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
 		return
 	}
 
-	h.writeJSONResponse(w, response, http.StatusOK)
+	if err := startCookieSession(w, h.cookies, response); err != nil {
+		logger.FromContext(r.Context()).Error("failed to start cookie session", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+	setDeviceCookie(w, h.cookieConfig, response.DeviceID)
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+// deviceCookieMaxAge keeps the device cookie for two years
+const deviceCookieMaxAge = 2 * 365 * 24 * 60 * 60
+
+// setDeviceCookie stores the device ID in the browser so later logins from it
+// are recognized and don't trigger new device notifications
+func setDeviceCookie(w http.ResponseWriter, cfg config.CookieConfig, deviceID string) {
+	if deviceID == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.DeviceCookie,
+		Value:    deviceID,
+		Path:     "/",
+		MaxAge:   deviceCookieMaxAge,
+		HttpOnly: true,
+		Secure:   cfg.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
 // GetProfile returns the current user's profile
@@ -117,45 +177,185 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
 	if !ok {
-		h.writeErrorResponse(w, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
 		return
 	}
 
 	user, err := h.authService.GetUserByID(r.Context(), userID)
 	if err != nil {
-		if err.Error() == "user not found" {
-			h.writeErrorResponse(w, "User not found", "USER_NOT_FOUND", http.StatusNotFound, nil)
+		if errors.Is(err, services.ErrUserNotFound) {
+			h.writeErrorResponse(w, r, "User not found", "USER_NOT_FOUND", http.StatusNotFound, nil)
 			return
 		}
 		
-		h.logger.Error("get profile failed", "error", err, "user_id", userID)
-		h.writeErrorResponse(w, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		logger.FromContext(r.Context()).Error("get profile failed", "error", err, "user_id", userID)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
 		return
 	}
 
-	h.writeJSONResponse(w, user, http.StatusOK)
+	h.writeJSONResponse(w, r, user, http.StatusOK)
 }
 
-func (h *AuthHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		h.logger.Error("failed to encode JSON response", "error", err)
+// ChangePassword changes the current user's password
+// @Summary Change password
+// @Description Change the authenticated user's password. Also required after an administrator forces a password reset.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.ChangePasswordRequest true "Current and new password"
+// @Success 204
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /profile/password [put]
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
 	}
+
+	if err := h.authService.ChangePassword(r.Context(), userID, &req); err != nil {
+		if validationErr, ok := err.(models.ValidationErrors); ok {
+			h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+			return
+		}
+
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			h.writeErrorResponse(w, r, "Current password is incorrect", "INVALID_CREDENTIALS", http.StatusUnauthorized, nil)
+			return
+		}
+
+		if errors.Is(err, services.ErrUserNotFound) {
+			h.writeErrorResponse(w, r, "User not found", "USER_NOT_FOUND", http.StatusNotFound, nil)
+			return
+		}
+
+		logger.FromContext(r.Context()).Error("change password failed", "error", err, "user_id", userID)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *AuthHandler) writeErrorResponse(w http.ResponseWriter, message, code string, statusCode int, details map[string]string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	
-	errorResponse := models.APIError{
-		Message: message,
-		Code:    code,
-		Details: details,
+// DeleteAccount schedules the current user's account for deletion
+// @Summary Delete account
+// @Description Schedule the authenticated user's account for deletion. The deletion can be cancelled with POST /profile/restore until deletion_scheduled_at.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.DeleteAccountRequest true "Current password"
+// @Success 202 {object} models.UserResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /profile [delete]
+func (h *AuthHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
 	}
-	
-	if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
-		h.logger.Error("failed to encode error response", "error", err)
+
+	var req models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := h.authService.RequestDeletion(r.Context(), userID, &req)
+	if err != nil {
+		if validationErr, ok := err.(models.ValidationErrors); ok {
+			h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+			return
+		}
+
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			h.writeErrorResponse(w, r, "Password is incorrect", "INVALID_CREDENTIALS", http.StatusUnauthorized, nil)
+			return
+		}
+
+		if errors.Is(err, services.ErrUserNotFound) {
+			h.writeErrorResponse(w, r, "User not found", "USER_NOT_FOUND", http.StatusNotFound, nil)
+			return
+		}
+
+		logger.FromContext(r.Context()).Error("delete account failed", "error", err, "user_id", userID)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusAccepted)
+}
+
+// RestoreAccount cancels a pending account deletion
+// @Summary Cancel account deletion
+// @Description Cancel a pending deletion of the authenticated user's account
+// @Tags user
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.UserResponse
+// @Failure 401 {object} models.APIError
+// @Failure 409 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /profile/restore [post]
+func (h *AuthHandler) RestoreAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	user, err := h.authService.CancelDeletion(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, services.ErrNoPendingDeletion) {
+			h.writeErrorResponse(w, r, "Account is not scheduled for deletion", "NO_PENDING_DELETION", http.StatusConflict, nil)
+			return
+		}
+
+		if errors.Is(err, services.ErrUserNotFound) {
+			h.writeErrorResponse(w, r, "User not found", "USER_NOT_FOUND", http.StatusNotFound, nil)
+			return
+		}
+
+		logger.FromContext(r.Context()).Error("restore account failed", "error", err, "user_id", userID)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusOK)
+}
+
+// Logout signs the current user out
+// @Summary Log out
+// @Description Revoke the session the token was issued for. The token is rejected from then on.
+// @Tags auth
+// @Security ApiKeyAuth
+// @Success 204
+// @Failure 401 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /logout [post]
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+	sessionID, _ := r.Context().Value(middleware.SessionIDKey).(string)
+
+	if err := h.authService.Logout(r.Context(), userID, sessionID); err != nil {
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
 	}
+	h.cookies.EndSession(w)
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auth/internal/auth"
+	"auth/internal/logger"
+	"auth/internal/middleware"
+	"auth/internal/services"
+)
+
+// CSRFTokenResponse holds the CSRF token of a cookie session
+type CSRFTokenResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
+
+// startCookieSession puts a new token in the session cookie when cookie
+// sessions are enabled, and adds the CSRF token to the response. The token
+// itself is kept out of the body when the cookie is the only way in.
+func startCookieSession(w http.ResponseWriter, cookies *middleware.Cookies, response *services.AuthTokenResponse) error {
+	if !cookies.Enabled() {
+		return nil
+	}
+	csrfToken, err := cookies.StartSession(w, response.Token, response.SessionID, response.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	response.CSRFToken = csrfToken
+	if cookies.Exclusive() {
+		response.Token = ""
+	}
+	return nil
+}
+
+// CSRFToken issues a new CSRF token for the current cookie session
+// @Summary Get CSRF token
+// @Description Issue a new CSRF token for the current cookie session, for a page that no longer has the one returned at login. In double-submit mode the CSRF cookie is replaced too.
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} CSRFTokenResponse
+// @Failure 401 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /csrf [get]
+func (h *AuthHandler) CSRFToken(w http.ResponseWriter, r *http.Request) {
+	if !h.cookies.Enabled() {
+		h.writeErrorResponse(w, r, "Cookie sessions are not enabled", "COOKIE_SESSIONS_DISABLED", http.StatusNotFound, nil)
+		return
+	}
+	claims, ok := r.Context().Value(middleware.ClaimsKey).(*auth.Claims)
+	if !ok || claims.SessionID == "" || claims.ExpiresAt == nil {
+		h.writeErrorResponse(w, r, "Invalid token", "INVALID_TOKEN", http.StatusUnauthorized, nil)
+		return
+	}
+
+	csrfToken, err := h.cookies.RefreshCSRF(w, claims.SessionID, claims.ExpiresAt.Time)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to generate csrf token", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, r, CSRFTokenResponse{CSRFToken: csrfToken}, http.StatusOK)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"mime"
+	"net/http"
+
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/services"
+)
+
+// Login notifications link to the confirmation page rather than to the
+// report itself, so mail scanners that follow links can't lock accounts
+var (
+	reportConfirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Report sign-in</title></head>
+<body>
+<h1>Wasn't you?</h1>
+<p>Locking your account signs it out on every device. You won't be able to sign in until an administrator unlocks it.</p>
+<form method="post" action="/login/report">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Lock my account</button>
+</form>
+</body>
+</html>
+`))
+	reportResultPage = template.Must(template.New("result").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Report sign-in</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+)
+
+// ReportLoginPage asks the user to confirm a report from a login notification
+// @Summary Confirm login report
+// @Description HTML page linked from login notifications that asks the user to confirm locking their account
+// @Tags auth
+// @Produce html
+// @Param token query string true "Report token from the notification"
+// @Success 200
+// @Router /login/report [get]
+func (h *AuthHandler) ReportLoginPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := reportConfirmPage.Execute(w, r.URL.Query().Get("token")); err != nil {
+		logger.FromContext(r.Context()).Error("failed to render login report page", "error", err)
+	}
+}
+
+// ReportLogin locks the account after a login its user doesn't recognize
+// @Summary Report login
+// @Description Report a login from a notification as not made by the user. The account is locked and every session signed out until an administrator enables it. Accepts JSON, or a form post from the confirmation page.
+// @Tags auth
+// @Accept json
+// @Param request body models.ReportLoginRequest true "Report token from the notification"
+// @Success 204
+// @Failure 400 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /login/report [post]
+func (h *AuthHandler) ReportLogin(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		h.reportLoginForm(w, r)
+		return
+	}
+
+	var req models.ReportLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	err := h.authService.ReportLogin(r.Context(), &req)
+	var validationErr models.ValidationErrors
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.As(err, &validationErr):
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+	case errors.Is(err, services.ErrInvalidReportToken):
+		h.writeErrorResponse(w, r, "Invalid or expired report token", "INVALID_TOKEN", http.StatusBadRequest, nil)
+	default:
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+	}
+}
+
+// reportLoginForm handles the confirmation page's form and answers in HTML
+func (h *AuthHandler) reportLoginForm(w http.ResponseWriter, r *http.Request) {
+	req := models.ReportLoginRequest{Token: r.PostFormValue("token")}
+	err := h.authService.ReportLogin(r.Context(), &req)
+
+	page := struct{ Title, Message string }{
+		Title:   "Your account is locked",
+		Message: "Every device has been signed out. Contact an administrator to unlock your account.",
+	}
+	status := http.StatusOK
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrInvalidReportToken), errors.As(err, new(models.ValidationErrors)):
+		page.Title, page.Message = "Link expired", "This link is invalid or has expired. Contact an administrator if you think your account is at risk."
+		status = http.StatusBadRequest
+	default:
+		page.Title, page.Message = "Something went wrong", "Your account could not be locked. Please try again."
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := reportResultPage.Execute(w, page); err != nil {
+		logger.FromContext(r.Context()).Error("failed to render login report page", "error", err)
+	}
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/logger"
+	"auth/internal/middleware"
+	"auth/internal/models"
+	"auth/internal/services"
+)
+
+// CompleteMFA finishes a login that needs a second factor
+// @Summary Complete MFA login
+// @Description Finish a login that failed with MFA_REQUIRED by presenting its mfa_token and a code from the user's authenticator app
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.MFALoginRequest true "MFA token and code"
+// @Success 200 {object} services.AuthTokenResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 403 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /login/mfa [post]
+func (h *AuthHandler) CompleteMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	response, err := h.authService.CompleteMFA(r.Context(), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAccountDisabled):
+			h.writeErrorResponse(w, r, "Account disabled", "ACCOUNT_DISABLED", http.StatusForbidden, nil)
+		case errors.Is(err, services.ErrAccountLocked):
+			h.writeErrorResponse(w, r, "Account locked", "ACCOUNT_LOCKED", http.StatusForbidden, nil)
+		case errors.Is(err, services.ErrLoginDenied):
+			h.writeErrorResponse(w, r, "Login denied", "LOGIN_DENIED", http.StatusForbidden, nil)
+		default:
+			h.writeMFAError(w, r, err)
+		}
+		return
+	}
+
+	if err := startCookieSession(w, h.cookies, response); err != nil {
+		logger.FromContext(r.Context()).Error("failed to start cookie session", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+	setDeviceCookie(w, h.cookieConfig, response.DeviceID)
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+// StepUp re-authenticates the current user for a sensitive operation
+// @Summary Step up authentication
+// @Description Re-enter the password, and a code from the authenticator app if MFA is required, to get a fresh token for the current session. Sensitive operations answer 401 with WWW-Authenticate error="insufficient_user_authentication" until the user steps up.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.StepUpRequest true "Password and optional code"
+// @Success 200 {object} services.AuthTokenResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /login/step-up [post]
+func (h *AuthHandler) StepUp(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+	sessionID, _ := r.Context().Value(middleware.SessionIDKey).(string)
+	// Step-up codes are limited per token, which auth_time tells apart.
+	// Tokens from before auth_time was added were issued at login.
+	var authTime time.Time
+	if claims, ok := r.Context().Value(middleware.ClaimsKey).(*auth.Claims); ok {
+		switch {
+		case claims.AuthTime != nil:
+			authTime = claims.AuthTime.Time
+		case claims.IssuedAt != nil:
+			authTime = claims.IssuedAt.Time
+		}
+	}
+
+	var req models.StepUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	response, err := h.authService.StepUp(r.Context(), userID, sessionID, authTime, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidCredentials):
+			h.writeErrorResponse(w, r, "Password is incorrect", "INVALID_CREDENTIALS", http.StatusUnauthorized, nil)
+		case errors.Is(err, services.ErrSessionNotFound):
+			h.writeErrorResponse(w, r, "Session revoked", "SESSION_REVOKED", http.StatusUnauthorized, nil)
+		default:
+			h.writeMFAError(w, r, err)
+		}
+		return
+	}
+
+	// The stepped-up token replaces the one in the session cookie
+	if err := startCookieSession(w, h.cookies, response); err != nil {
+		logger.FromContext(r.Context()).Error("failed to start cookie session", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+// EnrollTOTP starts enrolling an authenticator app
+// @Summary Enroll authenticator app
+// @Description Generate a TOTP secret for the authenticated user. MFA is enabled once a code from the app is confirmed at /profile/mfa/totp/confirm.
+// @Tags user
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} services.MFAEnrollResponse
+// @Failure 401 {object} models.APIError
+// @Failure 409 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /profile/mfa/totp [post]
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	response, err := h.authService.EnrollTOTP(r.Context(), userID)
+	if err != nil {
+		h.writeMFAError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+// ConfirmTOTP enables the authenticator app being enrolled
+// @Summary Confirm authenticator app
+// @Description Enable MFA with a code from the authenticator app enrolled at POST /profile/mfa/totp
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.MFACodeRequest true "Code from the app"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 409 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /profile/mfa/totp/confirm [post]
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	h.mfaCode(w, r, h.authService.ConfirmTOTP)
+}
+
+// DisableTOTP removes the user's authenticator app
+// @Summary Disable authenticator app
+// @Description Disable MFA with a code from the authenticator app. Requires recent authentication.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.MFACodeRequest true "Code from the app"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 403 {object} models.APIError
+// @Failure 409 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /profile/mfa/totp [delete]
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	h.mfaCode(w, r, h.authService.DisableTOTP)
+}
+
+// mfaCode handles the requests that change MFA with a code from the app
+func (h *AuthHandler) mfaCode(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, userID string, req *models.MFACodeRequest) (*models.UserResponse, error)) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := action(r.Context(), userID, &req)
+	if err != nil {
+		h.writeMFAError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, user, http.StatusOK)
+}
+
+// writeMFAError writes the response for an error of the MFA flows
+func (h *AuthHandler) writeMFAError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErr):
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+	case errors.Is(err, services.ErrInvalidMFAToken):
+		h.writeErrorResponse(w, r, "Invalid or expired MFA token", "INVALID_MFA_TOKEN", http.StatusUnauthorized, nil)
+	case errors.Is(err, services.ErrInvalidMFACode):
+		h.writeErrorResponse(w, r, "Invalid code", "INVALID_MFA_CODE", http.StatusUnauthorized, nil)
+	case errors.Is(err, services.ErrMFAAttemptsExceeded):
+		h.writeErrorResponse(w, r, "Too many codes tried; log in again", "MFA_ATTEMPTS_EXCEEDED", http.StatusUnauthorized, nil)
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		h.writeErrorResponse(w, r, "MFA is already enabled", "MFA_ALREADY_ENABLED", http.StatusConflict, nil)
+	case errors.Is(err, services.ErrMFANotEnrolled):
+		h.writeErrorResponse(w, r, "No authenticator app is enrolled", "MFA_NOT_ENROLLED", http.StatusConflict, nil)
+	case errors.Is(err, services.ErrUserNotFound):
+		h.writeErrorResponse(w, r, "User not found", "USER_NOT_FOUND", http.StatusNotFound, nil)
+	default:
+		logger.FromContext(r.Context()).Error("mfa request failed", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+	}
+}
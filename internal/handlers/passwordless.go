@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/middleware"
+	"auth/internal/models"
+	"auth/internal/services"
+)
+
+// EmailLoginCookie binds a passwordless login to the browser that requested
+// it
+const EmailLoginCookie = "email_login"
+
+// emailLoginCookiePath limits the binding cookie to the passwordless
+// endpoints
+const emailLoginCookiePath = "/login/email"
+
+type PasswordlessHandler struct {
+	responder
+	passwordlessService *services.PasswordlessService
+	cookies             *middleware.Cookies
+	cookieConfig        config.CookieConfig
+	ttl                 time.Duration
+}
+
+// NewPasswordlessHandler creates the handler. ttl is how long the binding
+// cookie is kept, which should match the lifetime of a login. cookies may be
+// nil, in which case logins only return the token. cookieConfig applies to
+// the binding and device cookies either way.
+func NewPasswordlessHandler(passwordlessService *services.PasswordlessService, cookies *middleware.Cookies, cookieConfig config.CookieConfig, ttl time.Duration) *PasswordlessHandler {
+	return &PasswordlessHandler{
+		passwordlessService: passwordlessService,
+		cookies:             cookies,
+		cookieConfig:        cookieConfig,
+		ttl:                 ttl,
+	}
+}
+
+// RequestEmailLogin sends a magic link or code
+// @Summary Request passwordless login
+// @Description Send a magic link (method=link, the default) or a 6-digit code (method=code) to the account with the given email address. The response is the same whether or not the address has an account. Sets an email_login cookie: the login can only be redeemed from the same browser, once, before it expires.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.EmailLoginRequest true "Email address and method"
+// @Success 202 {object} services.EmailLoginResponse
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /login/email [post]
+func (h *PasswordlessHandler) RequestEmailLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.EmailLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	// A browser requesting several logins keeps its binding, so any of
+	// them can be redeemed
+	var binding string
+	if cookie, err := r.Cookie(EmailLoginCookie); err == nil {
+		binding = cookie.Value
+	}
+
+	response, err := h.passwordlessService.RequestEmailLogin(r.Context(), &req, binding)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     EmailLoginCookie,
+		Value:    response.Binding,
+		Path:     emailLoginCookiePath,
+		MaxAge:   int(h.ttl / time.Second),
+		HttpOnly: true,
+		Secure:   h.cookieConfig.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	h.writeJSONResponse(w, r, response, http.StatusAccepted)
+}
+
+// VerifyEmailLogin redeems a magic link or code
+// @Summary Complete passwordless login
+// @Description Log in with the token of a magic link, or with the challenge_id returned by POST /login/email and the code sent by email. Must come from the browser that requested the login. Responds like /login, including MFA_REQUIRED for risky logins to accounts with MFA.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.VerifyEmailLoginRequest true "Link token, or challenge ID and code"
+// @Success 200 {object} services.AuthTokenResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 403 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /login/email/verify [post]
+func (h *PasswordlessHandler) VerifyEmailLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyEmailLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	var binding string
+	if cookie, err := r.Cookie(EmailLoginCookie); err == nil {
+		binding = cookie.Value
+	}
+
+	response, err := h.passwordlessService.VerifyEmailLogin(r.Context(), &req, binding)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     EmailLoginCookie,
+		Path:     emailLoginCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.cookieConfig.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if err := startCookieSession(w, h.cookies, response); err != nil {
+		logger.FromContext(r.Context()).Error("failed to start cookie session", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+	setDeviceCookie(w, h.cookieConfig, response.DeviceID)
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+// writeError writes the response for an error of the passwordless flow
+func (h *PasswordlessHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr models.ValidationErrors
+	var mfaErr *services.MFARequiredError
+	switch {
+	case errors.As(err, &validationErr):
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+	case errors.Is(err, services.ErrPasswordlessDisabled):
+		h.writeErrorResponse(w, r, "Passwordless login is not enabled", "PASSWORDLESS_DISABLED", http.StatusNotFound, nil)
+	case errors.Is(err, services.ErrInvalidLoginChallenge), errors.Is(err, services.ErrInvalidCredentials):
+		// Deleted accounts fail like a wrong code
+		h.writeErrorResponse(w, r, "Invalid or expired login code", "INVALID_LOGIN_CODE", http.StatusUnauthorized, nil)
+	case errors.Is(err, services.ErrAccountDisabled):
+		h.writeErrorResponse(w, r, "Account disabled", "ACCOUNT_DISABLED", http.StatusForbidden, nil)
+	case errors.Is(err, services.ErrAccountLocked):
+		h.writeErrorResponse(w, r, "Account locked", "ACCOUNT_LOCKED", http.StatusForbidden, nil)
+	case errors.As(err, &mfaErr):
+		h.writeErrorResponse(w, r, "Second factor required", "MFA_REQUIRED", http.StatusUnauthorized, map[string]string{
+			"mfa_token":  mfaErr.Token,
+			"expires_at": mfaErr.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	case errors.Is(err, services.ErrLoginDenied):
+		h.writeErrorResponse(w, r, "Login denied", "LOGIN_DENIED", http.StatusForbidden, nil)
+	default:
+		logger.FromContext(r.Context()).Error("passwordless login failed", "error", err)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+	}
+}
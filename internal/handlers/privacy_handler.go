@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"auth/internal/middleware"
+	"auth/internal/models"
+	"auth/internal/services"
+)
+
+type PrivacyHandler struct {
+	responder
+	privacyService *services.PrivacyService
+}
+
+func NewPrivacyHandler(privacyService *services.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{
+		privacyService: privacyService,
+	}
+}
+
+// RequestExport starts a personal data export
+// @Summary Request data export
+// @Description Start building a bundle of all data held about the authenticated user. Poll GET /profile/export/{id} until the status is ready, then download it.
+// @Tags privacy
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.ExportRequest false "Bundle format (json or zip, default zip)"
+// @Success 202 {object} models.DataExport
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /profile/export [post]
+func (h *PrivacyHandler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	// The body is optional
+	var req models.ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+
+	export, err := h.privacyService.RequestExport(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, export, http.StatusAccepted)
+}
+
+// ListExports lists the user's data exports
+// @Summary List data exports
+// @Tags privacy
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} models.DataExport
+// @Failure 401 {object} models.APIError
+// @Router /profile/export [get]
+func (h *PrivacyHandler) ListExports(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	exports, err := h.privacyService.ListExports(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, exports, http.StatusOK)
+}
+
+// GetExport returns the status of a data export
+// @Summary Get data export
+// @Tags privacy
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Export ID"
+// @Success 200 {object} models.DataExport
+// @Failure 401 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Router /profile/export/{id} [get]
+func (h *PrivacyHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	export, err := h.privacyService.GetExport(r.Context(), userID, r.PathValue("id"))
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, export, http.StatusOK)
+}
+
+// DownloadExport downloads a finished data export
+// @Summary Download data export
+// @Tags privacy
+// @Produce application/zip
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Export ID"
+// @Success 200 {file} file
+// @Failure 401 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 409 {object} models.APIError
+// @Router /profile/export/{id}/download [get]
+func (h *PrivacyHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	export, err := h.privacyService.DownloadExport(r.Context(), userID, r.PathValue("id"))
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	contentType := "application/zip"
+	if export.Format == models.ExportFormatJSON {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.%s"`, export.ID, export.Format))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Data)
+}
+
+func (h *PrivacyHandler) writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErr):
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+	case errors.Is(err, services.ErrExportNotFound):
+		h.writeErrorResponse(w, r, "Export not found", "EXPORT_NOT_FOUND", http.StatusNotFound, nil)
+	case errors.Is(err, services.ErrExportNotReady):
+		h.writeErrorResponse(w, r, "Export is not ready", "EXPORT_NOT_READY", http.StatusConflict, nil)
+	default:
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auth/internal/logger"
+	"auth/internal/middleware/ratelimit"
+)
+
+type QuotaHandler struct {
+	responder
+	limiter *ratelimit.RateLimiter
+}
+
+func NewQuotaHandler(limiter *ratelimit.RateLimiter) *QuotaHandler {
+	return &QuotaHandler{
+		limiter: limiter,
+	}
+}
+
+// GetQuota shows the caller's plan and usage
+// @Summary Get quota
+// @Description Show the authenticated user's plan, its rate limits, and how much of the daily and monthly quotas is used. Quotas reset at midnight UTC and on the first of the month.
+// @Tags quota
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} ratelimit.QuotaStatus
+// @Failure 401 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /quota [get]
+func (h *QuotaHandler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	if h.limiter == nil {
+		h.writeErrorResponse(w, r, "Rate limiting is disabled", "RATE_LIMIT_DISABLED", http.StatusNotFound, nil)
+		return
+	}
+	principal, ok := h.limiter.Principal(r)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	status, err := h.limiter.Quota(r.Context(), principal)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to get quota", "error", err, "principal", principal.ID)
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.writeJSONResponse(w, r, status, http.StatusOK)
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"auth/internal/logger"
+	"auth/internal/middleware/ratelimit"
+	"auth/internal/models"
+	"auth/internal/services"
+)
+
+type RateLimitHandler struct {
+	responder
+	limiter      *ratelimit.RateLimiter
+	authService  *services.AuthService
+	auditService *services.AuditService
+}
+
+func NewRateLimitHandler(limiter *ratelimit.RateLimiter, authService *services.AuthService, auditService *services.AuditService) *RateLimitHandler {
+	return &RateLimitHandler{
+		limiter:      limiter,
+		authService:  authService,
+		auditService: auditService,
+	}
+}
+
+// GetStats lists the rate limits
+// @Summary List rate limits
+// @Description List each rate limit and how many clients have used part of it
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} ratelimit.LimitStats
+// @Failure 403 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/ratelimit [get]
+func (h *RateLimitHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w, r) {
+		return
+	}
+
+	stats, err := h.limiter.Stats(r.Context())
+	if err != nil {
+		h.writeLimiterError(w, r, err, "failed to get rate limit stats")
+		return
+	}
+
+	h.writeJSONResponse(w, r, stats, http.StatusOK)
+}
+
+// InspectClient shows a client's usage
+// @Summary Inspect client
+// @Description Show the buckets of a client address, or the buckets and quotas of a user
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param ip query string false "Client IP address"
+// @Param user_id query string false "User ID"
+// @Success 200 {object} ratelimit.ClientStatus
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/ratelimit/clients [get]
+func (h *RateLimitHandler) InspectClient(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w, r) {
+		return
+	}
+	client, ok := h.client(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := h.limiter.Inspect(r.Context(), client)
+	h.record(r, models.AuditActionRateLimitInspect, client.String(), nil, err)
+	if err != nil {
+		h.writeLimiterError(w, r, err, "failed to inspect rate limit client")
+		return
+	}
+
+	h.writeJSONResponse(w, r, status, http.StatusOK)
+}
+
+// ResetClient empties a client's buckets
+// @Summary Reset client
+// @Description Give a client address full buckets, or a user full buckets and unused quotas
+// @Tags admin
+// @Security ApiKeyAuth
+// @Param ip query string false "Client IP address"
+// @Param user_id query string false "User ID"
+// @Success 204
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/ratelimit/clients [delete]
+func (h *RateLimitHandler) ResetClient(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w, r) {
+		return
+	}
+	client, ok := h.client(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := h.limiter.Reset(r.Context(), client)
+	h.record(r, models.AuditActionRateLimitReset, client.String(), nil, err)
+	if err != nil {
+		h.writeLimiterError(w, r, err, "failed to reset rate limit client")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListRules lists the allow and deny rules
+// @Summary List rate limit rules
+// @Description List the addresses, networks and users that are allowed past rate limits or denied, excluding expired bans
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} ratelimit.Rule
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/ratelimit/rules [get]
+func (h *RateLimitHandler) ListRules(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w, r) {
+		return
+	}
+
+	rules, err := h.limiter.Rules(r.Context())
+	if err != nil {
+		h.writeLimiterError(w, r, err, "failed to list rate limit rules")
+		return
+	}
+
+	h.writeJSONResponse(w, r, rules, http.StatusOK)
+}
+
+// SetRule allows or denies a client
+// @Summary Set rate limit rule
+// @Description Allow an IP address, CIDR or user past rate limits and quotas, or deny its requests. A deny rule with a duration is a ban. It replaces any rule for the same target.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.RateLimitRuleRequest true "Rule"
+// @Success 200 {object} ratelimit.Rule
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/ratelimit/rules [put]
+func (h *RateLimitHandler) SetRule(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w, r) {
+		return
+	}
+	var req models.RateLimitRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "Invalid JSON format", "INVALID_JSON", http.StatusBadRequest, nil)
+		return
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil {
+			h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string{"duration": "duration must be like 30m or 24h"})
+			return
+		}
+	}
+
+	rule, err := h.limiter.SetRule(r.Context(), req.Target, req.Action, req.Reason, duration)
+	details := map[string]string{"action": req.Action}
+	if req.Duration != "" {
+		details["duration"] = req.Duration
+	}
+	target := req.Target
+	if rule != nil {
+		target = rule.Target
+	}
+	h.record(r, models.AuditActionRateLimitRuleSet, target, details, err)
+	if err != nil {
+		h.writeLimiterError(w, r, err, "failed to set rate limit rule")
+		return
+	}
+
+	h.writeJSONResponse(w, r, rule, http.StatusOK)
+}
+
+// DeleteRule removes the rule for a target
+// @Summary Delete rate limit rule
+// @Description Remove the allow or deny rule for an IP address, CIDR or user, lifting a ban
+// @Tags admin
+// @Security ApiKeyAuth
+// @Param target query string true "IP address, CIDR or user:<id>"
+// @Success 204
+// @Failure 400 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /admin/ratelimit/rules [delete]
+func (h *RateLimitHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w, r) {
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	deleted, err := h.limiter.DeleteRule(r.Context(), target)
+	if err == nil && !deleted {
+		h.writeErrorResponse(w, r, "Rule not found", "RULE_NOT_FOUND", http.StatusNotFound, nil)
+		return
+	}
+	h.record(r, models.AuditActionRateLimitRuleDelete, target, nil, err)
+	if err != nil {
+		h.writeLimiterError(w, r, err, "failed to delete rate limit rule")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enabled writes a not found response if rate limiting is disabled
+func (h *RateLimitHandler) enabled(w http.ResponseWriter, r *http.Request) bool {
+	if h.limiter == nil {
+		h.writeErrorResponse(w, r, "Rate limiting is disabled", "RATE_LIMIT_DISABLED", http.StatusNotFound, nil)
+		return false
+	}
+	return true
+}
+
+// client reads the client of an inspect or reset request. Users are looked
+// up for their plan, which sets their limits.
+func (h *RateLimitHandler) client(w http.ResponseWriter, r *http.Request) (ratelimit.Client, bool) {
+	q := r.URL.Query()
+	var principal ratelimit.Principal
+	if userID := q.Get("user_id"); userID != "" && q.Get("ip") == "" {
+		user, err := h.authService.GetUserByID(r.Context(), userID)
+		if err != nil {
+			if errors.Is(err, services.ErrUserNotFound) {
+				h.writeErrorResponse(w, r, "User not found", "USER_NOT_FOUND", http.StatusNotFound, nil)
+			} else {
+				h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+			}
+			return ratelimit.Client{}, false
+		}
+		principal = ratelimit.Principal{ID: ratelimit.UserPrincipal(user.ID), Plan: user.Plan}
+	} else if userID != "" {
+		principal.ID = ratelimit.UserPrincipal(userID)
+	}
+
+	client, err := ratelimit.NewClient(q.Get("ip"), principal)
+	if err != nil {
+		h.writeLimiterError(w, r, err, "invalid rate limit client")
+		return ratelimit.Client{}, false
+	}
+	return client, true
+}
+
+func (h *RateLimitHandler) record(r *http.Request, action, target string, details map[string]string, err error) {
+	h.auditService.Record(r.Context(), models.AuditEvent{
+		ActorID:  actorID(r),
+		Action:   action,
+		TargetID: target,
+		Details:  details,
+	}, err)
+}
+
+func (h *RateLimitHandler) writeLimiterError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	var validationErr models.ValidationErrors
+	if errors.As(err, &validationErr) {
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+		return
+	}
+	logger.FromContext(r.Context()).Error(msg, "error", err)
+	h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"auth/internal/logger"
+	"auth/internal/models"
+)
+
+// responder writes JSON responses and is embedded by every handler
+type responder struct{}
+
+func (h responder) writeJSONResponse(w http.ResponseWriter, r *http.Request, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.FromContext(r.Context()).Error("failed to encode JSON response", "error", err)
+	}
+}
+
+func (h responder) writeErrorResponse(w http.ResponseWriter, r *http.Request, message, code string, statusCode int, details map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	errorResponse := models.APIError{
+		Message: message,
+		Code:    code,
+		Details: details,
+	}
+
+	if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
+		logger.FromContext(r.Context()).Error("failed to encode error response", "error", err)
+	}
+}
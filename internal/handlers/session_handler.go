@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"auth/internal/middleware"
+	"auth/internal/models"
+	"auth/internal/services"
+)
+
+type SessionHandler struct {
+	responder
+	sessionService *services.SessionService
+}
+
+func NewSessionHandler(sessionService *services.SessionService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+	}
+}
+
+// ListSessions lists the user's sessions
+// @Summary List sessions
+// @Description List the devices the authenticated user is signed in on, newest first. With all=true, revoked and expired sessions are included as login history.
+// @Tags sessions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param all query bool false "Include ended sessions"
+// @Success 200 {object} services.ListSessionsResponse
+// @Failure 400 {object} models.APIError
+// @Failure 401 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /sessions [get]
+func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+	sessionID, _ := r.Context().Value(middleware.SessionIDKey).(string)
+
+	var includeEnded bool
+	if raw := r.URL.Query().Get("all"); raw != "" {
+		var err error
+		if includeEnded, err = strconv.ParseBool(raw); err != nil {
+			h.writeServiceError(w, r, models.ValidationErrors{"all": "all must be true or false"})
+			return
+		}
+	}
+
+	response, err := h.sessionService.List(r.Context(), userID, sessionID, includeEnded)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+// RevokeSession signs one of the user's sessions out
+// @Summary Revoke session
+// @Description Revoke one of the authenticated user's sessions. Its token is rejected from then on. Revoking the current session logs out.
+// @Tags sessions
+// @Security ApiKeyAuth
+// @Param id path string true "Session ID"
+// @Success 204
+// @Failure 401 {object} models.APIError
+// @Failure 404 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /sessions/{id} [delete]
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := h.sessionService.Revoke(r.Context(), userID, r.PathValue("id")); err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions signs the user out everywhere else
+// @Summary Revoke other sessions
+// @Description Revoke every session of the authenticated user except the one making the request
+// @Tags sessions
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} services.RevokeSessionsResponse
+// @Failure 401 {object} models.APIError
+// @Failure 500 {object} models.APIError
+// @Router /sessions/revoke-others [post]
+func (h *SessionHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		h.writeErrorResponse(w, r, "User not found in context", "NO_USER_CONTEXT", http.StatusUnauthorized, nil)
+		return
+	}
+	sessionID, _ := r.Context().Value(middleware.SessionIDKey).(string)
+
+	response, err := h.sessionService.RevokeOthers(r.Context(), userID, sessionID)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.writeJSONResponse(w, r, response, http.StatusOK)
+}
+
+func (h *SessionHandler) writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErr):
+		h.writeErrorResponse(w, r, "Validation failed", "VALIDATION_ERROR", http.StatusBadRequest, map[string]string(validationErr))
+	case errors.Is(err, services.ErrSessionNotFound):
+		h.writeErrorResponse(w, r, "Session not found", "SESSION_NOT_FOUND", http.StatusNotFound, nil)
+	default:
+		h.writeErrorResponse(w, r, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError, nil)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"auth/internal/middleware"
+)
+
+// SwaggerUI serves the Swagger UI of ui, adding the request's CSP nonce to
+// the inline script of its index page so it runs under a nonce-based policy
+func SwaggerUI(ui http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := middleware.CSPNonce(r.Context())
+		if nonce == "" || !strings.HasSuffix(r.URL.Path, "/index.html") {
+			ui.ServeHTTP(w, r)
+			return
+		}
+
+		page := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		ui.ServeHTTP(page, r)
+		body := bytes.ReplaceAll(page.body.Bytes(), []byte("<script"), []byte(`<script nonce="`+nonce+`"`))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(page.status)
+		w.Write(body)
+	})
+}
+
+// bufferedResponse holds a response so it can be changed before it is sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
@@ -0,0 +1,91 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"auth/internal/config"
+	"auth/internal/handlers"
+	"auth/internal/middleware"
+)
+
+const swaggerIndex = `<html><head><script src="./swagger-ui-bundle.js"></script></head>` +
+	`<body><script>window.onload = function() {}</script></body></html>`
+
+// swaggerFiles stands in for the Swagger UI file server
+var swaggerFiles = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/swagger/index.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(swaggerIndex))
+	case "/swagger/swagger-ui.css":
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte("<script>not html</script>"))
+	default:
+		http.NotFound(w, r)
+	}
+})
+
+func newSwaggerHandler(t *testing.T, htmlCSP string) http.Handler {
+	t.Helper()
+	headers, err := middleware.NewSecurityHeaders(config.SecurityHeadersConfig{Enabled: true, HTMLCSP: htmlCSP})
+	if err != nil {
+		t.Fatalf("NewSecurityHeaders() unexpected error: %v", err)
+	}
+	return headers.HTML(handlers.SwaggerUI(swaggerFiles))
+}
+
+func TestSwaggerUINonce(t *testing.T) {
+	handler := newSwaggerHandler(t, "script-src 'self' "+config.CSPNonce)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/swagger/index.html", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	match := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+	if match == nil {
+		t.Fatalf("Content-Security-Policy = %q has no nonce", rec.Header().Get("Content-Security-Policy"))
+	}
+	body := rec.Body.String()
+	// Both the bundle and the inline script carry the response's nonce
+	if got := strings.Count(body, `<script nonce="`+match[1]+`"`); got != 2 {
+		t.Errorf("index has %d scripts with the nonce, want 2:\n%s", got, body)
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+		t.Errorf("Content-Length = %s, body is %d bytes", got, len(body))
+	}
+
+	// Every response has its own nonce
+	again := httptest.NewRecorder()
+	handler.ServeHTTP(again, httptest.NewRequest(http.MethodGet, "/swagger/index.html", nil))
+	if strings.Contains(again.Body.String(), match[1]) {
+		t.Error("second response reused the nonce")
+	}
+}
+
+func TestSwaggerUIUnchanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		htmlCSP string
+		path    string
+		want    string
+	}{
+		{"other files", "script-src " + config.CSPNonce, "/swagger/swagger-ui.css", "<script>not html</script>"},
+		{"policy without a nonce", "script-src 'self'", "/swagger/index.html", swaggerIndex},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newSwaggerHandler(t, tt.htmlCSP).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
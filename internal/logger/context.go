@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"auth/internal/reqctx"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Context attributes. A log line written with a context carries the IDs of
+// the trace and request it belongs to and who made the request, so it can be
+// found from any of them.
+const (
+	TraceIDKey   = "trace_id"
+	SpanIDKey    = "span_id"
+	RequestIDKey = "request_id"
+	UserIDKey    = "user_id"
+	TenantIDKey  = "tenant_id"
+)
+
+// contextHandler adds the context attributes to each record it handles
+type contextHandler struct {
+	slog.Handler
+	// bound lists the context attributes already added with WithAttrs, such
+	// as by WithRequestID, which aren't added again
+	bound []string
+}
+
+// NewContextHandler wraps h to add the trace, span, request, user and tenant
+// IDs of the context each record is logged with. Attributes the call already
+// sets, such as the user_id of a failed login, are left as they are.
+func NewContextHandler(h slog.Handler) slog.Handler {
+	return &contextHandler{Handler: h}
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	set := func(key, value string) {
+		if value == "" || slices.Contains(h.bound, key) {
+			return
+		}
+		present := false
+		r.Attrs(func(a slog.Attr) bool {
+			present = a.Key == key
+			return !present
+		})
+		if !present {
+			r.AddAttrs(slog.String(key, value))
+		}
+	}
+
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		set(TraceIDKey, spanContext.TraceID().String())
+		set(SpanIDKey, spanContext.SpanID().String())
+	}
+	info := reqctx.FromContext(ctx)
+	set(RequestIDKey, info.RequestID)
+	set(UserIDKey, info.UserID)
+	set(TenantIDKey, info.Tenant)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	bound := slices.Clone(h.bound)
+	for _, a := range attrs {
+		bound = append(bound, a.Key)
+	}
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs), bound: bound}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name), bound: h.bound}
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying l, which FromContext returns
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+var defaultLogger atomic.Pointer[Logger]
+
+// SetDefault sets the logger FromContext returns for contexts that don't
+// carry one, such as those of background jobs
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+// FromContext returns the logger of ctx, or the default logger, writing its
+// lines with ctx so they carry the context attributes
+func FromContext(ctx context.Context) *Logger {
+	l, ok := ctx.Value(loggerKey{}).(*Logger)
+	if !ok {
+		if l = defaultLogger.Load(); l == nil {
+			l = New("info")
+			defaultLogger.CompareAndSwap(nil, l)
+		}
+	}
+	return &Logger{Logger: l.Logger, ctx: ctx}
+}
+
+// Debug logs at debug level with the logger's context
+func (l *Logger) Debug(msg string, args ...any) {
+	l.Logger.DebugContext(l.context(), msg, args...)
+}
+
+// Info logs at info level with the logger's context
+func (l *Logger) Info(msg string, args ...any) {
+	l.Logger.InfoContext(l.context(), msg, args...)
+}
+
+// Warn logs at warn level with the logger's context
+func (l *Logger) Warn(msg string, args ...any) {
+	l.Logger.WarnContext(l.context(), msg, args...)
+}
+
+// Error logs at error level with the logger's context
+func (l *Logger) Error(msg string, args ...any) {
+	l.Logger.ErrorContext(l.context(), msg, args...)
+}
+
+func (l *Logger) context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
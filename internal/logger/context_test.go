@@ -0,0 +1,93 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"auth/internal/logger"
+	"auth/internal/reqctx"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestContextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := &logger.Logger{Logger: slog.New(logger.NewContextHandler(slog.NewJSONHandler(&buf, nil)))}
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+	ctx = reqctx.WithInfo(ctx, reqctx.Info{RequestID: "req-1", Tenant: "acme"})
+	ctx = reqctx.WithUserID(ctx, "user-1")
+	ctx = logger.NewContext(ctx, log)
+
+	tests := []struct {
+		name string
+		log  func()
+		want map[string]string
+	}{
+		{
+			name: "context attributes",
+			log:  func() { logger.FromContext(ctx).Info("hello") },
+			want: map[string]string{
+				"trace_id":   spanContext.TraceID().String(),
+				"span_id":    spanContext.SpanID().String(),
+				"request_id": "req-1",
+				"user_id":    "user-1",
+				"tenant_id":  "acme",
+			},
+		},
+		{
+			name: "attributes of the call win",
+			log:  func() { logger.FromContext(ctx).Warn("login failed", "user_id", "user-2") },
+			want: map[string]string{"user_id": "user-2", "request_id": "req-1"},
+		},
+		{
+			name: "bound attributes win",
+			log:  func() { logger.FromContext(ctx).WithRequestID("req-2").Error("failed") },
+			want: map[string]string{"request_id": "req-2", "user_id": "user-1"},
+		},
+		{
+			name: "without a request",
+			log:  func() { log.Info("background") },
+			want: map[string]string{"request_id": "", "trace_id": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			tt.log()
+
+			// Duplicate keys would be kept by the JSON handler
+			var line map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("log line %q is not JSON: %v", buf.String(), err)
+			}
+			for key, want := range tt.want {
+				got, _ := line[key].(string)
+				if got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+				if want != "" && bytes.Count(buf.Bytes(), []byte(`"`+key+`"`)) != 1 {
+					t.Errorf("log line %s has %s more than once", buf.String(), key)
+				}
+			}
+		})
+	}
+}
+
+func TestFromContextDefault(t *testing.T) {
+	var buf bytes.Buffer
+	logger.SetDefault(&logger.Logger{Logger: slog.New(logger.NewContextHandler(slog.NewJSONHandler(&buf, nil)))})
+	defer logger.SetDefault(logger.New("error"))
+
+	ctx := reqctx.WithInfo(context.Background(), reqctx.Info{RequestID: "req-1"})
+	logger.FromContext(ctx).Info("job ran")
+	if !bytes.Contains(buf.Bytes(), []byte(`"request_id":"req-1"`)) {
+		t.Errorf("default logger wrote %q, want the request ID", buf.String())
+	}
+}
@@ -1,15 +1,29 @@
 package logger
 
 import (
+	"context"
 	"log/slog"
 	"os"
+
+	"auth/internal/config"
 )
 
 type Logger struct {
 	*slog.Logger
+	// ctx is the context the logger's lines are written with, set by
+	// FromContext
+	ctx context.Context
 }
 
+// New returns a logger that masks sensitive values
 func New(level string) *Logger {
+	l, _ := NewWithRedaction(level, config.RedactionConfig{Mode: RedactMask})
+	return l
+}
+
+// NewWithRedaction returns a logger that redacts sensitive values as cfg
+// configures
+func NewWithRedaction(level string, cfg config.RedactionConfig) (*Logger, error) {
 	var logLevel slog.Level
 	switch level {
 	case "debug":
@@ -28,20 +42,25 @@ func New(level string) *Logger {
 		Level: logLevel,
 	}
 
-	handler := slog.NewJSONHandler(os.Stdout, opts)
-	return &Logger{
-		Logger: slog.New(handler),
+	handler, err := NewRedactHandler(slog.NewJSONHandler(os.Stdout, opts), cfg)
+	if err != nil {
+		return nil, err
 	}
+	return &Logger{
+		Logger: slog.New(NewContextHandler(handler)),
+	}, nil
 }
 
 func (l *Logger) WithRequestID(requestID string) *Logger {
 	return &Logger{
-		Logger: l.Logger.With("request_id", requestID),
+		Logger: l.Logger.With(RequestIDKey, requestID),
+		ctx:    l.ctx,
 	}
 }
 
 func (l *Logger) WithUser(userID string) *Logger {
 	return &Logger{
-		Logger: l.Logger.With("user_id", userID),
+		Logger: l.Logger.With(UserIDKey, userID),
+		ctx:    l.ctx,
 	}
 }
\ No newline at end of file
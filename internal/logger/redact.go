@@ -0,0 +1,332 @@
+package logger
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"auth/internal/config"
+)
+
+// Redaction modes
+const (
+	RedactMask = "mask"
+	RedactHash = "hash"
+	RedactDrop = "drop"
+)
+
+// Redacted replaces masked values
+const Redacted = "[REDACTED]"
+
+// minRedactKeyLength is the shortest HMAC key the hash mode accepts
+const minRedactKeyLength = 32
+
+// maxRedactDepth bounds how deep composite values, which may be cyclic, are
+// walked. Anything deeper is masked.
+const maxRedactDepth = 8
+
+// secretFields name attributes that are always dropped. Hashing them would
+// let anyone holding the key test guesses of a password against the logs.
+var secretFields = []string{"password", "passwd", "secret", "privatekey"}
+
+// sensitiveFields name attributes redacted with the configured mode.
+// Attribute names are compared without case or separators, so apikey covers
+// api_key and API-Key, and token covers report_token.
+var sensitiveFields = []string{
+	"token", "jwt", "authorization", "bearer", "cookie", "csrf", "apikey",
+	"email", "username",
+}
+
+// sensitiveValues find secrets and personal data inside any string value and
+// message, whatever the attribute is called
+var sensitiveValues = []*regexp.Regexp{
+	// JWTs, whose header always starts with {"
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	// API keys of common providers: Stripe, GitHub, Slack and AWS
+	regexp.MustCompile(`\b(?:sk|pk|rk)_(?:live|test)_[A-Za-z0-9]{8,}`),
+	regexp.MustCompile(`\b(?:ghp|gho|ghu|ghs|ghr|github_pat)_[A-Za-z0-9_]{20,}`),
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`),
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+}
+
+// redactor applies the redaction rules to attribute values
+type redactor struct {
+	mode   string
+	key    []byte
+	fields []string
+}
+
+func newRedactor(cfg config.RedactionConfig) (*redactor, error) {
+	r := &redactor{mode: cfg.Mode, fields: sensitiveFields}
+	switch cfg.Mode {
+	case RedactMask, RedactDrop:
+	case RedactHash:
+		if len(cfg.Key) < minRedactKeyLength {
+			return nil, fmt.Errorf("log redaction key must be at least %d bytes for the hash mode", minRedactKeyLength)
+		}
+		r.key = []byte(cfg.Key)
+	default:
+		return nil, fmt.Errorf("unknown log redaction mode %q, want %s, %s or %s", cfg.Mode, RedactMask, RedactHash, RedactDrop)
+	}
+	for _, field := range cfg.Fields {
+		r.fields = append(r.fields, normalizeField(field))
+	}
+	return r, nil
+}
+
+func normalizeField(name string) string {
+	return strings.Map(func(c rune) rune {
+		if c == '_' || c == '-' || c == '.' || c == ' ' {
+			return -1
+		}
+		return c
+	}, strings.ToLower(name))
+}
+
+func matchesField(name string, fields []string) bool {
+	name = normalizeField(name)
+	for _, field := range fields {
+		if strings.Contains(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// replacement returns what value is replaced with. Values inside a message
+// can't be dropped, so the drop mode masks those.
+func (r *redactor) replacement(value string) string {
+	if r.mode != RedactHash {
+		return Redacted
+	}
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// redactString replaces the sensitive values found in s, and reports whether
+// it found any
+func (r *redactor) redactString(s string) (string, bool) {
+	found := false
+	for _, pattern := range sensitiveValues {
+		s = pattern.ReplaceAllStringFunc(s, func(match string) string {
+			found = true
+			return r.replacement(match)
+		})
+	}
+	return s, found
+}
+
+// redactAttr returns a with its sensitive values redacted, or false if it is
+// dropped
+func (r *redactor) redactAttr(a slog.Attr) (slog.Attr, bool) {
+	a.Value = a.Value.Resolve()
+	if matchesField(a.Key, secretFields) {
+		return a, false
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		var attrs []slog.Attr
+		for _, member := range a.Value.Group() {
+			if member, ok := r.redactAttr(member); ok {
+				attrs = append(attrs, member)
+			}
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(attrs...)}, len(attrs) > 0
+	}
+
+	if matchesField(a.Key, r.fields) {
+		if r.mode == RedactDrop {
+			return a, false
+		}
+		return slog.String(a.Key, r.replacement(a.Value.String())), true
+	}
+
+	// Strings, and errors and other values that are logged as text, are
+	// scanned for secrets they carry
+	var text string
+	switch v := a.Value.Any().(type) {
+	case string:
+		text = v
+	case error:
+		text = v.Error()
+	case fmt.Stringer:
+		text = v.String()
+	default:
+		if redacted, ok := r.redactComposite(v); ok {
+			return slog.Any(a.Key, redacted), true
+		}
+		return a, true
+	}
+	redacted, found := r.redactString(text)
+	if !found {
+		return a, true
+	}
+	if r.mode == RedactDrop {
+		return a, false
+	}
+	return slog.String(a.Key, redacted), true
+}
+
+// redactComposite returns a copy of a map, slice, array or struct, or of a
+// pointer to one, with its members redacted by their key or field name and
+// its strings by what they look like. Members are masked rather than the
+// whole value dropped. It returns false for any other value.
+func (r *redactor) redactComposite(value any) (any, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return value, false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		return r.redactValue(v, 0), true
+	}
+	return value, false
+}
+
+// redactValue returns v as maps of its keys or exported fields, slices of
+// its elements and redacted strings
+func (r *redactor) redactValue(v reflect.Value, depth int) any {
+	if depth > maxRedactDepth {
+		return Redacted
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	// Values that are logged as text are scanned as text
+	switch value := v.Interface().(type) {
+	case error:
+		return r.redactText(value.Error())
+	case fmt.Stringer:
+		return r.redactText(value.String())
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return r.redactValue(v.Elem(), depth+1)
+	case reflect.String:
+		return r.redactText(v.String())
+	case reflect.Map:
+		members := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			key := fmt.Sprint(iter.Key().Interface())
+			if member, ok := r.redactMember(key, iter.Value(), depth); ok {
+				members[key] = member
+			}
+		}
+		return members
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return r.redactText(string(v.Bytes()))
+		}
+		elems := make([]any, v.Len())
+		for i := range elems {
+			elems[i] = r.redactValue(v.Index(i), depth+1)
+		}
+		return elems
+	case reflect.Struct:
+		members := make(map[string]any)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if member, ok := r.redactMember(name, v.Field(i), depth); ok {
+				members[name] = member
+			}
+		}
+		return members
+	}
+	return v.Interface()
+}
+
+// redactMember applies the attribute name rules to a map key or field name,
+// and returns false if the member is dropped
+func (r *redactor) redactMember(name string, v reflect.Value, depth int) (any, bool) {
+	if matchesField(name, secretFields) {
+		return nil, false
+	}
+	if matchesField(name, r.fields) {
+		if r.mode == RedactDrop {
+			return nil, false
+		}
+		for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+			v = v.Elem()
+		}
+		return r.replacement(fmt.Sprint(v.Interface())), true
+	}
+	return r.redactValue(v, depth+1), true
+}
+
+func (r *redactor) redactText(s string) string {
+	redacted, _ := r.redactString(s)
+	return redacted
+}
+
+// redactHandler redacts records before passing them to the handler it wraps
+type redactHandler struct {
+	slog.Handler
+	redactor *redactor
+}
+
+// NewRedactHandler wraps h to keep secrets and personal data out of what it
+// writes. Attributes are redacted by name, such as password or email, and
+// values by what they look like, such as JWTs, bearer tokens, emails and API
+// keys, wherever they appear. Maps, slices and structs are walked, and their
+// keys and field names redacted like attribute names.
+func NewRedactHandler(h slog.Handler, cfg config.RedactionConfig) (slog.Handler, error) {
+	r, err := newRedactor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &redactHandler{Handler: h, redactor: r}, nil
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	msg, _ := h.redactor.redactString(r.Message)
+	redacted := slog.NewRecord(r.Time, r.Level, msg, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		if a, ok := h.redactor.redactAttr(a); ok {
+			redacted.AddAttrs(a)
+		}
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var redacted []slog.Attr
+	for _, a := range attrs {
+		if a, ok := h.redactor.redactAttr(a); ok {
+			redacted = append(redacted, a)
+		}
+	}
+	return &redactHandler{Handler: h.Handler.WithAttrs(redacted), redactor: h.redactor}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{Handler: h.Handler.WithGroup(name), redactor: h.redactor}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+)
+
+// Cookies keeps browser sessions in an HttpOnly cookie instead of a bearer
+// token the frontend has to store, and protects requests authenticated by it
+// against cross-site request forgery. A nil *Cookies authenticates from the
+// Authorization header only.
+type Cookies struct {
+	config   config.CookieConfig
+	secret   string
+	sameSite http.SameSite
+}
+
+// NewCookies checks the cookie configuration. It returns nil when the token
+// source is the Authorization header only.
+func NewCookies(cfg config.CookieConfig, secret string) (*Cookies, error) {
+	switch cfg.TokenSource {
+	case config.TokenSourceHeader:
+		return nil, nil
+	case config.TokenSourceCookie, config.TokenSourceBoth:
+	default:
+		return nil, fmt.Errorf("invalid token source %q, want header, cookie or both", cfg.TokenSource)
+	}
+
+	var sameSite http.SameSite
+	switch strings.ToLower(cfg.SameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	case "none":
+		// Browsers drop SameSite=None cookies that are not Secure
+		if !cfg.Secure {
+			return nil, fmt.Errorf("SameSite=None cookies must be Secure")
+		}
+		sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("invalid SameSite %q, want strict, lax or none", cfg.SameSite)
+	}
+
+	switch cfg.CSRFMode {
+	case config.CSRFModeDoubleSubmit, config.CSRFModeSynchronizer:
+	default:
+		return nil, fmt.Errorf("invalid CSRF mode %q, want double_submit or synchronizer", cfg.CSRFMode)
+	}
+	if cfg.Name == "" || cfg.CSRFHeader == "" || (cfg.CSRFMode == config.CSRFModeDoubleSubmit && cfg.CSRFCookie == "") {
+		return nil, fmt.Errorf("cookie and CSRF header names must be set")
+	}
+	return &Cookies{config: cfg, secret: secret, sameSite: sameSite}, nil
+}
+
+// Enabled reports whether logins start a cookie session
+func (c *Cookies) Enabled() bool {
+	return c != nil
+}
+
+// Exclusive reports whether the cookie is the only way to authenticate, in
+// which case tokens are kept out of response bodies
+func (c *Cookies) Exclusive() bool {
+	return c != nil && c.config.TokenSource == config.TokenSourceCookie
+}
+
+// StartSession sets the session cookie holding token, and the CSRF cookie in
+// double-submit mode. It returns the CSRF token the client must send with
+// state-changing requests.
+func (c *Cookies) StartSession(w http.ResponseWriter, token, sessionID string, expiresAt time.Time) (string, error) {
+	csrfToken, err := c.RefreshCSRF(w, sessionID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, c.cookie(c.config.Name, token, expiresAt, true))
+	return csrfToken, nil
+}
+
+// RefreshCSRF issues a new CSRF token for the session, so a page that lost
+// it can fetch another
+func (c *Cookies) RefreshCSRF(w http.ResponseWriter, sessionID string, expiresAt time.Time) (string, error) {
+	csrfToken, err := auth.GenerateCSRFToken(sessionID, c.secret)
+	if err != nil {
+		return "", err
+	}
+	if c.config.CSRFMode == config.CSRFModeDoubleSubmit {
+		// The frontend reads this cookie to echo it, so it is not HttpOnly
+		http.SetCookie(w, c.cookie(c.config.CSRFCookie, csrfToken, expiresAt, false))
+	}
+	return csrfToken, nil
+}
+
+// EndSession clears the session and CSRF cookies
+func (c *Cookies) EndSession(w http.ResponseWriter) {
+	if c == nil {
+		return
+	}
+	expired := c.cookie(c.config.Name, "", time.Time{}, true)
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+	if c.config.CSRFMode == config.CSRFModeDoubleSubmit {
+		expired = c.cookie(c.config.CSRFCookie, "", time.Time{}, false)
+		expired.MaxAge = -1
+		http.SetCookie(w, expired)
+	}
+}
+
+func (c *Cookies) cookie(name, value string, expiresAt time.Time, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   c.config.Domain,
+		Path:     c.config.Path,
+		HttpOnly: httpOnly,
+		Secure:   c.config.Secure,
+		SameSite: c.sameSite,
+	}
+	if !expiresAt.IsZero() {
+		cookie.Expires = expiresAt
+		cookie.MaxAge = max(int(time.Until(expiresAt).Seconds()), 1)
+	}
+	return cookie
+}
+
+// token returns the access token of the session cookie
+func (c *Cookies) token(r *http.Request) string {
+	if c == nil {
+		return ""
+	}
+	cookie, err := r.Cookie(c.config.Name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// headerAllowed reports whether the Authorization header is accepted
+func (c *Cookies) headerAllowed() bool {
+	return c == nil || c.config.TokenSource == config.TokenSourceBoth
+}
+
+// checkCSRF reports whether a request authenticated by the session cookie
+// carries a valid CSRF token for sessionID. Safe methods don't need one.
+func (c *Cookies) checkCSRF(r *http.Request, sessionID string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	token := r.Header.Get(c.config.CSRFHeader)
+	if !auth.ValidateCSRFToken(token, sessionID, c.secret) {
+		return false
+	}
+	if c.config.CSRFMode == config.CSRFModeDoubleSubmit {
+		cookie, err := r.Cookie(c.config.CSRFCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) != 1 {
+			return false
+		}
+	}
+	return true
+}
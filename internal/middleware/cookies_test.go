@@ -0,0 +1,234 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/middleware"
+)
+
+const testSecret = "test-secret"
+
+func cookieConfig(source, csrfMode string) config.CookieConfig {
+	return config.CookieConfig{
+		TokenSource: source,
+		Name:        "access_token",
+		Path:        "/",
+		Secure:      true,
+		SameSite:    "lax",
+		CSRFMode:    csrfMode,
+		CSRFCookie:  "csrf_token",
+		CSRFHeader:  "X-CSRF-Token",
+	}
+}
+
+// newJWTHandler returns a handler behind JWT that writes the authenticated
+// user's ID
+func newJWTHandler(t *testing.T, cookies *middleware.Cookies) http.Handler {
+	t.Helper()
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: testSecret}}
+	mw := middleware.New(cfg, logger.New("error"), nil, nil, nil, cookies, nil, nil)
+	return mw.JWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
+		w.Write([]byte(userID))
+	}))
+}
+
+func newToken(t *testing.T, userID, sessionID string) string {
+	t.Helper()
+	token, err := auth.GenerateJWT(&auth.Claims{Username: userID, UserID: userID, SessionID: sessionID}, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT() unexpected error: %v", err)
+	}
+	return token
+}
+
+// session is a browser's cookie session
+type session struct {
+	cookies   []*http.Cookie
+	csrfToken string
+}
+
+func startSession(t *testing.T, cookies *middleware.Cookies, userID, sessionID string) session {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	csrfToken, err := cookies.StartSession(rec, newToken(t, userID, sessionID), sessionID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("StartSession() unexpected error: %v", err)
+	}
+	return session{cookies: rec.Result().Cookies(), csrfToken: csrfToken}
+}
+
+func (s session) cookie(name string) *http.Cookie {
+	for _, cookie := range s.cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestNewCookies(t *testing.T) {
+	if cookies, err := middleware.NewCookies(cookieConfig(config.TokenSourceHeader, config.CSRFModeDoubleSubmit), testSecret); cookies != nil || err != nil {
+		t.Errorf("NewCookies() with the header source = %v, %v, want nil, nil", cookies, err)
+	}
+
+	invalid := map[string]func(*config.CookieConfig){
+		"token source":               func(c *config.CookieConfig) { c.TokenSource = "query" },
+		"SameSite":                   func(c *config.CookieConfig) { c.SameSite = "loose" },
+		"SameSite none not secure":   func(c *config.CookieConfig) { c.SameSite, c.Secure = "none", false },
+		"CSRF mode":                  func(c *config.CookieConfig) { c.CSRFMode = "referer" },
+		"no cookie name":             func(c *config.CookieConfig) { c.Name = "" },
+		"no CSRF header":             func(c *config.CookieConfig) { c.CSRFHeader = "" },
+		"double submit no CSRF name": func(c *config.CookieConfig) { c.CSRFCookie = "" },
+	}
+	for name, change := range invalid {
+		t.Run(name, func(t *testing.T) {
+			cfg := cookieConfig(config.TokenSourceCookie, config.CSRFModeDoubleSubmit)
+			change(&cfg)
+			if _, err := middleware.NewCookies(cfg, testSecret); err == nil {
+				t.Errorf("NewCookies(%+v) expected an error", cfg)
+			}
+		})
+	}
+}
+
+func TestStartSessionCookies(t *testing.T) {
+	for _, mode := range []string{config.CSRFModeDoubleSubmit, config.CSRFModeSynchronizer} {
+		t.Run(mode, func(t *testing.T) {
+			cookies, err := middleware.NewCookies(cookieConfig(config.TokenSourceCookie, mode), testSecret)
+			if err != nil {
+				t.Fatalf("NewCookies() unexpected error: %v", err)
+			}
+			s := startSession(t, cookies, "user-1", "session-1")
+
+			access := s.cookie("access_token")
+			if access == nil || !access.HttpOnly || !access.Secure || access.SameSite != http.SameSiteLaxMode {
+				t.Errorf("session cookie = %+v, want HttpOnly, Secure and SameSite=Lax", access)
+			}
+			csrf := s.cookie("csrf_token")
+			if mode == config.CSRFModeSynchronizer {
+				if csrf != nil {
+					t.Errorf("synchronizer mode set a CSRF cookie %+v", csrf)
+				}
+				return
+			}
+			// The frontend has to read the CSRF cookie to echo it
+			if csrf == nil || csrf.HttpOnly || csrf.Value != s.csrfToken {
+				t.Errorf("CSRF cookie = %+v, want readable with the returned token", csrf)
+			}
+		})
+	}
+}
+
+func TestJWTCookieCSRF(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		method string
+		// header and cookie are the CSRF header and cookie sent; "valid" is
+		// the session's token
+		header   string
+		cookie   string
+		wantCode int
+	}{
+		{"double submit safe method", config.CSRFModeDoubleSubmit, http.MethodGet, "", "", http.StatusOK},
+		{"double submit head", config.CSRFModeDoubleSubmit, http.MethodHead, "", "", http.StatusOK},
+		{"double submit valid", config.CSRFModeDoubleSubmit, http.MethodPost, "valid", "valid", http.StatusOK},
+		{"double submit missing token", config.CSRFModeDoubleSubmit, http.MethodPost, "", "valid", http.StatusForbidden},
+		{"double submit missing cookie", config.CSRFModeDoubleSubmit, http.MethodDelete, "valid", "", http.StatusForbidden},
+		{"double submit other session", config.CSRFModeDoubleSubmit, http.MethodPut, "other", "other", http.StatusForbidden},
+		{"double submit mismatch", config.CSRFModeDoubleSubmit, http.MethodPost, "valid", "other", http.StatusForbidden},
+		{"double submit forged", config.CSRFModeDoubleSubmit, http.MethodPost, "forged", "forged", http.StatusForbidden},
+		{"synchronizer safe method", config.CSRFModeSynchronizer, http.MethodGet, "", "", http.StatusOK},
+		{"synchronizer valid", config.CSRFModeSynchronizer, http.MethodPost, "valid", "", http.StatusOK},
+		{"synchronizer missing token", config.CSRFModeSynchronizer, http.MethodPatch, "", "", http.StatusForbidden},
+		{"synchronizer other session", config.CSRFModeSynchronizer, http.MethodPost, "other", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies, err := middleware.NewCookies(cookieConfig(config.TokenSourceCookie, tt.mode), testSecret)
+			if err != nil {
+				t.Fatalf("NewCookies() unexpected error: %v", err)
+			}
+			handler := newJWTHandler(t, cookies)
+			s := startSession(t, cookies, "user-1", "session-1")
+			other := startSession(t, cookies, "user-1", "session-2")
+			tokens := map[string]string{"valid": s.csrfToken, "other": other.csrfToken, "forged": "bm9uY2U.bWFj"}
+
+			req := httptest.NewRequest(tt.method, "/profile", nil)
+			req.AddCookie(s.cookie("access_token"))
+			if tt.header != "" {
+				req.Header.Set("X-CSRF-Token", tokens[tt.header])
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "csrf_token", Value: tokens[tt.cookie]})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("%s = %d, want %d: %s", tt.method, rec.Code, tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWTTokenSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		// header and cookie are the users whose tokens are sent
+		header   string
+		cookie   string
+		method   string
+		wantCode int
+		wantUser string
+	}{
+		{"both prefers the header", config.TokenSourceBoth, "alice", "bob", http.MethodGet, http.StatusOK, "alice"},
+		{"both header skips CSRF", config.TokenSourceBoth, "alice", "bob", http.MethodPost, http.StatusOK, "alice"},
+		{"both falls back to the cookie", config.TokenSourceBoth, "", "bob", http.MethodGet, http.StatusOK, "bob"},
+		{"both cookie needs CSRF", config.TokenSourceBoth, "", "bob", http.MethodPost, http.StatusForbidden, ""},
+		{"both invalid header", config.TokenSourceBoth, "malformed", "bob", http.MethodGet, http.StatusUnauthorized, ""},
+		{"both neither", config.TokenSourceBoth, "", "", http.MethodGet, http.StatusUnauthorized, ""},
+		{"cookie ignores the header", config.TokenSourceCookie, "alice", "bob", http.MethodGet, http.StatusOK, "bob"},
+		{"cookie header only", config.TokenSourceCookie, "alice", "", http.MethodGet, http.StatusUnauthorized, ""},
+		{"header ignores the cookie", config.TokenSourceHeader, "", "bob", http.MethodGet, http.StatusUnauthorized, ""},
+		{"header", config.TokenSourceHeader, "alice", "bob", http.MethodPost, http.StatusOK, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies, err := middleware.NewCookies(cookieConfig(tt.source, config.CSRFModeDoubleSubmit), testSecret)
+			if err != nil {
+				t.Fatalf("NewCookies() unexpected error: %v", err)
+			}
+			handler := newJWTHandler(t, cookies)
+
+			req := httptest.NewRequest(tt.method, "/profile", nil)
+			switch tt.header {
+			case "":
+			case "malformed":
+				req.Header.Set("Authorization", "Token "+newToken(t, "alice", "session-a"))
+			default:
+				req.Header.Set("Authorization", "Bearer "+newToken(t, tt.header, "session-a"))
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: newToken(t, tt.cookie, "session-b")})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode == http.StatusOK && rec.Body.String() != tt.wantUser {
+				t.Errorf("authenticated as %q, want %q", rec.Body.String(), tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"auth/internal/config"
+)
+
+// CORSRoute sets the methods and request headers cross-origin requests may
+// use on a path. Like ServeMux patterns, a path ending in a slash covers the
+// paths below it, and the longest matching path wins.
+type CORSRoute struct {
+	Path    string
+	Methods []string
+	Headers []string
+}
+
+// CORS is the cross-origin policy: which origins may call the API and what
+// each route lets them send. Paths without a route are same-origin only.
+type CORS struct {
+	origins originSet
+	// tenants are the origins each tenant allows on top of origins
+	tenants     map[string]originSet
+	credentials bool
+	headers     []string
+	exposed     string
+	maxAge      string
+	routes      []CORSRoute
+}
+
+// originSet is a list of allowed origins
+type originSet struct {
+	any   bool
+	exact map[string]bool
+	// wildcards are origins with a *. subdomain, split around the *
+	wildcards []originPattern
+}
+
+type originPattern struct {
+	prefix string // such as https://
+	suffix string // such as .example.com:8443
+}
+
+// NewCORS checks the configured origins, those allowed for every tenant and
+// each tenant's own, and builds the policy for routes
+func NewCORS(cfg config.CORSConfig, tenants []config.TenantConfig, routes []CORSRoute) (*CORS, error) {
+	c := &CORS{
+		tenants:     make(map[string]originSet),
+		credentials: cfg.AllowCredentials,
+		headers:     cfg.AllowedHeaders,
+		exposed:     strings.Join(cfg.ExposedHeaders, ", "),
+		maxAge:      strconv.Itoa(int(cfg.MaxAge.Seconds())),
+		routes:      make([]CORSRoute, len(routes)),
+	}
+
+	var err error
+	if c.origins, err = parseOrigins(cfg.AllowedOrigins, cfg.AllowCredentials); err != nil {
+		return nil, err
+	}
+	for _, tenant := range tenants {
+		if c.tenants[tenant.Name], err = parseOrigins(tenant.CORSOrigins, cfg.AllowCredentials); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenant.Name, err)
+		}
+	}
+
+	for i, route := range routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			return nil, fmt.Errorf("invalid CORS route %q", route.Path)
+		}
+		methods := make([]string, len(route.Methods))
+		for j, method := range route.Methods {
+			methods[j] = strings.ToUpper(method)
+		}
+		c.routes[i] = CORSRoute{Path: route.Path, Methods: methods, Headers: route.Headers}
+	}
+	return c, nil
+}
+
+func parseOrigins(origins []string, credentials bool) (originSet, error) {
+	set := originSet{exact: make(map[string]bool)}
+	for _, origin := range origins {
+		if origin == "*" {
+			// Browsers refuse credentials with *, and reflecting any origin
+			// with credentials would let every site act as the user
+			if credentials {
+				return set, fmt.Errorf("origin * can't be allowed with credentials")
+			}
+			set.any = true
+			continue
+		}
+
+		origin = strings.ToLower(origin)
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return set, fmt.Errorf("invalid origin %q, want scheme://host[:port]", origin)
+		}
+		if host, ok := strings.CutPrefix(u.Host, "*."); ok {
+			if strings.Contains(host, "*") || !strings.Contains(host, ".") {
+				return set, fmt.Errorf("invalid origin %q, a wildcard must be followed by a domain", origin)
+			}
+			set.wildcards = append(set.wildcards, originPattern{prefix: u.Scheme + "://", suffix: "." + host})
+			continue
+		}
+		if strings.Contains(u.Host, "*") {
+			return set, fmt.Errorf("invalid origin %q, only a leading *. is allowed", origin)
+		}
+		set.exact[origin] = true
+	}
+	return set, nil
+}
+
+// allowedOrigin reports whether origin may make cross-origin requests to
+// tenant
+func (c *CORS) allowedOrigin(tenant, origin string) bool {
+	if c == nil || origin == "" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	return c.origins.allows(origin) || c.tenants[tenant].allows(origin)
+}
+
+// allows reports whether the set includes the lower-case origin
+func (s originSet) allows(origin string) bool {
+	if s.any || s.exact[origin] {
+		return true
+	}
+	for _, pattern := range s.wildcards {
+		// The * stands for one or more labels, never for nothing
+		if sub, ok := strings.CutPrefix(origin, pattern.prefix); ok {
+			if label, ok := strings.CutSuffix(sub, pattern.suffix); ok && validSubdomain(label) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validSubdomain reports whether label is one or more DNS labels, so a
+// wildcard can't match across a port, path or userinfo
+func validSubdomain(label string) bool {
+	if label == "" || strings.HasPrefix(label, ".") || strings.HasSuffix(label, ".") || strings.Contains(label, "..") {
+		return false
+	}
+	for _, r := range label {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// route returns the route covering path, or nil
+func (c *CORS) route(path string) *CORSRoute {
+	if c == nil {
+		return nil
+	}
+	var match *CORSRoute
+	for i := range c.routes {
+		route := &c.routes[i]
+		covers := path == route.Path ||
+			(strings.HasSuffix(route.Path, "/") && strings.HasPrefix(path, route.Path))
+		if covers && (match == nil || len(route.Path) > len(match.Path)) {
+			match = route
+		}
+	}
+	return match
+}
+
+// allowedHeaders reports whether every header of a preflight's
+// Access-Control-Request-Headers may be sent to route
+func (c *CORS) allowedHeaders(route *CORSRoute, requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		allowed := func(h string) bool { return strings.EqualFold(h, header) }
+		if !slices.ContainsFunc(route.Headers, allowed) && !slices.ContainsFunc(c.headers, allowed) {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin sets the headers that let origin read the response
+func (c *CORS) setOrigin(w http.ResponseWriter, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if c.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
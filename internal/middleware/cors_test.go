@@ -0,0 +1,250 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/middleware"
+)
+
+var corsRoutes = []middleware.CORSRoute{
+	{Path: "/login", Methods: []string{"POST"}, Headers: []string{"Content-Type"}},
+	{Path: "/profile/", Methods: []string{"GET", "PUT"}, Headers: []string{"Content-Type", "Authorization"}},
+}
+
+// newCORSHandler returns a handler behind RequestID and CORS that answers
+// 200 to anything CORS lets through
+func newCORSHandler(t *testing.T, cfg config.CORSConfig, tenants []config.TenantConfig) http.Handler {
+	t.Helper()
+	cors, err := middleware.NewCORS(cfg, tenants, corsRoutes)
+	if err != nil {
+		t.Fatalf("NewCORS() unexpected error: %v", err)
+	}
+	appConfig := &config.Config{Audit: config.AuditConfig{Tenant: "default"}, Tenants: tenants}
+	mw := middleware.New(appConfig, logger.New("error"), nil, nil, nil, nil, cors, nil)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mw.RequestID(mw.CORS(ok))
+}
+
+func preflight(host, path, origin, method, headers string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, "http://"+host+path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestNewCORSInvalidOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.CORSConfig
+		tenants []config.TenantConfig
+	}{
+		{"any origin with credentials", config.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, nil},
+		{"tenant any origin with credentials", config.CORSConfig{AllowCredentials: true},
+			[]config.TenantConfig{{Name: "acme", CORSOrigins: []string{"*"}}}},
+		{"no scheme", config.CORSConfig{AllowedOrigins: []string{"app.example.com"}}, nil},
+		{"other scheme", config.CORSConfig{AllowedOrigins: []string{"ftp://app.example.com"}}, nil},
+		{"path", config.CORSConfig{AllowedOrigins: []string{"https://app.example.com/login"}}, nil},
+		{"userinfo", config.CORSConfig{AllowedOrigins: []string{"https://user@app.example.com"}}, nil},
+		{"wildcard without a domain", config.CORSConfig{AllowedOrigins: []string{"https://*.com"}}, nil},
+		{"inner wildcard", config.CORSConfig{AllowedOrigins: []string{"https://app.*.example.com"}}, nil},
+		{"two wildcards", config.CORSConfig{AllowedOrigins: []string{"https://*.*.example.com"}}, nil},
+		{"invalid tenant origin", config.CORSConfig{},
+			[]config.TenantConfig{{Name: "acme", CORSOrigins: []string{"acme.example.com"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := middleware.NewCORS(tt.cfg, tt.tenants, corsRoutes); err == nil {
+				t.Errorf("NewCORS(%+v, %+v) expected an error", tt.cfg, tt.tenants)
+			}
+		})
+	}
+
+	if _, err := middleware.NewCORS(config.CORSConfig{AllowedOrigins: []string{"*"}}, nil, corsRoutes); err != nil {
+		t.Errorf("NewCORS() of * without credentials unexpected error: %v", err)
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	cfg := config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.net", "http://*.example.org:8080"},
+		AllowCredentials: true,
+	}
+	tenants := []config.TenantConfig{
+		{Name: "acme", Hosts: []string{"api.acme.test"}, CORSOrigins: []string{"https://acme.example.com", "https://*.acme.io"}},
+		{Name: "globex", Hosts: []string{"api.globex.test", "API.Globex.example"}},
+	}
+	handler := newCORSHandler(t, cfg, tenants)
+
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   bool
+	}{
+		{"exact", "api.test", "https://app.example.com", true},
+		{"exact in another case", "api.test", "HTTPS://App.Example.com", true},
+		{"other scheme", "api.test", "http://app.example.com", false},
+		{"other port", "api.test", "https://app.example.com:8443", false},
+		{"other host", "api.test", "https://evil.example", false},
+		{"suffix of an allowed host", "api.test", "https://evilapp.example.com", false},
+		{"wildcard subdomain", "api.test", "https://app.example.net", true},
+		{"wildcard nested subdomain", "api.test", "https://a.b-c.example.net", true},
+		{"wildcard with a port", "api.test", "http://app.example.org:8080", true},
+		{"wildcard other port", "api.test", "http://app.example.org", false},
+		{"wildcard apex", "api.test", "https://example.net", false},
+		{"wildcard empty label", "api.test", "https://.example.net", false},
+		{"wildcard empty inner label", "api.test", "https://a..example.net", false},
+		{"wildcard across a path", "api.test", "https://evil.test/.example.net", false},
+		{"wildcard across userinfo", "api.test", "https://evil.test@x.example.net", false},
+		{"wildcard across a port", "api.test", "https://evil.test:1.example.net", false},
+		{"wildcard with an underscore", "api.test", "https://a_b.example.net", false},
+		{"tenant origin on its host", "api.acme.test", "https://acme.example.com", true},
+		{"tenant wildcard on its host", "api.acme.test", "https://shop.acme.io", true},
+		{"tenant host with a port", "api.acme.test:8443", "https://acme.example.com", true},
+		{"shared origin on a tenant host", "api.acme.test", "https://app.example.com", true},
+		{"tenant origin on another tenant", "api.globex.test", "https://acme.example.com", false},
+		{"tenant origin on the default tenant", "api.test", "https://shop.acme.io", false},
+		{"tenant host in another case", "api.globex.example", "https://app.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, preflight(tt.host, "/login", tt.origin, "POST", "content-type"))
+
+			allowed := rec.Code == http.StatusNoContent
+			if allowed != tt.want {
+				t.Fatalf("preflight from %s to %s = %d, want allowed %v", tt.origin, tt.host, rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); allowed && got != tt.origin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.origin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); allowed && got != "true" {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler := newCORSHandler(t, config.CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedHeaders: []string{"X-Trace"},
+		MaxAge:         5 * time.Minute,
+	}, nil)
+	const origin = "https://app.example.com"
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		wantCode int
+	}{
+		{"allowed", preflight("api.test", "/login", origin, "POST", "Content-Type"), http.StatusNoContent},
+		{"route below a subtree", preflight("api.test", "/profile/email", origin, "PUT", "authorization, x-trace"), http.StatusNoContent},
+		{"origin not allowed", preflight("api.test", "/login", "https://evil.example", "POST", ""), http.StatusForbidden},
+		{"path without a route", preflight("api.test", "/swagger/", origin, "GET", ""), http.StatusForbidden},
+		{"method not allowed", preflight("api.test", "/login", origin, "DELETE", ""), http.StatusForbidden},
+		{"header not allowed", preflight("api.test", "/login", origin, "POST", "Content-Type, Authorization"), http.StatusForbidden},
+		{"subtree root not covered", preflight("api.test", "/profile", origin, "GET", ""), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tt.req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("preflight = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			vary := rec.Header().Values("Vary")
+			for _, header := range []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"} {
+				if !slices.Contains(vary, header) {
+					t.Errorf("Vary = %v, want %s", vary, header)
+				}
+			}
+			if tt.wantCode == http.StatusForbidden {
+				if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+					t.Errorf("rejected preflight Access-Control-Allow-Origin = %q", got)
+				}
+				return
+			}
+			if got := rec.Header().Get("Access-Control-Max-Age"); got != "300" {
+				t.Errorf("Access-Control-Max-Age = %q, want 300", got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Headers"); got == "" {
+				t.Error("Access-Control-Allow-Headers is missing")
+			}
+		})
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	handler := newCORSHandler(t, config.CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		ExposedHeaders: []string{"X-Request-ID"},
+	}, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		origin     string
+		wantOrigin string
+	}{
+		{"allowed", http.MethodPost, "/login", "https://app.example.com", "https://app.example.com"},
+		{"same origin", http.MethodPost, "/login", "", ""},
+		{"origin not allowed", http.MethodPost, "/login", "https://evil.example", ""},
+		{"method not on the route", http.MethodGet, "/login", "https://app.example.com", ""},
+		{"path without a route", http.MethodGet, "/health", "https://app.example.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://api.test"+tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			// The response is served either way; browsers withhold it
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200", rec.Code)
+			}
+			// Every response varies by origin, even those without CORS headers
+			if !slices.Contains(rec.Header().Values("Vary"), "Origin") {
+				t.Errorf("Vary = %v, want Origin", rec.Header().Values("Vary"))
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			exposed := rec.Header().Get("Access-Control-Expose-Headers")
+			if (tt.wantOrigin != "") != (exposed == "X-Request-ID") {
+				t.Errorf("Access-Control-Expose-Headers = %q with origin %q", exposed, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+				t.Errorf("Access-Control-Allow-Credentials = %q without credentials configured", got)
+			}
+		})
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	handler := newCORSHandler(t, config.CORSConfig{AllowedOrigins: []string{"*"}}, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, preflight("api.test", "/login", "https://anywhere.example", "POST", ""))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://anywhere.example" {
+		t.Errorf("preflight = %d, %v, want any origin allowed", rec.Code, rec.Header())
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want none with *", got)
+	}
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth/internal/config"
+)
+
+// cspNonceKey holds the CSP nonce of an HTML response
+const cspNonceKey contextKey = "csp_nonce"
+
+// CSPNonce returns the nonce inline scripts of the request's HTML response
+// must carry, or "" if its policy doesn't use one
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey).(string)
+	return nonce
+}
+
+// SecurityHeaders sets the headers that tell browsers to only use HTTPS, not
+// to sniff or frame responses, and what pages may load. API is the preset
+// for JSON routes; HTML replaces it on pages browsers render.
+type SecurityHeaders struct {
+	hsts    string
+	api     http.Header
+	html    http.Header
+	htmlCSP string
+}
+
+// NewSecurityHeaders checks the configuration. It returns nil when security
+// headers are disabled, and a nil *SecurityHeaders sets none.
+func NewSecurityHeaders(cfg config.SecurityHeadersConfig) (*SecurityHeaders, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	h := &SecurityHeaders{htmlCSP: cfg.HTMLCSP}
+	if cfg.HSTSMaxAge < 0 {
+		return nil, fmt.Errorf("HSTS max age can't be negative")
+	}
+	if cfg.HSTSMaxAge > 0 {
+		h.hsts = "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge/time.Second))
+		if cfg.HSTSIncludeSubdomains {
+			h.hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			// The preload lists reject anything weaker
+			if cfg.HSTSMaxAge < 365*24*time.Hour || !cfg.HSTSIncludeSubdomains {
+				return nil, fmt.Errorf("HSTS preload requires a max age of at least a year and includeSubDomains")
+			}
+			h.hsts += "; preload"
+		}
+	} else if cfg.HSTSPreload {
+		return nil, fmt.Errorf("HSTS preload requires HSTS")
+	}
+	if strings.Contains(cfg.APICSP, config.CSPNonce) {
+		return nil, fmt.Errorf("the API Content-Security-Policy can't use a nonce")
+	}
+
+	common := http.Header{}
+	common.Set("X-Content-Type-Options", "nosniff")
+	common.Set("X-Frame-Options", "DENY")
+	setIfNotEmpty(common, "Referrer-Policy", cfg.ReferrerPolicy)
+	setIfNotEmpty(common, "Permissions-Policy", cfg.PermissionsPolicy)
+	setIfNotEmpty(common, "Cross-Origin-Opener-Policy", cfg.CrossOriginOpenerPolicy)
+	setIfNotEmpty(common, "Cross-Origin-Embedder-Policy", cfg.CrossOriginEmbedderPolicy)
+
+	h.api = common.Clone()
+	setIfNotEmpty(h.api, "Content-Security-Policy", cfg.APICSP)
+	// The HTML policy is set per response, since it may carry a nonce
+	h.html = common.Clone()
+	return h, nil
+}
+
+func setIfNotEmpty(header http.Header, key, value string) {
+	if value != "" {
+		header.Set(key, value)
+	}
+}
+
+// API sets the headers of JSON responses
+func (h *SecurityHeaders) API(next http.Handler) http.Handler {
+	if h == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.set(w, h.api)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HTML sets the headers of pages, replacing those of API. When the HTML
+// policy uses a nonce, a new one is generated for every response and can be
+// read with CSPNonce.
+func (h *SecurityHeaders) HTML(next http.Handler) http.Handler {
+	if h == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.set(w, h.html)
+		csp := h.htmlCSP
+		if strings.Contains(csp, config.CSPNonce) {
+			nonce, err := newCSPNonce()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			csp = strings.ReplaceAll(csp, config.CSPNonce, "'nonce-"+nonce+"'")
+			r = r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce))
+		}
+		if csp != "" {
+			w.Header().Set("Content-Security-Policy", csp)
+		} else {
+			w.Header().Del("Content-Security-Policy")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *SecurityHeaders) set(w http.ResponseWriter, preset http.Header) {
+	if h.hsts != "" {
+		w.Header().Set("Strict-Transport-Security", h.hsts)
+	}
+	for key, values := range preset {
+		w.Header()[key] = values
+	}
+}
+
+// newCSPNonce returns 128 random bits, base64 encoded
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,154 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/middleware"
+)
+
+const year = 365 * 24 * time.Hour
+
+var noncePattern = regexp.MustCompile(`'nonce-([A-Za-z0-9+/=]+)'`)
+
+func headersConfig() config.SecurityHeadersConfig {
+	return config.SecurityHeadersConfig{
+		Enabled:               true,
+		HSTSMaxAge:            year,
+		HSTSIncludeSubdomains: true,
+		APICSP:                "default-src 'none'",
+		HTMLCSP:               "default-src 'self'; script-src 'self' " + config.CSPNonce,
+		ReferrerPolicy:        "no-referrer",
+	}
+}
+
+func TestNewSecurityHeadersInvalid(t *testing.T) {
+	invalid := map[string]func(*config.SecurityHeadersConfig){
+		"negative max age":           func(c *config.SecurityHeadersConfig) { c.HSTSMaxAge = -time.Second },
+		"preload without HSTS":       func(c *config.SecurityHeadersConfig) { c.HSTSMaxAge, c.HSTSPreload = 0, true },
+		"preload under a year":       func(c *config.SecurityHeadersConfig) { c.HSTSMaxAge, c.HSTSPreload = year-time.Second, true },
+		"preload without subdomains": func(c *config.SecurityHeadersConfig) { c.HSTSIncludeSubdomains, c.HSTSPreload = false, true },
+		"nonce in the API policy":    func(c *config.SecurityHeadersConfig) { c.APICSP = "script-src " + config.CSPNonce },
+	}
+	for name, change := range invalid {
+		t.Run(name, func(t *testing.T) {
+			cfg := headersConfig()
+			change(&cfg)
+			if _, err := middleware.NewSecurityHeaders(cfg); err == nil {
+				t.Errorf("NewSecurityHeaders(%+v) expected an error", cfg)
+			}
+		})
+	}
+
+	cfg := headersConfig()
+	cfg.HSTSPreload = true
+	headers, err := middleware.NewSecurityHeaders(cfg)
+	if err != nil {
+		t.Fatalf("NewSecurityHeaders() of a valid preload config unexpected error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	headers.API(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains; preload" {
+		t.Errorf("Strict-Transport-Security = %q", got)
+	}
+}
+
+func TestSecurityHeadersDisabled(t *testing.T) {
+	headers, err := middleware.NewSecurityHeaders(config.SecurityHeadersConfig{})
+	if headers != nil || err != nil {
+		t.Fatalf("NewSecurityHeaders() disabled = %v, %v, want nil, nil", headers, err)
+	}
+	rec := httptest.NewRecorder()
+	headers.HTML(headers.API(http.NotFoundHandler())).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(rec.Header().Values("Content-Security-Policy")) != 0 || rec.Header().Get("X-Frame-Options") != "" {
+		t.Errorf("disabled headers set %v", rec.Header())
+	}
+}
+
+func TestSecurityHeadersHTMLOverridesAPI(t *testing.T) {
+	headers, err := middleware.NewSecurityHeaders(headersConfig())
+	if err != nil {
+		t.Fatalf("NewSecurityHeaders() unexpected error: %v", err)
+	}
+	var nonce string
+	page := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = middleware.CSPNonce(r.Context())
+	})
+	// As routed: API wraps every response and HTML the pages inside it
+	handler := headers.API(headers.HTML(page))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/swagger/index.html", nil))
+
+	policies := rec.Header().Values("Content-Security-Policy")
+	if len(policies) != 1 {
+		t.Fatalf("Content-Security-Policy = %q, want only the HTML policy", policies)
+	}
+	if want := "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'"; nonce == "" || policies[0] != want {
+		t.Errorf("Content-Security-Policy = %q, want %q", policies[0], want)
+	}
+	for key, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "no-referrer",
+	} {
+		if got := rec.Header().Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// API responses keep the API policy and have no nonce
+	rec = httptest.NewRecorder()
+	headers.API(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profile", nil))
+	if got := rec.Header().Get("Content-Security-Policy"); got != "default-src 'none'" {
+		t.Errorf("API Content-Security-Policy = %q", got)
+	}
+}
+
+func TestSecurityHeadersNoncePerResponse(t *testing.T) {
+	headers, err := middleware.NewSecurityHeaders(headersConfig())
+	if err != nil {
+		t.Fatalf("NewSecurityHeaders() unexpected error: %v", err)
+	}
+	handler := headers.HTML(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(middleware.CSPNonce(r.Context())))
+	}))
+
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login/report", nil))
+
+		match := noncePattern.FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+		if match == nil {
+			t.Fatalf("Content-Security-Policy = %q has no nonce", rec.Header().Get("Content-Security-Policy"))
+		}
+		// The page is given the nonce its policy allows
+		if match[1] != rec.Body.String() {
+			t.Errorf("CSPNonce() = %q, policy nonce %q", rec.Body.String(), match[1])
+		}
+		if seen[match[1]] {
+			t.Errorf("nonce %q reused", match[1])
+		}
+		seen[match[1]] = true
+	}
+
+	// Without {nonce} in the policy no nonce is made
+	cfg := headersConfig()
+	cfg.HTMLCSP = "default-src 'self'"
+	if headers, err = middleware.NewSecurityHeaders(cfg); err != nil {
+		t.Fatalf("NewSecurityHeaders() unexpected error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	headers.HTML(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(middleware.CSPNonce(r.Context())))
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Body.Len() != 0 || rec.Header().Get("Content-Security-Policy") != "default-src 'self'" {
+		t.Errorf("policy without a nonce gave nonce %q and policy %q", rec.Body.String(), rec.Header().Get("Content-Security-Policy"))
+	}
+}
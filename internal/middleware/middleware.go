@@ -2,25 +2,82 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"auth/internal/auth"
+	"auth/internal/clientip"
 	"auth/internal/config"
 	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/reqctx"
+	"auth/internal/risk"
 	"github.com/google/uuid"
 )
 
+// AccountLookup reports the current status of a user account, so tokens
+// issued before an account was disabled or deleted stop working
+type AccountLookup interface {
+	AccountStatus(ctx context.Context, userID string) (string, error)
+}
+
+// SessionLookup reports whether the login session a token was issued for is
+// still active, so revoked sessions can't be used
+type SessionLookup interface {
+	SessionActive(ctx context.Context, userID, sessionID string) (bool, error)
+}
+
+// StepUpPolicy decides how strongly a user must have authenticated to
+// perform a sensitive operation. It returns risk.ErrDenied for operations
+// that are refused outright.
+type StepUpPolicy interface {
+	RequiredACR(ctx context.Context, userID, operation string) (string, error)
+}
+
 type Middleware struct {
-	config *config.Config
-	logger *logger.Logger
+	config   *config.Config
+	logger   *logger.Logger
+	accounts AccountLookup
+	sessions SessionLookup
+	stepUp   StepUpPolicy
+	cookies  *Cookies
+	cors     *CORS
+	proxies  *clientip.Resolver
+	// tenants are the tenant names by host
+	tenants map[string]string
 }
 
-func New(cfg *config.Config, logger *logger.Logger) *Middleware {
+// New creates the middleware. accounts and sessions may be nil, in which case
+// JWT trusts any valid token until it expires. stepUp may be nil, in which
+// case RequireStepUp only checks how recently the user authenticated.
+// cookies may be nil, in which case JWT only reads the Authorization header.
+// cors may be nil, in which case no cross-origin requests are allowed.
+// proxies may be nil, in which case forwarding headers are ignored.
+func New(cfg *config.Config, logger *logger.Logger, accounts AccountLookup, sessions SessionLookup, stepUp StepUpPolicy, cookies *Cookies, cors *CORS, proxies *clientip.Resolver) *Middleware {
+	tenants := make(map[string]string)
+	for _, tenant := range cfg.Tenants {
+		for _, host := range tenant.Hosts {
+			// The first tenant listed with a host gets it
+			if _, ok := tenants[strings.ToLower(host)]; !ok {
+				tenants[strings.ToLower(host)] = tenant.Name
+			}
+		}
+	}
 	return &Middleware{
-		config: cfg,
-		logger: logger,
+		config:   cfg,
+		logger:   logger,
+		accounts: accounts,
+		sessions: sessions,
+		stepUp:   stepUp,
+		cookies:  cookies,
+		cors:     cors,
+		proxies:  proxies,
+		tenants:  tenants,
 	}
 }
 
@@ -30,18 +87,102 @@ const (
 	RequestIDKey contextKey = "request_id"
 	UserIDKey    contextKey = "user_id"
 	UsernameKey  contextKey = "username"
+	RoleKey      contextKey = "role"
+	SessionIDKey contextKey = "session_id"
+	// ClaimsKey holds the *auth.Claims of the request's token
+	ClaimsKey contextKey = "claims"
+)
+
+// PasswordChangePath is the only route a token issued for an account with a
+// forced password reset may call
+const PasswordChangePath = "/profile/password"
+
+// Routes an account pending deletion may still call, so the user can see the
+// schedule, cancel it, or take a copy of their data first
+const (
+	ProfilePath = "/profile"
+	RestorePath = "/profile/restore"
+	ExportPath  = "/profile/export"
 )
 
-// RequestID adds a unique request ID to each request
+// RequestID adds a unique request ID to each request, along with the client
+// IP, user agent and tenant that services record in the audit log. The client
+// IP is resolved here once, so everything after agrees on it. A trusted proxy's
+// X-Request-ID is kept, so a request can be followed across services; other
+// clients can't choose the ID their requests are logged and audited with.
 func (m *Middleware) RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := uuid.New().String()
+		requestID := r.Header.Get("X-Request-ID")
+		if !m.proxies.TrustsPeer(r) || !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
 		ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
+		ctx = reqctx.WithInfo(ctx, reqctx.Info{
+			RequestID: requestID,
+			IP:        m.proxies.Resolve(r),
+			UserAgent: r.UserAgent(),
+			DeviceID:  deviceID(r),
+			Tenant:    m.tenant(r),
+		})
+		ctx = logger.NewContext(ctx, m.logger)
 		w.Header().Set("X-Request-ID", requestID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// tenant returns the tenant whose hosts include the one the request was sent
+// to, as a trusted proxy's X-Forwarded-Host tells, or the default tenant
+func (m *Middleware) tenant(r *http.Request) string {
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" && m.proxies.TrustsPeer(r) {
+		host, _, _ = strings.Cut(forwarded, ",")
+	}
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if tenant, ok := m.tenants[strings.ToLower(host)]; ok {
+		return tenant
+	}
+	return m.config.Audit.Tenant
+}
+
+// maxRequestIDLength is the longest upstream request ID that is kept
+const maxRequestIDLength = 128
+
+// validRequestID reports whether id is a request ID safe to log and audit,
+// made of letters, digits and the separators of common ID formats
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// DeviceCookie is the cookie that recognizes a browser on later logins
+const DeviceCookie = "device_id"
+
+// deviceID returns the device cookie of the request, ignoring values that
+// were not issued by this service
+func deviceID(r *http.Request) string {
+	cookie, err := r.Cookie(DeviceCookie)
+	if err != nil {
+		return ""
+	}
+	if _, err := uuid.Parse(cookie.Value); err != nil || len(cookie.Value) != 36 {
+		return ""
+	}
+	return cookie.Value
+}
+
 // Logging logs all HTTP requests
 func (m *Middleware) Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -50,20 +191,20 @@ func (m *Middleware) Logging(next http.Handler) http.Handler {
 		// Create a response writer wrapper to capture status code
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		
-		requestID := r.Context().Value(RequestIDKey).(string)
-		logger := m.logger.WithRequestID(requestID)
+		log := logger.FromContext(r.Context())
 		
-		logger.Info("request started",
+		log.Info("request started",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"remote_addr", r.RemoteAddr,
+			"client_ip", clientip.FromRequest(r),
 			"user_agent", r.UserAgent(),
 		)
 		
 		next.ServeHTTP(wrapped, r)
 		
 		duration := time.Since(start)
-		logger.Info("request completed",
+		log.Info("request completed",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"status", wrapped.statusCode,
@@ -82,64 +223,262 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// CORS handles Cross-Origin Resource Sharing
+// CORS lets the origins allowed for every tenant, and those of the request's
+// tenant, call the routes of the CORS policy. Responses
+// to other origins carry no CORS headers, so browsers keep them from the
+// page, and their preflights are rejected.
 func (m *Middleware) CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Max-Age", "86400")
+		// Whether CORS headers are sent depends on the origin, so caches
+		// must not hand one origin's response to another
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		tenant := reqctx.FromContext(r.Context()).Tenant
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodOptions && origin != "" && requestedMethod != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			route := m.cors.route(r.URL.Path)
+			requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if !m.cors.allowedOrigin(tenant, origin) || route == nil ||
+				!slices.Contains(route.Methods, requestedMethod) ||
+				!m.cors.allowedHeaders(route, requestedHeaders) {
+				logger.FromContext(r.Context()).Warn("CORS preflight rejected",
+					"origin", origin,
+					"path", r.URL.Path,
+					"method", requestedMethod,
+					"headers", requestedHeaders,
+				)
+				m.writeErrorResponse(w, "CORS request not allowed", http.StatusForbidden)
+				return
+			}
+
+			m.cors.setOrigin(w, origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(route.Methods, ", "))
+			if headers := slices.Concat(route.Headers, m.cors.headers); len(headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			}
+			w.Header().Set("Access-Control-Max-Age", m.cors.maxAge)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		if route := m.cors.route(r.URL.Path); route != nil && m.cors.allowedOrigin(tenant, origin) &&
+			slices.Contains(route.Methods, r.Method) {
+			m.cors.setOrigin(w, origin)
+			if m.cors.exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", m.cors.exposed)
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// JWT validates JWT tokens and adds user context
+// JWT validates JWT tokens and adds user context. The token is taken from the
+// Authorization header or the session cookie, as configured; state-changing
+// requests authenticated by the cookie must carry a CSRF token.
 func (m *Middleware) JWT(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			m.writeErrorResponse(w, "Authorization header required", http.StatusUnauthorized)
+		tokenString, fromCookie, errMessage := m.accessToken(r)
+		if errMessage != "" {
+			m.writeErrorResponse(w, errMessage, http.StatusUnauthorized)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			m.writeErrorResponse(w, "Invalid authorization header format", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := parts[1]
 		claims, err := auth.ValidateJWT(tokenString, m.config.JWT.Secret)
 		if err != nil {
-			requestID := r.Context().Value(RequestIDKey).(string)
-			m.logger.WithRequestID(requestID).Warn("invalid token", "error", err)
+			logger.FromContext(r.Context()).Warn("invalid token", "error", err)
 			m.writeErrorResponse(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
+		if fromCookie && !m.cookies.checkCSRF(r, claims.SessionID) {
+			logger.FromContext(r.Context()).Warn("invalid csrf token", "user_id", claims.UserID, "path", r.URL.Path)
+			m.writeErrorResponse(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		if claims.PasswordResetRequired && r.URL.Path != PasswordChangePath {
+			m.writeErrorResponse(w, "Password reset required", http.StatusForbidden)
+			return
+		}
+
+		if !m.checkAccount(w, r, claims) || !m.checkSession(w, r, claims) {
+			return
+		}
+
 		// Add user info to context
 		ctx := context.WithValue(r.Context(), UsernameKey, claims.Username)
 		if claims.UserID != "" {
 			ctx = context.WithValue(ctx, UserIDKey, claims.UserID)
+			ctx = reqctx.WithUserID(ctx, claims.UserID)
+		}
+		ctx = context.WithValue(ctx, RoleKey, claims.Role)
+		if claims.SessionID != "" {
+			ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
 		}
+		ctx = context.WithValue(ctx, ClaimsKey, claims)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// accessToken returns the request's token and whether it came from the
+// session cookie, or the message to reject the request with. The
+// Authorization header wins when both are accepted and present.
+func (m *Middleware) accessToken(r *http.Request) (string, bool, string) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" && m.cookies.headerAllowed() {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return "", false, "Invalid authorization header format"
+		}
+		return parts[1], false, ""
+	}
+	if token := m.cookies.token(r); token != "" {
+		return token, true, ""
+	}
+	if !m.cookies.Enabled() {
+		return "", false, "Authorization header required"
+	}
+	return "", false, "Authentication required"
+}
+
+// checkAccount enforces the account's current status and writes an error
+// response if the request may not proceed
+func (m *Middleware) checkAccount(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	if m.accounts == nil || claims.UserID == "" {
+		return true
+	}
+
+	status, err := m.accounts.AccountStatus(r.Context(), claims.UserID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to check account status", "error", err, "user_id", claims.UserID)
+		m.writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	switch status {
+	case models.StatusActive:
+		return true
+	case models.StatusPendingDeletion:
+		if allowedPendingDeletion(r) {
+			return true
+		}
+		m.writeErrorResponse(w, "Account is scheduled for deletion", http.StatusForbidden)
+	case models.StatusDisabled:
+		m.writeErrorResponse(w, "Account disabled", http.StatusForbidden)
+	case models.StatusLocked:
+		m.writeErrorResponse(w, "Account locked", http.StatusForbidden)
+	default:
+		m.writeErrorResponse(w, "Invalid token", http.StatusUnauthorized)
+	}
+	return false
+}
+
+// checkSession rejects tokens whose session was revoked or has ended, and
+// tokens issued without a session
+func (m *Middleware) checkSession(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	if m.sessions == nil {
+		return true
+	}
+	if claims.SessionID == "" {
+		m.writeErrorResponse(w, "Invalid token", http.StatusUnauthorized)
+		return false
+	}
+
+	active, err := m.sessions.SessionActive(r.Context(), claims.UserID, claims.SessionID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to check session", "error", err, "session_id", claims.SessionID)
+		m.writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !active {
+		m.writeErrorResponse(w, "Session revoked", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func allowedPendingDeletion(r *http.Request) bool {
+	switch {
+	case r.URL.Path == ProfilePath:
+		return r.Method == http.MethodGet
+	case r.URL.Path == RestorePath:
+		return true
+	case r.URL.Path == ExportPath, strings.HasPrefix(r.URL.Path, ExportPath+"/"):
+		return true
+	}
+	return false
+}
+
+// RequirePermission rejects requests whose authenticated role lacks perm.
+// It must run after JWT.
+func (m *Middleware) RequirePermission(perm auth.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value(RoleKey).(string)
+			if !auth.HasPermission(role, perm) {
+				userID, _ := r.Context().Value(UserIDKey).(string)
+				logger.FromContext(r.Context()).Warn("permission denied", "user_id", userID, "permission", perm, "path", r.URL.Path)
+				m.writeErrorResponse(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireStepUp rejects requests for a sensitive operation unless the user
+// authenticated recently enough, and with a second factor if the risk of the
+// operation calls for one. The client steps up at /login/step-up and retries
+// with the new token. It must run after JWT.
+func (m *Middleware) RequireStepUp(operation string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(ClaimsKey).(*auth.Claims)
+			if !ok {
+				m.writeErrorResponse(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			required := auth.ACRPassword
+			if m.stepUp != nil {
+				var err error
+				required, err = m.stepUp.RequiredACR(r.Context(), claims.UserID, operation)
+				if errors.Is(err, risk.ErrDenied) {
+					m.writeErrorResponse(w, "Operation denied by risk policy", http.StatusForbidden)
+					return
+				}
+				if err != nil {
+					logger.FromContext(r.Context()).Error("failed to assess operation risk", "error", err, "operation", operation)
+					m.writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			maxAge := m.config.Risk.StepUpMaxAge
+			recent := claims.AuthTime != nil && time.Since(claims.AuthTime.Time) <= maxAge
+			if !recent || !auth.ACRSatisfies(claims.ACR, required) {
+				// RFC 9470 tells the client what authentication to come back with
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+					`Bearer error="insufficient_user_authentication", error_description="Step-up authentication required", acr_values="%s", max_age=%d`,
+					required, int(maxAge.Seconds())))
+				m.writeErrorResponse(w, "Step-up authentication required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // Recovery recovers from panics
 func (m *Middleware) Recovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				requestID := r.Context().Value(RequestIDKey).(string)
-				m.logger.WithRequestID(requestID).Error("panic recovered", "error", err)
+				logger.FromContext(r.Context()).Error("panic recovered", "error", err)
 				m.writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}()
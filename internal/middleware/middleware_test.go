@@ -0,0 +1,124 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"auth/internal/clientip"
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/middleware"
+	"auth/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// newRequestIDHandler returns a handler behind RequestID that trusts proxies
+// at 10.0.0.1 and records the request's info
+func newRequestIDHandler(t *testing.T, tenants []config.TenantConfig, info *reqctx.Info) http.Handler {
+	t.Helper()
+	proxies, err := clientip.New([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("clientip.New() unexpected error: %v", err)
+	}
+	cfg := &config.Config{Audit: config.AuditConfig{Tenant: "default"}, Tenants: tenants}
+	mw := middleware.New(cfg, logger.New("error"), nil, nil, nil, nil, nil, proxies)
+	return mw.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*info = reqctx.FromContext(r.Context())
+	}))
+}
+
+func TestRequestID(t *testing.T) {
+	const (
+		proxy  = "10.0.0.1:1234"
+		client = "192.0.2.1:1234"
+	)
+	tests := []struct {
+		name       string
+		remoteAddr string
+		requestID  string
+		want       bool
+	}{
+		{"trusted proxy", proxy, "trace-1234", true},
+		{"trusted proxy UUID", proxy, "0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{"common separators", proxy, "svc:edge.01_a-b", true},
+		{"longest allowed", proxy, strings.Repeat("a", 128), true},
+		{"untrusted peer", client, "trace-1234", false},
+		{"too long", proxy, strings.Repeat("a", 129), false},
+		{"newline", proxy, "trace\nforged log line", false},
+		{"space", proxy, "trace 1234", false},
+		{"quote", proxy, `trace"1234`, false},
+		{"non-ASCII", proxy, "tracé", false},
+		{"empty", proxy, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info reqctx.Info
+			handler := newRequestIDHandler(t, nil, &info)
+
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get("X-Request-ID")
+			if got != info.RequestID {
+				t.Errorf("X-Request-ID = %q, request info has %q", got, info.RequestID)
+			}
+			if tt.want {
+				if got != tt.requestID {
+					t.Errorf("X-Request-ID = %q, want the upstream %q", got, tt.requestID)
+				}
+				return
+			}
+			// Anything not accepted is replaced by a new ID
+			if _, err := uuid.Parse(got); err != nil || got == tt.requestID {
+				t.Errorf("X-Request-ID = %q, want a new UUID", got)
+			}
+		})
+	}
+}
+
+func TestRequestIDTenant(t *testing.T) {
+	tenants := []config.TenantConfig{
+		{Name: "acme", Hosts: []string{"auth.acme.test"}},
+		{Name: "globex", Hosts: []string{"auth.globex.test"}},
+	}
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		host          string
+		forwardedHost string
+		want          string
+	}{
+		{"host", "192.0.2.1:1234", "auth.acme.test", "", "acme"},
+		{"host with a port", "192.0.2.1:1234", "auth.acme.test:8443", "", "acme"},
+		{"unknown host", "192.0.2.1:1234", "auth.other.test", "", "default"},
+		{"forwarded by a trusted proxy", "10.0.0.1:1234", "internal.test", "auth.globex.test", "globex"},
+		{"first forwarded host", "10.0.0.1:1234", "internal.test", "auth.globex.test, auth.acme.test", "globex"},
+		{"forwarded host with a port", "10.0.0.1:1234", "internal.test", "AUTH.Globex.test:443", "globex"},
+		{"forwarded by an untrusted peer", "192.0.2.1:1234", "auth.acme.test", "auth.globex.test", "acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info reqctx.Info
+			handler := newRequestIDHandler(t, tenants, &info)
+
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Host = tt.host
+			if tt.forwardedHost != "" {
+				req.Header.Set("X-Forwarded-Host", tt.forwardedHost)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if info.Tenant != tt.want {
+				t.Errorf("tenant = %q, want %q", info.Tenant, tt.want)
+			}
+		})
+	}
+}
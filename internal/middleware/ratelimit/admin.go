@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"auth/internal/models"
+)
+
+// LimitStats describes one limit and how many clients are using it
+type LimitStats struct {
+	LimitType string `json:"limit_type"`
+	LimitStatus
+	// ActiveClients counts the clients whose bucket isn't full
+	ActiveClients int `json:"active_clients"`
+}
+
+// Client identifies a client to inspect or reset: the principal of an
+// authenticated client, or the address of anonymous ones
+type Client struct {
+	IP        string
+	Principal Principal
+}
+
+// ClientStatus is the state of a client's buckets and quotas
+type ClientStatus struct {
+	Client  string         `json:"client"`
+	Buckets []BucketStatus `json:"buckets"`
+	// Quota is only kept for principals
+	Quota *QuotaStatus `json:"quota,omitempty"`
+}
+
+// BucketStatus is the state of one bucket. Anonymous clients have one bucket
+// per IP address for each limit.
+type BucketStatus struct {
+	Key       string    `json:"key"`
+	LimitType string    `json:"limit_type"`
+	Remaining int       `json:"remaining"`
+	Burst     int       `json:"burst"`
+	FullAt    time.Time `json:"full_at"`
+}
+
+// NewClient returns the client at ip or, if ip is empty, the principal. The
+// address is normalized the way requests are.
+func NewClient(ip string, principal Principal) (Client, error) {
+	if (ip == "") == (principal.ID == "") {
+		return Client{}, models.ValidationErrors{"client": "give either an IP address or a user"}
+	}
+	if ip == "" {
+		return Client{Principal: principal}, nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Client{}, models.ValidationErrors{"ip": "invalid IP address"}
+	}
+	return Client{IP: addr.Unmap().WithZone("").String()}, nil
+}
+
+// String returns the principal ID or address of the client
+func (c Client) String() string {
+	if c.IP != "" {
+		return c.IP
+	}
+	return c.Principal.ID
+}
+
+// Stats returns every limit and how many clients are using it
+func (rl *RateLimiter) Stats(ctx context.Context) ([]LimitStats, error) {
+	stats := make([]LimitStats, 0, len(rl.limits))
+	for _, limitType := range rl.limitTypes() {
+		active, err := rl.store.ActiveKeys(ctx, fmt.Sprintf("ratelimit:%s:", limitType))
+		if err != nil {
+			return nil, err
+		}
+		limit := rl.limit(limitType)
+		stats = append(stats, LimitStats{
+			LimitType:     limitType,
+			LimitStatus:   LimitStatus{Requests: limit.Requests, Window: limit.Window.String(), Burst: limit.Burst},
+			ActiveClients: active,
+		})
+	}
+	return stats, nil
+}
+
+// Inspect returns the state of client's buckets, and its quotas if it is a
+// principal
+func (rl *RateLimiter) Inspect(ctx context.Context, client Client) (*ClientStatus, error) {
+	keys, limitTypes := rl.bucketKeys(client)
+	tats, err := rl.store.Buckets(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	status := &ClientStatus{Client: client.String(), Buckets: []BucketStatus{}}
+	plan := rl.plan(client.Principal)
+	for i, key := range keys {
+		if !tats[i].After(now) {
+			continue
+		}
+		limit := rl.limit(limitTypes[i])
+		if client.IP == "" {
+			limit = rl.planLimit(plan, limitTypes[i])
+		}
+		remaining, fullAt := gcraResult(tats[i], now, limit, true)
+		status.Buckets = append(status.Buckets, BucketStatus{
+			Key:       key,
+			LimitType: limitTypes[i],
+			Remaining: remaining,
+			Burst:     limit.Burst,
+			FullAt:    fullAt,
+		})
+	}
+
+	if client.IP == "" {
+		if status.Quota, err = rl.Quota(ctx, client.Principal); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// Reset empties client's buckets and, for a principal, its quotas. It
+// returns how many keys were removed.
+func (rl *RateLimiter) Reset(ctx context.Context, client Client) (int, error) {
+	keys, _ := rl.bucketKeys(client)
+	if client.IP == "" {
+		quotaKeys, err := rl.store.Scan(ctx, "quota:*:"+escapeGlob(client.Principal.ID)+":*")
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, quotaKeys...)
+	}
+	if err := rl.store.Delete(ctx, keys); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// bucketKeys returns the rate limit keys of client and their limit types,
+// one per limit
+func (rl *RateLimiter) bucketKeys(client Client) ([]string, []string) {
+	limitTypes := rl.limitTypes()
+	keys := make([]string, len(limitTypes))
+	for i, limitType := range limitTypes {
+		keys[i] = fmt.Sprintf("ratelimit:%s:%s", limitType, client)
+	}
+	return keys, limitTypes
+}
+
+// limitTypes returns the names of the limits in order
+func (rl *RateLimiter) limitTypes() []string {
+	limitTypes := make([]string, 0, len(rl.limits))
+	for limitType := range rl.limits {
+		limitTypes = append(limitTypes, limitType)
+	}
+	sort.Strings(limitTypes)
+	return limitTypes
+}
+
+// escapeGlob escapes the characters Redis glob patterns treat specially
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import "time"
+
+// Limits are enforced with the generic cell rate algorithm (GCRA), a token
+// bucket that only stores one time per key: the theoretical arrival time
+// (TAT) at which the client's bucket is full again. Each request moves it one
+// emission interval (Window / Requests) forward, and a request is allowed
+// while the TAT stays within Burst intervals of now.
+
+// emissionInterval is the time one request adds to the TAT
+func (c Config) emissionInterval() time.Duration {
+	return c.Window / time.Duration(max(c.Requests, 1))
+}
+
+// capacity is how far ahead of now the TAT may be, which allows Burst
+// requests at once from a full bucket
+func (c Config) capacity() time.Duration {
+	return c.emissionInterval() * time.Duration(max(c.Burst, 1))
+}
+
+// gcra decides a request at now for a key whose TAT is tat, and returns the
+// TAT to store if it is allowed
+func gcra(tat, now time.Time, limit Config) (time.Time, bool) {
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(limit.emissionInterval())
+	if newTAT.Sub(now) > limit.capacity() {
+		return tat, false
+	}
+	return newTAT, true
+}
+
+// gcraResult returns how many more requests the bucket allows at now, and
+// when the client may retry if the request was rejected, or when its full
+// burst is available again if it was allowed
+func gcraResult(tat, now time.Time, limit Config, allowed bool) (int, time.Time) {
+	interval := limit.emissionInterval()
+	if tat.Before(now) {
+		tat = now
+	}
+	remaining := int((limit.capacity() - tat.Sub(now)) / interval)
+	if !allowed {
+		return 0, tat.Add(interval - limit.capacity())
+	}
+	return max(remaining, 0), tat
+}
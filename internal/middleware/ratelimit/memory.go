@@ -0,0 +1,252 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/fnv"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryShards spreads keys over locks so concurrent requests for different
+// clients rarely wait on each other
+const memoryShards = 64
+
+// memorySweepInterval is how often a shard drops keys whose bucket is full
+// again
+const memorySweepInterval = time.Minute
+
+// MemoryStore keeps the GCRA state of each key in this process. Limits are
+// not shared between instances, so each allows the full limit.
+type MemoryStore struct {
+	shards [memoryShards]memoryShard
+
+	// Quotas are consumed together, so they share one lock
+	quotaMu        sync.Mutex
+	quotas         map[string]memoryCounter
+	lastQuotaSweep time.Time
+
+	rulesMu sync.Mutex
+	rules   map[string]Rule
+}
+
+type memoryCounter struct {
+	count     int64
+	resetTime time.Time
+}
+
+type memoryShard struct {
+	mu sync.Mutex
+	// tats holds each key's theoretical arrival time
+	tats      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		quotas: make(map[string]memoryCounter),
+		rules:  make(map[string]Rule),
+	}
+	for i := range s.shards {
+		s.shards[i].tats = make(map[string]time.Time)
+	}
+	return s
+}
+
+// Allow checks the request against the key's bucket
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Config, now time.Time) (bool, int, time.Time, error) {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.sweep(now)
+
+	tat, allowed := gcra(shard.tats[key], now, limit)
+	if allowed {
+		shard.tats[key] = tat
+	}
+	remaining, resetTime := gcraResult(tat, now, limit, allowed)
+	return allowed, remaining, resetTime, nil
+}
+
+// ActiveKeys counts the keys with prefix whose bucket isn't full
+func (s *MemoryStore) ActiveKeys(ctx context.Context, prefix string) (int, error) {
+	now := time.Now()
+	var count int
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for key, tat := range shard.tats {
+			if strings.HasPrefix(key, prefix) && tat.After(now) {
+				count++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return count, nil
+}
+
+// Consume counts one request against every quota if none of them is used up
+func (s *MemoryStore) Consume(ctx context.Context, quotas []Quota, now time.Time) (bool, []int64, error) {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+	s.sweepQuotas(now)
+
+	used := make([]int64, len(quotas))
+	allowed := true
+	for i, quota := range quotas {
+		used[i] = s.count(quota.Key, now)
+		if quota.Limit > 0 && used[i] >= quota.Limit {
+			allowed = false
+		}
+	}
+	if !allowed {
+		return false, used, nil
+	}
+	for i, quota := range quotas {
+		used[i]++
+		s.quotas[quota.Key] = memoryCounter{count: used[i], resetTime: quota.ResetTime}
+	}
+	return true, used, nil
+}
+
+// Usage returns how many requests each key has counted
+func (s *MemoryStore) Usage(ctx context.Context, keys []string, now time.Time) ([]int64, error) {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	used := make([]int64, len(keys))
+	for i, key := range keys {
+		used[i] = s.count(key, now)
+	}
+	return used, nil
+}
+
+// count returns the requests key has counted since it last reset. The caller
+// holds quotaMu.
+func (s *MemoryStore) count(key string, now time.Time) int64 {
+	counter, ok := s.quotas[key]
+	if !ok || !counter.resetTime.After(now) {
+		return 0
+	}
+	return counter.count
+}
+
+// sweepQuotas drops the counters that have reset. The caller holds quotaMu.
+func (s *MemoryStore) sweepQuotas(now time.Time) {
+	if now.Sub(s.lastQuotaSweep) < memorySweepInterval {
+		return
+	}
+	s.lastQuotaSweep = now
+	for key, counter := range s.quotas {
+		if !counter.resetTime.After(now) {
+			delete(s.quotas, key)
+		}
+	}
+}
+
+// Scan returns the live keys matching a Redis glob pattern
+func (s *MemoryStore) Scan(ctx context.Context, match string) ([]string, error) {
+	if _, err := path.Match(match, ""); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var keys []string
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for key, tat := range shard.tats {
+			if ok, _ := path.Match(match, key); ok && tat.After(now) {
+				keys = append(keys, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+	for key, counter := range s.quotas {
+		if ok, _ := path.Match(match, key); ok && counter.resetTime.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Buckets returns the TAT of each rate limit key
+func (s *MemoryStore) Buckets(ctx context.Context, keys []string) ([]time.Time, error) {
+	tats := make([]time.Time, len(keys))
+	for i, key := range keys {
+		shard := s.shard(key)
+		shard.mu.Lock()
+		tats[i] = shard.tats[key]
+		shard.mu.Unlock()
+	}
+	return tats, nil
+}
+
+// Delete removes keys
+func (s *MemoryStore) Delete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		shard := s.shard(key)
+		shard.mu.Lock()
+		delete(shard.tats, key)
+		shard.mu.Unlock()
+	}
+
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+	for _, key := range keys {
+		delete(s.quotas, key)
+	}
+	return nil
+}
+
+// Rules returns the allow and deny rules
+func (s *MemoryStore) Rules(ctx context.Context) ([]Rule, error) {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+	rules := make([]Rule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SetRule adds rule, replacing any rule for the same target
+func (s *MemoryStore) SetRule(ctx context.Context, rule Rule) error {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+	s.rules[rule.Target] = rule
+	return nil
+}
+
+// DeleteRule removes the rule for target
+func (s *MemoryStore) DeleteRule(ctx context.Context, target string) (bool, error) {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+	_, ok := s.rules[target]
+	delete(s.rules, target)
+	return ok, nil
+}
+
+func (s *MemoryStore) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &s.shards[h.Sum32()%memoryShards]
+}
+
+// sweep drops the keys whose bucket is full again, which behave like keys
+// that were never seen, so clients that went away don't hold memory. The
+// caller holds the shard's lock.
+func (sh *memoryShard) sweep(now time.Time) {
+	if now.Sub(sh.lastSweep) < memorySweepInterval {
+		return
+	}
+	sh.lastSweep = now
+	for key, tat := range sh.tats {
+		if !tat.After(now) {
+			delete(sh.tats, key)
+		}
+	}
+}
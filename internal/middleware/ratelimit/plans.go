@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"auth/internal/config"
+	"auth/internal/models"
+)
+
+// Principal is who a request is made for. Its requests share one set of
+// limits wherever they come from, so clients behind a shared address don't
+// limit each other.
+type Principal struct {
+	// ID is unique across kinds of principal, such as user:<id>
+	ID string
+	// Plan names the principal's plan; unknown plans get the free plan
+	Plan string
+}
+
+// principalUser is the kind of principal of users
+const principalUser = "user"
+
+// UserPrincipal returns the principal ID of a user
+func UserPrincipal(userID string) string {
+	return principalUser + ":" + userID
+}
+
+// PrincipalFunc returns the principal of an authenticated request. Requests
+// without one are limited by client address.
+type PrincipalFunc func(r *http.Request) (Principal, bool)
+
+// Plan sets the quotas of principals on it, and the limits that differ from
+// everyone else's
+type Plan struct {
+	Name string
+	// Daily and Monthly are the requests allowed per UTC day and month, 0
+	// for no limit
+	Daily   int64
+	Monthly int64
+	// Limits override the limits of the same name
+	Limits map[string]Config
+}
+
+// LoadPlans parses the plans of cfg, which must include the free plan new
+// accounts are on
+func LoadPlans(cfg config.RateLimitConfig) (map[string]Plan, error) {
+	plans := make(map[string]Plan, len(cfg.Plans))
+	for name, spec := range cfg.Plans {
+		plan, err := ParsePlan(name, spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s plan: %w", name, err)
+		}
+		plans[name] = plan
+	}
+	if _, ok := plans[models.PlanFree]; !ok {
+		return nil, fmt.Errorf("the %s plan is not configured", models.PlanFree)
+	}
+	return plans, nil
+}
+
+// ParsePlan parses a plan such as daily=10000;monthly=200000;api=1000/1m.
+// Entries other than daily and monthly are limits, as parsed by ParseLimit.
+func ParsePlan(name, spec string) (Plan, error) {
+	plan := Plan{Name: name, Limits: make(map[string]Config)}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return plan, fmt.Errorf("%q is not name=value", entry)
+		}
+		key = strings.TrimSpace(key)
+		var err error
+		switch key {
+		case "daily":
+			plan.Daily, err = parseQuota(value)
+		case "monthly":
+			plan.Monthly, err = parseQuota(value)
+		default:
+			plan.Limits[key], err = ParseLimit(value)
+		}
+		if err != nil {
+			return plan, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	return plan, nil
+}
+
+func parseQuota(value string) (int64, error) {
+	quota, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || quota < 0 {
+		return 0, fmt.Errorf("%q is not a number of requests", value)
+	}
+	return quota, nil
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// QuotaStatus is a principal's plan and how much of it they have used
+type QuotaStatus struct {
+	Plan    string                 `json:"plan"`
+	Daily   QuotaUsage             `json:"daily"`
+	Monthly QuotaUsage             `json:"monthly"`
+	Limits  map[string]LimitStatus `json:"limits"`
+}
+
+// QuotaUsage is the state of one quota
+type QuotaUsage struct {
+	// Limit is 0 when the plan has no quota, in which case nothing remains
+	// to count down
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining *int64    `json:"remaining,omitempty"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// LimitStatus describes a rate limit that applies to a principal
+type LimitStatus struct {
+	Requests int    `json:"requests"`
+	Window   string `json:"window"`
+	Burst    int    `json:"burst"`
+}
+
+// quotas returns the daily and monthly quotas of principal at now. Keys
+// include the period, so each period counts from zero.
+func (rl *RateLimiter) quotas(principal Principal, plan Plan, now time.Time) []Quota {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return []Quota{
+		{
+			Key:       "quota:daily:" + principal.ID + ":" + day.Format("2006-01-02"),
+			Limit:     plan.Daily,
+			ResetTime: day.AddDate(0, 0, 1),
+		},
+		{
+			Key:       "quota:monthly:" + principal.ID + ":" + month.Format("2006-01"),
+			Limit:     plan.Monthly,
+			ResetTime: month.AddDate(0, 1, 0),
+		},
+	}
+}
+
+// Quota returns principal's plan and usage
+func (rl *RateLimiter) Quota(ctx context.Context, principal Principal) (*QuotaStatus, error) {
+	now := time.Now()
+	plan := rl.plan(principal)
+	quotas := rl.quotas(principal, plan, now)
+	used, err := rl.store.Usage(ctx, []string{quotas[0].Key, quotas[1].Key}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &QuotaStatus{
+		Plan:    plan.Name,
+		Daily:   quotaUsage(quotas[0], used[0]),
+		Monthly: quotaUsage(quotas[1], used[1]),
+		Limits:  make(map[string]LimitStatus, len(rl.limits)),
+	}
+	for name := range rl.limits {
+		limit := rl.planLimit(plan, name)
+		status.Limits[name] = LimitStatus{Requests: limit.Requests, Window: limit.Window.String(), Burst: limit.Burst}
+	}
+	return status, nil
+}
+
+func quotaUsage(quota Quota, used int64) QuotaUsage {
+	usage := QuotaUsage{Limit: quota.Limit, Used: used, ResetsAt: quota.ResetTime}
+	if quota.Limit > 0 {
+		remaining := max(quota.Limit-used, 0)
+		usage.Remaining = &remaining
+	}
+	return usage
+}
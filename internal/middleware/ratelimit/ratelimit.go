@@ -3,24 +3,46 @@ package ratelimit
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"auth/internal/clientip"
+	"auth/internal/config"
 	"auth/internal/logger"
-	"github.com/go-redis/redis/v8"
+	"auth/internal/models"
+	"auth/internal/observability/metrics"
 )
 
+// RateLimiter limits how often each client may call a group of routes. A nil
+// *RateLimiter allows everything.
 type RateLimiter struct {
-	client *redis.Client
-	logger *logger.Logger
+	store     Store
+	limits    map[string]Config
+	plans     map[string]Plan
+	principal PrincipalFunc
+	logger    *logger.Logger
+
+	// rules is the instance's copy of the allow and deny rules, which one
+	// request at a time reloads
+	rules        atomic.Pointer[ruleSet]
+	rulesLoading sync.Mutex
 }
 
+// Config allows Requests per Window on average. A client that has been idle
+// may send up to Burst requests at once, after which requests are spaced
+// Window/Requests apart.
 type Config struct {
 	Requests int           // Number of requests allowed
 	Window   time.Duration // Time window
 	Burst    int           // Burst capacity
+	// FailClosed rejects requests when the store can't be reached, instead
+	// of letting them through
+	FailClosed bool
 }
 
 var (
@@ -34,188 +56,273 @@ var (
 	}
 )
 
-func New(redisClient *redis.Client, logger *logger.Logger) *RateLimiter {
+// Results of rate limit checks, as recorded in metrics
+const (
+	resultAllowed       = "allowed"
+	resultLimited       = "limited"
+	resultQuotaExceeded = "quota_exceeded"
+	resultDenied        = "denied"
+	resultAllowlisted   = "allowlisted"
+	resultError         = "error"
+)
+
+// New creates the rate limiter. limits and plans are usually loaded with
+// LoadLimits and LoadPlans; limit types missing from limits use the api
+// limit. principal identifies authenticated requests, and may be nil to limit
+// every request by client address.
+func New(store Store, limits map[string]Config, plans map[string]Plan, principal PrincipalFunc, logger *logger.Logger) *RateLimiter {
 	return &RateLimiter{
-		client: redisClient,
-		logger: logger,
+		store:     store,
+		limits:    limits,
+		plans:     plans,
+		principal: principal,
+		logger:    logger,
+	}
+}
+
+// LoadLimits returns DefaultLimits with the overrides of cfg applied
+func LoadLimits(cfg config.RateLimitConfig) (map[string]Config, error) {
+	limits := make(map[string]Config, len(DefaultLimits))
+	for name, limit := range DefaultLimits {
+		limits[name] = limit
+	}
+	for name, spec := range cfg.Limits {
+		if spec == "" {
+			continue
+		}
+		limit, err := ParseLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s rate limit: %w", name, err)
+		}
+		limits[name] = limit
 	}
+	return limits, nil
 }
 
-// Middleware returns HTTP middleware for rate limiting
+// ParseLimit parses a limit such as 10/1m,burst=15,fail=closed. The burst
+// defaults to the number of requests, and the store failing lets requests
+// through unless fail is closed.
+func ParseLimit(spec string) (Config, error) {
+	var limit Config
+	rate, options, _ := strings.Cut(spec, ",")
+	requests, window, ok := strings.Cut(rate, "/")
+	if !ok {
+		return limit, fmt.Errorf("%q is not requests/window", spec)
+	}
+	var err error
+	if limit.Requests, err = strconv.Atoi(strings.TrimSpace(requests)); err != nil || limit.Requests <= 0 {
+		return limit, fmt.Errorf("invalid number of requests %q", requests)
+	}
+	if limit.Window, err = time.ParseDuration(strings.TrimSpace(window)); err != nil || limit.Window <= 0 {
+		return limit, fmt.Errorf("invalid window %q", window)
+	}
+	limit.Burst = limit.Requests
+
+	if options == "" {
+		return limit, nil
+	}
+	for _, option := range strings.Split(options, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case "burst":
+			if limit.Burst, err = strconv.Atoi(value); err != nil || limit.Burst < limit.Requests {
+				return limit, fmt.Errorf("invalid burst %q, it can't be below the number of requests", value)
+			}
+		case "fail":
+			switch value {
+			case "open":
+				limit.FailClosed = false
+			case "closed":
+				limit.FailClosed = true
+			default:
+				return limit, fmt.Errorf("invalid fail policy %q, want open or closed", value)
+			}
+		default:
+			return limit, fmt.Errorf("unknown option %q", option)
+		}
+	}
+	return limit, nil
+}
+
+// limit returns the limit of limitType
+func (rl *RateLimiter) limit(limitType string) Config {
+	if limit, ok := rl.limits[limitType]; ok {
+		return limit
+	}
+	return rl.limits["api"] // Default fallback
+}
+
+// Middleware returns HTTP middleware for rate limiting. Requests with a
+// principal are limited by their plan and count against its quotas, so
+// authenticated routes should be limited after authentication.
 func (rl *RateLimiter) Middleware(limitType string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
+		if rl == nil {
+			return next
+		}
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Get client identifier (IP + User-Agent hash)
-			clientID := rl.getClientID(r)
+			// Allow and deny rules come before limits
+			principal, authenticated := rl.Principal(r)
+			switch rl.ruleAction(r.Context(), clientip.FromRequest(r), principal.ID, time.Now()) {
+			case RuleDeny:
+				metrics.RecordRateLimit(limitType, resultDenied)
+				logger.FromContext(r.Context()).Warn("request denied by rate limit rule",
+					"client_ip", clientip.FromRequest(r),
+					"principal", principal.ID,
+					"endpoint", r.URL.Path)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"client_blocked","message":"Requests from this client are blocked."}`))
+				return
+			case RuleAllow:
+				metrics.RecordRateLimit(limitType, resultAllowlisted)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Get client identifier: the principal, or the client IP for
+			// anonymous requests
+			var clientID string
+			var limit Config
+			if authenticated {
+				clientID = principal.ID
+				limit = rl.planLimit(rl.plan(principal), limitType)
+			} else {
+				clientID = rl.getClientID(r)
+				limit = rl.limit(limitType)
+			}
 			
 			// Check rate limit
-			allowed, remaining, resetTime, err := rl.Allow(r.Context(), clientID, limitType)
+			allowed, remaining, resetTime, err := rl.Allow(r.Context(), clientID, limitType, limit)
 			if err != nil {
-				rl.logger.Error("rate limit check failed", "error", err, "client_id", clientID)
-				// Allow request on error (fail open)
+				logger.FromContext(r.Context()).Error("rate limit check failed", "error", err, "client_id", clientID, "limit_type", limitType)
+				metrics.RecordRateLimit(limitType, resultError)
+				if limit.FailClosed {
+					writeUnavailable(w)
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			// Set rate limit headers
-			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(DefaultLimits[limitType].Requests))
+			// Set rate limit headers. Remaining counts down from the burst,
+			// and Reset is when it is full again, or when a rejected request
+			// can be retried.
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
 			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
 			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(resetTime.Unix(), 10))
 
 			if !allowed {
-				rl.logger.Warn("rate limit exceeded", 
+				logger.FromContext(r.Context()).Warn("rate limit exceeded", 
 					"client_id", clientID, 
 					"endpoint", r.URL.Path, 
 					"limit_type", limitType)
 				
-				w.Header().Set("Content-Type", "application/json")
-				w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(resetTime).Seconds())))
-				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte(`{
-					"error": "rate_limit_exceeded",
-					"message": "Too many requests. Please try again later.",
-					"retry_after": ` + strconv.Itoa(int(time.Until(resetTime).Seconds())) + `
-				}`))
+				metrics.RecordRateLimit(limitType, resultLimited)
+				writeTooManyRequests(w, "rate_limit_exceeded", "Too many requests. Please try again later.", resetTime)
+				return
+			}
+
+			if authenticated && !rl.consumeQuota(w, r, principal, limitType, limit) {
 				return
 			}
 
+			metrics.RecordRateLimit(limitType, resultAllowed)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// Allow checks if a request is allowed based on sliding window algorithm
-func (rl *RateLimiter) Allow(ctx context.Context, clientID, limitType string) (allowed bool, remaining int, resetTime time.Time, err error) {
-	config, exists := DefaultLimits[limitType]
-	if !exists {
-		config = DefaultLimits["api"] // Default fallback
-	}
-
+// consumeQuota counts the request against the daily and monthly quotas of
+// principal, and writes the response if it may not go ahead
+func (rl *RateLimiter) consumeQuota(w http.ResponseWriter, r *http.Request, principal Principal, limitType string, limit Config) bool {
 	now := time.Now()
-	window := config.Window
-	maxRequests := config.Requests
-	burstCapacity := config.Burst
-
-	// Redis key for this client and limit type
-	key := fmt.Sprintf("ratelimit:%s:%s", limitType, clientID)
-	
-	// Use Redis pipeline for atomic operations
-	pipe := rl.client.Pipeline()
-	
-	// Remove expired entries (sliding window)
-	cutoff := now.Add(-window)
-	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(cutoff.UnixNano(), 10))
-	
-	// Count current requests in window
-	pipe.ZCard(ctx, key)
-	
-	// Add current request
-	pipe.ZAdd(ctx, key, &redis.Z{
-		Score:  float64(now.UnixNano()),
-		Member: fmt.Sprintf("%d", now.UnixNano()),
-	})
-	
-	// Set expiration
-	pipe.Expire(ctx, key, window+time.Minute)
-	
-	// Execute pipeline
-	results, err := pipe.Exec(ctx)
+	quotas := rl.quotas(principal, rl.plan(principal), now)
+	allowed, used, err := rl.store.Consume(r.Context(), quotas, now)
 	if err != nil {
-		return false, 0, time.Time{}, err
-	}
-
-	// Get current count (before adding new request)
-	currentCount := results[1].(*redis.IntCmd).Val()
-	
-	// Calculate reset time (start of next window)
-	resetTime = now.Add(window)
-	
-	// Check if burst capacity allows this request
-	if currentCount >= int64(burstCapacity) {
-		// Remove the request we just added since it's not allowed
-		rl.client.ZRem(ctx, key, fmt.Sprintf("%d", now.UnixNano()))
-		return false, 0, resetTime, nil
-	}
-	
-	// Check regular limit
-	if currentCount >= int64(maxRequests) {
-		// Check if we can use burst capacity
-		if currentCount >= int64(burstCapacity) {
-			rl.client.ZRem(ctx, key, fmt.Sprintf("%d", now.UnixNano()))
-			return false, 0, resetTime, nil
+		logger.FromContext(r.Context()).Error("quota check failed", "error", err, "client_id", principal.ID)
+		metrics.RecordRateLimit(limitType, resultError)
+		if limit.FailClosed {
+			writeUnavailable(w)
+			return false
 		}
+		return true
+	}
+	if allowed {
+		return true
 	}
 
-	remaining = maxRequests - int(currentCount) - 1
-	if remaining < 0 {
-		remaining = 0
+	// The request may be retried once every used up quota has reset
+	var exhausted Quota
+	for i, quota := range quotas {
+		if quota.Limit > 0 && used[i] >= quota.Limit && quota.ResetTime.After(exhausted.ResetTime) {
+			exhausted = quota
+		}
 	}
+	logger.FromContext(r.Context()).Warn("quota exceeded", "client_id", principal.ID, "plan", principal.Plan, "quota", exhausted.Key)
+	metrics.RecordRateLimit(limitType, resultQuotaExceeded)
+	writeTooManyRequests(w, "quota_exceeded", "Request quota exceeded. Please try again after it resets.", exhausted.ResetTime)
+	return false
+}
 
-	return true, remaining, resetTime, nil
+// writeTooManyRequests rejects a request that may be retried at resetTime
+func writeTooManyRequests(w http.ResponseWriter, code, message string, resetTime time.Time) {
+	// Round up, so clients don't retry before the request is allowed
+	retryAfter := strconv.Itoa(int(math.Ceil(time.Until(resetTime).Seconds())))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", retryAfter)
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(`{
+		"error": "` + code + `",
+		"message": "` + message + `",
+		"retry_after": ` + retryAfter + `
+	}`))
 }
 
-// getClientID generates a unique identifier for rate limiting
-func (rl *RateLimiter) getClientID(r *http.Request) string {
-	// Get real IP (considering proxies)
-	ip := rl.getRealIP(r)
-	
-	// Include user agent for better identification
-	userAgent := r.Header.Get("User-Agent")
-	if len(userAgent) > 100 {
-		userAgent = userAgent[:100] // Truncate long user agents
-	}
-	
-	// Create hash-based ID to avoid storing sensitive data
-	return fmt.Sprintf("%s:%s", ip, hashString(userAgent))
+// writeUnavailable rejects a request whose limit fails closed when the store
+// can't be reached
+func writeUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(`{"error":"rate_limit_unavailable","message":"Please try again later."}`))
 }
 
-// getRealIP extracts the real IP address from request
-func (rl *RateLimiter) getRealIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
-		}
-	}
-	
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	
-	// Fallback to RemoteAddr
-	parts := strings.Split(r.RemoteAddr, ":")
-	if len(parts) > 0 {
-		return parts[0]
-	}
-	
-	return "unknown"
+// Allow checks whether clientID may make another request of limitType
+func (rl *RateLimiter) Allow(ctx context.Context, clientID, limitType string, limit Config) (allowed bool, remaining int, resetTime time.Time, err error) {
+	key := fmt.Sprintf("ratelimit:%s:%s", limitType, clientID)
+	return rl.store.Allow(ctx, key, limit, time.Now())
 }
 
-// hashString creates a simple hash of the string for anonymization
-func hashString(s string) string {
-	h := uint32(2166136261)
-	for _, c := range []byte(s) {
-		h ^= uint32(c)
-		h *= 16777619
+// Principal returns the principal of an authenticated request
+func (rl *RateLimiter) Principal(r *http.Request) (Principal, bool) {
+	if rl.principal == nil {
+		return Principal{}, false
 	}
-	return fmt.Sprintf("%x", h)
+	return rl.principal(r)
 }
 
-// GetStats returns rate limiting statistics for monitoring
-func (rl *RateLimiter) GetStats(ctx context.Context, limitType string) (map[string]interface{}, error) {
-	pattern := fmt.Sprintf("ratelimit:%s:*", limitType)
-	keys, err := rl.client.Keys(ctx, pattern).Result()
-	if err != nil {
-		return nil, err
+// plan returns the plan of principal, the free plan if it is unknown
+func (rl *RateLimiter) plan(principal Principal) Plan {
+	if plan, ok := rl.plans[principal.Plan]; ok {
+		return plan
+	}
+	if plan, ok := rl.plans[models.PlanFree]; ok {
+		return plan
 	}
+	return Plan{Name: models.PlanFree}
+}
 
-	stats := map[string]interface{}{
-		"active_clients": len(keys),
-		"limit_type":     limitType,
-		"config":         DefaultLimits[limitType],
+// planLimit returns the limit of limitType on plan
+func (rl *RateLimiter) planLimit(plan Plan, limitType string) Config {
+	if limit, ok := plan.Limits[limitType]; ok {
+		return limit
 	}
+	return rl.limit(limitType)
+}
 
-	return stats, nil
-}
\ No newline at end of file
+// getClientID returns the key of an anonymous client: its IP address,
+// resolved behind the trusted proxies. Headers the client controls, such as
+// the User-Agent, aren't part of it, or changing them would start new buckets.
+func (rl *RateLimiter) getClientID(r *http.Request) string {
+	return clientip.FromRequest(r)
+}
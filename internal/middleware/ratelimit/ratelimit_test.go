@@ -0,0 +1,639 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/middleware/ratelimit"
+	"auth/internal/models"
+	"auth/internal/observability/metrics"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// stores returns every Store implementation, Redis backed by miniredis
+func stores(t *testing.T) map[string]ratelimit.Store {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return map[string]ratelimit.Store{
+		"redis":  ratelimit.NewRedisStore(client),
+		"memory": ratelimit.NewMemoryStore(),
+	}
+}
+
+func TestStoreAllow(t *testing.T) {
+	// One request a second on average, up to three at once
+	limit := ratelimit.Config{Requests: 60, Window: time.Minute, Burst: 3}
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			// Redis keeps microseconds
+			now := time.Now().Truncate(time.Microsecond)
+			allow := func(key string, at time.Duration) (bool, int, time.Time) {
+				t.Helper()
+				allowed, remaining, reset, err := store.Allow(ctx, key, limit, now.Add(at))
+				if err != nil {
+					t.Fatalf("Allow() unexpected error: %v", err)
+				}
+				return allowed, remaining, reset
+			}
+
+			for i := range limit.Burst {
+				allowed, remaining, reset := allow("ratelimit:login:a", 0)
+				if !allowed {
+					t.Fatalf("request %d of the burst rejected, want allowed", i+1)
+				}
+				if want := limit.Burst - i - 1; remaining != want {
+					t.Errorf("request %d remaining = %d, want %d", i+1, remaining, want)
+				}
+				if want := now.Add(time.Duration(i+1) * time.Second); !reset.Equal(want) {
+					t.Errorf("request %d reset = %v, want %v", i+1, reset, want)
+				}
+			}
+
+			allowed, remaining, retry := allow("ratelimit:login:a", 0)
+			if allowed || remaining != 0 {
+				t.Errorf("request over the burst: allowed = %v, remaining = %d, want rejected with 0", allowed, remaining)
+			}
+			if want := now.Add(time.Second); !retry.Equal(want) {
+				t.Errorf("request over the burst retry at %v, want %v", retry, want)
+			}
+
+			// Other clients have their own bucket
+			if allowed, _, _ := allow("ratelimit:login:b", 0); !allowed {
+				t.Error("other client rejected, want allowed")
+			}
+
+			// A request is let through every interval, and rejected requests
+			// don't push the next one back
+			if allowed, _, _ := allow("ratelimit:login:a", 500*time.Millisecond); allowed {
+				t.Error("request within the interval allowed, want rejected")
+			}
+			if allowed, remaining, _ := allow("ratelimit:login:a", time.Second); !allowed || remaining != 0 {
+				t.Errorf("request after the interval: allowed = %v, remaining = %d, want allowed with 0", allowed, remaining)
+			}
+			if allowed, _, _ := allow("ratelimit:login:a", time.Second); allowed {
+				t.Error("second request after the interval allowed, want rejected")
+			}
+
+			// After a pause the whole burst is available again
+			if allowed, remaining, _ := allow("ratelimit:login:a", time.Minute); !allowed || remaining != limit.Burst-1 {
+				t.Errorf("request after a pause: allowed = %v, remaining = %d, want allowed with %d", allowed, remaining, limit.Burst-1)
+			}
+
+			active, err := store.ActiveKeys(ctx, "ratelimit:login:")
+			if err != nil {
+				t.Fatalf("ActiveKeys() unexpected error: %v", err)
+			}
+			if active != 2 {
+				t.Errorf("ActiveKeys() = %d, want 2", active)
+			}
+		})
+	}
+}
+
+func TestStoreConcurrent(t *testing.T) {
+	limit := ratelimit.Config{Requests: 1, Window: time.Hour, Burst: 10}
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			var wg sync.WaitGroup
+			var allowed atomic.Int32
+			for range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ok, _, _, err := store.Allow(context.Background(), "ratelimit:login:a", limit, now)
+					if err != nil {
+						t.Errorf("Allow() unexpected error: %v", err)
+					}
+					if ok {
+						allowed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if got := int(allowed.Load()); got != limit.Burst {
+				t.Errorf("%d concurrent requests allowed, want %d", got, limit.Burst)
+			}
+		})
+	}
+}
+
+func TestStoreConsume(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			quotas := []ratelimit.Quota{
+				{Key: "quota:daily:user:a", Limit: 2, ResetTime: now.Add(time.Hour)},
+				{Key: "quota:monthly:user:a", Limit: 0, ResetTime: now.Add(24 * time.Hour)},
+			}
+			for i := range 2 {
+				allowed, used, err := store.Consume(ctx, quotas, now)
+				if err != nil {
+					t.Fatalf("Consume() unexpected error: %v", err)
+				}
+				if !allowed || used[0] != int64(i+1) || used[1] != int64(i+1) {
+					t.Errorf("request %d: allowed = %v, used = %v, want allowed with %d each", i+1, allowed, used, i+1)
+				}
+			}
+
+			// A used up quota rejects the request without counting it against
+			// the others
+			allowed, used, err := store.Consume(ctx, quotas, now)
+			if err != nil {
+				t.Fatalf("Consume() unexpected error: %v", err)
+			}
+			if allowed || used[0] != 2 || used[1] != 2 {
+				t.Errorf("request over the quota: allowed = %v, used = %v, want rejected with 2 each", allowed, used)
+			}
+
+			used, err = store.Usage(ctx, []string{quotas[0].Key, quotas[1].Key, "quota:daily:user:b"}, now)
+			if err != nil {
+				t.Fatalf("Usage() unexpected error: %v", err)
+			}
+			if used[0] != 2 || used[1] != 2 || used[2] != 0 {
+				t.Errorf("Usage() = %v, want [2 2 0]", used)
+			}
+		})
+	}
+}
+
+func TestStoreAdmin(t *testing.T) {
+	limit := ratelimit.Config{Requests: 60, Window: time.Minute, Burst: 3}
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now().Truncate(time.Microsecond)
+			for _, key := range []string{"ratelimit:api:192.0.2.1:aaaa", "ratelimit:api:192.0.2.1:bbbb", "ratelimit:api:192.0.2.10:aaaa"} {
+				if _, _, _, err := store.Allow(ctx, key, limit, now); err != nil {
+					t.Fatalf("Allow() unexpected error: %v", err)
+				}
+			}
+			quota := ratelimit.Quota{Key: "quota:daily:user:a:2026-10-18", ResetTime: now.Add(time.Hour)}
+			if _, _, err := store.Consume(ctx, []ratelimit.Quota{quota}, now); err != nil {
+				t.Fatalf("Consume() unexpected error: %v", err)
+			}
+
+			keys, err := store.Scan(ctx, "ratelimit:api:192.0.2.1:*")
+			if err != nil {
+				t.Fatalf("Scan() unexpected error: %v", err)
+			}
+			sort.Strings(keys)
+			if want := []string{"ratelimit:api:192.0.2.1:aaaa", "ratelimit:api:192.0.2.1:bbbb"}; !slices.Equal(keys, want) {
+				t.Errorf("Scan() = %v, want %v", keys, want)
+			}
+			if keys, _ := store.Scan(ctx, "quota:*:user:a:*"); len(keys) != 1 {
+				t.Errorf("Scan() of quotas = %v, want the daily quota", keys)
+			}
+
+			tats, err := store.Buckets(ctx, []string{"ratelimit:api:192.0.2.1:aaaa", "ratelimit:api:missing"})
+			if err != nil {
+				t.Fatalf("Buckets() unexpected error: %v", err)
+			}
+			if want := now.Add(time.Second); !tats[0].Equal(want) || !tats[1].IsZero() {
+				t.Errorf("Buckets() = %v, want [%v zero]", tats, want)
+			}
+
+			if err := store.Delete(ctx, []string{"ratelimit:api:192.0.2.1:aaaa", quota.Key}); err != nil {
+				t.Fatalf("Delete() unexpected error: %v", err)
+			}
+			if allowed, remaining, _, _ := store.Allow(ctx, "ratelimit:api:192.0.2.1:aaaa", limit, now); !allowed || remaining != limit.Burst-1 {
+				t.Errorf("deleted bucket: allowed = %v, remaining = %d, want a full bucket", allowed, remaining)
+			}
+			if used, _ := store.Usage(ctx, []string{quota.Key}, now); used[0] != 0 {
+				t.Errorf("deleted quota used = %d, want 0", used[0])
+			}
+
+			rule := ratelimit.Rule{Target: "192.0.2.0/24", Action: ratelimit.RuleDeny, CreatedAt: now.UTC()}
+			if err := store.SetRule(ctx, rule); err != nil {
+				t.Fatalf("SetRule() unexpected error: %v", err)
+			}
+			rule.Action = ratelimit.RuleAllow
+			if err := store.SetRule(ctx, rule); err != nil {
+				t.Fatalf("SetRule() unexpected error: %v", err)
+			}
+			rules, err := store.Rules(ctx)
+			if err != nil {
+				t.Fatalf("Rules() unexpected error: %v", err)
+			}
+			if len(rules) != 1 || rules[0].Action != ratelimit.RuleAllow {
+				t.Errorf("Rules() = %+v, want the replaced rule", rules)
+			}
+			if deleted, err := store.DeleteRule(ctx, rule.Target); err != nil || !deleted {
+				t.Errorf("DeleteRule() = %v, %v, want deleted", deleted, err)
+			}
+			if deleted, _ := store.DeleteRule(ctx, rule.Target); deleted {
+				t.Error("DeleteRule() of a missing rule reported it deleted")
+			}
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ratelimit.Config
+		wantErr bool
+	}{
+		{spec: "10/1m", want: ratelimit.Config{Requests: 10, Window: time.Minute, Burst: 10}},
+		{spec: "10/1m,burst=15", want: ratelimit.Config{Requests: 10, Window: time.Minute, Burst: 15}},
+		{spec: "3/1h, fail=closed", want: ratelimit.Config{Requests: 3, Window: time.Hour, Burst: 3, FailClosed: true}},
+		{spec: "10", wantErr: true},
+		{spec: "0/1m", wantErr: true},
+		{spec: "10/forever", wantErr: true},
+		{spec: "10/1m,burst=5", wantErr: true},
+		{spec: "10/1m,fail=maybe", wantErr: true},
+		{spec: "10/1m,jitter=1s", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ratelimit.ParseLimit(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	limits, err := ratelimit.LoadLimits(config.RateLimitConfig{Limits: map[string]string{"login": "2/1s", "api": ""}})
+	if err != nil {
+		t.Fatalf("LoadLimits() unexpected error: %v", err)
+	}
+	if limits["login"].Requests != 2 || limits["api"] != ratelimit.DefaultLimits["api"] {
+		t.Errorf("LoadLimits() = %+v, want login overridden and api unchanged", limits)
+	}
+	if _, err := ratelimit.LoadLimits(config.RateLimitConfig{Limits: map[string]string{"login": "fast"}}); err == nil {
+		t.Error("LoadLimits() accepted an invalid limit")
+	}
+}
+
+func TestParsePlan(t *testing.T) {
+	plan, err := ratelimit.ParsePlan("pro", "daily=1000; monthly=20000; api=500/1m,burst=600")
+	if err != nil {
+		t.Fatalf("ParsePlan() unexpected error: %v", err)
+	}
+	want := ratelimit.Config{Requests: 500, Window: time.Minute, Burst: 600}
+	if plan.Name != "pro" || plan.Daily != 1000 || plan.Monthly != 20000 || plan.Limits["api"] != want {
+		t.Errorf("ParsePlan() = %+v, want daily 1000, monthly 20000 and api %+v", plan, want)
+	}
+
+	for _, spec := range []string{"daily=-1", "monthly=lots", "api=fast", "daily"} {
+		if _, err := ratelimit.ParsePlan("pro", spec); err == nil {
+			t.Errorf("ParsePlan(%q) accepted an invalid plan", spec)
+		}
+	}
+
+	if _, err := ratelimit.LoadPlans(config.RateLimitConfig{Plans: map[string]string{"enterprise": ""}}); err == nil {
+		t.Error("LoadPlans() accepted plans without the free plan")
+	}
+}
+
+// failingStore is a store that can't be reached
+type failingStore struct{}
+
+func (failingStore) Allow(context.Context, string, ratelimit.Config, time.Time) (bool, int, time.Time, error) {
+	return false, 0, time.Time{}, errors.New("connection refused")
+}
+
+func (failingStore) ActiveKeys(context.Context, string) (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingStore) Consume(context.Context, []ratelimit.Quota, time.Time) (bool, []int64, error) {
+	return false, nil, errors.New("connection refused")
+}
+
+func (failingStore) Usage(context.Context, []string, time.Time) ([]int64, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingStore) Scan(context.Context, string) ([]string, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingStore) Buckets(context.Context, []string) ([]time.Time, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingStore) Delete(context.Context, []string) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) Rules(context.Context) ([]ratelimit.Rule, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingStore) SetRule(context.Context, ratelimit.Rule) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) DeleteRule(context.Context, string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	request := func(handler http.Handler) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	limits := map[string]ratelimit.Config{
+		"login": {Requests: 2, Window: time.Minute, Burst: 2},
+		"api":   {Requests: 100, Window: time.Minute, Burst: 100, FailClosed: true},
+	}
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), limits, nil, nil, logger.New("error"))
+	login := limiter.Middleware("login")(ok)
+	for i := range 2 {
+		if rec := request(login); rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i+1, rec.Code)
+		}
+	}
+	rec := request(login)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit status = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("rate limit headers = %v, want limit 2 and Retry-After", rec.Header())
+	}
+	if rec := request(limiter.Middleware("api")(ok)); rec.Code != http.StatusOK {
+		t.Errorf("other limit status = %d, want 200", rec.Code)
+	}
+
+	// Each limit decides what happens when the store fails
+	failing := ratelimit.New(failingStore{}, limits, nil, nil, logger.New("error"))
+	for limitType, want := range map[string]int{"login": http.StatusOK, "api": http.StatusServiceUnavailable} {
+		if rec := request(failing.Middleware(limitType)(ok)); rec.Code != want {
+			t.Errorf("%s with a failing store: status = %d, want %d", limitType, rec.Code, want)
+		}
+	}
+
+	var disabled *ratelimit.RateLimiter
+	for i := range 5 {
+		if rec := request(disabled.Middleware("login")(ok)); rec.Code != http.StatusOK {
+			t.Fatalf("nil limiter request %d status = %d, want 200", i+1, rec.Code)
+		}
+	}
+}
+
+func TestMiddlewarePrincipal(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	// The test requests name their user and plan in headers
+	principal := func(r *http.Request) (ratelimit.Principal, bool) {
+		user := r.Header.Get("X-Test-User")
+		return ratelimit.Principal{ID: "user:" + user, Plan: r.Header.Get("X-Test-Plan")}, user != ""
+	}
+	request := func(handler http.Handler, user, plan string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+			req.Header.Set("X-Test-Plan", plan)
+		}
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	limits := map[string]ratelimit.Config{
+		"api": {Requests: 2, Window: time.Minute, Burst: 2},
+	}
+	plans := map[string]ratelimit.Plan{
+		"free":       {Name: "free", Daily: 3},
+		"enterprise": {Name: "enterprise", Limits: map[string]ratelimit.Config{"api": {Requests: 10, Window: time.Minute, Burst: 10}}},
+	}
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), limits, plans, principal, logger.New("error"))
+	api := limiter.Middleware("api")(ok)
+
+	// Users behind one address have their own limits
+	for _, user := range []string{"alice", "bob"} {
+		for i := range 2 {
+			if rec := request(api, user, "free"); rec.Code != http.StatusOK {
+				t.Fatalf("%s request %d status = %d, want 200", user, i+1, rec.Code)
+			}
+		}
+	}
+	if rec := request(api, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("anonymous request status = %d, want 200", rec.Code)
+	}
+	if rec := request(api, "alice", "free"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("request over the limit status = %d, want 429", rec.Code)
+	}
+
+	// Plans override limits, and unknown plans get the free plan
+	for i := range 5 {
+		if rec := request(api, "carol", "enterprise"); rec.Code != http.StatusOK {
+			t.Fatalf("enterprise request %d status = %d, want 200", i+1, rec.Code)
+		}
+	}
+	if rec := request(api, "dave", "platinum"); rec.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Errorf("unknown plan limit = %q, want the free plan's 2", rec.Header().Get("X-RateLimit-Limit"))
+	}
+
+	// The daily quota holds across limits
+	other := limiter.Middleware("profile")(ok)
+	request(other, "bob", "free")
+	rec := request(other, "bob", "free")
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "quota_exceeded") {
+		t.Fatalf("request over the quota: status = %d, body = %s, want 429 quota_exceeded", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("request over the quota has no Retry-After")
+	}
+
+	status, err := limiter.Quota(context.Background(), ratelimit.Principal{ID: "user:bob", Plan: "free"})
+	if err != nil {
+		t.Fatalf("Quota() unexpected error: %v", err)
+	}
+	if status.Plan != "free" || status.Daily.Limit != 3 || status.Daily.Used != 3 || status.Daily.Remaining == nil || *status.Daily.Remaining != 0 {
+		t.Errorf("Quota() daily = %+v, want 3 of 3 used", status.Daily)
+	}
+	if status.Monthly.Limit != 0 || status.Monthly.Used != 3 || status.Monthly.Remaining != nil {
+		t.Errorf("Quota() monthly = %+v, want 3 used without a limit", status.Monthly)
+	}
+	if !status.Daily.ResetsAt.After(time.Now()) || status.Limits["api"].Burst != 2 {
+		t.Errorf("Quota() = %+v, want a future reset and the api limit", status)
+	}
+}
+
+func TestRules(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	principal := func(r *http.Request) (ratelimit.Principal, bool) {
+		user := r.Header.Get("X-Test-User")
+		return ratelimit.Principal{ID: ratelimit.UserPrincipal(user)}, user != ""
+	}
+	request := func(handler http.Handler, remoteAddr, user string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = remoteAddr
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	limits := map[string]ratelimit.Config{"login": {Requests: 1, Window: time.Minute, Burst: 1}}
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), limits, nil, principal, logger.New("error"))
+	login := limiter.Middleware("login")(ok)
+	ctx := context.Background()
+
+	for _, rule := range []struct{ target, action string }{
+		{"203.0.113.0/24", ratelimit.RuleAllow},
+		{"203.0.113.66", ratelimit.RuleDeny},
+		{"2001:db8::/32", ratelimit.RuleDeny},
+		{"user:mallory", ratelimit.RuleDeny},
+	} {
+		if _, err := limiter.SetRule(ctx, rule.target, rule.action, "test", 0); err != nil {
+			t.Fatalf("SetRule(%s) unexpected error: %v", rule.target, err)
+		}
+	}
+
+	// Allowed networks skip limits, but deny rules win
+	for i := range 3 {
+		if code := request(login, "203.0.113.5:1234", ""); code != http.StatusOK {
+			t.Fatalf("allowlisted request %d status = %d, want 200", i+1, code)
+		}
+	}
+	for name, code := range map[string]int{
+		"denied address":   request(login, "203.0.113.66:1234", ""),
+		"denied network":   request(login, "[2001:db8::1]:1234", ""),
+		"denied principal": request(login, "198.51.100.1:1234", "mallory"),
+	} {
+		if code != http.StatusForbidden {
+			t.Errorf("%s status = %d, want 403", name, code)
+		}
+	}
+
+	// Bans expire
+	if _, err := limiter.SetRule(ctx, "198.51.100.7", ratelimit.RuleDeny, "ban", time.Millisecond); err != nil {
+		t.Fatalf("SetRule() unexpected error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if code := request(login, "198.51.100.7:1234", ""); code != http.StatusOK {
+		t.Errorf("request after the ban expired status = %d, want 200", code)
+	}
+
+	if deleted, err := limiter.DeleteRule(ctx, "2001:db8:0::/32"); err != nil || !deleted {
+		t.Fatalf("DeleteRule() = %v, %v, want deleted", deleted, err)
+	}
+	if code := request(login, "[2001:db8::1]:1234", ""); code != http.StatusOK {
+		t.Errorf("request after the rule was deleted status = %d, want 200", code)
+	}
+
+	rules, err := limiter.Rules(ctx)
+	if err != nil {
+		t.Fatalf("Rules() unexpected error: %v", err)
+	}
+	if len(rules) != 3 {
+		t.Errorf("Rules() = %+v, want the 3 active rules", rules)
+	}
+
+	var validationErr models.ValidationErrors
+	if _, err := limiter.SetRule(ctx, "example.com", "block", "", -time.Second); !errors.As(err, &validationErr) || len(validationErr) != 3 {
+		t.Errorf("SetRule() of an invalid rule error = %v, want 3 validation errors", err)
+	}
+}
+
+func TestInspectReset(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	principal := func(r *http.Request) (ratelimit.Principal, bool) {
+		user := r.Header.Get("X-Test-User")
+		return ratelimit.Principal{ID: ratelimit.UserPrincipal(user), Plan: "free"}, user != ""
+	}
+	request := func(handler http.Handler, userAgent, user string) {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("User-Agent", userAgent)
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	limits := map[string]ratelimit.Config{
+		"api":   {Requests: 10, Window: time.Minute, Burst: 10},
+		"login": {Requests: 10, Window: time.Minute, Burst: 10},
+	}
+	plans := map[string]ratelimit.Plan{"free": {Name: "free", Daily: 100}}
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), limits, plans, principal, logger.New("error"))
+	api := limiter.Middleware("api")(ok)
+	login := limiter.Middleware("login")(ok)
+
+	before := testutil.ToFloat64(metrics.RateLimitRequests.WithLabelValues("api", "allowed"))
+	request(api, "curl", "")
+	request(api, "firefox", "")
+	request(login, "curl", "")
+	request(api, "curl", "alice")
+	request(api, "curl", "alice")
+	if got := testutil.ToFloat64(metrics.RateLimitRequests.WithLabelValues("api", "allowed")) - before; got != 4 {
+		t.Errorf("allowed api requests recorded = %v, want 4", got)
+	}
+
+	ctx := context.Background()
+	ip, err := ratelimit.NewClient("192.0.2.1", ratelimit.Principal{})
+	if err != nil {
+		t.Fatalf("NewClient() unexpected error: %v", err)
+	}
+	status, err := limiter.Inspect(ctx, ip)
+	if err != nil {
+		t.Fatalf("Inspect() unexpected error: %v", err)
+	}
+	// One bucket per limit, whatever the user agent; the user's requests
+	// aren't the address's
+	if len(status.Buckets) != 2 || status.Buckets[0].Remaining != 8 || status.Quota != nil {
+		t.Errorf("Inspect() of the address = %+v, want 2 buckets, the api one with 8 remaining, and no quota", status)
+	}
+
+	alice, _ := ratelimit.NewClient("", ratelimit.Principal{ID: ratelimit.UserPrincipal("alice"), Plan: "free"})
+	if status, err = limiter.Inspect(ctx, alice); err != nil {
+		t.Fatalf("Inspect() unexpected error: %v", err)
+	}
+	if len(status.Buckets) != 1 || status.Buckets[0].Remaining != 8 || status.Quota == nil || status.Quota.Daily.Used != 2 {
+		t.Errorf("Inspect() of the user = %+v, want one bucket with 8 remaining and 2 requests used", status)
+	}
+
+	if removed, err := limiter.Reset(ctx, alice); err != nil || removed != 4 {
+		t.Errorf("Reset() = %d, %v, want the bucket, both quotas and the missing login bucket", removed, err)
+	}
+	if status, _ = limiter.Inspect(ctx, alice); len(status.Buckets) != 0 || status.Quota.Daily.Used != 0 {
+		t.Errorf("Inspect() after Reset() = %+v, want nothing used", status)
+	}
+	if status, _ = limiter.Inspect(ctx, ip); len(status.Buckets) != 2 {
+		t.Errorf("Reset() of the user changed the address's buckets: %+v", status)
+	}
+
+	for _, client := range []struct{ ip, user string }{{"", ""}, {"192.0.2.1", "alice"}, {"localhost", ""}} {
+		if _, err := ratelimit.NewClient(client.ip, ratelimit.Principal{ID: client.user}); err == nil {
+			t.Errorf("NewClient(%q, %q) accepted an invalid client", client.ip, client.user)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// gcraScript applies GCRA to one key atomically, in a single round trip.
+// Times are microseconds since the epoch, which Lua's doubles hold exactly.
+// It returns whether the request is allowed and the key's TAT.
+var gcraScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call("GET", KEYS[1])) or now
+if tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval
+if new_tat - now > capacity then
+	return {0, tat}
+end
+
+-- The key expires when the bucket is full again, since a missing key means
+-- the same
+redis.call("SET", KEYS[1], new_tat, "PX", math.ceil((new_tat - now) / 1000))
+return {1, new_tat}
+`)
+
+// consumeScript counts a request against every quota in KEYS, unless one is
+// used up. ARGV holds each key's limit, 0 for none, and the time it resets
+// at in milliseconds since the epoch. It returns whether the request was
+// counted, followed by each key's count.
+var consumeScript = redis.NewScript(`
+local result = {1}
+for i, key in ipairs(KEYS) do
+	local count = tonumber(redis.call("GET", key)) or 0
+	local limit = tonumber(ARGV[i * 2 - 1])
+	if limit > 0 and count >= limit then
+		result[1] = 0
+	end
+	result[i + 1] = count
+end
+if result[1] == 0 then
+	return result
+end
+
+for i, key in ipairs(KEYS) do
+	result[i + 1] = redis.call("INCR", key)
+	redis.call("PEXPIREAT", key, ARGV[i * 2])
+end
+return result
+`)
+
+// rulesKey is the hash of allow and deny rules, by target
+const rulesKey = "ratelimit:rules"
+
+// RedisStore keeps the GCRA state of each key in Redis, so all instances
+// share it. The instances' clocks are compared, so they must be in sync.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Allow checks the request against the key's bucket
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Config, now time.Time) (bool, int, time.Time, error) {
+	result, err := gcraScript.Run(ctx, s.client, []string{key},
+		now.UnixMicro(),
+		limit.emissionInterval().Microseconds(),
+		limit.capacity().Microseconds(),
+	).Slice()
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+	if len(result) != 2 {
+		return false, 0, time.Time{}, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+	allowed, ok1 := result[0].(int64)
+	tatMicros, ok2 := result[1].(int64)
+	if !ok1 || !ok2 {
+		return false, 0, time.Time{}, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+
+	remaining, resetTime := gcraResult(time.UnixMicro(tatMicros), now, limit, allowed == 1)
+	return allowed == 1, remaining, resetTime, nil
+}
+
+// Consume counts one request against every quota if none of them is used up
+func (s *RedisStore) Consume(ctx context.Context, quotas []Quota, now time.Time) (bool, []int64, error) {
+	keys := make([]string, len(quotas))
+	args := make([]interface{}, 0, 2*len(quotas))
+	for i, quota := range quotas {
+		keys[i] = quota.Key
+		args = append(args, quota.Limit, quota.ResetTime.UnixMilli())
+	}
+	result, err := consumeScript.Run(ctx, s.client, keys, args...).Int64Slice()
+	if err != nil {
+		return false, nil, err
+	}
+	if len(result) != len(quotas)+1 {
+		return false, nil, fmt.Errorf("unexpected quota script result %v", result)
+	}
+	return result[0] == 1, result[1:], nil
+}
+
+// Usage returns how many requests each key has counted. Keys that have
+// reset have expired, so they count none.
+func (s *RedisStore) Usage(ctx context.Context, keys []string, now time.Time) ([]int64, error) {
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	used := make([]int64, len(keys))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected quota value %v", value)
+		}
+		if used[i], err = strconv.ParseInt(str, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid quota value %q: %w", str, err)
+		}
+	}
+	return used, nil
+}
+
+// Scan returns the keys matching a Redis glob pattern
+func (s *RedisStore) Scan(ctx context.Context, match string) ([]string, error) {
+	var keys []string
+	iter := s.client.Scan(ctx, 0, match, 1000).Iterator()
+	for iter.Next(ctx) {
+		if key := iter.Val(); key != rulesKey {
+			keys = append(keys, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("scan rate limit keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Buckets returns the TAT of each rate limit key. Full buckets have
+// expired, so they have none.
+func (s *RedisStore) Buckets(ctx context.Context, keys []string) ([]time.Time, error) {
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	tats := make([]time.Time, len(keys))
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		micros, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit state %q: %w", str, err)
+		}
+		tats[i] = time.UnixMicro(micros)
+	}
+	return tats, nil
+}
+
+// Delete removes keys
+func (s *RedisStore) Delete(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+// Rules returns the allow and deny rules
+func (s *RedisStore) Rules(ctx context.Context) ([]Rule, error) {
+	values, err := s.client.HGetAll(ctx, rulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]Rule, 0, len(values))
+	for target, value := range values {
+		var rule Rule
+		if err := json.Unmarshal([]byte(value), &rule); err != nil {
+			return nil, fmt.Errorf("invalid rule for %s: %w", target, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SetRule adds rule, replacing any rule for the same target
+func (s *RedisStore) SetRule(ctx context.Context, rule Rule) error {
+	value, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, rulesKey, rule.Target, value).Err()
+}
+
+// DeleteRule removes the rule for target
+func (s *RedisStore) DeleteRule(ctx context.Context, target string) (bool, error) {
+	deleted, err := s.client.HDel(ctx, rulesKey, target).Result()
+	return deleted > 0, err
+}
+
+// ActiveKeys counts the keys with prefix whose bucket isn't full. It scans
+// rather than using KEYS, which blocks Redis while it walks every key.
+func (s *RedisStore) ActiveKeys(ctx context.Context, prefix string) (int, error) {
+	var count int
+	iter := s.client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("scan rate limit keys: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,112 @@
+package ratelimit_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"auth/internal/middleware/ratelimit"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// slidingLogAllow is the sorted set sliding log the limiter used before
+// GCRA, kept to compare against. It needs two round trips to reject a
+// request and one set member per request in the window.
+func slidingLogAllow(ctx context.Context, client *redis.Client, key string, limit ratelimit.Config, now time.Time) (bool, error) {
+	member := strconv.FormatInt(now.UnixNano(), 10)
+	pipe := client.Pipeline()
+	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(now.Add(-limit.Window).UnixNano(), 10))
+	count := pipe.ZCard(ctx, key)
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixNano()), Member: member})
+	pipe.Expire(ctx, key, limit.Window+time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	if count.Val() >= int64(limit.Burst) {
+		return false, client.ZRem(ctx, key, member).Err()
+	}
+	return true, nil
+}
+
+// newBenchClient connects to the Redis at REDIS_BENCH_ADDR, or to miniredis.
+// miniredis interprets Lua in Go, so only a real Redis gives comparable
+// timings: go test -bench . with REDIS_BENCH_ADDR=localhost:6379.
+func newBenchClient(b *testing.B) *redis.Client {
+	b.Helper()
+	if addr := os.Getenv("REDIS_BENCH_ADDR"); addr != "" {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		b.Cleanup(func() {
+			client.Del(context.Background(), benchKeys...)
+			client.Close()
+		})
+		client.Del(context.Background(), benchKeys...)
+		return client
+	}
+	server := miniredis.NewMiniRedis()
+	if err := server.Start(); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(server.Close)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	b.Cleanup(func() { client.Close() })
+	return client
+}
+
+// benchLimit rejects most requests once the keys are warm, which is where
+// the sliding log pays for its extra round trip
+var benchLimit = ratelimit.Config{Requests: 100, Window: time.Minute, Burst: 100}
+
+var benchKeys = func() []string {
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = "ratelimit:bench:" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func BenchmarkRedisGCRA(b *testing.B) {
+	client := newBenchClient(b)
+	store := ratelimit.NewRedisStore(client)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := range b.N {
+		if _, _, _, err := store.Allow(ctx, benchKeys[i%len(benchKeys)], benchLimit, time.Now()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	// A single value, however many requests were made
+	b.ReportMetric(1, "entries/key")
+}
+
+func BenchmarkRedisSlidingLog(b *testing.B) {
+	client := newBenchClient(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := range b.N {
+		if _, err := slidingLogAllow(ctx, client, benchKeys[i%len(benchKeys)], benchLimit, time.Now()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	var entries int64
+	for _, key := range benchKeys {
+		entries += client.ZCard(ctx, key).Val()
+	}
+	b.ReportMetric(float64(entries)/float64(len(benchKeys)), "entries/key")
+}
+
+func BenchmarkMemoryStore(b *testing.B) {
+	store := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			store.Allow(ctx, "ratelimit:bench:"+strconv.Itoa(i%1000), benchLimit, time.Now())
+			i++
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"time"
+
+	"auth/internal/models"
+)
+
+// Rule actions. Allowed clients skip limits and quotas; denied clients are
+// rejected outright. Deny rules win when both match.
+const (
+	RuleAllow = "allow"
+	RuleDeny  = "deny"
+)
+
+// rulesRefreshInterval is how long each instance uses its copy of the rules,
+// so rules set on another instance apply within it
+const rulesRefreshInterval = 10 * time.Second
+
+// Rule allows or denies the requests of a client. A deny rule that expires
+// is a ban.
+type Rule struct {
+	// Target is an IP address, a CIDR, or a principal ID such as user:<id>
+	Target    string     `json:"target"`
+	Action    string     `json:"action"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// active reports whether the rule applies at now
+func (r Rule) active(now time.Time) bool {
+	return r.ExpiresAt == nil || r.ExpiresAt.After(now)
+}
+
+// ruleSet is an instance's copy of the rules. It is replaced rather than
+// changed, so requests read it without locking.
+type ruleSet struct {
+	rules    []Rule
+	prefixes []netip.Prefix // The network of each rule, invalid for principals
+	loadedAt time.Time
+}
+
+// normalizeTarget validates target and returns it in canonical form, so each
+// address and network has one rule
+func normalizeTarget(target string) (string, bool) {
+	target = strings.TrimSpace(target)
+	if kind, id, ok := strings.Cut(target, ":"); ok && kind == principalUser && id != "" {
+		return target, true
+	}
+	if prefix, err := netip.ParsePrefix(target); err == nil {
+		return prefix.Masked().String(), true
+	}
+	if addr, err := netip.ParseAddr(target); err == nil {
+		return addr.Unmap().WithZone("").String(), true
+	}
+	return "", false
+}
+
+// ruleAction returns the action of the rules matching the client at ip and
+// principal, or "" if none match
+func (rl *RateLimiter) ruleAction(ctx context.Context, ip string, principal string, now time.Time) string {
+	set := rl.ruleSet(ctx, now)
+	addr, addrErr := netip.ParseAddr(ip)
+	var action string
+	for i, rule := range set.rules {
+		if !rule.active(now) {
+			continue
+		}
+		prefix := set.prefixes[i]
+		matches := (principal != "" && rule.Target == principal) ||
+			(addrErr == nil && prefix.IsValid() && prefix.Contains(addr.Unmap()))
+		if !matches {
+			continue
+		}
+		if rule.Action == RuleDeny {
+			return RuleDeny
+		}
+		action = rule.Action
+	}
+	return action
+}
+
+// ruleSet returns the instance's copy of the rules, reloading it when it is
+// stale. One request reloads it while the others use the stale copy, and if
+// the rules can't be loaded, the stale copy is kept until the next refresh.
+func (rl *RateLimiter) ruleSet(ctx context.Context, now time.Time) *ruleSet {
+	set := rl.rules.Load()
+	if set != nil && now.Sub(set.loadedAt) < rulesRefreshInterval {
+		return set
+	}
+	if !rl.rulesLoading.TryLock() {
+		if set == nil {
+			return &ruleSet{}
+		}
+		return set
+	}
+	defer rl.rulesLoading.Unlock()
+
+	rules, err := rl.store.Rules(ctx)
+	if err != nil {
+		rl.logger.Error("failed to load rate limit rules", "error", err)
+		if set == nil {
+			set = &ruleSet{}
+		}
+		stale := *set
+		stale.loadedAt = now
+		rl.rules.Store(&stale)
+		return &stale
+	}
+
+	set = &ruleSet{rules: rules, prefixes: make([]netip.Prefix, len(rules)), loadedAt: now}
+	for i, rule := range rules {
+		if prefix, err := netip.ParsePrefix(rule.Target); err == nil {
+			set.prefixes[i] = prefix
+		} else if addr, err := netip.ParseAddr(rule.Target); err == nil {
+			set.prefixes[i] = netip.PrefixFrom(addr, addr.BitLen())
+		}
+	}
+	rl.rules.Store(set)
+	return set
+}
+
+// Rules returns the rules that apply now
+func (rl *RateLimiter) Rules(ctx context.Context) ([]Rule, error) {
+	rules, err := rl.store.Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	active := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.active(now) {
+			active = append(active, rule)
+		}
+	}
+	return active, nil
+}
+
+// SetRule allows or denies target, until it expires if duration is positive.
+// It applies on this instance at once, and on others within the refresh
+// interval.
+func (rl *RateLimiter) SetRule(ctx context.Context, target, action, reason string, duration time.Duration) (*Rule, error) {
+	errs := models.ValidationErrors{}
+	normalized, ok := normalizeTarget(target)
+	if !ok {
+		errs["target"] = "target must be an IP address, a CIDR or user:<id>"
+	}
+	if action != RuleAllow && action != RuleDeny {
+		errs["action"] = "action must be allow or deny"
+	}
+	if duration < 0 {
+		errs["duration"] = "duration can't be negative"
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	now := time.Now().UTC()
+	rule := Rule{Target: normalized, Action: action, Reason: reason, CreatedAt: now}
+	if duration > 0 {
+		expiresAt := now.Add(duration)
+		rule.ExpiresAt = &expiresAt
+	}
+	if err := rl.store.SetRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	rl.invalidateRules()
+	return &rule, nil
+}
+
+// DeleteRule removes the rule for target, reporting whether there was one
+func (rl *RateLimiter) DeleteRule(ctx context.Context, target string) (bool, error) {
+	normalized, ok := normalizeTarget(target)
+	if !ok {
+		return false, models.ValidationErrors{"target": "target must be an IP address, a CIDR or user:<id>"}
+	}
+	deleted, err := rl.store.DeleteRule(ctx, normalized)
+	if err != nil {
+		return false, err
+	}
+	rl.invalidateRules()
+	return deleted, nil
+}
+
+// invalidateRules makes the next request reload the rules. The old copy is
+// kept for requests that arrive while it loads.
+func (rl *RateLimiter) invalidateRules() {
+	if set := rl.rules.Load(); set != nil {
+		stale := *set
+		stale.loadedAt = time.Time{}
+		rl.rules.Store(&stale)
+	}
+}
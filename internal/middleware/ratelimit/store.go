@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Store keeps the request history rate limits are checked against. Redis
+// shares it between instances; memory keeps it per instance.
+type Store interface {
+	// Allow records a request for key at now if limit allows it, and
+	// returns how many more requests the window allows and when it resets
+	Allow(ctx context.Context, key string, limit Config, now time.Time) (allowed bool, remaining int, resetTime time.Time, err error)
+	// ActiveKeys counts the keys with prefix that still hold requests
+	ActiveKeys(ctx context.Context, prefix string) (int, error)
+	// Consume counts one request against every quota if none of them is
+	// used up, and returns how many requests each has counted
+	Consume(ctx context.Context, quotas []Quota, now time.Time) (allowed bool, used []int64, err error)
+	// Usage returns how many requests each key has counted
+	Usage(ctx context.Context, keys []string, now time.Time) ([]int64, error)
+
+	// Scan returns the keys matching a Redis glob pattern, without blocking
+	// the store while it walks them
+	Scan(ctx context.Context, match string) ([]string, error)
+	// Buckets returns the TAT of each rate limit key, the zero time for keys
+	// whose bucket is full
+	Buckets(ctx context.Context, keys []string) ([]time.Time, error)
+	// Delete removes keys, which resets their buckets and quotas
+	Delete(ctx context.Context, keys []string) error
+
+	// Rules returns the allow and deny rules, including expired ones
+	Rules(ctx context.Context) ([]Rule, error)
+	// SetRule adds rule, replacing any rule for the same target
+	SetRule(ctx context.Context, rule Rule) error
+	// DeleteRule removes the rule for target, reporting whether there was one
+	DeleteRule(ctx context.Context, target string) (bool, error)
+}
+
+// Quota is a counter of requests that resets at a fixed time
+type Quota struct {
+	Key string
+	// Limit is the number of requests allowed before the reset, 0 for no
+	// limit
+	Limit     int64
+	ResetTime time.Time
+}
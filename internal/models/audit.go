@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// Audit actions. Authentication events are recorded with the user as the
+// actor; administrative events with the administrator as the actor and the
+// affected user as the target.
+const (
+	AuditActionSignup          = "auth.signup"
+	AuditActionLogin           = "auth.login"
+	AuditActionLogout          = "auth.logout"
+	AuditActionPasswordChange  = "auth.password_change"
+	AuditActionDeletionRequest = "account.deletion_request"
+	AuditActionDeletionCancel  = "account.deletion_cancel"
+
+	AuditActionSessionRevoke       = "session.revoke"
+	AuditActionSessionRevokeOthers = "session.revoke_others"
+
+	// AuditActionLoginReport locks an account after its user reported a
+	// login that wasn't them
+	AuditActionLoginReport = "account.login_report"
+
+	// AuditActionMFAChallenge is a login that passed the password check and
+	// was asked for a second factor; the login itself is recorded once the
+	// factor is presented
+	AuditActionMFAChallenge = "auth.mfa_challenge"
+	AuditActionStepUp       = "auth.step_up"
+	AuditActionMFAEnroll    = "auth.mfa_enroll"
+	AuditActionMFADisable   = "auth.mfa_disable"
+	AuditActionRiskDenied   = "risk.operation_denied"
+
+	// AuditActionEmailLoginRequest is a request for a magic link or code;
+	// redeeming it is recorded as a login
+	AuditActionEmailLoginRequest = "auth.email_login_request"
+
+	AuditActionUserList          = "user.list"
+	AuditActionUserGet           = "user.get"
+	AuditActionUserDisable       = "user.disable"
+	AuditActionUserEnable        = "user.enable"
+	AuditActionUserPasswordReset = "user.force_password_reset"
+	AuditActionUserRoleChange    = "user.role_change"
+	AuditActionUserPlanChange    = "user.plan_change"
+	AuditActionUserDelete        = "user.delete"
+	AuditActionUserErase         = "user.erase"
+	AuditActionUserTombstone     = "user.tombstone.get"
+
+	AuditActionAuditQuery  = "audit.query"
+	AuditActionAuditExport = "audit.export"
+	// AuditActionCheckpoint marks a signed checkpoint of the hash chain
+	AuditActionCheckpoint = "audit.checkpoint"
+
+	// Rate limit actions target the client's address or principal, or the
+	// rule's target
+	AuditActionRateLimitInspect    = "ratelimit.inspect"
+	AuditActionRateLimitReset      = "ratelimit.reset"
+	AuditActionRateLimitRuleSet    = "ratelimit.rule_set"
+	AuditActionRateLimitRuleDelete = "ratelimit.rule_delete"
+)
+
+// AuditEvent is a single entry in the append-only audit log
+type AuditEvent struct {
+	ID         string    `json:"id" db:"id"`
+	OccurredAt time.Time `json:"occurred_at" db:"occurred_at"`
+	Tenant     string    `json:"tenant" db:"tenant"`
+	// ActorID is the user who performed the action, or "system" for
+	// background jobs. It is empty when the actor is unknown, such as a
+	// failed login for a username that doesn't exist.
+	ActorID  string `json:"actor_id,omitempty" db:"actor_id"`
+	Action   string `json:"action" db:"action"`
+	TargetID string `json:"target_id,omitempty" db:"target_id"`
+	Outcome  string `json:"outcome" db:"outcome"`
+	// Reason explains a failure
+	Reason    string `json:"reason,omitempty" db:"reason"`
+	IP        string `json:"ip,omitempty" db:"ip"`
+	UserAgent string `json:"user_agent,omitempty" db:"user_agent"`
+	RequestID string `json:"request_id,omitempty" db:"request_id"`
+	// Details holds action-specific context, such as the new role of a role
+	// change. It must never contain secrets.
+	Details map[string]string `json:"details,omitempty" db:"details"`
+
+	// Seq is the event's position in the hash chain, starting at 1. Events
+	// written before chaining was enabled have Seq 0 and no hashes.
+	Seq      int64  `json:"seq,omitempty" db:"seq"`
+	PrevHash string `json:"prev_hash,omitempty" db:"prev_hash"`
+	Hash     string `json:"hash,omitempty" db:"hash"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Login challenge methods
+const (
+	// LoginMethodLink sends a magic link to the user's email
+	LoginMethodLink = "link"
+	// LoginMethodCode sends a 6-digit code to the user's email
+	LoginMethodCode = "code"
+	// LoginMethodMFA asks for a code from the user's authenticator app. Its
+	// challenge only counts the attempts; nothing is sent.
+	LoginMethodMFA = "mfa"
+)
+
+// LoginChallenge is a pending login. Only hashes of the secret sent by email
+// and of the browser binding are stored.
+type LoginChallenge struct {
+	ID     string `json:"id" db:"id"`
+	UserID string `json:"user_id" db:"user_id"`
+	Method string `json:"method" db:"method"`
+	// SecretHash is the SHA-256 of the link token or code
+	SecretHash string `json:"-" db:"secret_hash"`
+	// BindingHash is the SHA-256 of the cookie set on the browser that
+	// requested the login
+	BindingHash string     `json:"-" db:"binding_hash"`
+	Attempts    int        `json:"attempts" db:"attempts"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt      *time.Time `json:"used_at,omitempty" db:"used_at"`
+}
+
+// Usable reports whether the challenge can still be redeemed at now
+func (c *LoginChallenge) Usable(now time.Time) bool {
+	return c.UsedAt == nil && now.Before(c.ExpiresAt)
+}
@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+const (
+	ExportStatusPending = "pending"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+)
+
+const (
+	ExportFormatJSON = "json"
+	ExportFormatZIP  = "zip"
+)
+
+// DataExport is a user's request for a copy of their personal data. The
+// bundle is built in the background and kept until ExpiresAt.
+type DataExport struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	Format      string     `json:"format" db:"format"`
+	Status      string     `json:"status" db:"status"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	Data        []byte     `json:"-" db:"data"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+}
+
+// ErasureTombstone records that a user's personal data was erased. It holds
+// no personal data itself, only the account ID and what was erased.
+type ErasureTombstone struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      string    `json:"user_id" db:"user_id"`
+	RequestedBy string    `json:"requested_by" db:"requested_by"`
+	Reason      string    `json:"reason" db:"reason"`
+	Sections    []string  `json:"sections" db:"sections"`
+	ErasedAt    time.Time `json:"erased_at" db:"erased_at"`
+}
+
+// ExportRequest defines the structure for a data export request
+type ExportRequest struct {
+	Format string `json:"format"`
+}
+
+// EraseUserRequest defines the structure for an admin erasure request
+type EraseUserRequest struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// Validate validates the ExportRequest, defaulting the format to zip
+func (r *ExportRequest) Validate() error {
+	switch r.Format {
+	case "":
+		r.Format = ExportFormatZIP
+	case ExportFormatJSON, ExportFormatZIP:
+	default:
+		return ValidationErrors{"format": "format must be json or zip"}
+	}
+	return nil
+}
+
+// Validate validates the EraseUserRequest
+func (r *EraseUserRequest) Validate() error {
+	if r.Reason == "" {
+		return ValidationErrors{"reason": "reason is required"}
+	}
+	return nil
+}
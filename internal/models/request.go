@@ -1,7 +1,6 @@
 package models
 
 import (
-	"errors"
 	"regexp"
 	"strings"
 )
@@ -19,6 +18,81 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// ChangePasswordRequest defines the structure for a password change request
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8"`
+}
+
+// DeleteAccountRequest defines the structure for a self-service account
+// deletion request
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// ReportLoginRequest defines the structure for reporting a login the user
+// doesn't recognize, with the token from the login notification
+type ReportLoginRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// MFALoginRequest defines the structure for completing a login that needs a
+// second factor
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// StepUpRequest defines the structure for re-authenticating before a
+// sensitive operation. Code is needed to step up to MFA.
+type StepUpRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code"`
+}
+
+// MFACodeRequest defines the structure for confirming or disabling an
+// authenticator app with one of its codes
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// EmailLoginRequest defines the structure for a passwordless login request.
+// Method is link or code and defaults to link.
+type EmailLoginRequest struct {
+	Email  string `json:"email" validate:"required,email"`
+	Method string `json:"method"`
+}
+
+// VerifyEmailLoginRequest defines the structure for redeeming a passwordless
+// login, either with the token of a magic link or with the challenge ID and
+// the code sent by email
+type VerifyEmailLoginRequest struct {
+	Token       string `json:"token"`
+	ChallengeID string `json:"challenge_id"`
+	Code        string `json:"code"`
+}
+
+// UpdateRoleRequest defines the structure for an admin role change request
+type UpdateRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// UpdatePlanRequest defines the structure for an admin plan change request
+type UpdatePlanRequest struct {
+	Plan string `json:"plan" validate:"required"`
+}
+
+// RateLimitRuleRequest defines the structure for an admin request to allow
+// or deny a client. A deny rule with a duration is a ban.
+type RateLimitRuleRequest struct {
+	Target string `json:"target" validate:"required"`
+	Action string `json:"action" validate:"required"`
+	// Duration is how long the rule applies, such as 30m or 24h; empty for
+	// no expiry
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
 // APIError represents an API error response
 type APIError struct {
 	Message string            `json:"message"`
@@ -85,6 +159,122 @@ func (r *LoginRequest) Validate() error {
 	return nil
 }
 
+// Validate validates the ChangePasswordRequest
+func (r *ChangePasswordRequest) Validate() error {
+	errors := make(ValidationErrors)
+
+	if r.CurrentPassword == "" {
+		errors["current_password"] = "current password is required"
+	}
+
+	if r.NewPassword == "" {
+		errors["new_password"] = "new password is required"
+	} else if len(r.NewPassword) < 8 {
+		errors["new_password"] = "new password must be at least 8 characters"
+	} else if r.NewPassword == r.CurrentPassword {
+		errors["new_password"] = "new password must differ from the current password"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// Validate validates the DeleteAccountRequest
+func (r *DeleteAccountRequest) Validate() error {
+	if r.Password == "" {
+		return ValidationErrors{"password": "password is required"}
+	}
+	return nil
+}
+
+// Validate validates the ReportLoginRequest
+func (r *ReportLoginRequest) Validate() error {
+	if r.Token == "" {
+		return ValidationErrors{"token": "token is required"}
+	}
+	return nil
+}
+
+// Validate validates the MFALoginRequest
+func (r *MFALoginRequest) Validate() error {
+	errors := make(ValidationErrors)
+
+	if r.MFAToken == "" {
+		errors["mfa_token"] = "mfa token is required"
+	}
+
+	if r.Code == "" {
+		errors["code"] = "code is required"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// Validate validates the StepUpRequest
+func (r *StepUpRequest) Validate() error {
+	if r.Password == "" {
+		return ValidationErrors{"password": "password is required"}
+	}
+	return nil
+}
+
+// Validate validates the MFACodeRequest
+func (r *MFACodeRequest) Validate() error {
+	if r.Code == "" {
+		return ValidationErrors{"code": "code is required"}
+	}
+	return nil
+}
+
+// Validate validates the EmailLoginRequest
+func (r *EmailLoginRequest) Validate() error {
+	errors := make(ValidationErrors)
+
+	if r.Email == "" {
+		errors["email"] = "email is required"
+	} else if !isValidEmail(r.Email) {
+		errors["email"] = "invalid email format"
+	}
+
+	switch r.Method {
+	case "", LoginMethodLink, LoginMethodCode:
+	default:
+		errors["method"] = "method must be link or code"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// Validate validates the VerifyEmailLoginRequest
+func (r *VerifyEmailLoginRequest) Validate() error {
+	if r.Token != "" {
+		return nil
+	}
+
+	errors := make(ValidationErrors)
+
+	if r.ChallengeID == "" {
+		errors["challenge_id"] = "token or challenge id is required"
+	}
+
+	if r.Code == "" {
+		errors["code"] = "code is required"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
 func isValidEmail(email string) bool {
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 	return emailRegex.MatchString(email)
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Session is a single login. The token issued at login carries the session
+// ID in its sid claim and is rejected once the session is revoked or expires.
+type Session struct {
+	ID     string `json:"id" db:"id"`
+	UserID string `json:"user_id" db:"user_id"`
+	// DeviceID is the device cookie the client presented at login. It is
+	// kept out of responses since it lets a device pass as a known one.
+	DeviceID string `json:"-" db:"device_id"`
+	// Device is a short description of the client, such as "Firefox on
+	// Windows", derived from the user agent
+	Device    string `json:"device" db:"device"`
+	UserAgent string `json:"user_agent" db:"user_agent"`
+	IP        string `json:"ip" db:"ip"`
+	// Location is the approximate location of IP, empty when it is unknown
+	Location   string     `json:"location,omitempty" db:"location"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at" db:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Active reports whether the session's token is still accepted at now
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -2,31 +2,109 @@ package models
 
 import "time"
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Account states. A pending deletion can be cancelled by the user until its
+// scheduled time; a deleted account keeps its data until it is purged. A
+// locked account was reported as compromised by its user and stays locked
+// until an administrator enables it.
+const (
+	StatusActive          = "active"
+	StatusDisabled        = "disabled"
+	StatusLocked          = "locked"
+	StatusPendingDeletion = "pending_deletion"
+	StatusDeleted         = "deleted"
+)
+
+// PlanFree is the rate limit plan of new accounts. Plans are configured, so
+// an administrator may move an account to any other configured plan.
+const PlanFree = "free"
+
 // User defines the structure for a user
 type User struct {
-	ID        string    `json:"id" db:"id"`
-	Username  string    `json:"username" db:"username"`
-	Password  string    `json:"-" db:"password"` // Never expose password in JSON
-	Email     string    `json:"email" db:"email"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID                    string `json:"id" db:"id"`
+	Username              string `json:"username" db:"username"`
+	Password              string `json:"-" db:"password"` // Never expose password in JSON
+	Email                 string `json:"email" db:"email"`
+	Role                  string `json:"role" db:"role"`
+	Status                string `json:"status" db:"status"`
+	PasswordResetRequired bool   `json:"password_reset_required" db:"password_reset_required"`
+	// DeletionScheduledAt is when a pending deletion takes effect
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" db:"deletion_scheduled_at"`
+	DeletedAt           *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// PurgedAt is set once the personal data of a deleted account is erased
+	PurgedAt  *time.Time `json:"purged_at,omitempty" db:"purged_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	// MFASecret is the base32 TOTP secret. It is pending enrollment until
+	// MFAEnabledAt is set.
+	MFASecret    string     `json:"-" db:"mfa_secret"`
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at,omitempty" db:"mfa_enabled_at"`
+	// MFALastStep is the TOTP time step of the last code accepted, so that a
+	// code can't be used twice
+	MFALastStep int64 `json:"-" db:"mfa_last_step"`
+	// Plan names the rate limit plan, which sets the account's limits and
+	// daily and monthly quotas
+	Plan string `json:"plan" db:"plan"`
 }
 
 // UserResponse represents user data for API responses
 type UserResponse struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                    string     `json:"id"`
+	Username              string     `json:"username"`
+	Email                 string     `json:"email"`
+	Role                  string     `json:"role"`
+	Status                string     `json:"status"`
+	PasswordResetRequired bool       `json:"password_reset_required,omitempty"`
+	DeletionScheduledAt   *time.Time `json:"deletion_scheduled_at,omitempty"`
+	DeletedAt             *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+	MFAEnabled            bool       `json:"mfa_enabled"`
+	Plan                  string     `json:"plan"`
 }
 
 func (u *User) ToResponse() *UserResponse {
 	return &UserResponse{
-		ID:        u.ID,
-		Username:  u.Username,
-		Email:     u.Email,
-		CreatedAt: u.CreatedAt,
-		UpdatedAt: u.UpdatedAt,
+		ID:                    u.ID,
+		Username:              u.Username,
+		Email:                 u.Email,
+		Role:                  u.Role,
+		Status:                u.Status,
+		PasswordResetRequired: u.PasswordResetRequired,
+		DeletionScheduledAt:   u.DeletionScheduledAt,
+		DeletedAt:             u.DeletedAt,
+		CreatedAt:             u.CreatedAt,
+		UpdatedAt:             u.UpdatedAt,
+		MFAEnabled:            u.MFAEnabled(),
+		Plan:                  u.Plan,
+	}
+}
+
+// SetDefaults fills in the role, status and plan of a newly created user
+func (u *User) SetDefaults() {
+	if u.Role == "" {
+		u.Role = RoleUser
 	}
+	if u.Status == "" {
+		u.Status = StatusActive
+	}
+	if u.Plan == "" {
+		u.Plan = PlanFree
+	}
+}
+
+// MFAEnabled reports whether the user has finished enrolling a TOTP
+// authenticator
+func (u *User) MFAEnabled() bool {
+	return u.MFAEnabledAt != nil && u.MFASecret != ""
+}
+
+// CanLogin reports whether the account may authenticate. Accounts pending
+// deletion can still log in so that the user is able to cancel the deletion.
+func (u *User) CanLogin() bool {
+	return u.Status == StatusActive || u.Status == StatusPendingDeletion
 }
@@ -0,0 +1,113 @@
+// Package notify delivers security notifications, such as a login from a new
+// device, to users and to other systems. Each Notifier sends notifications
+// over one channel; Multi combines them and Async keeps slow mail servers or
+// webhooks off the request path.
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"auth/internal/logger"
+)
+
+// Notification kinds
+const (
+	KindNewDevice       = "new_device"
+	KindSuspiciousLogin = "suspicious_login"
+)
+
+// KindEmailLogin carries a magic link or code for a passwordless login. Its
+// Text holds the secret, so it must only be sent to the user's own inbox.
+const KindEmailLogin = "email_login"
+
+// Notification is a message to a user about their account
+type Notification struct {
+	Kind     string `json:"kind"`
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	// Email is the address the message is sent to. Email notifiers skip
+	// notifications without one.
+	Email   string `json:"email,omitempty"`
+	Subject string `json:"subject"`
+	// Text is the plain text message shown to the user
+	Text string `json:"text"`
+	// Data carries the details of the event for machine consumers
+	Data map[string]string `json:"data,omitempty"`
+	Time time.Time         `json:"time"`
+}
+
+// Notifier delivers notifications over one channel
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Multi sends every notification through each of its notifiers. A failing
+// notifier does not stop the others; their errors are joined.
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, n *Notification) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Log writes notifications to the log instead of delivering them. It stands
+// in when no delivery channel is configured.
+type Log struct {
+	logger *logger.Logger
+}
+
+func NewLog(logger *logger.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+func (l *Log) Notify(ctx context.Context, n *Notification) error {
+	l.logger.Info("notification not delivered, no notifier configured",
+		"kind", n.Kind,
+		"user_id", n.UserID,
+		"subject", n.Subject,
+	)
+	return nil
+}
+
+// Async delivers notifications in the background so callers never wait for
+// delivery. Failures are logged, since nobody is left to return them to.
+type Async struct {
+	next    Notifier
+	timeout time.Duration
+	logger  *logger.Logger
+	wg      sync.WaitGroup
+}
+
+// NewAsync sends notifications through next, giving each one timeout to be
+// delivered
+func NewAsync(next Notifier, timeout time.Duration, logger *logger.Logger) *Async {
+	return &Async{next: next, timeout: timeout, logger: logger}
+}
+
+// Notify starts delivering n and returns immediately. Delivery outlives the
+// request ctx belongs to.
+func (a *Async) Notify(ctx context.Context, n *Notification) error {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.timeout)
+		defer cancel()
+		if err := a.next.Notify(ctx, n); err != nil {
+			a.logger.Error("failed to send notification", "error", err, "kind", n.Kind, "user_id", n.UserID)
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until the notifications already started have been delivered
+func (a *Async) Wait() {
+	a.wg.Wait()
+}
@@ -0,0 +1,210 @@
+package notify_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/logger"
+	"auth/internal/notify"
+)
+
+func testNotification() *notify.Notification {
+	return &notify.Notification{
+		Kind:     notify.KindNewDevice,
+		UserID:   "user-1",
+		Username: "alice",
+		Email:    "alice@example.com",
+		Subject:  "New sign-in to your account",
+		Text:     "Hi alice,\nsomeone signed in.\n",
+		Data:     map[string]string{"ip": "192.0.2.1"},
+		Time:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got notify.Notification
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get(notify.SignatureHeader)
+		if signature != notify.Sign([]byte("secret"), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := notify.NewWebhook(server.URL, "secret").Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify() unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Errorf("signature = %q, want a sha256= prefix", signature)
+	}
+	if got.Kind != notify.KindNewDevice || got.UserID != "user-1" || got.Data["ip"] != "192.0.2.1" {
+		t.Errorf("webhook received %+v", got)
+	}
+
+	if err := notify.NewWebhook(server.URL, "wrong").Notify(context.Background(), testNotification()); err == nil {
+		t.Error("Notify() succeeded although the webhook rejected the request")
+	}
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	sent []*notify.Notification
+	err  error
+}
+
+func (r *recorder) Notify(ctx context.Context, n *notify.Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return r.err
+}
+
+func TestMulti(t *testing.T) {
+	failing := &recorder{err: errors.New("unavailable")}
+	working := &recorder{}
+
+	err := notify.Multi{failing, working}.Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("Notify() error = %v, want the failing notifier's error", err)
+	}
+	if len(working.sent) != 1 {
+		t.Error("a failing notifier stopped the next one")
+	}
+}
+
+func TestAsync(t *testing.T) {
+	next := &recorder{}
+	async := notify.NewAsync(next, time.Second, logger.New("error"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 3; i++ {
+		if err := async.Notify(ctx, testNotification()); err != nil {
+			t.Fatalf("Notify() unexpected error: %v", err)
+		}
+	}
+	// Delivery outlives the request that triggered it
+	cancel()
+	async.Wait()
+
+	if len(next.sent) != 3 {
+		t.Errorf("delivered %d notifications, want 3", len(next.sent))
+	}
+}
+
+func TestEmail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() unexpected error: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan smtpMessage, 1)
+	go serveSMTP(listener, received)
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	email := notify.NewEmail("127.0.0.1", portNumber, "", "", "Security <security@example.com>")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := email.Notify(ctx, testNotification()); err != nil {
+		t.Fatalf("Notify() unexpected error: %v", err)
+	}
+
+	message := <-received
+	if message.from != "<security@example.com>" || message.to != "<alice@example.com>" {
+		t.Errorf("envelope = %s -> %s", message.from, message.to)
+	}
+	for _, want := range []string{
+		"Subject: New sign-in to your account\r\n",
+		"To: <alice@example.com>\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nHi alice,\r\nsomeone signed in.\r\n",
+	} {
+		if !strings.Contains(message.data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, message.data)
+		}
+	}
+
+	// Notifications for users without an address are skipped
+	n := testNotification()
+	n.Email = ""
+	if err := email.Notify(ctx, n); err != nil {
+		t.Errorf("Notify() without an address error = %v", err)
+	}
+	n.Email = "alice@example.com\r\nBcc: mallory@example.com"
+	if err := email.Notify(ctx, n); err == nil {
+		t.Error("Notify() accepted an address with a header in it")
+	}
+}
+
+type smtpMessage struct {
+	from, to, data string
+}
+
+// serveSMTP accepts one connection and speaks just enough SMTP to receive a
+// message
+func serveSMTP(listener net.Listener, received chan<- smtpMessage) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+
+	var message smtpMessage
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message.from = strings.TrimPrefix(command, "MAIL FROM:")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.to = strings.TrimPrefix(command, "RCPT TO:")
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			message.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			received <- message
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
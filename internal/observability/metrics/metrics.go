@@ -1,9 +1,11 @@
 package metrics
 
 import (
+	"context"
 	"net/http"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -56,6 +58,15 @@ var (
 		[]string{"type", "result"},
 	)
 
+	// Rate limiting metrics
+	RateLimitRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_requests_total",
+			Help: "Total number of requests checked by the rate limiter",
+		},
+		[]string{"limit_type", "result"},
+	)
+
 	ActiveSessions = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "active_sessions_count",
@@ -88,6 +99,7 @@ func init() {
 		DatabaseQueriesTotal,
 		DatabaseQueryDuration,
 		AuthenticationAttempts,
+		RateLimitRequests,
 		ActiveSessions,
 		GoRoutines,
 		MemoryUsage,
@@ -99,21 +111,69 @@ func MetricsHandler() http.Handler {
 	return promhttp.Handler()
 }
 
-// HTTPMiddleware for collecting HTTP metrics
+// HTTPMiddleware for collecting HTTP metrics. Requests are labeled by the
+// pattern of the route that served them, as recorded by Routes, so paths
+// with IDs in them share one series.
 func HTTPMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		
+		r = r.WithContext(TrackRoute(r.Context()))
+
 		// Wrap the response writer to capture status code
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-		
+
 		next.ServeHTTP(wrapped, r)
-		
+
 		duration := time.Since(start).Seconds()
 		status := strconv.Itoa(wrapped.statusCode)
-		
-		HTTPRequestsTotal.WithLabelValues(r.Method, r.URL.Path, status).Inc()
-		HTTPRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(duration)
+		endpoint := Route(r.Context())
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+
+		HTTPRequestsTotal.WithLabelValues(r.Method, endpoint, status).Inc()
+		HTTPRequestDuration.WithLabelValues(r.Method, endpoint).Observe(duration)
+	})
+}
+
+// routeKey holds the route of a request, as recorded by Routes
+type routeKey struct{}
+
+// TrackRoute returns ctx with a place for Routes to record the route of the
+// request in, unless it already has one
+func TrackRoute(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(routeKey{}).(*string); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, routeKey{}, new(string))
+}
+
+// Route returns the pattern of the route recorded for the request, such as
+// /admin/users/{id}, or "" if no route matched or it isn't tracked
+func Route(ctx context.Context) string {
+	if route, ok := ctx.Value(routeKey{}).(*string); ok {
+		return *route
+	}
+	return ""
+}
+
+// Routes records the pattern of the route mux serves a request with, for
+// requests whose route is tracked. Every mux should be wrapped, including
+// those behind middleware: the innermost mux runs last and its more specific
+// pattern is kept. The route is recorded before the handler runs, so
+// requests that panic are labeled too.
+func Routes(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			if _, pattern := mux.Handler(r); pattern != "" {
+				// The method is a label of its own
+				if i := strings.Index(pattern, " "); i >= 0 {
+					pattern = pattern[i+1:]
+				}
+				*route = pattern
+			}
+		}
+		mux.ServeHTTP(w, r)
 	})
 }
 
@@ -138,6 +198,11 @@ func RecordAuthenticationAttempt(authType, result string) {
 	AuthenticationAttempts.WithLabelValues(authType, result).Inc()
 }
 
+// RecordRateLimit records the rate limiter's decision on a request
+func RecordRateLimit(limitType, result string) {
+	RateLimitRequests.WithLabelValues(limitType, result).Inc()
+}
+
 // UpdateActiveSession updates active sessions count
 func UpdateActiveSessions(count float64) {
 	ActiveSessions.Set(count)
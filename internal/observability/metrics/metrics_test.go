@@ -0,0 +1,63 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"auth/internal/observability/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHTTPMiddlewareRoutes(t *testing.T) {
+	inner := http.NewServeMux()
+	inner.HandleFunc("GET /things/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	inner.HandleFunc("GET /things/{id}/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	outer := http.NewServeMux()
+	// Middleware between the muxes replaces the request, as JWT does
+	outer.Handle("/things/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.Routes(inner).ServeHTTP(w, r.WithContext(r.Context()))
+	}))
+	outer.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+
+	recovery := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if recover() != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := metrics.HTTPMiddleware(recovery(metrics.Routes(outer)))
+
+	// Paths with different IDs share their route's series
+	paths := []string{"/things/1", "/things/2", "/things/3/panic", "/health", "/nowhere"}
+	want := []struct {
+		endpoint, status string
+		count            float64
+	}{
+		{"/things/{id}", "200", 2},
+		{"/things/{id}/panic", "500", 1},
+		{"/health", "200", 1},
+		{"unmatched", "404", 1},
+	}
+
+	before := make([]float64, len(want))
+	for i, w := range want {
+		before[i] = testutil.ToFloat64(metrics.HTTPRequestsTotal.WithLabelValues("GET", w.endpoint, w.status))
+	}
+	for _, path := range paths {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	for i, w := range want {
+		got := testutil.ToFloat64(metrics.HTTPRequestsTotal.WithLabelValues("GET", w.endpoint, w.status)) - before[i]
+		if got != w.count {
+			t.Errorf("requests to %s with status %s = %v, want %v", w.endpoint, w.status, got, w.count)
+		}
+	}
+}
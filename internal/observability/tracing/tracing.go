@@ -2,13 +2,22 @@ package tracing
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 
+	"auth/internal/clientip"
+	"auth/internal/config"
 	"auth/internal/logger"
-	"github.com/google/uuid"
+	"auth/internal/observability/metrics"
+	"auth/internal/reqctx"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
@@ -20,117 +29,149 @@ const (
 	TracerName = "go-auth-api"
 )
 
-type contextKey string
-
+// Exporters
 const (
-	TraceIDKey      contextKey = "trace_id"
-	SpanIDKey       contextKey = "span_id"
-	CorrelationKey  contextKey = "correlation_id"
+	ExporterNone     = "none"
+	ExporterOTLPGRPC = "otlp-grpc"
+	ExporterOTLPHTTP = "otlp-http"
+	ExporterStdout   = "stdout"
 )
 
-// TracingConfig holds tracing configuration
-type TracingConfig struct {
-	ServiceName     string
-	ServiceVersion  string
-	Environment     string
-	JaegerEndpoint  string
-	SamplingRatio   float64
-	Enabled         bool
-}
-
 // Tracer wraps OpenTelemetry tracer with additional functionality
 type Tracer struct {
-	tracer trace.Tracer
-	config *TracingConfig
-	logger *logger.Logger
+	tracer   trace.Tracer
+	provider *tracesdk.TracerProvider
+	file     io.Closer
+	logger   *logger.Logger
 }
 
-// New creates a new tracer instance
-func New(config *TracingConfig, logger *logger.Logger) (*Tracer, error) {
-	if !config.Enabled {
-		return &Tracer{
-			tracer: otel.GetTracerProvider().Tracer(TracerName),
-			config: config,
-			logger: logger,
-		}, nil
+// New installs the tracer provider for the exporter selected by cfg as the
+// global provider, which the rest of the service starts its spans with. With
+// the none exporter the global provider is left as is, and spans cost
+// nothing. Shutdown flushes the spans not exported yet.
+func New(cfg config.TracingConfig, logger *logger.Logger) (*Tracer, error) {
+	t := &Tracer{logger: logger}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("trace sample ratio %v is not between 0 and 1", cfg.SampleRatio)
 	}
 
-	// Create Jaeger exporter
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(config.JaegerEndpoint)))
+	var exp tracesdk.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		t.tracer = otel.GetTracerProvider().Tracer(TracerName)
+		return t, nil
+	case ExporterOTLPGRPC:
+		opts := []otlptracegrpc.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exp, err = otlptracegrpc.New(context.Background(), opts...)
+	case ExporterOTLPHTTP:
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exp, err = otlptracehttp.New(context.Background(), opts...)
+	case ExporterStdout:
+		var w io.Writer = os.Stdout
+		if cfg.File != "" {
+			file, openErr := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+			if openErr != nil {
+				return nil, fmt.Errorf("failed to open trace file: %w", openErr)
+			}
+			w, t.file = file, file
+		}
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want %s, %s, %s or %s",
+			cfg.Exporter, ExporterOTLPGRPC, ExporterOTLPHTTP, ExporterStdout, ExporterNone)
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Exporter, err)
 	}
 
 	// Create tracer provider
-	tp := tracesdk.NewTracerProvider(
+	t.provider = tracesdk.NewTracerProvider(
 		tracesdk.WithBatcher(exp),
 		tracesdk.WithResource(resource.NewWithAttributes(
 			semconv.SchemaURL,
-			semconv.ServiceName(config.ServiceName),
-			semconv.ServiceVersion(config.ServiceVersion),
-			semconv.DeploymentEnvironment(config.Environment),
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.ServiceVersion(cfg.ServiceVersion),
+			semconv.DeploymentEnvironment(cfg.Environment),
 		)),
-		tracesdk.WithSampler(tracesdk.TraceIDRatioBased(config.SamplingRatio)),
+		tracesdk.WithSampler(tracesdk.ParentBased(tracesdk.TraceIDRatioBased(cfg.SampleRatio))),
 	)
+	Install(t.provider)
+	t.tracer = t.provider.Tracer(TracerName)
 
-	// Register the tracer provider
-	otel.SetTracerProvider(tp)
+	logger.Info("distributed tracing initialized",
+		"service", cfg.ServiceName,
+		"environment", cfg.Environment,
+		"exporter", cfg.Exporter,
+		"endpoint", cfg.Endpoint)
 
-	// Set global propagator
+	return t, nil
+}
+
+// Install makes provider the global tracer provider and propagates W3C trace
+// context and baggage. Tests install one with a span recorder.
+func Install(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
 	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
 		propagation.TraceContext{},
 		propagation.Baggage{},
 	))
+}
 
-	tracer := tp.Tracer(TracerName)
-
-	logger.Info("distributed tracing initialized",
-		"service", config.ServiceName,
-		"environment", config.Environment,
-		"jaeger_endpoint", config.JaegerEndpoint)
-
-	return &Tracer{
-		tracer: tracer,
-		config: config,
-		logger: logger,
-	}, nil
+// Shutdown exports the spans still buffered and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+	err := t.provider.Shutdown(ctx)
+	if t.file != nil {
+		if closeErr := t.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
-// HTTPMiddleware returns middleware for HTTP request tracing
+// HTTPMiddleware returns middleware for HTTP request tracing. Each request
+// gets a server span, continuing the caller's trace if it sent one, named by
+// the route that served it.
 func (t *Tracer) HTTPMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract trace context from headers
 			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
-			
-			// Generate correlation ID
-			correlationID := generateCorrelationID()
-			ctx = context.WithValue(ctx, CorrelationKey, correlationID)
-			
-			// Start new span
-			ctx, span := t.tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			ctx = metrics.TrackRoute(ctx)
+
+			// Start new span. It is renamed once the route is known.
+			ctx, span := t.tracer.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
 				trace.WithAttributes(
 					semconv.HTTPMethod(r.Method),
 					semconv.HTTPTarget(r.URL.Path),
-					semconv.HTTPScheme(r.URL.Scheme),
+					semconv.HTTPScheme(scheme(r)),
 					semconv.HTTPUserAgent(r.UserAgent()),
-					semconv.HTTPClientIP(getRealIP(r)),
-					attribute.String("correlation.id", correlationID),
+					semconv.HTTPClientIP(clientip.FromRequest(r)),
+					attribute.String("request.id", reqctx.FromContext(ctx).RequestID),
 				),
 			)
 			defer span.End()
 
-			// Add trace info to context
+			// Add tracing headers to response
 			if span.SpanContext().HasTraceID() {
-				ctx = context.WithValue(ctx, TraceIDKey, span.SpanContext().TraceID().String())
+				w.Header().Set("X-Trace-Id", span.SpanContext().TraceID().String())
 			}
-			if span.SpanContext().HasSpanID() {
-				ctx = context.WithValue(ctx, SpanIDKey, span.SpanContext().SpanID().String())
-			}
-
-			// Add tracing headers to response
-			w.Header().Set("X-Trace-Id", span.SpanContext().TraceID().String())
-			w.Header().Set("X-Correlation-Id", correlationID)
 
 			// Wrap response writer to capture status code
 			wrapped := &tracingResponseWriter{ResponseWriter: w, statusCode: 200}
@@ -138,22 +179,27 @@ func (t *Tracer) HTTPMiddleware() func(http.Handler) http.Handler {
 			// Continue with request
 			next.ServeHTTP(wrapped, r.WithContext(ctx))
 
-			// Add response attributes to span
-			span.SetAttributes(
-				semconv.HTTPStatusCode(wrapped.statusCode),
-			)
+			if route := metrics.Route(ctx); route != "" {
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(semconv.HTTPRoute(route))
+			}
+			span.SetAttributes(semconv.HTTPStatusCode(wrapped.statusCode))
 
-			// Set span status based on HTTP status
-			if wrapped.statusCode >= 400 {
-				span.RecordError(
-					&HTTPError{StatusCode: wrapped.statusCode},
-					trace.WithAttributes(attribute.Int("http.status_code", wrapped.statusCode)),
-				)
+			// Client errors are the client's; only server errors fail the span
+			if wrapped.statusCode >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(wrapped.statusCode))
 			}
 		})
 	}
 }
 
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
 type tracingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -164,13 +210,13 @@ func (w *tracingResponseWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
-// HTTPError represents an HTTP error for tracing
-type HTTPError struct {
-	StatusCode int
-}
-
-func (e *HTTPError) Error() string {
-	return http.StatusText(e.StatusCode)
+// End ends span, recording err and failing the span if err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
 
 // StartSpan starts a new span with the given name
@@ -178,26 +224,6 @@ func (t *Tracer) StartSpan(ctx context.Context, name string, opts ...trace.SpanS
 	return t.tracer.Start(ctx, name, opts...)
 }
 
-// TraceDatabase wraps database operations with tracing
-func (t *Tracer) TraceDatabase(ctx context.Context, operation, table string) (context.Context, trace.Span) {
-	return t.tracer.Start(ctx, "db."+operation,
-		trace.WithAttributes(
-			semconv.DBOperation(operation),
-			semconv.DBSQLTable(table),
-			semconv.DBSystem("postgresql"),
-		),
-	)
-}
-
-// TraceAuthentication wraps authentication operations with tracing
-func (t *Tracer) TraceAuthentication(ctx context.Context, authType string) (context.Context, trace.Span) {
-	return t.tracer.Start(ctx, "auth."+authType,
-		trace.WithAttributes(
-			attribute.String("auth.type", authType),
-		),
-	)
-}
-
 // TraceExternalCall wraps external service calls with tracing
 func (t *Tracer) TraceExternalCall(ctx context.Context, service, operation string) (context.Context, trace.Span) {
 	return t.tracer.Start(ctx, "external."+service+"."+operation,
@@ -225,50 +251,22 @@ func RecordError(ctx context.Context, err error, attributes ...attribute.KeyValu
 }
 
 // SetSpanStatus sets the status of the current span
-func SetSpanStatus(ctx context.Context, code trace.StatusCode, description string) {
+func SetSpanStatus(ctx context.Context, code codes.Code, description string) {
 	span := trace.SpanFromContext(ctx)
 	if span != nil {
 		span.SetStatus(code, description)
 	}
 }
 
-// GetTraceID extracts trace ID from context
+// GetTraceID returns the ID of the trace ctx is part of, or "" if it isn't
+// being traced
 func GetTraceID(ctx context.Context) string {
-	if traceID, ok := ctx.Value(TraceIDKey).(string); ok {
-		return traceID
-	}
-	return ""
-}
-
-// GetCorrelationID extracts correlation ID from context
-func GetCorrelationID(ctx context.Context) string {
-	if correlationID, ok := ctx.Value(CorrelationKey).(string); ok {
-		return correlationID
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		return spanContext.TraceID().String()
 	}
 	return ""
 }
 
-// generateCorrelationID generates a new correlation ID
-func generateCorrelationID() string {
-	return uuid.New().String()
-}
-
-// getRealIP extracts the real IP address from request
-func getRealIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
-	}
-	
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	
-	// Fallback to RemoteAddr
-	return r.RemoteAddr
-}
-
 // InjectHeaders injects tracing headers into HTTP request
 func (t *Tracer) InjectHeaders(ctx context.Context, headers http.Header) {
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(headers))
@@ -277,4 +275,4 @@ func (t *Tracer) InjectHeaders(ctx context.Context, headers http.Header) {
 // ExtractContext extracts tracing context from HTTP headers
 func (t *Tracer) ExtractContext(ctx context.Context, headers http.Header) context.Context {
 	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(headers))
-}
\ No newline at end of file
+}
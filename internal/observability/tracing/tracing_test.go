@@ -0,0 +1,121 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/observability/metrics"
+	"auth/internal/observability/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHTTPMiddlewareSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracing.Install(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)))
+	tracer, err := tracing.New(config.TracingConfig{Exporter: tracing.ExporterNone}, logger.New("error"))
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := otel.Tracer("test").Start(r.Context(), "child")
+		span.End()
+	})
+	mux.HandleFunc("GET /broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	handler := tracer.HTTPMiddleware()(metrics.Routes(mux))
+
+	// The caller's trace is continued
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest("GET", "/things/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Trace-Id"); got != traceID {
+		t.Errorf("X-Trace-Id = %q, want %q", got, traceID)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nowhere", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("ended %d spans, want 4", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name() != "GET /things/{id}" {
+		t.Errorf("server span name = %q, want %q", server.Name(), "GET /things/{id}")
+	}
+	if server.Parent().SpanID().String() != parentID || server.SpanContext().TraceID().String() != traceID {
+		t.Errorf("server span parent = %v, want the caller's span", server.Parent())
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("handler span is not a child of the server span")
+	}
+	attrs := attribute.NewSet(server.Attributes()...)
+	if got, _ := attrs.Value("http.route"); got.AsString() != "/things/{id}" {
+		t.Errorf("http.route = %q, want %q", got.AsString(), "/things/{id}")
+	}
+	if got, _ := attrs.Value("http.status_code"); got.AsInt64() != 200 {
+		t.Errorf("http.status_code = %d, want 200", got.AsInt64())
+	}
+
+	// Only server errors fail the span; unmatched requests keep the method
+	// as their name
+	if broken := spans[2]; broken.Name() != "GET /broken" || broken.Status().Code != codes.Error {
+		t.Errorf("span %q status = %v, want %q with an error", broken.Name(), broken.Status().Code, "GET /broken")
+	}
+	if unmatched := spans[3]; unmatched.Name() != "GET" || unmatched.Status().Code != codes.Unset {
+		t.Errorf("span %q status = %v, want %q without one", unmatched.Name(), unmatched.Status().Code, "GET")
+	}
+}
+
+func TestNewStdoutExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	tracer, err := tracing.New(config.TracingConfig{
+		Exporter:    tracing.ExporterStdout,
+		File:        path,
+		SampleRatio: 1,
+		ServiceName: "test",
+	}, logger.New("error"))
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	_, span := tracer.StartSpan(context.Background(), "exported")
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() unexpected error: %v", err)
+	}
+	if !strings.Contains(string(data), `"Name":"exported"`) {
+		t.Errorf("trace file = %s, want the exported span", data)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	for _, cfg := range []config.TracingConfig{
+		{Exporter: "jaeger"},
+		{Exporter: tracing.ExporterStdout, SampleRatio: 2},
+	} {
+		if _, err := tracing.New(cfg, logger.New("error")); err == nil {
+			t.Errorf("New(%+v) expected an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"auth/internal/models"
+)
+
+// AuditFilter selects a page of audit events. Zero values disable a filter.
+type AuditFilter struct {
+	Tenant   string
+	ActorID  string
+	TargetID string
+	// UserID matches events where the user is either the actor or the target
+	UserID    string
+	Action    string
+	Outcome   string
+	RequestID string
+	// Since and Until bound OccurredAt; Since is inclusive, Until exclusive
+	Since  time.Time
+	Until  time.Time
+	Cursor string
+	Limit  int
+}
+
+// AuditPage is one page of an audit log listing, newest event first
+type AuditPage struct {
+	Events []*models.AuditEvent
+	// NextCursor is empty when there are no more results
+	NextCursor string
+}
+
+// PageSize returns the effective page size for the filter
+func (f *AuditFilter) PageSize() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultPageSize
+	case f.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return f.Limit
+}
+
+// AuditCursor is the keyset position after which the next page starts
+type AuditCursor struct {
+	OccurredAt time.Time `json:"t"`
+	ID         string    `json:"id"`
+}
+
+// NewAuditCursor returns the cursor that continues after event
+func NewAuditCursor(event *models.AuditEvent) AuditCursor {
+	return AuditCursor{OccurredAt: event.OccurredAt.UTC(), ID: event.ID}
+}
+
+// Encode returns the opaque string handed to API clients
+func (c AuditCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses the filter's cursor. It returns nil when the filter has
+// no cursor.
+func (f *AuditFilter) DecodeCursor() (*AuditCursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor AuditCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" || cursor.OccurredAt.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
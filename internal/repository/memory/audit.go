@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+type AuditRepository struct {
+	store *Store
+}
+
+func (r *AuditRepository) Append(ctx context.Context, event *models.AuditEvent) error {
+	return r.store.write(func(t *tables) error {
+		for _, existing := range t.audit {
+			if existing.ID == event.ID || (event.Seq > 0 && existing.Seq == event.Seq) {
+				return repository.ErrConflict
+			}
+		}
+		t.audit = append(t.audit, copyAuditEvent(event))
+		return nil
+	})
+}
+
+func (r *AuditRepository) List(ctx context.Context, filter repository.AuditFilter) (*repository.AuditPage, error) {
+	cursor, err := filter.DecodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*models.AuditEvent
+	r.store.read(func(t *tables) error {
+		for _, event := range t.audit {
+			if matchesAuditFilter(event, &filter) && (cursor == nil || beforeCursor(event, cursor)) {
+				events = append(events, copyAuditEvent(event))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(events, func(i, j int) bool {
+		if c := events[i].OccurredAt.Compare(events[j].OccurredAt); c != 0 {
+			return c > 0
+		}
+		return events[i].ID > events[j].ID
+	})
+
+	page := &repository.AuditPage{Events: events}
+	if limit := filter.PageSize(); len(events) > limit {
+		page.Events = events[:limit]
+		page.NextCursor = repository.NewAuditCursor(page.Events[limit-1]).Encode()
+	}
+	return page, nil
+}
+
+func (r *AuditRepository) Head(ctx context.Context) (*models.AuditEvent, error) {
+	var head *models.AuditEvent
+	r.store.read(func(t *tables) error {
+		for _, event := range t.audit {
+			if event.Seq > 0 && (head == nil || event.Seq > head.Seq) {
+				head = event
+			}
+		}
+		return nil
+	})
+	if head == nil {
+		return nil, repository.ErrNotFound
+	}
+	return copyAuditEvent(head), nil
+}
+
+func (r *AuditRepository) ListBySeq(ctx context.Context, afterSeq int64, limit int) ([]*models.AuditEvent, error) {
+	var events []*models.AuditEvent
+	r.store.read(func(t *tables) error {
+		for _, event := range t.audit {
+			if event.Seq > afterSeq {
+				events = append(events, copyAuditEvent(event))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func matchesAuditFilter(event *models.AuditEvent, filter *repository.AuditFilter) bool {
+	for _, match := range [...]struct{ want, got string }{
+		{filter.Tenant, event.Tenant},
+		{filter.ActorID, event.ActorID},
+		{filter.TargetID, event.TargetID},
+		{filter.Action, event.Action},
+		{filter.Outcome, event.Outcome},
+		{filter.RequestID, event.RequestID},
+	} {
+		if match.want != "" && match.want != match.got {
+			return false
+		}
+	}
+	if filter.UserID != "" && event.ActorID != filter.UserID && event.TargetID != filter.UserID {
+		return false
+	}
+	if !filter.Since.IsZero() && event.OccurredAt.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !event.OccurredAt.Before(filter.Until) {
+		return false
+	}
+	return true
+}
+
+// beforeCursor reports whether event sorts after the cursor position in the
+// newest-first listing
+func beforeCursor(event *models.AuditEvent, cursor *repository.AuditCursor) bool {
+	if c := event.OccurredAt.Compare(cursor.OccurredAt); c != 0 {
+		return c < 0
+	}
+	return event.ID < cursor.ID
+}
+
+func copyAuditEvent(event *models.AuditEvent) *models.AuditEvent {
+	copied := *event
+	if event.Details != nil {
+		copied.Details = make(map[string]string, len(event.Details))
+		for k, v := range event.Details {
+			copied.Details[k] = v
+		}
+	}
+	return &copied
+}
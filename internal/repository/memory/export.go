@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+type ExportRepository struct {
+	store *Store
+}
+
+func (r *ExportRepository) Create(ctx context.Context, export *models.DataExport) error {
+	return r.store.write(func(t *tables) error {
+		if _, exists := t.exports.rows[export.ID]; exists {
+			return repository.ErrConflict
+		}
+		if _, exists := t.users.rows[export.UserID]; !exists {
+			return repository.ErrNotFound
+		}
+		if export.CreatedAt.IsZero() {
+			export.CreatedAt = time.Now()
+		}
+		t.exports.set(export.ID, copyExport(export, true))
+		return nil
+	})
+}
+
+func (r *ExportRepository) GetByID(ctx context.Context, id string) (*models.DataExport, error) {
+	var export *models.DataExport
+	err := r.store.read(func(t *tables) error {
+		stored, ok := t.exports.rows[id]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		export = copyExport(stored, true)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func (r *ExportRepository) Update(ctx context.Context, export *models.DataExport) error {
+	return r.store.write(func(t *tables) error {
+		existing, ok := t.exports.rows[export.ID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		updated := copyExport(export, true)
+		updated.UserID = existing.UserID
+		updated.Format = existing.Format
+		updated.CreatedAt = existing.CreatedAt
+		t.exports.set(export.ID, updated)
+		return nil
+	})
+}
+
+func (r *ExportRepository) ListByUser(ctx context.Context, userID string) ([]*models.DataExport, error) {
+	var exports []*models.DataExport
+	r.store.read(func(t *tables) error {
+		for _, export := range t.exports.rows {
+			if export.UserID == userID {
+				exports = append(exports, copyExport(export, false))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(exports, func(i, j int) bool {
+		if c := exports[i].CreatedAt.Compare(exports[j].CreatedAt); c != 0 {
+			return c > 0
+		}
+		return exports[i].ID > exports[j].ID
+	})
+	return exports, nil
+}
+
+func (r *ExportRepository) DeleteByUser(ctx context.Context, userID string) error {
+	return r.store.write(func(t *tables) error {
+		for id, export := range t.exports.rows {
+			if export.UserID == userID {
+				t.exports.delete(id)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *ExportRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	var deleted int
+	err := r.store.write(func(t *tables) error {
+		for id, export := range t.exports.rows {
+			if export.ExpiresAt.Before(before) {
+				t.exports.delete(id)
+				deleted++
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// copyExport returns a deep copy of export, optionally leaving out its data
+func copyExport(export *models.DataExport, withData bool) *models.DataExport {
+	copied := *export
+	copied.CompletedAt = copyTime(export.CompletedAt)
+	copied.Data = nil
+	if withData && export.Data != nil {
+		copied.Data = append([]byte(nil), export.Data...)
+	}
+	return &copied
+}
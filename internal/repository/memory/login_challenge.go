@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+type LoginChallengeRepository struct {
+	store *Store
+}
+
+func (r *LoginChallengeRepository) Create(ctx context.Context, challenge *models.LoginChallenge) error {
+	return r.store.write(func(t *tables) error {
+		if _, exists := t.loginChallenges.rows[challenge.ID]; exists {
+			return repository.ErrConflict
+		}
+		if _, exists := t.users.rows[challenge.UserID]; !exists {
+			return repository.ErrNotFound
+		}
+		if challenge.CreatedAt.IsZero() {
+			challenge.CreatedAt = time.Now()
+		}
+		t.loginChallenges.set(challenge.ID, copyLoginChallenge(challenge))
+		return nil
+	})
+}
+
+func (r *LoginChallengeRepository) GetByID(ctx context.Context, id string) (*models.LoginChallenge, error) {
+	var challenge *models.LoginChallenge
+	err := r.store.read(func(t *tables) error {
+		stored, ok := t.loginChallenges.rows[id]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		challenge = copyLoginChallenge(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (r *LoginChallengeRepository) IncrementAttempts(ctx context.Context, id string, max int) (int, error) {
+	var attempts int
+	err := r.update(id, func(challenge *models.LoginChallenge) error {
+		if challenge.Attempts >= max {
+			return repository.ErrConflict
+		}
+		challenge.Attempts++
+		attempts = challenge.Attempts
+		return nil
+	})
+	return attempts, err
+}
+
+func (r *LoginChallengeRepository) Consume(ctx context.Context, id string, at time.Time) error {
+	return r.update(id, func(challenge *models.LoginChallenge) error {
+		if challenge.UsedAt != nil {
+			return repository.ErrConflict
+		}
+		challenge.UsedAt = &at
+		return nil
+	})
+}
+
+func (r *LoginChallengeRepository) CountByUserSince(ctx context.Context, userID string, since time.Time, methods ...string) (int, error) {
+	var count int
+	r.store.read(func(t *tables) error {
+		for _, challenge := range t.loginChallenges.rows {
+			if challenge.UserID == userID && !challenge.CreatedAt.Before(since) && slices.Contains(methods, challenge.Method) {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, nil
+}
+
+func (r *LoginChallengeRepository) DeleteByUser(ctx context.Context, userID string) error {
+	return r.store.write(func(t *tables) error {
+		for id, challenge := range t.loginChallenges.rows {
+			if challenge.UserID == userID {
+				t.loginChallenges.delete(id)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *LoginChallengeRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	var deleted int
+	err := r.store.write(func(t *tables) error {
+		for id, challenge := range t.loginChallenges.rows {
+			if challenge.ExpiresAt.Before(before) {
+				t.loginChallenges.delete(id)
+				deleted++
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// update replaces the stored challenge with a copy changed by fn, since
+// stored records are shared with transaction snapshots
+func (r *LoginChallengeRepository) update(id string, fn func(challenge *models.LoginChallenge) error) error {
+	return r.store.write(func(t *tables) error {
+		stored, ok := t.loginChallenges.rows[id]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		updated := copyLoginChallenge(stored)
+		if err := fn(updated); err != nil {
+			return err
+		}
+		t.loginChallenges.set(id, updated)
+		return nil
+	})
+}
+
+func copyLoginChallenge(challenge *models.LoginChallenge) *models.LoginChallenge {
+	copied := *challenge
+	copied.UsedAt = copyTime(challenge.UsedAt)
+	return &copied
+}
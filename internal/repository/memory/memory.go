@@ -0,0 +1,161 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+// Store holds every in-memory table behind a single lock so that
+// transactions can span repositories
+type Store struct {
+	mu   sync.RWMutex
+	data *tables
+}
+
+type tables struct {
+	users      table[string, *models.User]
+	byUsername table[string, string]
+	byEmail    table[string, string]
+	exports    table[string, *models.DataExport]
+	// tombstones is keyed by user ID
+	tombstones table[string, *models.ErasureTombstone]
+	// audit is in append order
+	audit           []*models.AuditEvent
+	sessions        table[string, *models.Session]
+	loginChallenges table[string, *models.LoginChallenge]
+}
+
+func NewStore() *Store {
+	return &Store{data: newTables()}
+}
+
+func newTables() *tables {
+	return &tables{
+		users:           newTable[string, *models.User](),
+		byUsername:      newTable[string, string](),
+		byEmail:         newTable[string, string](),
+		exports:         newTable[string, *models.DataExport](),
+		tombstones:      newTable[string, *models.ErasureTombstone](),
+		sessions:        newTable[string, *models.Session](),
+		loginChallenges: newTable[string, *models.LoginChallenge](),
+	}
+}
+
+// snapshot returns tables a transaction can work on privately. Each table is
+// shared with t until the transaction first writes to it, so a transaction
+// copies only the tables it changes. Stored records are never mutated in
+// place, so copying pointers is enough. The audit log is only appended to:
+// the snapshot appends past the end of t's slice, which t never reads.
+func (t *tables) snapshot() *tables {
+	return &tables{
+		users:           t.users.share(),
+		byUsername:      t.byUsername.share(),
+		byEmail:         t.byEmail.share(),
+		exports:         t.exports.share(),
+		tombstones:      t.tombstones.share(),
+		audit:           t.audit,
+		sessions:        t.sessions.share(),
+		loginChallenges: t.loginChallenges.share(),
+	}
+}
+
+// table is a map that is copied on its first write after being shared
+type table[K comparable, V any] struct {
+	rows   map[K]V
+	shared bool
+}
+
+func newTable[K comparable, V any]() table[K, V] {
+	return table[K, V]{rows: make(map[K]V)}
+}
+
+func (t *table[K, V]) share() table[K, V] {
+	return table[K, V]{rows: t.rows, shared: true}
+}
+
+func (t *table[K, V]) set(key K, value V) {
+	t.own()
+	t.rows[key] = value
+}
+
+func (t *table[K, V]) delete(key K) {
+	t.own()
+	delete(t.rows, key)
+}
+
+func (t *table[K, V]) own() {
+	if t.shared {
+		t.rows = maps.Clone(t.rows)
+		t.shared = false
+	}
+}
+
+func (s *Store) read(fn func(t *tables) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(s.data)
+}
+
+func (s *Store) write(fn func(t *tables) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.data)
+}
+
+// NewRepository returns all in-memory repositories sharing one Store, with
+// transaction support
+func NewRepository() *repository.Repository {
+	return newRepository(NewStore())
+}
+
+func newRepository(store *Store) *repository.Repository {
+	return &repository.Repository{
+		User:           &UserRepository{store: store},
+		Export:         &ExportRepository{store: store},
+		Tombstone:      &TombstoneRepository{store: store},
+		Audit:          &AuditRepository{store: store},
+		Session:        &SessionRepository{store: store},
+		LoginChallenge: &LoginChallengeRepository{store: store},
+		Transactor:     &Transactor{store: store},
+	}
+}
+
+// Transactor provides serializable transactions: it holds the store's write
+// lock for the whole unit of work, runs fn against a copy-on-write snapshot
+// and swaps the snapshot in only if fn succeeds.
+type Transactor struct {
+	store *Store
+}
+
+func (t *Transactor) WithTx(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	snapshot := &Store{data: t.store.data.snapshot()}
+	txRepo := newRepository(snapshot)
+	txRepo.Transactor = joinedTx{repo: txRepo}
+
+	if err := fn(txRepo); err != nil {
+		return err
+	}
+
+	t.store.data = snapshot.data
+	return nil
+}
+
+// joinedTx makes nested WithTx calls reuse the surrounding transaction
+type joinedTx struct {
+	repo *repository.Repository
+}
+
+func (j joinedTx) WithTx(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	return fn(j.repo)
+}
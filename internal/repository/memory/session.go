@@ -0,0 +1,158 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+type SessionRepository struct {
+	store *Store
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	return r.store.write(func(t *tables) error {
+		if _, exists := t.sessions.rows[session.ID]; exists {
+			return repository.ErrConflict
+		}
+		if _, exists := t.users.rows[session.UserID]; !exists {
+			return repository.ErrNotFound
+		}
+		if session.CreatedAt.IsZero() {
+			session.CreatedAt = time.Now()
+		}
+		if session.LastSeenAt.IsZero() {
+			session.LastSeenAt = session.CreatedAt
+		}
+		t.sessions.set(session.ID, copySession(session))
+		return nil
+	})
+}
+
+func (r *SessionRepository) GetByID(ctx context.Context, id string) (*models.Session, error) {
+	var session *models.Session
+	err := r.store.read(func(t *tables) error {
+		stored, ok := t.sessions.rows[id]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		session = copySession(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *SessionRepository) ListByUser(ctx context.Context, userID string) ([]*models.Session, error) {
+	var sessions []*models.Session
+	r.store.read(func(t *tables) error {
+		for _, session := range t.sessions.rows {
+			if session.UserID == userID {
+				sessions = append(sessions, copySession(session))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if c := sessions[i].CreatedAt.Compare(sessions[j].CreatedAt); c != 0 {
+			return c > 0
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (r *SessionRepository) Touch(ctx context.Context, id string, lastSeenAt time.Time) error {
+	return r.update(id, func(session *models.Session) {
+		session.LastSeenAt = lastSeenAt
+	})
+}
+
+func (r *SessionRepository) Revoke(ctx context.Context, id string, at time.Time) error {
+	return r.update(id, func(session *models.Session) {
+		if session.RevokedAt == nil {
+			session.RevokedAt = &at
+		}
+	})
+}
+
+func (r *SessionRepository) RevokeByUser(ctx context.Context, userID, exceptID string, at time.Time) (int, error) {
+	var revoked int
+	err := r.store.write(func(t *tables) error {
+		for id, session := range t.sessions.rows {
+			if session.UserID == userID && id != exceptID && session.Active(at) {
+				updated := copySession(session)
+				updated.RevokedAt = &at
+				t.sessions.set(id, updated)
+				revoked++
+			}
+		}
+		return nil
+	})
+	return revoked, err
+}
+
+func (r *SessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	return r.store.write(func(t *tables) error {
+		for id, session := range t.sessions.rows {
+			if session.UserID == userID {
+				t.sessions.delete(id)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *SessionRepository) DeleteEnded(ctx context.Context, before time.Time) (int, error) {
+	var deleted int
+	err := r.store.write(func(t *tables) error {
+		for id, session := range t.sessions.rows {
+			if session.ExpiresAt.Before(before) || (session.RevokedAt != nil && session.RevokedAt.Before(before)) {
+				t.sessions.delete(id)
+				deleted++
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (r *SessionRepository) CountActive(ctx context.Context, now time.Time) (int, error) {
+	var count int
+	err := r.store.read(func(t *tables) error {
+		for _, session := range t.sessions.rows {
+			if session.Active(now) {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// update replaces the stored session with a copy changed by fn, since stored
+// records are shared with transaction snapshots
+func (r *SessionRepository) update(id string, fn func(session *models.Session)) error {
+	return r.store.write(func(t *tables) error {
+		stored, ok := t.sessions.rows[id]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		updated := copySession(stored)
+		fn(updated)
+		t.sessions.set(id, updated)
+		return nil
+	})
+}
+
+func copySession(session *models.Session) *models.Session {
+	copied := *session
+	copied.RevokedAt = copyTime(session.RevokedAt)
+	return &copied
+}
@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"context"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+type TombstoneRepository struct {
+	store *Store
+}
+
+func (r *TombstoneRepository) Create(ctx context.Context, tombstone *models.ErasureTombstone) error {
+	return r.store.write(func(t *tables) error {
+		if _, exists := t.tombstones.rows[tombstone.UserID]; exists {
+			return repository.ErrConflict
+		}
+		t.tombstones.set(tombstone.UserID, copyTombstone(tombstone))
+		return nil
+	})
+}
+
+func (r *TombstoneRepository) GetByUserID(ctx context.Context, userID string) (*models.ErasureTombstone, error) {
+	var tombstone *models.ErasureTombstone
+	err := r.store.read(func(t *tables) error {
+		stored, ok := t.tombstones.rows[userID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		tombstone = copyTombstone(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tombstone, nil
+}
+
+func copyTombstone(tombstone *models.ErasureTombstone) *models.ErasureTombstone {
+	copied := *tombstone
+	copied.Sections = append([]string(nil), tombstone.Sections...)
+	return &copied
+}
@@ -0,0 +1,287 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+// UserRepository is a concurrency-safe in-memory UserRepository. It enforces
+// the same unique constraints as the SQL schema (id, username and email).
+type UserRepository struct {
+	store *Store
+}
+
+// NewUserRepository returns a UserRepository with its own Store
+func NewUserRepository() *UserRepository {
+	return &UserRepository{store: NewStore()}
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	return r.store.write(func(t *tables) error {
+		if _, exists := t.users.rows[user.ID]; exists {
+			return repository.ErrConflict
+		}
+		if _, exists := t.byUsername.rows[user.Username]; exists {
+			return repository.ErrConflict
+		}
+		if _, exists := t.byEmail.rows[user.Email]; exists {
+			return repository.ErrConflict
+		}
+
+		user.SetDefaults()
+		now := time.Now()
+		user.CreatedAt = now
+		user.UpdatedAt = now
+
+		t.users.set(user.ID, copyUser(user))
+		t.byUsername.set(user.Username, user.ID)
+		t.byEmail.set(user.Email, user.ID)
+		return nil
+	})
+}
+
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user *models.User
+	err := r.store.read(func(t *tables) error {
+		id, ok := t.byUsername.rows[username]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		user = copyUser(t.users.rows[id])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user *models.User
+	err := r.store.read(func(t *tables) error {
+		id, ok := t.byEmail.rows[email]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		user = copyUser(t.users.rows[id])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *UserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	var user *models.User
+	err := r.store.read(func(t *tables) error {
+		stored, ok := t.users.rows[id]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		user = copyUser(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
+	return r.store.write(func(t *tables) error {
+		existing, ok := t.users.rows[user.ID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		if id, taken := t.byUsername.rows[user.Username]; taken && id != user.ID {
+			return repository.ErrConflict
+		}
+		if id, taken := t.byEmail.rows[user.Email]; taken && id != user.ID {
+			return repository.ErrConflict
+		}
+
+		t.byUsername.delete(existing.Username)
+		t.byEmail.delete(existing.Email)
+
+		user.CreatedAt = existing.CreatedAt
+		user.UpdatedAt = time.Now()
+
+		t.users.set(user.ID, copyUser(user))
+		t.byUsername.set(user.Username, user.ID)
+		t.byEmail.set(user.Email, user.ID)
+		return nil
+	})
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	return r.store.write(func(t *tables) error {
+		existing, ok := t.users.rows[id]
+		if !ok {
+			return repository.ErrNotFound
+		}
+
+		t.users.delete(id)
+		t.byUsername.delete(existing.Username)
+		t.byEmail.delete(existing.Email)
+
+		// Mirror ON DELETE CASCADE
+		for exportID, export := range t.exports.rows {
+			if export.UserID == id {
+				t.exports.delete(exportID)
+			}
+		}
+		for sessionID, session := range t.sessions.rows {
+			if session.UserID == id {
+				t.sessions.delete(sessionID)
+			}
+		}
+		for challengeID, challenge := range t.loginChallenges.rows {
+			if challenge.UserID == id {
+				t.loginChallenges.delete(challengeID)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *UserRepository) List(ctx context.Context, filter repository.UserFilter) (*repository.UserPage, error) {
+	if !filter.SortColumn().Valid() {
+		return nil, repository.ErrInvalidSort
+	}
+	cursor, err := filter.DecodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*models.User
+	r.store.read(func(t *tables) error {
+		for _, user := range t.users.rows {
+			if matchesFilter(user, &filter) {
+				users = append(users, copyUser(user))
+			}
+		}
+		return nil
+	})
+
+	column := filter.SortColumn()
+	less := func(a, b *models.User) bool {
+		if c := compareUsers(a, b, column); c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if filter.Descending {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+
+	// Skip everything up to and including the cursor position
+	if cursor != nil {
+		start := sort.Search(len(users), func(i int) bool {
+			position := repository.NewUserCursor(&filter, users[i])
+			c := strings.Compare(position.Value, cursor.Value)
+			if column == repository.SortByCreatedAt {
+				at, _ := cursor.Time()
+				c = users[i].CreatedAt.Compare(at)
+			}
+			if c == 0 {
+				c = strings.Compare(users[i].ID, cursor.ID)
+			}
+			if filter.Descending {
+				return c < 0
+			}
+			return c > 0
+		})
+		users = users[start:]
+	}
+
+	page := &repository.UserPage{Users: users}
+	if limit := filter.PageSize(); len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = repository.NewUserCursor(&filter, page.Users[limit-1]).Encode()
+	}
+	return page, nil
+}
+
+func (r *UserRepository) ListDeletionsDue(ctx context.Context, scheduledBefore, deletedBefore time.Time, limit int) ([]*models.User, error) {
+	var users []*models.User
+	r.store.read(func(t *tables) error {
+		for _, user := range t.users.rows {
+			switch {
+			case user.Status == models.StatusPendingDeletion && user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(scheduledBefore),
+				user.Status == models.StatusDeleted && user.PurgedAt == nil && user.DeletedAt != nil && !user.DeletedAt.After(deletedBefore):
+				users = append(users, copyUser(user))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// copyUser returns a deep copy of user so callers never share state with the
+// store
+func copyUser(user *models.User) *models.User {
+	copied := *user
+	copied.DeletionScheduledAt = copyTime(user.DeletionScheduledAt)
+	copied.DeletedAt = copyTime(user.DeletedAt)
+	copied.PurgedAt = copyTime(user.PurgedAt)
+	copied.MFAEnabledAt = copyTime(user.MFAEnabledAt)
+	return &copied
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+func matchesFilter(user *models.User, filter *repository.UserFilter) bool {
+	if filter.EmailDomain != "" && !strings.HasSuffix(strings.ToLower(user.Email), "@"+strings.ToLower(filter.EmailDomain)) {
+		return false
+	}
+	if !filter.CreatedAfter.IsZero() && user.CreatedAt.Before(filter.CreatedAfter) {
+		return false
+	}
+	if !filter.CreatedBefore.IsZero() && !user.CreatedAt.Before(filter.CreatedBefore) {
+		return false
+	}
+	if filter.Status != "" && user.Status != filter.Status {
+		return false
+	}
+	if filter.Role != "" && user.Role != filter.Role {
+		return false
+	}
+	if filter.Search != "" {
+		prefix := strings.ToLower(filter.Search)
+		if !strings.HasPrefix(strings.ToLower(user.Username), prefix) && !strings.HasPrefix(strings.ToLower(user.Email), prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareUsers(a, b *models.User, column repository.UserSort) int {
+	switch column {
+	case repository.SortByUsername:
+		return strings.Compare(a.Username, b.Username)
+	case repository.SortByEmail:
+		return strings.Compare(a.Email, b.Email)
+	default:
+		return a.CreatedAt.Compare(b.CreatedAt)
+	}
+}
@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"auth/internal/repository/repositorytest"
+)
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) *repository.Repository {
+		return memory.NewRepository()
+	})
+}
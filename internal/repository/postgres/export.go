@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+const exportColumns = `id, user_id, format, status, error, data, created_at, completed_at, expires_at`
+
+type ExportRepository struct {
+	db dbtx
+}
+
+func (r *ExportRepository) Create(ctx context.Context, export *models.DataExport) error {
+	query := `
+		INSERT INTO data_exports (` + exportColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	if export.CreatedAt.IsZero() {
+		export.CreatedAt = time.Now()
+	}
+	_, err := r.db.ExecContext(ctx, query,
+		export.ID, export.UserID, export.Format, export.Status, export.Error, export.Data,
+		export.CreatedAt, nullTime(export.CompletedAt), export.ExpiresAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrConflict
+		}
+		return fmt.Errorf("failed to create export: %w", err)
+	}
+	return nil
+}
+
+func (r *ExportRepository) GetByID(ctx context.Context, id string) (*models.DataExport, error) {
+	query := `SELECT ` + exportColumns + ` FROM data_exports WHERE id = $1`
+	export, err := scanExport(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get export: %w", err)
+	}
+	return export, nil
+}
+
+func (r *ExportRepository) Update(ctx context.Context, export *models.DataExport) error {
+	query := `
+		UPDATE data_exports
+		SET status = $2, error = $3, data = $4, completed_at = $5, expires_at = $6
+		WHERE id = $1
+	`
+	result, err := r.db.ExecContext(ctx, query,
+		export.ID, export.Status, export.Error, export.Data,
+		nullTime(export.CompletedAt), export.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update export: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *ExportRepository) ListByUser(ctx context.Context, userID string) ([]*models.DataExport, error) {
+	query := `
+		SELECT id, user_id, format, status, error, NULL, created_at, completed_at, expires_at
+		FROM data_exports WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exports: %w", err)
+	}
+	defer rows.Close()
+
+	var exports []*models.DataExport
+	for rows.Next() {
+		export, err := scanExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan export: %w", err)
+		}
+		exports = append(exports, export)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list exports: %w", err)
+	}
+	return exports, nil
+}
+
+func (r *ExportRepository) DeleteByUser(ctx context.Context, userID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM data_exports WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete exports: %w", err)
+	}
+	return nil
+}
+
+func (r *ExportRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM data_exports WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired exports: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired exports: %w", err)
+	}
+	return int(rows), nil
+}
+
+func scanExport(row rowScanner) (*models.DataExport, error) {
+	export := &models.DataExport{}
+	var completedAt sql.NullTime
+	err := row.Scan(
+		&export.ID, &export.UserID, &export.Format, &export.Status, &export.Error, &export.Data,
+		&export.CreatedAt, &completedAt, &export.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	export.CompletedAt = timePtr(completedAt)
+	return export, nil
+}
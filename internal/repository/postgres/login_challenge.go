@@ -0,0 +1,142 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+const loginChallengeColumns = `id, user_id, method, secret_hash, binding_hash, attempts, created_at, expires_at, used_at`
+
+type LoginChallengeRepository struct {
+	db dbtx
+}
+
+func (r *LoginChallengeRepository) Create(ctx context.Context, challenge *models.LoginChallenge) error {
+	query := `
+		INSERT INTO login_challenges (` + loginChallengeColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	if challenge.CreatedAt.IsZero() {
+		challenge.CreatedAt = time.Now()
+	}
+	_, err := r.db.ExecContext(ctx, query,
+		challenge.ID, challenge.UserID, challenge.Method, challenge.SecretHash, challenge.BindingHash, challenge.Attempts,
+		challenge.CreatedAt, challenge.ExpiresAt, nullTime(challenge.UsedAt),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrConflict
+		}
+		if isForeignKeyViolation(err) {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	return nil
+}
+
+func (r *LoginChallengeRepository) GetByID(ctx context.Context, id string) (*models.LoginChallenge, error) {
+	query := `SELECT ` + loginChallengeColumns + ` FROM login_challenges WHERE id = $1`
+	challenge, err := scanLoginChallenge(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get login challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+func (r *LoginChallengeRepository) IncrementAttempts(ctx context.Context, id string, max int) (int, error) {
+	query := `UPDATE login_challenges SET attempts = attempts + 1 WHERE id = $1 AND attempts < $2 RETURNING attempts`
+	var attempts int
+	if err := r.db.QueryRowContext(ctx, query, id, max).Scan(&attempts); err != nil {
+		if err == sql.ErrNoRows {
+			// Tell a used up challenge from a missing one
+			if _, err := r.GetByID(ctx, id); err != nil {
+				return 0, err
+			}
+			return 0, repository.ErrConflict
+		}
+		return 0, fmt.Errorf("failed to count login challenge attempt: %w", err)
+	}
+	return attempts, nil
+}
+
+func (r *LoginChallengeRepository) Consume(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE login_challenges SET used_at = $2 WHERE id = $1 AND used_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, id, at)
+	if err != nil {
+		return fmt.Errorf("failed to consume login challenge: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to consume login challenge: %w", err)
+	}
+	if rows == 0 {
+		// Tell a used challenge from a missing one
+		if _, err := r.GetByID(ctx, id); err != nil {
+			return err
+		}
+		return repository.ErrConflict
+	}
+	return nil
+}
+
+func (r *LoginChallengeRepository) CountByUserSince(ctx context.Context, userID string, since time.Time, methods ...string) (int, error) {
+	if len(methods) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{userID, since}
+	placeholders := make([]string, len(methods))
+	for i, method := range methods {
+		args = append(args, method)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+	query := `SELECT COUNT(*) FROM login_challenges WHERE user_id = $1 AND created_at >= $2
+		AND method IN (` + strings.Join(placeholders, ", ") + `)`
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count login challenges: %w", err)
+	}
+	return count, nil
+}
+
+func (r *LoginChallengeRepository) DeleteByUser(ctx context.Context, userID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete login challenges: %w", err)
+	}
+	return nil
+}
+
+func (r *LoginChallengeRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired login challenges: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired login challenges: %w", err)
+	}
+	return int(rows), nil
+}
+
+func scanLoginChallenge(row rowScanner) (*models.LoginChallenge, error) {
+	challenge := &models.LoginChallenge{}
+	var usedAt sql.NullTime
+	err := row.Scan(
+		&challenge.ID, &challenge.UserID, &challenge.Method, &challenge.SecretHash, &challenge.BindingHash, &challenge.Attempts,
+		&challenge.CreatedAt, &challenge.ExpiresAt, &usedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	challenge.UsedAt = timePtr(usedAt)
+	return challenge, nil
+}
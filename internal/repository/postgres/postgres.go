@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"auth/internal/database"
+	"auth/internal/repository"
+	"github.com/lib/pq"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx so repositories can run
+// inside or outside a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// NewRepository returns all PostgreSQL repositories with transaction support
+func NewRepository(db *sql.DB) *repository.Repository {
+	repo := newRepository(db)
+	repo.Transactor = &Transactor{db: db, policy: repository.DefaultRetryPolicy}
+	return repo
+}
+
+func newRepository(db dbtx) *repository.Repository {
+	db = database.Instrument(db, "postgresql")
+	return &repository.Repository{
+		User:           &UserRepository{db: db},
+		Export:         &ExportRepository{db: db},
+		Tombstone:      &TombstoneRepository{db: db},
+		Audit:          &AuditRepository{db: db},
+		Session:        &SessionRepository{db: db},
+		LoginChallenge: &LoginChallengeRepository{db: db},
+	}
+}
+
+// Transactor runs units of work in SERIALIZABLE transactions and retries
+// them on serialization failures and deadlocks
+type Transactor struct {
+	db     *sql.DB
+	policy repository.RetryPolicy
+}
+
+func (t *Transactor) WithTx(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	return repository.Retry(ctx, t.policy, isRetryable, func() error {
+		return t.run(ctx, fn)
+	})
+}
+
+func (t *Transactor) run(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	tx, err := t.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	txRepo := newRepository(tx)
+	txRepo.Transactor = joinedTx{repo: txRepo}
+
+	if err := fn(txRepo); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// joinedTx makes nested WithTx calls reuse the surrounding transaction
+type joinedTx struct {
+	repo *repository.Repository
+}
+
+func (j joinedTx) WithTx(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	return fn(j.repo)
+}
+
+// isRetryable reports serialization_failure and deadlock_detected errors
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+const sessionColumns = `id, user_id, device_id, device, user_agent, ip, location, created_at, last_seen_at, expires_at, revoked_at`
+
+type SessionRepository struct {
+	db dbtx
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	query := `
+		INSERT INTO sessions (` + sessionColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	if session.LastSeenAt.IsZero() {
+		session.LastSeenAt = session.CreatedAt
+	}
+	_, err := r.db.ExecContext(ctx, query,
+		session.ID, session.UserID, session.DeviceID, session.Device, session.UserAgent, session.IP, session.Location,
+		session.CreatedAt, session.LastSeenAt, session.ExpiresAt, nullTime(session.RevokedAt),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrConflict
+		}
+		if isForeignKeyViolation(err) {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) GetByID(ctx context.Context, id string) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return session, nil
+}
+
+func (r *SessionRepository) ListByUser(ctx context.Context, userID string) ([]*models.Session, error) {
+	query := `
+		SELECT ` + sessionColumns + ` FROM sessions WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+func (r *SessionRepository) Touch(ctx context.Context, id string, lastSeenAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE sessions SET last_seen_at = $2 WHERE id = $1`, id, lastSeenAt)
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *SessionRepository) Revoke(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE sessions SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1`
+	result, err := r.db.ExecContext(ctx, query, id, at)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *SessionRepository) RevokeByUser(ctx context.Context, userID, exceptID string, at time.Time) (int, error) {
+	query := `
+		UPDATE sessions SET revoked_at = $3
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND expires_at > $3
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, exceptID, at)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return int(rows), nil
+}
+
+func (r *SessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) DeleteEnded(ctx context.Context, before time.Time) (int, error) {
+	query := `DELETE FROM sessions WHERE expires_at < $1 OR revoked_at < $1`
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete ended sessions: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete ended sessions: %w", err)
+	}
+	return int(rows), nil
+}
+
+func (r *SessionRepository) CountActive(ctx context.Context, now time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM sessions WHERE revoked_at IS NULL AND expires_at > $1`
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, now).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count active sessions: %w", err)
+	}
+	return count, nil
+}
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	session := &models.Session{}
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&session.ID, &session.UserID, &session.DeviceID, &session.Device, &session.UserAgent, &session.IP, &session.Location,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	session.RevokedAt = timePtr(revokedAt)
+	return session, nil
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"auth/internal/models"
-	"github.com/lib/pq"
-	_ "github.com/lib/pq"
+	"auth/internal/repository"
 )
 
+const userColumns = `id, username, password, email, role, status, password_reset_required,
+	deletion_scheduled_at, deleted_at, purged_at, created_at, updated_at,
+	mfa_secret, mfa_enabled_at, mfa_last_step, plan`
+
 type UserRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewUserRepository(db *sql.DB) *UserRepository {
@@ -21,33 +25,48 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 
 func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 	query := `
-		INSERT INTO users (id, username, password, email, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (id, username, password, email, role, status, password_reset_required,
+			deletion_scheduled_at, deleted_at, purged_at, created_at, updated_at,
+			mfa_secret, mfa_enabled_at, mfa_last_step, plan)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 	`
+	user.SetDefaults()
 	now := time.Now()
-	_, err := r.db.ExecContext(ctx, query, user.ID, user.Username, user.Password, user.Email, now, now)
+	_, err := r.db.ExecContext(ctx, query,
+		user.ID, user.Username, user.Password, user.Email,
+		user.Role, user.Status, user.PasswordResetRequired,
+		nullTime(user.DeletionScheduledAt), nullTime(user.DeletedAt), nullTime(user.PurgedAt), now, now,
+		user.MFASecret, nullTime(user.MFAEnabledAt), user.MFALastStep, user.Plan,
+	)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
-			return fmt.Errorf("user already exists")
+		if isUniqueViolation(err) {
+			return repository.ErrConflict
 		}
 		return fmt.Errorf("failed to create user: %w", err)
 	}
+	user.CreatedAt = now
+	user.UpdatedAt = now
 	return nil
 }
 
 func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
-	query := `
-		SELECT id, username, password, email, created_at, updated_at
-		FROM users
-		WHERE username = $1
-	`
-	user := &models.User{}
-	err := r.db.QueryRowContext(ctx, query, username).Scan(
-		&user.ID, &user.Username, &user.Password, &user.Email, &user.CreatedAt, &user.UpdatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, username))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, email))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, repository.ErrNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -55,18 +74,11 @@ func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*m
 }
 
 func (r *UserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
-	query := `
-		SELECT id, username, password, email, created_at, updated_at
-		FROM users
-		WHERE id = $1
-	`
-	user := &models.User{}
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID, &user.Username, &user.Password, &user.Email, &user.CreatedAt, &user.UpdatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, repository.ErrNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -76,21 +88,197 @@ func (r *UserRepository) GetByID(ctx context.Context, id string) (*models.User,
 func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 	query := `
 		UPDATE users
-		SET username = $2, password = $3, email = $4, updated_at = $5
+		SET username = $2, password = $3, email = $4, role = $5, status = $6,
+			password_reset_required = $7, deletion_scheduled_at = $8, deleted_at = $9,
+			purged_at = $10, updated_at = $11, mfa_secret = $12, mfa_enabled_at = $13,
+			mfa_last_step = $14, plan = $15
 		WHERE id = $1
 	`
-	_, err := r.db.ExecContext(ctx, query, user.ID, user.Username, user.Password, user.Email, time.Now())
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, query,
+		user.ID, user.Username, user.Password, user.Email,
+		user.Role, user.Status, user.PasswordResetRequired,
+		nullTime(user.DeletionScheduledAt), nullTime(user.DeletedAt), nullTime(user.PurgedAt), now,
+		user.MFASecret, nullTime(user.MFAEnabledAt), user.MFALastStep, user.Plan,
+	)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrConflict
+		}
 		return fmt.Errorf("failed to update user: %w", err)
 	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
+	}
+	user.UpdatedAt = now
 	return nil
 }
 
 func (r *UserRepository) Delete(ctx context.Context, id string) error {
 	query := `DELETE FROM users WHERE id = $1`
-	_, err := r.db.ExecContext(ctx, query, id)
+	result, err := r.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (r *UserRepository) List(ctx context.Context, filter repository.UserFilter) (*repository.UserPage, error) {
+	// The sort column is written into the query, so only known columns pass
+	if !filter.SortColumn().Valid() {
+		return nil, repository.ErrInvalidSort
+	}
+	cursor, err := filter.DecodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.EmailDomain != "" {
+		conditions = append(conditions, `LOWER(email) LIKE `+arg("%@"+escapeLike(strings.ToLower(filter.EmailDomain)))+` ESCAPE '\'`)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, `created_at >= `+arg(filter.CreatedAfter))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, `created_at < `+arg(filter.CreatedBefore))
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, `status = `+arg(filter.Status))
+	}
+	if filter.Role != "" {
+		conditions = append(conditions, `role = `+arg(filter.Role))
+	}
+	if filter.Search != "" {
+		prefix := arg(escapeLike(strings.ToLower(filter.Search)) + "%")
+		conditions = append(conditions, `(LOWER(username) LIKE `+prefix+` ESCAPE '\' OR LOWER(email) LIKE `+prefix+` ESCAPE '\')`)
+	}
+
+	column := string(filter.SortColumn())
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if cursor != nil {
+		var value interface{} = cursor.Value
+		if cursor.Sort == repository.SortByCreatedAt {
+			value, _ = cursor.Time()
+		}
+		conditions = append(conditions, fmt.Sprintf(`(%s, id) %s (%s, %s)`, column, comparison, arg(value), arg(cursor.ID)))
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	limit := filter.PageSize()
+	query += fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT %s`, column, direction, direction, arg(limit+1))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	page := &repository.UserPage{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	// One extra row was fetched to know whether another page exists
+	if len(page.Users) > limit {
+		page.Users = page.Users[:limit]
+		page.NextCursor = repository.NewUserCursor(&filter, page.Users[limit-1]).Encode()
+	}
+	return page, nil
+}
+
+func (r *UserRepository) ListDeletionsDue(ctx context.Context, scheduledBefore, deletedBefore time.Time, limit int) ([]*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+		WHERE (status = $1 AND deletion_scheduled_at <= $2)
+			OR (status = $3 AND purged_at IS NULL AND deleted_at <= $4)
+		ORDER BY id LIMIT $5`
+	rows, err := r.db.QueryContext(ctx, query,
+		models.StatusPendingDeletion, scheduledBefore,
+		models.StatusDeleted, deletedBefore, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list due deletions: %w", err)
+	}
+	return users, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	user := &models.User{}
+	var deletionScheduledAt, deletedAt, purgedAt, mfaEnabledAt sql.NullTime
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Password, &user.Email,
+		&user.Role, &user.Status, &user.PasswordResetRequired,
+		&deletionScheduledAt, &deletedAt, &purgedAt,
+		&user.CreatedAt, &user.UpdatedAt,
+		&user.MFASecret, &mfaEnabledAt, &user.MFALastStep, &user.Plan,
+	)
+	if err != nil {
+		return nil, err
+	}
+	user.DeletionScheduledAt = timePtr(deletionScheduledAt)
+	user.DeletedAt = timePtr(deletedAt)
+	user.PurgedAt = timePtr(purgedAt)
+	user.MFAEnabledAt = timePtr(mfaEnabledAt)
+	return user, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -2,23 +2,151 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
+
 	"auth/internal/models"
 )
 
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a write would violate a unique constraint
+	ErrConflict = errors.New("record already exists")
+)
+
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
 	GetByID(ctx context.Context, id string) (*models.User, error)
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id string) error
+	// List returns a page of users matching filter, ordered by its sort column
+	List(ctx context.Context, filter UserFilter) (*UserPage, error)
+	// ListDeletionsDue returns up to limit accounts the purge job must
+	// process, ordered by ID: pending deletions scheduled at or before
+	// scheduledBefore, and deleted accounts that are not purged yet and were
+	// deleted at or before deletedBefore
+	ListDeletionsDue(ctx context.Context, scheduledBefore, deletedBefore time.Time, limit int) ([]*models.User, error)
+}
+
+// ExportRepository stores personal data export jobs and their bundles
+type ExportRepository interface {
+	Create(ctx context.Context, export *models.DataExport) error
+	GetByID(ctx context.Context, id string) (*models.DataExport, error)
+	Update(ctx context.Context, export *models.DataExport) error
+	// ListByUser returns the user's exports, newest first, without their data
+	ListByUser(ctx context.Context, userID string) ([]*models.DataExport, error)
+	DeleteByUser(ctx context.Context, userID string) error
+	// DeleteExpired removes exports that expired before the given time and
+	// returns how many were removed
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// TombstoneRepository stores proof that a user's data was erased. A user has
+// at most one tombstone; creating a second returns ErrConflict.
+type TombstoneRepository interface {
+	Create(ctx context.Context, tombstone *models.ErasureTombstone) error
+	GetByUserID(ctx context.Context, userID string) (*models.ErasureTombstone, error)
+}
+
+// SessionRepository stores login sessions
+type SessionRepository interface {
+	// Create stores session. The user must exist.
+	Create(ctx context.Context, session *models.Session) error
+	GetByID(ctx context.Context, id string) (*models.Session, error)
+	// ListByUser returns all of the user's sessions, including revoked and
+	// expired ones, newest first
+	ListByUser(ctx context.Context, userID string) ([]*models.Session, error)
+	// Touch records that the session was used at lastSeenAt
+	Touch(ctx context.Context, id string, lastSeenAt time.Time) error
+	// Revoke marks the session revoked at the given time. Revoking a revoked
+	// session keeps the original time.
+	Revoke(ctx context.Context, id string, at time.Time) error
+	// RevokeByUser revokes every active session of the user except exceptID
+	// and returns how many were revoked
+	RevokeByUser(ctx context.Context, userID, exceptID string, at time.Time) (int, error)
+	DeleteByUser(ctx context.Context, userID string) error
+	// DeleteEnded removes sessions that expired or were revoked before the
+	// given time and returns how many were removed
+	DeleteEnded(ctx context.Context, before time.Time) (int, error)
+	// CountActive counts the sessions of every user that are neither revoked
+	// nor expired at now
+	CountActive(ctx context.Context, now time.Time) (int, error)
+}
+
+// LoginChallengeRepository stores pending passwordless logins and counts the
+// codes tried for MFA challenges
+type LoginChallengeRepository interface {
+	// Create stores challenge. The user must exist.
+	Create(ctx context.Context, challenge *models.LoginChallenge) error
+	GetByID(ctx context.Context, id string) (*models.LoginChallenge, error)
+	// IncrementAttempts records an attempt to redeem the challenge and
+	// returns the new count. The count is checked and incremented atomically:
+	// once it has reached max, ErrConflict is returned and it isn't
+	// incremented, so concurrent attempts can't get past the limit.
+	IncrementAttempts(ctx context.Context, id string, max int) (int, error)
+	// Consume marks the challenge used at the given time. Consuming a used
+	// challenge returns ErrConflict, so a challenge is redeemed at most once.
+	Consume(ctx context.Context, id string, at time.Time) error
+	// CountByUserSince counts the challenges of any of methods created for
+	// the user at or after since
+	CountByUserSince(ctx context.Context, userID string, since time.Time, methods ...string) (int, error)
+	DeleteByUser(ctx context.Context, userID string) error
+	// DeleteExpired removes challenges that expired before the given time
+	// and returns how many were removed
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// AuditRepository is an append-only log of audit events. Events can't be
+// changed or removed once appended.
+type AuditRepository interface {
+	// Append stores event. An event whose ID or non-zero Seq is already
+	// taken returns ErrConflict.
+	Append(ctx context.Context, event *models.AuditEvent) error
+	// List returns a page of events matching filter, newest first
+	List(ctx context.Context, filter AuditFilter) (*AuditPage, error)
+	// Head returns the event with the highest Seq, or ErrNotFound when no
+	// event has a Seq
+	Head(ctx context.Context) (*models.AuditEvent, error)
+	// ListBySeq returns up to limit events with a Seq greater than afterSeq,
+	// in Seq order
+	ListBySeq(ctx context.Context, afterSeq int64, limit int) ([]*models.AuditEvent, error)
+}
+
+// Transactor runs fn inside a single storage transaction. The Repository
+// passed to fn is bound to that transaction; fn must use it instead of the
+// outer Repository, and may be called more than once if the transaction is
+// retried, so it should not have side effects outside the repository.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(tx *Repository) error) error
 }
 
 type Repository struct {
-	User UserRepository
+	User           UserRepository
+	Export         ExportRepository
+	Tombstone      TombstoneRepository
+	Audit          AuditRepository
+	Session        SessionRepository
+	LoginChallenge LoginChallengeRepository
+
+	// Transactor is set by storage implementations that support transactions
+	Transactor Transactor
 }
 
 func New(userRepo UserRepository) *Repository {
 	return &Repository{
 		User: userRepo,
 	}
-}
\ No newline at end of file
+}
+
+// WithTx runs fn atomically: either every write made through tx is committed
+// or none is. Calling WithTx on a transaction-bound Repository joins the
+// existing transaction. Without a Transactor fn runs directly and is not atomic.
+func (r *Repository) WithTx(ctx context.Context, fn func(tx *Repository) error) error {
+	if r.Transactor == nil {
+		return fn(r)
+	}
+	return r.Transactor.WithTx(ctx, fn)
+}
@@ -0,0 +1,1433 @@
+// Package repositorytest provides a conformance suite that every repository
+// implementation must pass. Implementations call Run from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		repositorytest.Run(t, func(t *testing.T) *repository.Repository {
+//			return newEmptyRepository(t)
+//		})
+//	}
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Factory returns a repository backed by empty storage. It is called once per
+// subtest, so implementations must not share state between calls.
+type Factory func(t *testing.T) *repository.Repository
+
+// Run executes the conformance suite against the repositories built by factory
+func Run(t *testing.T, factory Factory) {
+	t.Run("User", func(t *testing.T) {
+		runUserTests(t, factory)
+	})
+	t.Run("List", func(t *testing.T) {
+		runListTests(t, factory)
+	})
+	t.Run("Deletion", func(t *testing.T) {
+		runDeletionTests(t, factory)
+	})
+	t.Run("Export", func(t *testing.T) {
+		runExportTests(t, factory)
+	})
+	t.Run("Tombstone", func(t *testing.T) {
+		runTombstoneTests(t, factory)
+	})
+	t.Run("Audit", func(t *testing.T) {
+		runAuditTests(t, factory)
+	})
+	t.Run("Session", func(t *testing.T) {
+		runSessionTests(t, factory)
+	})
+	t.Run("LoginChallenge", func(t *testing.T) {
+		runLoginChallengeTests(t, factory)
+	})
+	t.Run("Tx", func(t *testing.T) {
+		runTxTests(t, factory)
+	})
+}
+
+func runUserTests(t *testing.T, factory Factory) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		user := newUser("alice")
+
+		if err := repo.Create(ctx, user); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		byID, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		assertUser(t, byID, user)
+
+		if byID.CreatedAt.IsZero() || byID.UpdatedAt.IsZero() {
+			t.Errorf("GetByID() timestamps not set: %+v", byID)
+		}
+
+		byUsername, err := repo.GetByUsername(ctx, user.Username)
+		if err != nil {
+			t.Fatalf("GetByUsername() unexpected error: %v", err)
+		}
+		assertUser(t, byUsername, user)
+
+		byEmail, err := repo.GetByEmail(ctx, user.Email)
+		if err != nil {
+			t.Fatalf("GetByEmail() unexpected error: %v", err)
+		}
+		assertUser(t, byEmail, user)
+	})
+
+	t.Run("ReturnedUserIsACopy", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		user := newUser("bob")
+		mustCreate(t, repo, user)
+
+		got, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		got.Email = "mutated@example.com"
+		user.Email = "mutated-too@example.com"
+
+		again, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if again.Email != "bob@example.com" {
+			t.Errorf("stored user changed without Update: email = %q", again.Email)
+		}
+	})
+
+	t.Run("CreateDuplicateUsername", func(t *testing.T) {
+		repo := factory(t).User
+		mustCreate(t, repo, newUser("carol"))
+
+		duplicate := newUser("carol")
+		duplicate.Email = "other@example.com"
+		if err := repo.Create(context.Background(), duplicate); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Create() error = %v, want ErrConflict", err)
+		}
+	})
+
+	t.Run("CreateDuplicateEmail", func(t *testing.T) {
+		repo := factory(t).User
+		mustCreate(t, repo, newUser("dave"))
+
+		duplicate := newUser("dave2")
+		duplicate.Email = "dave@example.com"
+		if err := repo.Create(context.Background(), duplicate); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Create() error = %v, want ErrConflict", err)
+		}
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+
+		if _, err := repo.GetByID(ctx, uuid.New().String()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() error = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.GetByUsername(ctx, "nobody"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByUsername() error = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.GetByEmail(ctx, "nobody@example.com"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByEmail() error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		user := newUser("erin")
+		mustCreate(t, repo, user)
+
+		created, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		user.Username = "erin2"
+		user.Email = "erin2@example.com"
+		user.Password = "new-hash"
+		if err := repo.Update(ctx, user); err != nil {
+			t.Fatalf("Update() unexpected error: %v", err)
+		}
+
+		updated, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		assertUser(t, updated, user)
+
+		if !updated.UpdatedAt.After(created.UpdatedAt) {
+			t.Errorf("Update() did not advance updated_at: %v -> %v", created.UpdatedAt, updated.UpdatedAt)
+		}
+
+		if _, err := repo.GetByUsername(ctx, "erin"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("old username still resolves: %v", err)
+		}
+		if _, err := repo.GetByUsername(ctx, "erin2"); err != nil {
+			t.Errorf("new username does not resolve: %v", err)
+		}
+	})
+
+	t.Run("MFA", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		user := newUser("mfa")
+		mustCreate(t, repo, user)
+
+		enabledAt := time.Now().Truncate(time.Millisecond)
+		user.MFASecret = "JBSWY3DPEHPK3PXP"
+		user.MFAEnabledAt = &enabledAt
+		user.MFALastStep = 56789012
+		if err := repo.Update(ctx, user); err != nil {
+			t.Fatalf("Update() unexpected error: %v", err)
+		}
+
+		got, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.MFASecret != user.MFASecret || got.MFAEnabledAt == nil || !got.MFAEnabledAt.Equal(enabledAt) || got.MFALastStep != user.MFALastStep {
+			t.Errorf("GetByID() mfa = %q, %v, %d, want %q, %v, %d",
+				got.MFASecret, got.MFAEnabledAt, got.MFALastStep, user.MFASecret, enabledAt, user.MFALastStep)
+		}
+	})
+
+	t.Run("Plan", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		user := newUser("plan")
+		mustCreate(t, repo, user)
+
+		got, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.Plan != models.PlanFree {
+			t.Errorf("new user plan = %q, want %q", got.Plan, models.PlanFree)
+		}
+
+		user.Plan = "enterprise"
+		if err := repo.Update(ctx, user); err != nil {
+			t.Fatalf("Update() unexpected error: %v", err)
+		}
+		if got, err = repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.Plan != "enterprise" {
+			t.Errorf("updated plan = %q, want enterprise", got.Plan)
+		}
+	})
+
+	t.Run("UpdateNotFound", func(t *testing.T) {
+		repo := factory(t).User
+
+		if err := repo.Update(context.Background(), newUser("frank")); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Update() error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("UpdateConflict", func(t *testing.T) {
+		repo := factory(t).User
+		mustCreate(t, repo, newUser("gina"))
+		user := newUser("hank")
+		mustCreate(t, repo, user)
+
+		user.Username = "gina"
+		if err := repo.Update(context.Background(), user); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Update() error = %v, want ErrConflict", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		user := newUser("ivan")
+		mustCreate(t, repo, user)
+
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Delete() unexpected error: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, user.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() after Delete error = %v, want ErrNotFound", err)
+		}
+
+		// The username and email can be reused once the user is gone
+		mustCreate(t, repo, newUser("ivan"))
+	})
+
+	t.Run("DeleteNotFound", func(t *testing.T) {
+		repo := factory(t).User
+
+		if err := repo.Delete(context.Background(), uuid.New().String()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Delete() error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("ConcurrentCreate", func(t *testing.T) {
+		repo := factory(t).User
+		const workers = 8
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				user := newUser("julia")
+				user.Email = fmt.Sprintf("julia%d@example.com", i)
+				errs <- repo.Create(context.Background(), user)
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		created := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case !errors.Is(err, repository.ErrConflict):
+				t.Errorf("Create() unexpected error: %v", err)
+			}
+		}
+		if created != 1 {
+			t.Errorf("concurrent Create() succeeded %d times, want exactly 1", created)
+		}
+	})
+
+	t.Run("ConcurrentUpdate", func(t *testing.T) {
+		repo := factory(t).User
+		user := newUser("kyle")
+		mustCreate(t, repo, user)
+		const workers = 8
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				update := *user
+				update.Password = fmt.Sprintf("hash-%d", i)
+				if err := repo.Update(context.Background(), &update); err != nil {
+					t.Errorf("Update() unexpected error: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		got, err := repo.GetByID(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+
+		// Last writer wins, but the row must match exactly one of the writes
+		valid := false
+		for i := 0; i < workers; i++ {
+			if got.Password == fmt.Sprintf("hash-%d", i) {
+				valid = true
+			}
+		}
+		if !valid || got.Username != user.Username || got.Email != user.Email {
+			t.Errorf("concurrent Update() left inconsistent row: %+v", got)
+		}
+	})
+}
+
+var errAbort = errors.New("abort transaction")
+
+func runTxTests(t *testing.T, factory Factory) {
+	t.Run("Commit", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		first, second := newUser("lena"), newUser("mike")
+
+		err := repo.WithTx(ctx, func(tx *repository.Repository) error {
+			if err := tx.User.Create(ctx, first); err != nil {
+				return err
+			}
+			return tx.User.Create(ctx, second)
+		})
+		if err != nil {
+			t.Fatalf("WithTx() unexpected error: %v", err)
+		}
+
+		for _, user := range []*models.User{first, second} {
+			if _, err := repo.User.GetByID(ctx, user.ID); err != nil {
+				t.Errorf("GetByID(%s) after commit: %v", user.Username, err)
+			}
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		existing := newUser("nina")
+		mustCreate(t, repo.User, existing)
+		created := newUser("oscar")
+
+		err := repo.WithTx(ctx, func(tx *repository.Repository) error {
+			if err := tx.User.Create(ctx, created); err != nil {
+				return err
+			}
+			existing.Password = "changed-in-tx"
+			if err := tx.User.Update(ctx, existing); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("WithTx() error = %v, want the callback error", err)
+		}
+
+		if _, err := repo.User.GetByID(ctx, created.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("user created in rolled back tx is visible: %v", err)
+		}
+		got, err := repo.User.GetByID(ctx, existing.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.Password != "hashed-password" {
+			t.Errorf("update in rolled back tx is visible: password = %q", got.Password)
+		}
+	})
+
+	t.Run("RollbackAcrossTables", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		user := newUser("olga")
+		mustCreate(t, repo.User, user)
+		kept := newAuditEvent(time.Now(), user.ID, models.AuditActionLogin, "", models.AuditOutcomeSuccess)
+
+		err := repo.WithTx(ctx, func(tx *repository.Repository) error {
+			event := newAuditEvent(time.Now(), user.ID, models.AuditActionUserDelete, "", models.AuditOutcomeSuccess)
+			if err := tx.Audit.Append(ctx, event); err != nil {
+				return err
+			}
+			if err := tx.User.Delete(ctx, user.ID); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("WithTx() error = %v, want the callback error", err)
+		}
+		if err := repo.Audit.Append(ctx, kept); err != nil {
+			t.Fatalf("Append() unexpected error: %v", err)
+		}
+
+		if _, err := repo.User.GetByUsername(ctx, user.Username); err != nil {
+			t.Errorf("delete in rolled back tx is visible: %v", err)
+		}
+		if got := listAllAudit(t, repo.Audit, repository.AuditFilter{}); fmt.Sprint(got) != fmt.Sprint([]string{kept.ID}) {
+			t.Errorf("audit log after rollback = %v, want only %s", got, kept.ID)
+		}
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		outer, inner := newUser("paula"), newUser("quinn")
+
+		err := repo.WithTx(ctx, func(tx *repository.Repository) error {
+			if err := tx.User.Create(ctx, outer); err != nil {
+				return err
+			}
+			if err := tx.WithTx(ctx, func(tx *repository.Repository) error {
+				return tx.User.Create(ctx, inner)
+			}); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("WithTx() error = %v, want the callback error", err)
+		}
+
+		// The nested call joined the outer transaction, so both are rolled back
+		for _, user := range []*models.User{outer, inner} {
+			if _, err := repo.User.GetByID(ctx, user.ID); !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("GetByID(%s) after rollback error = %v, want ErrNotFound", user.Username, err)
+			}
+		}
+	})
+
+	t.Run("NoLostUpdates", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		user := newUser("rosa")
+		user.Password = "0"
+		mustCreate(t, repo.User, user)
+		const workers = 8
+
+		// Each transaction increments a counter with read-modify-write. Any
+		// interleaving that loses an update must be serialized or retried.
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := repo.WithTx(ctx, func(tx *repository.Repository) error {
+					current, err := tx.User.GetByID(ctx, user.ID)
+					if err != nil {
+						return err
+					}
+					var n int
+					fmt.Sscanf(current.Password, "%d", &n)
+					current.Password = fmt.Sprintf("%d", n+1)
+					return tx.User.Update(ctx, current)
+				})
+				if err != nil {
+					t.Errorf("WithTx() unexpected error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		got, err := repo.User.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.Password != fmt.Sprintf("%d", workers) {
+			t.Errorf("counter = %s after %d transactions, updates were lost", got.Password, workers)
+		}
+	})
+}
+
+func runDeletionTests(t *testing.T, factory Factory) {
+	t.Run("Timestamps", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		user := newUser("alice")
+		mustCreate(t, repo, user)
+
+		scheduled := time.Now().Add(time.Hour).Truncate(time.Second)
+		user.Status = models.StatusPendingDeletion
+		user.DeletionScheduledAt = &scheduled
+		if err := repo.Update(ctx, user); err != nil {
+			t.Fatalf("Update() unexpected error: %v", err)
+		}
+
+		got, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.Status != models.StatusPendingDeletion || got.DeletionScheduledAt == nil || !got.DeletionScheduledAt.Equal(scheduled) {
+			t.Errorf("GetByID() status = %s, deletion_scheduled_at = %v, want %s, %v", got.Status, got.DeletionScheduledAt, models.StatusPendingDeletion, scheduled)
+		}
+		if got.DeletedAt != nil || got.PurgedAt != nil {
+			t.Errorf("GetByID() deleted_at = %v, purged_at = %v, want nil", got.DeletedAt, got.PurgedAt)
+		}
+
+		got.DeletionScheduledAt = nil
+		got.Status = models.StatusActive
+		if err := repo.Update(ctx, got); err != nil {
+			t.Fatalf("Update() unexpected error: %v", err)
+		}
+		got, err = repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.DeletionScheduledAt != nil {
+			t.Errorf("GetByID() deletion_scheduled_at = %v after clearing, want nil", got.DeletionScheduledAt)
+		}
+	})
+
+	t.Run("ListDeletionsDue", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		now := time.Now().Truncate(time.Second)
+		past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+		create := func(username, status string, scheduled, deleted, purged *time.Time) {
+			user := newUser(username)
+			user.Status = status
+			user.DeletionScheduledAt = scheduled
+			user.DeletedAt = deleted
+			user.PurgedAt = purged
+			mustCreate(t, repo, user)
+		}
+		create("active", models.StatusActive, nil, nil, nil)
+		create("scheduled-past", models.StatusPendingDeletion, &past, nil, nil)
+		create("scheduled-future", models.StatusPendingDeletion, &future, nil, nil)
+		create("deleted-past", models.StatusDeleted, nil, &past, nil)
+		create("deleted-future", models.StatusDeleted, nil, &future, nil)
+		create("purged", models.StatusDeleted, nil, &past, &past)
+
+		users, err := repo.ListDeletionsDue(ctx, now, now, 10)
+		if err != nil {
+			t.Fatalf("ListDeletionsDue() unexpected error: %v", err)
+		}
+		got := make(map[string]bool)
+		for _, user := range users {
+			got[user.Username] = true
+		}
+		if len(got) != 2 || !got["scheduled-past"] || !got["deleted-past"] {
+			t.Errorf("ListDeletionsDue() = %v, want scheduled-past and deleted-past", got)
+		}
+
+		users, err = repo.ListDeletionsDue(ctx, now, now, 1)
+		if err != nil {
+			t.Fatalf("ListDeletionsDue() unexpected error: %v", err)
+		}
+		if len(users) != 1 {
+			t.Errorf("ListDeletionsDue() with limit 1 returned %d users", len(users))
+		}
+	})
+}
+
+func runExportTests(t *testing.T, factory Factory) {
+	t.Run("Lifecycle", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		user := newUser("alice")
+		mustCreate(t, repo.User, user)
+
+		export := newExport(user.ID, time.Now().Add(time.Hour))
+		if err := repo.Export.Create(ctx, export); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		completed := time.Now().Truncate(time.Second)
+		export.Status = models.ExportStatusReady
+		export.Data = []byte("bundle")
+		export.CompletedAt = &completed
+		if err := repo.Export.Update(ctx, export); err != nil {
+			t.Fatalf("Update() unexpected error: %v", err)
+		}
+
+		got, err := repo.Export.GetByID(ctx, export.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.UserID != user.ID || got.Status != models.ExportStatusReady || string(got.Data) != "bundle" {
+			t.Errorf("GetByID() = %+v", got)
+		}
+		if got.CompletedAt == nil || !got.CompletedAt.Equal(completed) {
+			t.Errorf("GetByID() completed_at = %v, want %v", got.CompletedAt, completed)
+		}
+
+		list, err := repo.Export.ListByUser(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("ListByUser() unexpected error: %v", err)
+		}
+		if len(list) != 1 || list[0].ID != export.ID || list[0].Data != nil {
+			t.Errorf("ListByUser() = %+v, want one export without data", list)
+		}
+
+		if _, err := repo.Export.GetByID(ctx, uuid.New().String()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() missing error = %v, want ErrNotFound", err)
+		}
+		missing := newExport(user.ID, time.Now())
+		if err := repo.Export.Update(ctx, missing); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Update() missing error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		alice, bob := newUser("alice"), newUser("bob")
+		mustCreate(t, repo.User, alice)
+		mustCreate(t, repo.User, bob)
+
+		expired := newExport(alice.ID, time.Now().Add(-time.Minute))
+		current := newExport(alice.ID, time.Now().Add(time.Hour))
+		other := newExport(bob.ID, time.Now().Add(time.Hour))
+		for _, export := range []*models.DataExport{expired, current, other} {
+			if err := repo.Export.Create(ctx, export); err != nil {
+				t.Fatalf("Create() unexpected error: %v", err)
+			}
+		}
+
+		deleted, err := repo.Export.DeleteExpired(ctx, time.Now())
+		if err != nil {
+			t.Fatalf("DeleteExpired() unexpected error: %v", err)
+		}
+		if deleted != 1 {
+			t.Errorf("DeleteExpired() = %d, want 1", deleted)
+		}
+		if _, err := repo.Export.GetByID(ctx, expired.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("expired export still present: %v", err)
+		}
+
+		if err := repo.Export.DeleteByUser(ctx, alice.ID); err != nil {
+			t.Fatalf("DeleteByUser() unexpected error: %v", err)
+		}
+		if list, _ := repo.Export.ListByUser(ctx, alice.ID); len(list) != 0 {
+			t.Errorf("ListByUser() after DeleteByUser = %d exports", len(list))
+		}
+
+		// Exports go away with their user
+		if err := repo.User.Delete(ctx, bob.ID); err != nil {
+			t.Fatalf("Delete() unexpected error: %v", err)
+		}
+		if _, err := repo.Export.GetByID(ctx, other.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("export of deleted user still present: %v", err)
+		}
+	})
+}
+
+func runTombstoneTests(t *testing.T, factory Factory) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		repo := factory(t).Tombstone
+		ctx := context.Background()
+		tombstone := &models.ErasureTombstone{
+			ID:          uuid.New().String(),
+			UserID:      uuid.New().String(),
+			RequestedBy: "system",
+			Reason:      "retention period expired",
+			Sections:    []string{"profile", "exports"},
+			ErasedAt:    time.Now().Truncate(time.Second),
+		}
+		if err := repo.Create(ctx, tombstone); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		got, err := repo.GetByUserID(ctx, tombstone.UserID)
+		if err != nil {
+			t.Fatalf("GetByUserID() unexpected error: %v", err)
+		}
+		if got.ID != tombstone.ID || got.Reason != tombstone.Reason || !got.ErasedAt.Equal(tombstone.ErasedAt) ||
+			fmt.Sprint(got.Sections) != fmt.Sprint(tombstone.Sections) {
+			t.Errorf("GetByUserID() = %+v, want %+v", got, tombstone)
+		}
+
+		duplicate := *tombstone
+		duplicate.ID = uuid.New().String()
+		if err := repo.Create(ctx, &duplicate); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Create() duplicate error = %v, want ErrConflict", err)
+		}
+
+		if _, err := repo.GetByUserID(ctx, uuid.New().String()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByUserID() missing error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func runAuditTests(t *testing.T, factory Factory) {
+	t.Run("AppendAndList", func(t *testing.T) {
+		repo := factory(t).Audit
+		ctx := context.Background()
+		base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+
+		alice, bob := uuid.New().String(), uuid.New().String()
+		events := []*models.AuditEvent{
+			newAuditEvent(base, alice, models.AuditActionLogin, "", models.AuditOutcomeSuccess),
+			newAuditEvent(base.Add(time.Minute), alice, models.AuditActionUserDisable, bob, models.AuditOutcomeSuccess),
+			newAuditEvent(base.Add(2*time.Minute), bob, models.AuditActionLogin, "", models.AuditOutcomeFailure),
+			newAuditEvent(base.Add(3*time.Minute), alice, models.AuditActionUserRoleChange, bob, models.AuditOutcomeSuccess),
+			newAuditEvent(base.Add(4*time.Minute), "", models.AuditActionLogin, "", models.AuditOutcomeFailure),
+		}
+		events[3].Details = map[string]string{"role": models.RoleAdmin}
+		for _, event := range events {
+			if err := repo.Append(ctx, event); err != nil {
+				t.Fatalf("Append() unexpected error: %v", err)
+			}
+		}
+
+		page, err := repo.List(ctx, repository.AuditFilter{Action: models.AuditActionUserRoleChange})
+		if err != nil {
+			t.Fatalf("List() unexpected error: %v", err)
+		}
+		if len(page.Events) != 1 {
+			t.Fatalf("List() returned %d events, want 1", len(page.Events))
+		}
+		got, want := page.Events[0], events[3]
+		if got.ID != want.ID || got.ActorID != want.ActorID || got.TargetID != want.TargetID ||
+			got.IP != want.IP || got.UserAgent != want.UserAgent || got.RequestID != want.RequestID ||
+			!got.OccurredAt.Equal(want.OccurredAt) || got.Details["role"] != models.RoleAdmin {
+			t.Errorf("List() event = %+v, want %+v", got, want)
+		}
+
+		// i is the position of an event in events
+		tests := []struct {
+			name   string
+			filter repository.AuditFilter
+			want   []int
+		}{
+			{"all newest first", repository.AuditFilter{}, []int{4, 3, 2, 1, 0}},
+			{"actor", repository.AuditFilter{ActorID: alice}, []int{3, 1, 0}},
+			{"target", repository.AuditFilter{TargetID: bob}, []int{3, 1}},
+			{"user is actor or target", repository.AuditFilter{UserID: bob}, []int{3, 2, 1}},
+			{"outcome", repository.AuditFilter{Outcome: models.AuditOutcomeFailure}, []int{4, 2}},
+			{"action", repository.AuditFilter{Action: models.AuditActionLogin}, []int{4, 2, 0}},
+			{"since", repository.AuditFilter{Since: base.Add(3 * time.Minute)}, []int{4, 3}},
+			{"until", repository.AuditFilter{Until: base.Add(time.Minute)}, []int{0}},
+			{"tenant", repository.AuditFilter{Tenant: "other"}, nil},
+			{"combined", repository.AuditFilter{ActorID: alice, TargetID: bob, Since: base.Add(2 * time.Minute)}, []int{3}},
+		}
+		for _, tt := range tests {
+			for _, limit := range []int{0, 2} {
+				tt.filter.Limit = limit
+				var wantIDs []string
+				for _, i := range tt.want {
+					wantIDs = append(wantIDs, events[i].ID)
+				}
+				if got := listAllAudit(t, repo, tt.filter); fmt.Sprint(got) != fmt.Sprint(wantIDs) {
+					t.Errorf("%s (limit %d): got %v, want %v", tt.name, limit, got, wantIDs)
+				}
+			}
+		}
+	})
+
+	t.Run("DuplicateID", func(t *testing.T) {
+		repo := factory(t).Audit
+		ctx := context.Background()
+		event := newAuditEvent(time.Now(), uuid.New().String(), models.AuditActionLogout, "", models.AuditOutcomeSuccess)
+		if err := repo.Append(ctx, event); err != nil {
+			t.Fatalf("Append() unexpected error: %v", err)
+		}
+		if err := repo.Append(ctx, event); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Append() duplicate error = %v, want ErrConflict", err)
+		}
+	})
+
+	t.Run("Sequence", func(t *testing.T) {
+		repo := factory(t).Audit
+		ctx := context.Background()
+
+		if _, err := repo.Head(ctx); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Head() on empty log error = %v, want ErrNotFound", err)
+		}
+
+		// An event without a Seq is not part of the chain
+		unchained := newAuditEvent(time.Now(), uuid.New().String(), models.AuditActionLogin, "", models.AuditOutcomeSuccess)
+		if err := repo.Append(ctx, unchained); err != nil {
+			t.Fatalf("Append() unexpected error: %v", err)
+		}
+		var chained []*models.AuditEvent
+		for _, seq := range []int64{1, 3, 2} {
+			event := newAuditEvent(time.Now(), uuid.New().String(), models.AuditActionLogin, "", models.AuditOutcomeSuccess)
+			event.Seq, event.PrevHash, event.Hash = seq, fmt.Sprint("hash-", seq-1), fmt.Sprint("hash-", seq)
+			if err := repo.Append(ctx, event); err != nil {
+				t.Fatalf("Append() unexpected error: %v", err)
+			}
+			chained = append(chained, event)
+		}
+
+		head, err := repo.Head(ctx)
+		if err != nil {
+			t.Fatalf("Head() unexpected error: %v", err)
+		}
+		if head.ID != chained[1].ID || head.Seq != 3 || head.PrevHash != "hash-2" || head.Hash != "hash-3" {
+			t.Errorf("Head() = %+v, want seq 3", head)
+		}
+
+		events, err := repo.ListBySeq(ctx, 1, 10)
+		if err != nil {
+			t.Fatalf("ListBySeq() unexpected error: %v", err)
+		}
+		if len(events) != 2 || events[0].Seq != 2 || events[1].Seq != 3 {
+			t.Errorf("ListBySeq(1) = %+v, want seq 2 and 3", events)
+		}
+		if events, _ := repo.ListBySeq(ctx, 0, 1); len(events) != 1 || events[0].Seq != 1 {
+			t.Errorf("ListBySeq(0, limit 1) = %+v, want seq 1", events)
+		}
+
+		duplicate := newAuditEvent(time.Now(), uuid.New().String(), models.AuditActionLogin, "", models.AuditOutcomeSuccess)
+		duplicate.Seq = 2
+		if err := repo.Append(ctx, duplicate); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Append() duplicate seq error = %v, want ErrConflict", err)
+		}
+	})
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		repo := factory(t).Audit
+		_, err := repo.List(context.Background(), repository.AuditFilter{Cursor: "not-a-cursor"})
+		if !errors.Is(err, repository.ErrInvalidCursor) {
+			t.Errorf("List() error = %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+func runSessionTests(t *testing.T, factory Factory) {
+	t.Run("Lifecycle", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		user := newUser("alice")
+		mustCreate(t, repo.User, user)
+
+		session := newSession(user.ID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+		if err := repo.Session.Create(ctx, session); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+		if err := repo.Session.Create(ctx, session); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Create() duplicate error = %v, want ErrConflict", err)
+		}
+		orphan := newSession(uuid.New().String(), time.Now(), time.Now().Add(time.Hour))
+		if err := repo.Session.Create(ctx, orphan); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Create() for missing user error = %v, want ErrNotFound", err)
+		}
+
+		got, err := repo.Session.GetByID(ctx, session.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.UserID != user.ID || got.DeviceID != session.DeviceID || got.Device != session.Device || got.UserAgent != session.UserAgent ||
+			got.IP != session.IP || got.Location != session.Location || got.RevokedAt != nil ||
+			!got.CreatedAt.Equal(session.CreatedAt) || !got.LastSeenAt.Equal(session.CreatedAt) ||
+			!got.ExpiresAt.Equal(session.ExpiresAt) {
+			t.Errorf("GetByID() = %+v, want %+v", got, session)
+		}
+
+		seen := time.Now().Truncate(time.Millisecond)
+		if err := repo.Session.Touch(ctx, session.ID, seen); err != nil {
+			t.Fatalf("Touch() unexpected error: %v", err)
+		}
+		revoked := time.Now().Truncate(time.Millisecond)
+		if err := repo.Session.Revoke(ctx, session.ID, revoked); err != nil {
+			t.Fatalf("Revoke() unexpected error: %v", err)
+		}
+		if err := repo.Session.Revoke(ctx, session.ID, revoked.Add(time.Minute)); err != nil {
+			t.Fatalf("Revoke() again unexpected error: %v", err)
+		}
+
+		got, err = repo.Session.GetByID(ctx, session.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if !got.LastSeenAt.Equal(seen) {
+			t.Errorf("last seen = %v, want %v", got.LastSeenAt, seen)
+		}
+		if got.RevokedAt == nil || !got.RevokedAt.Equal(revoked) {
+			t.Errorf("revoked at = %v, want the first revocation %v", got.RevokedAt, revoked)
+		}
+
+		missing := uuid.New().String()
+		if _, err := repo.Session.GetByID(ctx, missing); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() missing error = %v, want ErrNotFound", err)
+		}
+		if err := repo.Session.Touch(ctx, missing, seen); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Touch() missing error = %v, want ErrNotFound", err)
+		}
+		if err := repo.Session.Revoke(ctx, missing, seen); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Revoke() missing error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("RevokeAndDelete", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		alice, bob := newUser("alice"), newUser("bob")
+		mustCreate(t, repo.User, alice)
+		mustCreate(t, repo.User, bob)
+
+		now := time.Now()
+		current := newSession(alice.ID, now.Add(-3*time.Minute), now.Add(time.Hour))
+		other := newSession(alice.ID, now.Add(-2*time.Minute), now.Add(time.Hour))
+		expired := newSession(alice.ID, now.Add(-2*time.Hour), now.Add(-time.Hour))
+		bobs := newSession(bob.ID, now.Add(-time.Minute), now.Add(time.Hour))
+		for _, session := range []*models.Session{current, other, expired, bobs} {
+			if err := repo.Session.Create(ctx, session); err != nil {
+				t.Fatalf("Create() unexpected error: %v", err)
+			}
+		}
+
+		list, err := repo.Session.ListByUser(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("ListByUser() unexpected error: %v", err)
+		}
+		if len(list) != 3 || list[0].ID != other.ID || list[1].ID != current.ID || list[2].ID != expired.ID {
+			t.Errorf("ListByUser() = %+v, want alice's sessions newest first", list)
+		}
+
+		revoked, err := repo.Session.RevokeByUser(ctx, alice.ID, current.ID, now)
+		if err != nil {
+			t.Fatalf("RevokeByUser() unexpected error: %v", err)
+		}
+		if revoked != 1 {
+			t.Errorf("RevokeByUser() = %d, want 1", revoked)
+		}
+		for _, session := range []*models.Session{current, expired, bobs} {
+			if got, _ := repo.Session.GetByID(ctx, session.ID); got.RevokedAt != nil {
+				t.Errorf("session %s was revoked", session.ID)
+			}
+		}
+
+		// current and bobs; other was revoked and expired has expired
+		if active, err := repo.Session.CountActive(ctx, now.Add(time.Second)); err != nil || active != 2 {
+			t.Errorf("CountActive() = %d, %v, want 2", active, err)
+		}
+
+		// Only the expired session ended before now; other was revoked at now
+		deleted, err := repo.Session.DeleteEnded(ctx, now)
+		if err != nil {
+			t.Fatalf("DeleteEnded() unexpected error: %v", err)
+		}
+		if deleted != 1 {
+			t.Errorf("DeleteEnded() = %d, want 1", deleted)
+		}
+		if deleted, _ := repo.Session.DeleteEnded(ctx, now.Add(time.Second)); deleted != 1 {
+			t.Errorf("DeleteEnded() after revocation = %d, want 1", deleted)
+		}
+
+		if err := repo.Session.DeleteByUser(ctx, alice.ID); err != nil {
+			t.Fatalf("DeleteByUser() unexpected error: %v", err)
+		}
+		if list, _ := repo.Session.ListByUser(ctx, alice.ID); len(list) != 0 {
+			t.Errorf("ListByUser() after DeleteByUser = %+v", list)
+		}
+
+		// Deleting the user removes their sessions
+		if err := repo.User.Delete(ctx, bob.ID); err != nil {
+			t.Fatalf("Delete() unexpected error: %v", err)
+		}
+		if _, err := repo.Session.GetByID(ctx, bobs.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() after user deletion error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func runLoginChallengeTests(t *testing.T, factory Factory) {
+	t.Run("Lifecycle", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		user := newUser("alice")
+		mustCreate(t, repo.User, user)
+
+		challenge := newLoginChallenge(user.ID, time.Now(), time.Now().Add(10*time.Minute))
+		if err := repo.LoginChallenge.Create(ctx, challenge); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+		if err := repo.LoginChallenge.Create(ctx, challenge); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Create() duplicate error = %v, want ErrConflict", err)
+		}
+		orphan := newLoginChallenge(uuid.New().String(), time.Now(), time.Now().Add(time.Minute))
+		if err := repo.LoginChallenge.Create(ctx, orphan); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Create() for missing user error = %v, want ErrNotFound", err)
+		}
+
+		got, err := repo.LoginChallenge.GetByID(ctx, challenge.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.UserID != user.ID || got.Method != challenge.Method || got.SecretHash != challenge.SecretHash ||
+			got.BindingHash != challenge.BindingHash || got.Attempts != 0 || got.UsedAt != nil ||
+			!got.CreatedAt.Equal(challenge.CreatedAt) || !got.ExpiresAt.Equal(challenge.ExpiresAt) {
+			t.Errorf("GetByID() = %+v, want %+v", got, challenge)
+		}
+
+		for want := 1; want <= 2; want++ {
+			attempts, err := repo.LoginChallenge.IncrementAttempts(ctx, challenge.ID, 2)
+			if err != nil || attempts != want {
+				t.Errorf("IncrementAttempts() = %d, %v, want %d", attempts, err, want)
+			}
+		}
+		if _, err := repo.LoginChallenge.IncrementAttempts(ctx, challenge.ID, 2); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("IncrementAttempts() past the limit error = %v, want ErrConflict", err)
+		}
+
+		used := time.Now().Truncate(time.Millisecond)
+		if err := repo.LoginChallenge.Consume(ctx, challenge.ID, used); err != nil {
+			t.Fatalf("Consume() unexpected error: %v", err)
+		}
+		if err := repo.LoginChallenge.Consume(ctx, challenge.ID, used.Add(time.Minute)); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("Consume() again error = %v, want ErrConflict", err)
+		}
+		got, err = repo.LoginChallenge.GetByID(ctx, challenge.ID)
+		if err != nil {
+			t.Fatalf("GetByID() unexpected error: %v", err)
+		}
+		if got.Attempts != 2 || got.UsedAt == nil || !got.UsedAt.Equal(used) {
+			t.Errorf("GetByID() after Consume = %+v, want 2 attempts and used at %v", got, used)
+		}
+
+		missing := uuid.New().String()
+		if _, err := repo.LoginChallenge.GetByID(ctx, missing); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() missing error = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.LoginChallenge.IncrementAttempts(ctx, missing, 2); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("IncrementAttempts() missing error = %v, want ErrNotFound", err)
+		}
+		if err := repo.LoginChallenge.Consume(ctx, missing, used); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Consume() missing error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("ConcurrentConsume", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		user := newUser("alice")
+		mustCreate(t, repo.User, user)
+		challenge := newLoginChallenge(user.ID, time.Now(), time.Now().Add(time.Minute))
+		if err := repo.LoginChallenge.Create(ctx, challenge); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		const workers = 8
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		consumed := 0
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := repo.LoginChallenge.Consume(ctx, challenge.ID, time.Now())
+				if err != nil && !errors.Is(err, repository.ErrConflict) {
+					t.Errorf("Consume() unexpected error: %v", err)
+					return
+				}
+				if err == nil {
+					mu.Lock()
+					consumed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if consumed != 1 {
+			t.Errorf("challenge consumed %d times, want once", consumed)
+		}
+	})
+
+	t.Run("ConcurrentAttempts", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		user := newUser("alice")
+		mustCreate(t, repo.User, user)
+		challenge := newLoginChallenge(user.ID, time.Now(), time.Now().Add(time.Minute))
+		if err := repo.LoginChallenge.Create(ctx, challenge); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		const workers, max = 16, 3
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		counted := 0
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := repo.LoginChallenge.IncrementAttempts(ctx, challenge.ID, max)
+				if err != nil && !errors.Is(err, repository.ErrConflict) {
+					t.Errorf("IncrementAttempts() unexpected error: %v", err)
+					return
+				}
+				if err == nil {
+					mu.Lock()
+					counted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if counted != max {
+			t.Errorf("%d attempts counted, want %d", counted, max)
+		}
+		if got, err := repo.LoginChallenge.GetByID(ctx, challenge.ID); err != nil || got.Attempts != max {
+			t.Errorf("GetByID() attempts = %v, %v, want %d", got, err, max)
+		}
+	})
+
+	t.Run("CountAndDelete", func(t *testing.T) {
+		repo := factory(t)
+		ctx := context.Background()
+		alice, bob := newUser("alice"), newUser("bob")
+		mustCreate(t, repo.User, alice)
+		mustCreate(t, repo.User, bob)
+
+		now := time.Now()
+		old := newLoginChallenge(alice.ID, now.Add(-2*time.Hour), now.Add(-time.Hour))
+		recent := newLoginChallenge(alice.ID, now.Add(-time.Minute), now.Add(time.Minute))
+		bobs := newLoginChallenge(bob.ID, now.Add(-time.Minute), now.Add(time.Minute))
+		for _, challenge := range []*models.LoginChallenge{old, recent, bobs} {
+			if err := repo.LoginChallenge.Create(ctx, challenge); err != nil {
+				t.Fatalf("Create() unexpected error: %v", err)
+			}
+		}
+
+		count, err := repo.LoginChallenge.CountByUserSince(ctx, alice.ID, now.Add(-time.Hour), models.LoginMethodCode)
+		if err != nil || count != 1 {
+			t.Errorf("CountByUserSince() = %d, %v, want 1", count, err)
+		}
+		if count, _ := repo.LoginChallenge.CountByUserSince(ctx, alice.ID, now.Add(-3*time.Hour), models.LoginMethodLink, models.LoginMethodCode); count != 2 {
+			t.Errorf("CountByUserSince() over 3h = %d, want 2", count)
+		}
+		// Only the given methods count
+		if count, _ := repo.LoginChallenge.CountByUserSince(ctx, alice.ID, now.Add(-3*time.Hour), models.LoginMethodLink); count != 0 {
+			t.Errorf("CountByUserSince() of links = %d, want 0", count)
+		}
+
+		deleted, err := repo.LoginChallenge.DeleteExpired(ctx, now)
+		if err != nil || deleted != 1 {
+			t.Errorf("DeleteExpired() = %d, %v, want 1", deleted, err)
+		}
+		if _, err := repo.LoginChallenge.GetByID(ctx, old.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() expired error = %v, want ErrNotFound", err)
+		}
+
+		if err := repo.LoginChallenge.DeleteByUser(ctx, alice.ID); err != nil {
+			t.Fatalf("DeleteByUser() unexpected error: %v", err)
+		}
+		if _, err := repo.LoginChallenge.GetByID(ctx, recent.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() after DeleteByUser error = %v, want ErrNotFound", err)
+		}
+
+		// Deleting the user removes their challenges
+		if err := repo.User.Delete(ctx, bob.ID); err != nil {
+			t.Fatalf("Delete() unexpected error: %v", err)
+		}
+		if _, err := repo.LoginChallenge.GetByID(ctx, bobs.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() after user deletion error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func runListTests(t *testing.T, factory Factory) {
+	t.Run("Paginate", func(t *testing.T) {
+		repo := factory(t).User
+		want := make(map[string]bool)
+		for i := 0; i < 7; i++ {
+			user := newUser(fmt.Sprintf("user%02d", i))
+			mustCreate(t, repo, user)
+			want[user.Username] = true
+		}
+
+		for _, descending := range []bool{false, true} {
+			for _, sort := range []repository.UserSort{repository.SortByCreatedAt, repository.SortByUsername, repository.SortByEmail} {
+				filter := repository.UserFilter{Sort: sort, Descending: descending, Limit: 3}
+				usernames := listAll(t, repo, filter)
+
+				if len(usernames) != len(want) {
+					t.Fatalf("sort %s desc=%v: got %d users %v, want %d", sort, descending, len(usernames), usernames, len(want))
+				}
+				seen := make(map[string]bool)
+				for _, username := range usernames {
+					if seen[username] {
+						t.Errorf("sort %s desc=%v: %s returned twice", sort, descending, username)
+					}
+					seen[username] = true
+				}
+				if sort == repository.SortByUsername {
+					for i := 1; i < len(usernames); i++ {
+						if (usernames[i-1] < usernames[i]) == descending {
+							t.Errorf("sort %s desc=%v: out of order %v", sort, descending, usernames)
+							break
+						}
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+
+		alice := newUser("alice")
+		mustCreate(t, repo, alice)
+		albert := newUser("albert")
+		albert.Email = "albert@Corp.example"
+		albert.Role = models.RoleAdmin
+		mustCreate(t, repo, albert)
+
+		time.Sleep(10 * time.Millisecond)
+		boundary := time.Now()
+		time.Sleep(10 * time.Millisecond)
+
+		bob := newUser("bob")
+		bob.Email = "bob@corp.example"
+		mustCreate(t, repo, bob)
+		bob.Status = models.StatusDisabled
+		if err := repo.Update(ctx, bob); err != nil {
+			t.Fatalf("Update() unexpected error: %v", err)
+		}
+
+		tests := []struct {
+			name   string
+			filter repository.UserFilter
+			want   []string
+		}{
+			{"all", repository.UserFilter{}, []string{"albert", "alice", "bob"}},
+			{"search prefix", repository.UserFilter{Search: "AL"}, []string{"albert", "alice"}},
+			{"search email prefix", repository.UserFilter{Search: "bob@"}, []string{"bob"}},
+			{"search is not substring", repository.UserFilter{Search: "lice"}, nil},
+			{"search escapes wildcards", repository.UserFilter{Search: "a%"}, nil},
+			{"email domain", repository.UserFilter{EmailDomain: "corp.example"}, []string{"albert", "bob"}},
+			{"status", repository.UserFilter{Status: models.StatusDisabled}, []string{"bob"}},
+			{"role", repository.UserFilter{Role: models.RoleAdmin}, []string{"albert"}},
+			{"created after", repository.UserFilter{CreatedAfter: boundary}, []string{"bob"}},
+			{"created before", repository.UserFilter{CreatedBefore: boundary}, []string{"albert", "alice"}},
+			{"combined", repository.UserFilter{EmailDomain: "corp.example", Status: models.StatusActive}, []string{"albert"}},
+		}
+
+		for _, tt := range tests {
+			tt.filter.Sort = repository.SortByUsername
+			got := listAll(t, repo, tt.filter)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		repo := factory(t).User
+		ctx := context.Background()
+		mustCreate(t, repo, newUser("alice"))
+		mustCreate(t, repo, newUser("bob"))
+
+		_, err := repo.List(ctx, repository.UserFilter{Cursor: "not-a-cursor"})
+		if !errors.Is(err, repository.ErrInvalidCursor) {
+			t.Errorf("List() error = %v, want ErrInvalidCursor", err)
+		}
+
+		page, err := repo.List(ctx, repository.UserFilter{Sort: repository.SortByUsername, Limit: 1})
+		if err != nil {
+			t.Fatalf("List() unexpected error: %v", err)
+		}
+		// A cursor is only valid for the sort order it was issued for
+		_, err = repo.List(ctx, repository.UserFilter{Sort: repository.SortByEmail, Cursor: page.NextCursor})
+		if !errors.Is(err, repository.ErrInvalidCursor) {
+			t.Errorf("List() with mismatched sort error = %v, want ErrInvalidCursor", err)
+		}
+	})
+
+	t.Run("InvalidSort", func(t *testing.T) {
+		repo := factory(t).User
+		mustCreate(t, repo, newUser("alice"))
+
+		for _, sort := range []repository.UserSort{"password", "id; DROP TABLE users"} {
+			_, err := repo.List(context.Background(), repository.UserFilter{Sort: sort})
+			if !errors.Is(err, repository.ErrInvalidSort) {
+				t.Errorf("List(sort %q) error = %v, want ErrInvalidSort", sort, err)
+			}
+		}
+	})
+}
+
+// listAll follows cursors until the listing is exhausted and returns the
+// usernames in order
+func listAll(t *testing.T, repo repository.UserRepository, filter repository.UserFilter) []string {
+	t.Helper()
+	var usernames []string
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("List() did not terminate")
+		}
+		page, err := repo.List(context.Background(), filter)
+		if err != nil {
+			t.Fatalf("List(%+v) unexpected error: %v", filter, err)
+		}
+		for _, user := range page.Users {
+			usernames = append(usernames, user.Username)
+		}
+		if page.NextCursor == "" {
+			return usernames
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+// listAllAudit follows cursors until the audit log is exhausted and returns
+// the event IDs in order
+func listAllAudit(t *testing.T, repo repository.AuditRepository, filter repository.AuditFilter) []string {
+	t.Helper()
+	var ids []string
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("List() did not terminate")
+		}
+		page, err := repo.List(context.Background(), filter)
+		if err != nil {
+			t.Fatalf("List(%+v) unexpected error: %v", filter, err)
+		}
+		for _, event := range page.Events {
+			ids = append(ids, event.ID)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+func newUser(username string) *models.User {
+	return &models.User{
+		ID:       uuid.New().String(),
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "hashed-password",
+	}
+}
+
+func newExport(userID string, expiresAt time.Time) *models.DataExport {
+	return &models.DataExport{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Format:    models.ExportFormatJSON,
+		Status:    models.ExportStatusPending,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func newAuditEvent(at time.Time, actorID, action, targetID, outcome string) *models.AuditEvent {
+	return &models.AuditEvent{
+		ID:         uuid.New().String(),
+		OccurredAt: at,
+		Tenant:     "default",
+		ActorID:    actorID,
+		Action:     action,
+		TargetID:   targetID,
+		Outcome:    outcome,
+		IP:         "192.0.2.1",
+		UserAgent:  "repositorytest",
+		RequestID:  uuid.New().String(),
+	}
+}
+
+func newSession(userID string, createdAt, expiresAt time.Time) *models.Session {
+	return &models.Session{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		DeviceID:  uuid.New().String(),
+		Device:    "Firefox on Linux",
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+		IP:        "192.0.2.1",
+		Location:  "Berlin, Germany",
+		CreatedAt: createdAt.Truncate(time.Millisecond),
+		ExpiresAt: expiresAt.Truncate(time.Millisecond),
+	}
+}
+
+func newLoginChallenge(userID string, createdAt, expiresAt time.Time) *models.LoginChallenge {
+	return &models.LoginChallenge{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Method:      models.LoginMethodCode,
+		SecretHash:  fmt.Sprintf("%064x", createdAt.UnixNano()),
+		BindingHash: fmt.Sprintf("%064x", expiresAt.UnixNano()),
+		CreatedAt:   createdAt.Truncate(time.Millisecond),
+		ExpiresAt:   expiresAt.Truncate(time.Millisecond),
+	}
+}
+
+func mustCreate(t *testing.T, repo repository.UserRepository, user *models.User) {
+	t.Helper()
+	if err := repo.Create(context.Background(), user); err != nil {
+		t.Fatalf("Create(%s) unexpected error: %v", user.Username, err)
+	}
+}
+
+func assertUser(t *testing.T, got, want *models.User) {
+	t.Helper()
+	if got.ID != want.ID || got.Username != want.Username || got.Email != want.Email || got.Password != want.Password {
+		t.Errorf("user = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how transactions are retried after serialization
+// failures and deadlocks
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used by the SQL transactors
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 10,
+	BaseDelay:   5 * time.Millisecond,
+	MaxDelay:    500 * time.Millisecond,
+}
+
+// Retry calls fn until it succeeds, returns an error that retryable rejects,
+// or the policy's attempts are exhausted. Delays grow exponentially with
+// jitter so that competing transactions don't collide again.
+func Retry(ctx context.Context, policy RetryPolicy, retryable func(error) bool, fn func() error) error {
+	delay := policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+		if delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+const auditColumns = `id, occurred_at, tenant, actor_id, action, target_id, outcome, reason, ip, user_agent, request_id, details, seq, prev_hash, hash`
+
+type AuditRepository struct {
+	db dbtx
+}
+
+func (r *AuditRepository) Append(ctx context.Context, event *models.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (` + auditColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	`
+	details, err := encodeDetails(event.Details)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query,
+		event.ID, event.OccurredAt.UTC(), event.Tenant, event.ActorID, event.Action, event.TargetID,
+		event.Outcome, event.Reason, event.IP, event.UserAgent, event.RequestID, details,
+		event.Seq, event.PrevHash, event.Hash,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrConflict
+		}
+		return fmt.Errorf("failed to append audit event: %w", err)
+	}
+	return nil
+}
+
+func (r *AuditRepository) List(ctx context.Context, filter repository.AuditFilter) (*repository.AuditPage, error) {
+	cursor, err := filter.DecodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	for _, match := range [...]struct{ column, value string }{
+		{"tenant", filter.Tenant},
+		{"actor_id", filter.ActorID},
+		{"target_id", filter.TargetID},
+		{"action", filter.Action},
+		{"outcome", filter.Outcome},
+		{"request_id", filter.RequestID},
+	} {
+		if match.value != "" {
+			conditions = append(conditions, match.column+` = `+arg(match.value))
+		}
+	}
+	if filter.UserID != "" {
+		userID := arg(filter.UserID)
+		conditions = append(conditions, `(actor_id = `+userID+` OR target_id = `+userID+`)`)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, `occurred_at >= `+arg(filter.Since.UTC()))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, `occurred_at < `+arg(filter.Until.UTC()))
+	}
+	if cursor != nil {
+		// Timestamps are stored as UTC text, which sorts chronologically
+		conditions = append(conditions, `(occurred_at, id) < (`+arg(cursor.OccurredAt.UTC())+`, `+arg(cursor.ID)+`)`)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM audit_events`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	limit := filter.PageSize()
+	query += ` ORDER BY occurred_at DESC, id DESC LIMIT ` + arg(limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	page := &repository.AuditPage{}
+	if page.Events, err = scanAuditEvents(rows); err != nil {
+		return nil, err
+	}
+
+	// One extra row was fetched to know whether another page exists
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+		page.NextCursor = repository.NewAuditCursor(page.Events[limit-1]).Encode()
+	}
+	return page, nil
+}
+
+func (r *AuditRepository) Head(ctx context.Context) (*models.AuditEvent, error) {
+	query := `SELECT ` + auditColumns + ` FROM audit_events WHERE seq > 0 ORDER BY seq DESC LIMIT 1`
+	event, err := scanAuditEvent(r.db.QueryRowContext(ctx, query))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get audit chain head: %w", err)
+	}
+	return event, nil
+}
+
+func (r *AuditRepository) ListBySeq(ctx context.Context, afterSeq int64, limit int) ([]*models.AuditEvent, error) {
+	query := `SELECT ` + auditColumns + ` FROM audit_events WHERE seq > $1 ORDER BY seq LIMIT $2`
+	rows, err := r.db.QueryContext(ctx, query, afterSeq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+	return scanAuditEvents(rows)
+}
+
+func scanAuditEvents(rows *sql.Rows) ([]*models.AuditEvent, error) {
+	var events []*models.AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return events, nil
+}
+
+func scanAuditEvent(row rowScanner) (*models.AuditEvent, error) {
+	event := &models.AuditEvent{}
+	var details string
+	err := row.Scan(
+		&event.ID, &event.OccurredAt, &event.Tenant, &event.ActorID, &event.Action, &event.TargetID,
+		&event.Outcome, &event.Reason, &event.IP, &event.UserAgent, &event.RequestID, &details,
+		&event.Seq, &event.PrevHash, &event.Hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	event.OccurredAt = event.OccurredAt.UTC()
+	if event.Details, err = decodeDetails(details); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// encodeDetails stores event details as a JSON object, or an empty string
+// when there are none
+func encodeDetails(details map[string]string) (string, error) {
+	if len(details) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit details: %w", err)
+	}
+	return string(data), nil
+}
+
+func decodeDetails(data string) (map[string]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var details map[string]string
+	if err := json.Unmarshal([]byte(data), &details); err != nil {
+		return nil, fmt.Errorf("failed to decode audit details: %w", err)
+	}
+	return details, nil
+}
@@ -0,0 +1,107 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"auth/internal/database"
+	"auth/internal/repository"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx so repositories can run
+// inside or outside a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// NewRepository returns all SQLite repositories with transaction support.
+// SQLite serializes writers, so transactions are serializable; a transaction
+// holds the only connection until it finishes.
+func NewRepository(db *sql.DB) *repository.Repository {
+	repo := newRepository(db)
+	repo.Transactor = &Transactor{db: db, policy: repository.DefaultRetryPolicy}
+	return repo
+}
+
+func newRepository(db dbtx) *repository.Repository {
+	db = database.Instrument(db, "sqlite")
+	return &repository.Repository{
+		User:           &UserRepository{db: db},
+		Export:         &ExportRepository{db: db},
+		Tombstone:      &TombstoneRepository{db: db},
+		Audit:          &AuditRepository{db: db},
+		Session:        &SessionRepository{db: db},
+		LoginChallenge: &LoginChallengeRepository{db: db},
+	}
+}
+
+// Transactor runs units of work in SQLite transactions and retries them when
+// the database is busy or locked by another process
+type Transactor struct {
+	db     *sql.DB
+	policy repository.RetryPolicy
+}
+
+func (t *Transactor) WithTx(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	return repository.Retry(ctx, t.policy, isRetryable, func() error {
+		return t.run(ctx, fn)
+	})
+}
+
+func (t *Transactor) run(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	txRepo := newRepository(tx)
+	txRepo.Transactor = joinedTx{repo: txRepo}
+
+	if err := fn(txRepo); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// joinedTx makes nested WithTx calls reuse the surrounding transaction
+type joinedTx struct {
+	repo *repository.Repository
+}
+
+func (j joinedTx) WithTx(ctx context.Context, fn func(tx *repository.Repository) error) error {
+	return fn(j.repo)
+}
+
+func isRetryable(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff // primary result code
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code()
+	return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+func isForeignKeyViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+type TombstoneRepository struct {
+	db dbtx
+}
+
+func (r *TombstoneRepository) Create(ctx context.Context, tombstone *models.ErasureTombstone) error {
+	query := `
+		INSERT INTO erasure_tombstones (id, user_id, requested_by, reason, sections, erased_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		tombstone.ID, tombstone.UserID, tombstone.RequestedBy, tombstone.Reason,
+		strings.Join(tombstone.Sections, ","), tombstone.ErasedAt.UTC(),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrConflict
+		}
+		return fmt.Errorf("failed to create tombstone: %w", err)
+	}
+	return nil
+}
+
+func (r *TombstoneRepository) GetByUserID(ctx context.Context, userID string) (*models.ErasureTombstone, error) {
+	query := `
+		SELECT id, user_id, requested_by, reason, sections, erased_at
+		FROM erasure_tombstones WHERE user_id = $1
+	`
+	tombstone := &models.ErasureTombstone{}
+	var sections string
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&tombstone.ID, &tombstone.UserID, &tombstone.RequestedBy, &tombstone.Reason,
+		&sections, &tombstone.ErasedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get tombstone: %w", err)
+	}
+	if sections != "" {
+		tombstone.Sections = strings.Split(sections, ",")
+	}
+	return tombstone, nil
+}
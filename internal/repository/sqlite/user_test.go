@@ -0,0 +1,30 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"auth/internal/database"
+	"auth/internal/logger"
+	"auth/internal/repository"
+	"auth/internal/repository/repositorytest"
+	"auth/internal/repository/sqlite"
+)
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) *repository.Repository {
+		db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "auth.db"))
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		wrapped := &database.DB{DB: db, Driver: database.DriverSQLite}
+		if err := wrapped.RunMigrations(context.Background(), logger.New("error")); err != nil {
+			t.Fatalf("failed to run migrations: %v", err)
+		}
+
+		return sqlite.NewRepository(db)
+	})
+}
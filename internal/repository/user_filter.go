@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"auth/internal/models"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// was issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrInvalidSort is returned when a listing is sorted by a column that is not
+// a UserSort
+var ErrInvalidSort = errors.New("invalid sort column")
+
+// UserSort is a column users can be ordered by
+type UserSort string
+
+const (
+	SortByCreatedAt UserSort = "created_at"
+	SortByUsername  UserSort = "username"
+	SortByEmail     UserSort = "email"
+)
+
+// Valid reports whether s is a supported sort column
+func (s UserSort) Valid() bool {
+	switch s {
+	case SortByCreatedAt, SortByUsername, SortByEmail:
+		return true
+	}
+	return false
+}
+
+// UserFilter selects a page of users. Zero values disable a filter.
+type UserFilter struct {
+	// EmailDomain matches the part of the email after "@", case-insensitively
+	EmailDomain   string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Status        string
+	Role          string
+	// Search matches a case-insensitive prefix of the username or email
+	Search     string
+	Sort       UserSort
+	Descending bool
+	Cursor     string
+	Limit      int
+}
+
+// UserPage is one page of a user listing
+type UserPage struct {
+	Users []*models.User
+	// NextCursor is empty when there are no more results
+	NextCursor string
+}
+
+// PageSize returns the effective page size for the filter
+func (f *UserFilter) PageSize() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultPageSize
+	case f.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return f.Limit
+}
+
+// SortColumn returns the effective sort column for the filter
+func (f *UserFilter) SortColumn() UserSort {
+	if f.Sort == "" {
+		return SortByCreatedAt
+	}
+	return f.Sort
+}
+
+// UserCursor is the keyset position after which the next page starts
+type UserCursor struct {
+	Sort       UserSort `json:"s"`
+	Descending bool     `json:"d,omitempty"`
+	Value      string   `json:"v"`
+	ID         string   `json:"id"`
+}
+
+// NewUserCursor returns the cursor that continues after user
+func NewUserCursor(filter *UserFilter, user *models.User) UserCursor {
+	cursor := UserCursor{Sort: filter.SortColumn(), Descending: filter.Descending, ID: user.ID}
+	switch cursor.Sort {
+	case SortByUsername:
+		cursor.Value = user.Username
+	case SortByEmail:
+		cursor.Value = user.Email
+	default:
+		cursor.Value = user.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// Encode returns the opaque string handed to API clients
+func (c UserCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Time returns the cursor value of a created_at cursor
+func (c UserCursor) Time() (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, c.Value)
+	if err != nil {
+		return time.Time{}, ErrInvalidCursor
+	}
+	return t, nil
+}
+
+// DecodeCursor parses the filter's cursor and checks it matches the filter's
+// sort order. It returns nil when the filter has no cursor.
+func (f *UserFilter) DecodeCursor() (*UserCursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor UserCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != f.SortColumn() || cursor.Descending != f.Descending {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort == SortByCreatedAt {
+		if _, err := cursor.Time(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cursor, nil
+}
@@ -0,0 +1,42 @@
+// Package reqctx carries metadata about the current HTTP request through a
+// context.Context, so services can record where a call came from without
+// depending on the HTTP layer
+package reqctx
+
+import "context"
+
+// Info describes the request a call is serving
+type Info struct {
+	RequestID string
+	IP        string
+	UserAgent string
+	// DeviceID is the device cookie the client sent, empty if it sent none
+	DeviceID string
+	// Tenant is the tenant the deployment serves
+	Tenant string
+	// UserID is the authenticated user, empty until the request's token has
+	// been checked
+	UserID string
+}
+
+type infoKey struct{}
+
+// WithInfo returns a copy of ctx carrying info
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, infoKey{}, info)
+}
+
+// FromContext returns the request info stored in ctx, or the zero Info for
+// calls that don't come from an HTTP request
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(infoKey{}).(Info)
+	return info
+}
+
+// WithUserID returns a copy of ctx whose request info names userID as the
+// authenticated user
+func WithUserID(ctx context.Context, userID string) context.Context {
+	info := FromContext(ctx)
+	info.UserID = userID
+	return WithInfo(ctx, info)
+}
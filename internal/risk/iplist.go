@@ -0,0 +1,93 @@
+package risk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// IPList is a set of addresses and networks, such as Tor exit nodes or
+// hosting provider ranges. A nil *IPList contains nothing.
+type IPList struct {
+	addrs    map[netip.Addr]struct{}
+	prefixes []netip.Prefix
+}
+
+// LoadIPList reads the list file at path
+func LoadIPList(path string) (*IPList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IP list: %w", err)
+	}
+	defer f.Close()
+
+	list, err := ParseIPList(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load IP list %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// ParseIPList reads one address or CIDR network per line. Blank lines and
+// text after # are ignored, so published exit node lists load as they are.
+func ParseIPList(r io.Reader) (*IPList, error) {
+	list := &IPList{addrs: make(map[netip.Addr]struct{})}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			list.prefixes = append(list.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		list.addrs[addr.Unmap()] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Len returns the number of entries in the list
+func (l *IPList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.addrs) + len(l.prefixes)
+}
+
+// Contains reports whether ip is in the list
+func (l *IPList) Contains(ip string) bool {
+	if l == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if _, ok := l.addrs[addr]; ok {
+		return true
+	}
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
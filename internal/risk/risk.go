@@ -0,0 +1,212 @@
+// Package risk scores logins and sensitive operations against configurable
+// rules and decides whether they may go ahead, need a second factor, or must
+// be refused.
+//
+// Every rule that matches adds its score; the total is compared with the MFA
+// and deny thresholds. Rules are independent of storage: callers gather the
+// input, such as the number of recent failed logins, and the engine only
+// weighs it.
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth/internal/config"
+)
+
+// Decision is the outcome of an assessment
+type Decision string
+
+const (
+	Allow      Decision = "allow"
+	RequireMFA Decision = "mfa"
+	Deny       Decision = "deny"
+)
+
+// ErrDenied is returned when the risk policy refuses an operation
+var ErrDenied = errors.New("denied by risk policy")
+
+// Operations that are assessed. Sensitive operations are also assessed when
+// a route demands step-up authentication for them.
+const (
+	OperationLogin          = "login"
+	OperationPasswordChange = "password_change"
+	OperationAccountDelete  = "account_delete"
+	OperationMFADisable     = "mfa_disable"
+)
+
+// Reasons reported for the rules that matched
+const (
+	ReasonFailedAttempts   = "failed_attempts"
+	ReasonNewDevice        = "new_device"
+	ReasonNewCountry       = "new_country"
+	ReasonImpossibleTravel = "impossible_travel"
+	ReasonTorExit          = "tor_exit"
+	ReasonDatacenter       = "datacenter_ip"
+	ReasonOffHours         = "off_hours"
+)
+
+// Rules are the scores the engine adds for each risk factor. A zero score
+// disables its rule, and a zero threshold is never reached.
+type Rules struct {
+	MFAThreshold  int
+	DenyThreshold int
+
+	// VelocityThreshold is the number of recent failed logins at which
+	// VelocityScore is added
+	VelocityThreshold int
+	VelocityScore     int
+
+	NewDeviceScore        int
+	NewCountryScore       int
+	ImpossibleTravelScore int
+
+	TorExits        *IPList
+	TorExitScore    int
+	Datacenters     *IPList
+	DatacenterScore int
+
+	// ActiveFrom and ActiveTo are the hours of the day, in Location, between
+	// which activity is expected. The range may wrap past midnight; equal
+	// hours disable the rule.
+	ActiveFrom    int
+	ActiveTo      int
+	Location      *time.Location
+	OffHoursScore int
+}
+
+// Input describes the login or operation being assessed
+type Input struct {
+	Operation string
+	IP        string
+	Time      time.Time
+	// FailedAttempts is the number of failed logins to the account in the
+	// velocity window
+	FailedAttempts int
+	// NewDevice, NewCountry and ImpossibleTravel are login signals; they are
+	// unset for other operations
+	NewDevice        bool
+	NewCountry       bool
+	ImpossibleTravel bool
+}
+
+// Assessment is the score of an input and the decision it leads to
+type Assessment struct {
+	Score    int      `json:"score"`
+	Decision Decision `json:"decision"`
+	// Reasons names the rules that matched
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Engine assesses inputs against a set of rules. A nil *Engine allows
+// everything.
+type Engine struct {
+	rules Rules
+}
+
+func New(rules Rules) *Engine {
+	if rules.Location == nil {
+		rules.Location = time.UTC
+	}
+	return &Engine{rules: rules}
+}
+
+// Assess scores in and decides what happens to it
+func (e *Engine) Assess(in Input) Assessment {
+	a := Assessment{Decision: Allow}
+	if e == nil {
+		return a
+	}
+	r := e.rules
+	add := func(matched bool, score int, reason string) {
+		if matched && score > 0 {
+			a.Score += score
+			a.Reasons = append(a.Reasons, reason)
+		}
+	}
+
+	add(r.VelocityThreshold > 0 && in.FailedAttempts >= r.VelocityThreshold, r.VelocityScore, ReasonFailedAttempts)
+	add(in.NewDevice, r.NewDeviceScore, ReasonNewDevice)
+	add(in.NewCountry, r.NewCountryScore, ReasonNewCountry)
+	add(in.ImpossibleTravel, r.ImpossibleTravelScore, ReasonImpossibleTravel)
+	add(r.TorExits.Contains(in.IP), r.TorExitScore, ReasonTorExit)
+	add(r.Datacenters.Contains(in.IP), r.DatacenterScore, ReasonDatacenter)
+	add(r.offHours(in.Time), r.OffHoursScore, ReasonOffHours)
+
+	switch {
+	case r.DenyThreshold > 0 && a.Score >= r.DenyThreshold:
+		a.Decision = Deny
+	case r.MFAThreshold > 0 && a.Score >= r.MFAThreshold:
+		a.Decision = RequireMFA
+	}
+	return a
+}
+
+// offHours reports whether t falls outside the active hours
+func (r *Rules) offHours(t time.Time) bool {
+	if r.ActiveFrom == r.ActiveTo || t.IsZero() {
+		return false
+	}
+	hour := t.In(r.Location).Hour()
+	if r.ActiveFrom < r.ActiveTo {
+		return hour < r.ActiveFrom || hour >= r.ActiveTo
+	}
+	return hour >= r.ActiveTo && hour < r.ActiveFrom
+}
+
+// LoadRules builds the rules described by cfg, loading the IP lists it names
+func LoadRules(cfg config.RiskConfig) (Rules, error) {
+	rules := Rules{
+		MFAThreshold:          cfg.MFAThreshold,
+		DenyThreshold:         cfg.DenyThreshold,
+		VelocityThreshold:     cfg.VelocityThreshold,
+		VelocityScore:         cfg.VelocityScore,
+		NewDeviceScore:        cfg.NewDeviceScore,
+		NewCountryScore:       cfg.NewCountryScore,
+		ImpossibleTravelScore: cfg.ImpossibleTravelScore,
+		TorExitScore:          cfg.TorExitScore,
+		DatacenterScore:       cfg.DatacenterScore,
+		OffHoursScore:         cfg.OffHoursScore,
+	}
+
+	var err error
+	if cfg.TorExitList != "" {
+		if rules.TorExits, err = LoadIPList(cfg.TorExitList); err != nil {
+			return rules, err
+		}
+	}
+	if cfg.DatacenterList != "" {
+		if rules.Datacenters, err = LoadIPList(cfg.DatacenterList); err != nil {
+			return rules, err
+		}
+	}
+
+	if cfg.ActiveHours != "" {
+		if rules.ActiveFrom, rules.ActiveTo, err = parseHours(cfg.ActiveHours); err != nil {
+			return rules, err
+		}
+	}
+	if rules.Location, err = time.LoadLocation(cfg.Timezone); err != nil {
+		return rules, fmt.Errorf("invalid risk timezone %q: %w", cfg.Timezone, err)
+	}
+	return rules, nil
+}
+
+// parseHours parses an hour range such as "07-22"
+func parseHours(value string) (from, to int, err error) {
+	start, end, ok := strings.Cut(value, "-")
+	if ok {
+		from, err = strconv.Atoi(strings.TrimSpace(start))
+	}
+	if ok && err == nil {
+		to, err = strconv.Atoi(strings.TrimSpace(end))
+	}
+	if !ok || err != nil || from < 0 || from > 23 || to < 0 || to > 24 {
+		return 0, 0, fmt.Errorf("invalid active hours %q, expected a range such as 07-22", value)
+	}
+	return from, to % 24, nil
+}
@@ -0,0 +1,152 @@
+package risk_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/risk"
+)
+
+const testList = `# Exit nodes
+192.0.2.10
+2001:db8::1 # comment
+198.51.100.0/24
+`
+
+func TestIPList(t *testing.T) {
+	list, err := risk.ParseIPList(strings.NewReader(testList))
+	if err != nil {
+		t.Fatalf("ParseIPList() unexpected error: %v", err)
+	}
+	if list.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", list.Len())
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.0.2.10", true},
+		{"::ffff:192.0.2.10", true},
+		{"192.0.2.11", false},
+		{"198.51.100.77", true},
+		{"198.51.101.1", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := list.Contains(tt.ip); got != tt.want {
+			t.Errorf("Contains(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	var empty *risk.IPList
+	if empty.Contains("192.0.2.10") || empty.Len() != 0 {
+		t.Error("nil IPList is not empty")
+	}
+	if _, err := risk.ParseIPList(strings.NewReader("192.0.2.300\n")); err == nil {
+		t.Error("ParseIPList() accepted an invalid address")
+	}
+}
+
+func TestAssess(t *testing.T) {
+	tor, _ := risk.ParseIPList(strings.NewReader("192.0.2.10\n"))
+	engine := risk.New(risk.Rules{
+		MFAThreshold:          30,
+		DenyThreshold:         80,
+		VelocityThreshold:     3,
+		VelocityScore:         40,
+		NewDeviceScore:        20,
+		NewCountryScore:       30,
+		ImpossibleTravelScore: 60,
+		TorExits:              tor,
+		TorExitScore:          50,
+		ActiveFrom:            7,
+		ActiveTo:              22,
+		OffHoursScore:         15,
+	})
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		in       risk.Input
+		score    int
+		decision risk.Decision
+		reasons  []string
+	}{
+		{"known device", risk.Input{IP: "203.0.113.1", Time: noon}, 0, risk.Allow, nil},
+		{"new device", risk.Input{IP: "203.0.113.1", Time: noon, NewDevice: true}, 20, risk.Allow,
+			[]string{risk.ReasonNewDevice}},
+		{"new device at night", risk.Input{IP: "203.0.113.1", Time: night, NewDevice: true}, 35, risk.RequireMFA,
+			[]string{risk.ReasonNewDevice, risk.ReasonOffHours}},
+		{"below velocity threshold", risk.Input{IP: "203.0.113.1", Time: noon, FailedAttempts: 2}, 0, risk.Allow, nil},
+		{"failed attempts", risk.Input{IP: "203.0.113.1", Time: noon, FailedAttempts: 3}, 40, risk.RequireMFA,
+			[]string{risk.ReasonFailedAttempts}},
+		{"tor exit", risk.Input{IP: "192.0.2.10", Time: noon}, 50, risk.RequireMFA,
+			[]string{risk.ReasonTorExit}},
+		{"impossible travel over tor", risk.Input{IP: "192.0.2.10", Time: noon, ImpossibleTravel: true}, 110, risk.Deny,
+			[]string{risk.ReasonImpossibleTravel, risk.ReasonTorExit}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := engine.Assess(tt.in)
+			if got.Score != tt.score || got.Decision != tt.decision || !slices.Equal(got.Reasons, tt.reasons) {
+				t.Errorf("Assess() = %+v, want score %d, decision %s, reasons %v", got, tt.score, tt.decision, tt.reasons)
+			}
+		})
+	}
+
+	var disabled *risk.Engine
+	if got := disabled.Assess(risk.Input{ImpossibleTravel: true}); got.Decision != risk.Allow {
+		t.Errorf("nil Engine decided %s, want allow", got.Decision)
+	}
+}
+
+func TestOffHoursWrap(t *testing.T) {
+	// Active from 22:00 to 06:00, such as for night shift staff
+	engine := risk.New(risk.Rules{ActiveFrom: 22, ActiveTo: 6, OffHoursScore: 10, MFAThreshold: 10})
+	for hour, want := range map[int]risk.Decision{23: risk.Allow, 2: risk.Allow, 6: risk.RequireMFA, 12: risk.RequireMFA} {
+		in := risk.Input{Time: time.Date(2024, 5, 1, hour, 0, 0, 0, time.UTC)}
+		if got := engine.Assess(in).Decision; got != want {
+			t.Errorf("Assess() at %02d:00 = %s, want %s", hour, got, want)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tor.txt")
+	if err := os.WriteFile(path, []byte(testList), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := risk.LoadRules(config.RiskConfig{
+		MFAThreshold: 30,
+		TorExitList:  path,
+		ActiveHours:  "08-18",
+		Timezone:     "Europe/Berlin",
+	})
+	if err != nil {
+		t.Fatalf("LoadRules() unexpected error: %v", err)
+	}
+	if rules.TorExits.Len() != 3 || rules.ActiveFrom != 8 || rules.ActiveTo != 18 || rules.Location.String() != "Europe/Berlin" {
+		t.Errorf("LoadRules() = %+v", rules)
+	}
+
+	for _, cfg := range []config.RiskConfig{
+		{ActiveHours: "8", Timezone: "UTC"},
+		{ActiveHours: "25-03", Timezone: "UTC"},
+		{Timezone: "Nowhere/City"},
+		{TorExitList: filepath.Join(t.TempDir(), "missing.txt"), Timezone: "UTC"},
+	} {
+		if _, err := risk.LoadRules(cfg); err == nil {
+			t.Errorf("LoadRules(%+v) unexpected success", cfg)
+		}
+	}
+}
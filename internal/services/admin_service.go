@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+var (
+	ErrSelfAction  = errors.New("cannot perform this action on your own account")
+	ErrInvalidRole = errors.New("invalid role")
+	ErrInvalidPlan = errors.New("invalid plan")
+	// ErrAccountDeleted is returned when changing an account that has been
+	// deleted, or restoring one whose data has already been purged
+	ErrAccountDeleted = errors.New("account is deleted")
+)
+
+// AdminService implements user management for administrators. Permission
+// checks happen in middleware; every call is recorded in the audit log.
+type AdminService struct {
+	repo    *repository.Repository
+	privacy *PrivacyService
+	audit   *AuditService
+	config  *config.Config
+	logger  *logger.Logger
+}
+
+// ListUsersResponse is one page of the admin user listing
+type ListUsersResponse struct {
+	Users      []*models.UserResponse `json:"users"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+func NewAdminService(repo *repository.Repository, privacy *PrivacyService, audit *AuditService, cfg *config.Config, logger *logger.Logger) *AdminService {
+	return &AdminService{
+		repo:    repo,
+		privacy: privacy,
+		audit:   audit,
+		config:  cfg,
+		logger:  logger,
+	}
+}
+
+// ListUsers returns a page of users matching filter
+func (s *AdminService) ListUsers(ctx context.Context, actorID string, filter repository.UserFilter) (*ListUsersResponse, error) {
+	page, err := s.repo.User.List(ctx, filter)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrInvalidCursor):
+			err = models.ValidationErrors{"cursor": "invalid cursor"}
+		case errors.Is(err, repository.ErrInvalidSort):
+			err = models.ValidationErrors{"sort": "must be one of created_at, username, email"}
+		default:
+			logger.FromContext(ctx).Error("failed to list users", "error", err)
+			err = ErrInternal
+		}
+	}
+	s.audit.Record(ctx, models.AuditEvent{ActorID: actorID, Action: models.AuditActionUserList}, err)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ListUsersResponse{
+		Users:      make([]*models.UserResponse, 0, len(page.Users)),
+		NextCursor: page.NextCursor,
+	}
+	for _, user := range page.Users {
+		response.Users = append(response.Users, user.ToResponse())
+	}
+	return response, nil
+}
+
+// GetUser returns any user's account details
+func (s *AdminService) GetUser(ctx context.Context, actorID, userID string) (*models.UserResponse, error) {
+	user, err := s.repo.User.GetByID(ctx, userID)
+	if err != nil {
+		err = s.mapError(ctx, err, "failed to get user")
+	}
+	s.audit.Record(ctx, models.AuditEvent{ActorID: actorID, Action: models.AuditActionUserGet, TargetID: userID}, err)
+	if err != nil {
+		return nil, err
+	}
+	return user.ToResponse(), nil
+}
+
+// DisableUser prevents the user from logging in
+func (s *AdminService) DisableUser(ctx context.Context, actorID, userID string) (*models.UserResponse, error) {
+	user, err := s.updateUser(ctx, actorID, userID, models.AuditActionUserDisable, nil, func(_ *repository.Repository, user *models.User) error {
+		if userID == actorID {
+			return ErrSelfAction
+		}
+		if user.Status == models.StatusDeleted {
+			return ErrAccountDeleted
+		}
+		user.Status = models.StatusDisabled
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user.ToResponse(), nil
+}
+
+// EnableUser re-activates a disabled or locked user. It also restores
+// accounts that are pending deletion or deleted, as long as they have not
+// been purged.
+func (s *AdminService) EnableUser(ctx context.Context, actorID, userID string) (*models.UserResponse, error) {
+	user, err := s.updateUser(ctx, actorID, userID, models.AuditActionUserEnable, nil, func(_ *repository.Repository, user *models.User) error {
+		if user.PurgedAt != nil {
+			return ErrAccountDeleted
+		}
+		user.Status = models.StatusActive
+		user.DeletionScheduledAt = nil
+		user.DeletedAt = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user.ToResponse(), nil
+}
+
+// ForcePasswordReset requires the user to change their password before the
+// API can be used again. The user's sessions are revoked, so tokens issued
+// before the reset stop working and the next login gets a token that only
+// allows changing the password.
+func (s *AdminService) ForcePasswordReset(ctx context.Context, actorID, userID string) (*models.UserResponse, error) {
+	user, err := s.updateUser(ctx, actorID, userID, models.AuditActionUserPasswordReset, nil, func(tx *repository.Repository, user *models.User) error {
+		if user.Status == models.StatusDeleted {
+			return ErrAccountDeleted
+		}
+		user.PasswordResetRequired = true
+		_, err := tx.Session.RevokeByUser(ctx, user.ID, "", time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user.ToResponse(), nil
+}
+
+// SetUserRole assigns a role to the user
+func (s *AdminService) SetUserRole(ctx context.Context, actorID, userID, role string) (*models.UserResponse, error) {
+	details := map[string]string{"role": role}
+	user, err := s.updateUser(ctx, actorID, userID, models.AuditActionUserRoleChange, details, func(_ *repository.Repository, user *models.User) error {
+		details["previous_role"] = user.Role
+		if !auth.ValidRole(role) {
+			return ErrInvalidRole
+		}
+		if user.Status == models.StatusDeleted {
+			return ErrAccountDeleted
+		}
+		// Admins can't demote themselves, so there is always one admin left
+		if userID == actorID {
+			return ErrSelfAction
+		}
+		user.Role = role
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user.ToResponse(), nil
+}
+
+// SetUserPlan moves the user to one of the configured rate limit plans. The
+// plan applies to tokens issued from the user's next login.
+func (s *AdminService) SetUserPlan(ctx context.Context, actorID, userID, plan string) (*models.UserResponse, error) {
+	details := map[string]string{"plan": plan}
+	user, err := s.updateUser(ctx, actorID, userID, models.AuditActionUserPlanChange, details, func(_ *repository.Repository, user *models.User) error {
+		details["previous_plan"] = user.Plan
+		if _, ok := s.config.RateLimit.Plans[plan]; !ok {
+			return ErrInvalidPlan
+		}
+		if user.Status == models.StatusDeleted {
+			return ErrAccountDeleted
+		}
+		user.Plan = plan
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user.ToResponse(), nil
+}
+
+// DeleteUser deletes the user's account immediately, skipping the grace
+// period. Its data is kept until the purge job's retention window has passed.
+func (s *AdminService) DeleteUser(ctx context.Context, actorID, userID string) error {
+	_, err := s.updateUser(ctx, actorID, userID, models.AuditActionUserDelete, nil, func(_ *repository.Repository, user *models.User) error {
+		if userID == actorID {
+			return ErrSelfAction
+		}
+		if user.Status == models.StatusDeleted {
+			return nil
+		}
+		now := time.Now()
+		user.Status = models.StatusDeleted
+		user.DeletedAt = &now
+		user.DeletionScheduledAt = nil
+		return nil
+	})
+	return err
+}
+
+// EraseUser immediately erases the user's personal data, for example to
+// fulfil a verified erasure request, and returns the tombstone
+func (s *AdminService) EraseUser(ctx context.Context, actorID, userID string, req *models.EraseUserRequest) (*models.ErasureTombstone, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	event := models.AuditEvent{
+		ActorID:  actorID,
+		Action:   models.AuditActionUserErase,
+		TargetID: userID,
+		Details:  map[string]string{"reason": req.Reason},
+	}
+	if userID == actorID {
+		s.audit.Record(ctx, event, ErrSelfAction)
+		return nil, ErrSelfAction
+	}
+
+	tombstone, err := s.privacy.Erase(ctx, userID, actorID, req.Reason)
+	s.audit.Record(ctx, event, err)
+	return tombstone, err
+}
+
+// GetTombstone returns the proof that a user's data was erased
+func (s *AdminService) GetTombstone(ctx context.Context, actorID, userID string) (*models.ErasureTombstone, error) {
+	tombstone, err := s.privacy.GetTombstone(ctx, userID)
+	s.audit.Record(ctx, models.AuditEvent{ActorID: actorID, Action: models.AuditActionUserTombstone, TargetID: userID}, err)
+	return tombstone, err
+}
+
+// updateUser applies change to the user inside a transaction and records
+// the outcome as action. change may add to details before it returns, and
+// may make further writes through tx.
+func (s *AdminService) updateUser(ctx context.Context, actorID, userID, action string, details map[string]string, change func(tx *repository.Repository, user *models.User) error) (*models.User, error) {
+	event := models.AuditEvent{ActorID: actorID, Action: action, TargetID: userID, Details: details}
+	var updated *models.User
+	err := s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		user, err := tx.User.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := change(tx, user); err != nil {
+			return err
+		}
+		if err := tx.User.Update(ctx, user); err != nil {
+			return err
+		}
+		updated = user
+		// The change and its audit event are committed together
+		return s.audit.RecordTx(ctx, tx, event, nil)
+	})
+	if err != nil {
+		err = s.mapError(ctx, err, "failed to update user")
+		s.audit.Record(ctx, event, err)
+		return nil, err
+	}
+	countAuthentication(event.Action, nil)
+	return updated, nil
+}
+
+func (s *AdminService) mapError(ctx context.Context, err error, msg string) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return ErrUserNotFound
+	case errors.Is(err, ErrSelfAction), errors.Is(err, ErrInvalidRole), errors.Is(err, ErrInvalidPlan),
+		errors.Is(err, ErrAccountDeleted):
+		return err
+	}
+	logger.FromContext(ctx).Error(msg, "error", err)
+	return ErrInternal
+}
@@ -0,0 +1,212 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/middleware"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"auth/internal/services"
+)
+
+func setupAdminService(t *testing.T) (*services.AdminService, *services.AuthService, *services.SessionService, *models.UserResponse) {
+	t.Helper()
+	cfg := &config.Config{
+		JWT: config.JWTConfig{
+			Secret:     "test-secret",
+			Expiration: time.Hour,
+		},
+		RateLimit: config.RateLimitConfig{
+			Plans: map[string]string{"free": "", "enterprise": ""},
+		},
+	}
+	log := logger.New("error")
+	repo := memory.NewRepository()
+
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, nil, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, services.NewRiskService(repo, auditService, nil, cfg, log), notify.NewLog(log), cfg, log)
+	user, err := authService.SignUp(context.Background(), &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	privacyService := services.NewPrivacyService(repo, cfg, log)
+	return services.NewAdminService(repo, privacyService, auditService, cfg, log), authService, sessionService, user
+}
+
+func TestAdminService_DisableUser(t *testing.T) {
+	adminService, authService, _, user := setupAdminService(t)
+	ctx := context.Background()
+	login := &models.LoginRequest{Username: "testuser", Password: "password123"}
+
+	if _, err := adminService.DisableUser(ctx, "admin-id", user.ID); err != nil {
+		t.Fatalf("DisableUser() unexpected error: %v", err)
+	}
+	if _, err := authService.Login(ctx, login); !errors.Is(err, services.ErrAccountDisabled) {
+		t.Errorf("Login() error = %v, want ErrAccountDisabled", err)
+	}
+
+	if _, err := adminService.EnableUser(ctx, "admin-id", user.ID); err != nil {
+		t.Fatalf("EnableUser() unexpected error: %v", err)
+	}
+	if _, err := authService.Login(ctx, login); err != nil {
+		t.Errorf("Login() unexpected error after enable: %v", err)
+	}
+}
+
+func TestAdminService_ForcePasswordReset(t *testing.T) {
+	adminService, authService, sessionService, user := setupAdminService(t)
+	ctx := context.Background()
+	login := &models.LoginRequest{Username: "testuser", Password: "password123"}
+
+	// status returns the status of a profile request with token
+	mw := middleware.New(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}}, logger.New("error"), nil, sessionService, nil, nil, nil, nil)
+	handler := mw.JWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, middleware.ProfilePath, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	before, err := authService.Login(ctx, login)
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	if code := status(before.Token); code != http.StatusOK {
+		t.Fatalf("status before the reset = %d, want %d", code, http.StatusOK)
+	}
+
+	if _, err := adminService.ForcePasswordReset(ctx, "admin-id", user.ID); err != nil {
+		t.Fatalf("ForcePasswordReset() unexpected error: %v", err)
+	}
+
+	// Tokens issued before the reset don't carry the flag, so their sessions
+	// are revoked
+	if code := status(before.Token); code != http.StatusUnauthorized {
+		t.Errorf("status of a token issued before the reset = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	response, err := authService.Login(ctx, login)
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	if !response.User.PasswordResetRequired {
+		t.Errorf("Login() PasswordResetRequired = false, want true")
+	}
+	if code := status(response.Token); code != http.StatusForbidden {
+		t.Errorf("status of a token issued after the reset = %d, want %d", code, http.StatusForbidden)
+	}
+
+	err = authService.ChangePassword(ctx, user.ID, &models.ChangePasswordRequest{
+		CurrentPassword: "password123",
+		NewPassword:     "newpassword123",
+	})
+	if err != nil {
+		t.Fatalf("ChangePassword() unexpected error: %v", err)
+	}
+
+	profile, err := authService.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID() unexpected error: %v", err)
+	}
+	if profile.PasswordResetRequired {
+		t.Errorf("PasswordResetRequired still set after password change")
+	}
+}
+
+func TestAdminService_SetUserPlan(t *testing.T) {
+	adminService, authService, _, user := setupAdminService(t)
+	ctx := context.Background()
+
+	if user.Plan != models.PlanFree {
+		t.Errorf("new user plan = %q, want %q", user.Plan, models.PlanFree)
+	}
+	updated, err := adminService.SetUserPlan(ctx, "admin-id", user.ID, "enterprise")
+	if err != nil {
+		t.Fatalf("SetUserPlan() unexpected error: %v", err)
+	}
+	if updated.Plan != "enterprise" {
+		t.Errorf("SetUserPlan() plan = %q, want enterprise", updated.Plan)
+	}
+
+	// Tokens from the next login carry the plan
+	response, err := authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	claims, err := auth.ValidateJWT(response.Token, "test-secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT() unexpected error: %v", err)
+	}
+	if claims.Plan != "enterprise" {
+		t.Errorf("token plan = %q, want enterprise", claims.Plan)
+	}
+}
+
+func TestAdminService_Errors(t *testing.T) {
+	adminService, _, _, user := setupAdminService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{
+			name: "disable self",
+			call: func() error { _, err := adminService.DisableUser(ctx, user.ID, user.ID); return err },
+			want: services.ErrSelfAction,
+		},
+		{
+			name: "delete self",
+			call: func() error { return adminService.DeleteUser(ctx, user.ID, user.ID) },
+			want: services.ErrSelfAction,
+		},
+		{
+			name: "invalid role",
+			call: func() error { _, err := adminService.SetUserRole(ctx, "admin-id", user.ID, "root"); return err },
+			want: services.ErrInvalidRole,
+		},
+		{
+			name: "unknown plan",
+			call: func() error { _, err := adminService.SetUserPlan(ctx, "admin-id", user.ID, "platinum"); return err },
+			want: services.ErrInvalidPlan,
+		},
+		{
+			name: "unknown user",
+			call: func() error { _, err := adminService.GetUser(ctx, "admin-id", "missing"); return err },
+			want: services.ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	_, err := adminService.ListUsers(ctx, "admin-id", repository.UserFilter{Cursor: "garbage"})
+	var validationErr models.ValidationErrors
+	if !errors.As(err, &validationErr) {
+		t.Errorf("ListUsers() with bad cursor error = %v, want ValidationErrors", err)
+	}
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/observability/metrics"
+	"auth/internal/repository"
+	"auth/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+const (
+	AuditFormatJSON = "json"
+	AuditFormatCSV  = "csv"
+)
+
+// auditCSVHeader lists the columns of a CSV audit export
+var auditCSVHeader = []string{
+	"id", "occurred_at", "tenant", "actor_id", "action", "target_id", "outcome",
+	"reason", "ip", "user_agent", "request_id", "details", "seq", "prev_hash", "hash",
+}
+
+// AuditService writes security events to the append-only audit log and lets
+// administrators query and export it
+type AuditService struct {
+	repo   *repository.Repository
+	config config.AuditConfig
+	logger *logger.Logger
+}
+
+// ListAuditResponse is one page of the audit log
+type ListAuditResponse struct {
+	Events     []*models.AuditEvent `json:"events"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+func NewAuditService(repo *repository.Repository, cfg *config.Config, logger *logger.Logger) *AuditService {
+	return &AuditService{
+		repo:   repo,
+		config: cfg.Audit,
+		logger: logger,
+	}
+}
+
+// Record appends event to the audit log. err is the outcome of the audited
+// action: nil for success, or the error returned to the caller. The ID, time,
+// tenant and request metadata are filled in here. A failed write is logged
+// rather than returned, so an unavailable audit store never blocks the
+// action being audited.
+func (s *AuditService) Record(ctx context.Context, event models.AuditEvent, err error) {
+	s.fill(ctx, &event, err)
+	countAuthentication(event.Action, err)
+	// The event is written even if the request that caused it was cancelled
+	if appendErr := s.repo.Audit.Append(context.WithoutCancel(ctx), &event); appendErr != nil {
+		logger.FromContext(ctx).Error("failed to write audit event",
+			"error", appendErr,
+			"action", event.Action,
+			"actor_id", event.ActorID,
+			"target_id", event.TargetID,
+			"outcome", event.Outcome,
+		)
+	}
+}
+
+// RecordTx appends event to the audit log inside tx, so it is committed
+// with the action it records or not at all. Unlike Record it returns a
+// failed write, which must roll the transaction back. Transactions can be
+// retried, so the caller counts an authentication attempt with
+// countAuthentication once the transaction has committed.
+func (s *AuditService) RecordTx(ctx context.Context, tx *repository.Repository, event models.AuditEvent, err error) error {
+	s.fill(ctx, &event, err)
+	if appendErr := tx.Audit.Append(ctx, &event); appendErr != nil {
+		return fmt.Errorf("failed to write audit event: %w", appendErr)
+	}
+	return nil
+}
+
+// fill sets the fields of event Record and RecordTx fill in
+func (s *AuditService) fill(ctx context.Context, event *models.AuditEvent, err error) {
+	info := reqctx.FromContext(ctx)
+	event.ID = uuid.New().String()
+	event.OccurredAt = time.Now().UTC()
+	event.Tenant = s.tenant(ctx)
+	event.IP = info.IP
+	event.UserAgent = info.UserAgent
+	event.RequestID = info.RequestID
+	event.Outcome = models.AuditOutcomeSuccess
+	if err != nil {
+		event.Outcome = models.AuditOutcomeFailure
+		event.Reason = auditReason(err)
+	}
+}
+
+// countAuthentication counts an authentication attempt in the metrics if
+// action records one
+func countAuthentication(action string, err error) {
+	if authType, ok := authenticationTypes[action]; ok {
+		metrics.RecordAuthenticationAttempt(authType, authenticationResult(action, err))
+	}
+}
+
+// tenant returns the tenant of the request, or the default tenant for work
+// done outside one
+func (s *AuditService) tenant(ctx context.Context) string {
+	if tenant := reqctx.FromContext(ctx).Tenant; tenant != "" {
+		return tenant
+	}
+	return s.config.Tenant
+}
+
+// Query returns a page of the request tenant's audit events matching filter
+func (s *AuditService) Query(ctx context.Context, actorID string, filter repository.AuditFilter) (*ListAuditResponse, error) {
+	filter.Tenant = s.tenant(ctx)
+	page, err := s.repo.Audit.List(ctx, filter)
+	if err != nil {
+		err = s.mapError(ctx, err)
+	}
+	s.Record(ctx, models.AuditEvent{ActorID: actorID, Action: models.AuditActionAuditQuery}, err)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ListAuditResponse{Events: page.Events, NextCursor: page.NextCursor}
+	if response.Events == nil {
+		response.Events = []*models.AuditEvent{}
+	}
+	return response, nil
+}
+
+// Export writes every audit event of the request tenant matching filter to w as CSV
+// or a JSON array, newest first. The filter's limit and cursor are ignored.
+func (s *AuditService) Export(ctx context.Context, actorID string, filter repository.AuditFilter, format string, w io.Writer) error {
+	if format != AuditFormatJSON && format != AuditFormatCSV {
+		return models.ValidationErrors{"format": "format must be csv or json"}
+	}
+	filter.Tenant = s.tenant(ctx)
+
+	var err error
+	if format == AuditFormatCSV {
+		err = s.exportCSV(ctx, filter, w)
+	} else {
+		err = s.exportJSON(ctx, filter, w)
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to export audit log", "error", err)
+		err = ErrInternal
+	}
+	s.Record(ctx, models.AuditEvent{
+		ActorID: actorID,
+		Action:  models.AuditActionAuditExport,
+		Details: map[string]string{"format": format},
+	}, err)
+	return err
+}
+
+func (s *AuditService) exportCSV(ctx context.Context, filter repository.AuditFilter, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(auditCSVHeader); err != nil {
+		return err
+	}
+	err := s.each(ctx, filter, func(event *models.AuditEvent) error {
+		var details string
+		if len(event.Details) > 0 {
+			data, err := json.Marshal(event.Details)
+			if err != nil {
+				return err
+			}
+			details = string(data)
+		}
+		record := []string{
+			event.ID, event.OccurredAt.UTC().Format(time.RFC3339Nano), event.Tenant, event.ActorID,
+			event.Action, event.TargetID, event.Outcome, event.Reason, event.IP, event.UserAgent,
+			event.RequestID, details, strconv.FormatInt(event.Seq, 10), event.PrevHash, event.Hash,
+		}
+		for i := range record {
+			record[i] = csvSafe(record[i])
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (s *AuditService) exportJSON(ctx context.Context, filter repository.AuditFilter, w io.Writer) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	first := true
+	err := s.each(ctx, filter, func(event *models.AuditEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// each calls fn for every event matching filter, fetching them a page at a
+// time
+func (s *AuditService) each(ctx context.Context, filter repository.AuditFilter, fn func(event *models.AuditEvent) error) error {
+	filter.Limit = repository.MaxPageSize
+	filter.Cursor = ""
+	for {
+		page, err := s.repo.Audit.List(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, event := range page.Events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+func (s *AuditService) mapError(ctx context.Context, err error) error {
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return models.ValidationErrors{"cursor": "invalid cursor"}
+	}
+	logger.FromContext(ctx).Error("failed to query audit log", "error", err)
+	return ErrInternal
+}
+
+// authenticationTypes maps the audit actions that are authentication
+// attempts to the type they are counted as. Every outcome of a login or
+// signup is audited, so counting them here keeps the two in step. A login
+// asked for a second factor is counted as mfa_required, then again when the
+// factor is presented.
+var authenticationTypes = map[string]string{
+	models.AuditActionSignup:       "signup",
+	models.AuditActionLogin:        "login",
+	models.AuditActionMFAChallenge: "login",
+}
+
+// authenticationResult is the result label of an authentication attempt
+func authenticationResult(action string, err error) string {
+	switch {
+	case err == nil && action == models.AuditActionMFAChallenge:
+		return "mfa_required"
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidMFACode),
+		errors.Is(err, ErrInvalidMFAToken), errors.Is(err, ErrInvalidLoginChallenge):
+		return "invalid_credentials"
+	case errors.Is(err, ErrUserExists):
+		return "user_exists"
+	case errors.Is(err, ErrAccountDisabled):
+		return "account_disabled"
+	case errors.Is(err, ErrAccountLocked):
+		return "account_locked"
+	case errors.Is(err, ErrLoginDenied):
+		return "risk_denied"
+	}
+	return "error"
+}
+
+// auditReason describes a failed action. Callers pass the error they return,
+// which is already a service error safe to show to administrators.
+func auditReason(err error) string {
+	var validationErr models.ValidationErrors
+	if errors.As(err, &validationErr) {
+		return "validation failed"
+	}
+	return err.Error()
+}
+
+// csvSafe stops spreadsheet applications from evaluating a cell as a
+// formula. User agents and usernames are attacker controlled, so every cell
+// starting with a formula character is prefixed with a quote.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,221 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"auth/internal/reqctx"
+	"auth/internal/services"
+)
+
+func setupAuditService(t *testing.T) (*services.AuditService, *services.AuthService, *services.AdminService) {
+	t.Helper()
+	cfg := &config.Config{
+		JWT:   config.JWTConfig{Secret: "test-secret", Expiration: time.Hour},
+		Audit: config.AuditConfig{Tenant: "acme"},
+	}
+	log := logger.New("error")
+	repo := memory.NewRepository()
+
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, nil, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, services.NewRiskService(repo, auditService, nil, cfg, log), notify.NewLog(log), cfg, log)
+	adminService := services.NewAdminService(repo, services.NewPrivacyService(repo, cfg, log), auditService, cfg, log)
+	return auditService, authService, adminService
+}
+
+func TestAuditService_AuthEvents(t *testing.T) {
+	auditService, authService, _ := setupAuditService(t)
+	ctx := reqctx.WithInfo(context.Background(), reqctx.Info{
+		RequestID: "req-1",
+		IP:        "192.0.2.10",
+		UserAgent: "test-agent",
+	})
+
+	user, err := authService.SignUp(ctx, &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("SignUp() unexpected error: %v", err)
+	}
+	authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "wrong-password"})
+	authService.Login(ctx, &models.LoginRequest{Username: "nobody", Password: "password123"})
+	login, err := authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	claims, err := auth.ValidateJWT(login.Token, "test-secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT() unexpected error: %v", err)
+	}
+	err = authService.ChangePassword(ctx, user.ID, &models.ChangePasswordRequest{
+		CurrentPassword: "password123",
+		NewPassword:     "newpassword123",
+	})
+	if err != nil {
+		t.Fatalf("ChangePassword() unexpected error: %v", err)
+	}
+	if err := authService.Logout(ctx, user.ID, claims.SessionID); err != nil {
+		t.Fatalf("Logout() unexpected error: %v", err)
+	}
+
+	response, err := auditService.Query(ctx, "admin-id", repository.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Query() unexpected error: %v", err)
+	}
+
+	// Newest first
+	want := []struct {
+		action, actorID, outcome string
+	}{
+		{models.AuditActionLogout, user.ID, models.AuditOutcomeSuccess},
+		{models.AuditActionPasswordChange, user.ID, models.AuditOutcomeSuccess},
+		{models.AuditActionLogin, user.ID, models.AuditOutcomeSuccess},
+		{models.AuditActionLogin, "", models.AuditOutcomeFailure},
+		{models.AuditActionLogin, user.ID, models.AuditOutcomeFailure},
+		{models.AuditActionSignup, user.ID, models.AuditOutcomeSuccess},
+	}
+	if len(response.Events) != len(want) {
+		t.Fatalf("Query() returned %d events, want %d", len(response.Events), len(want))
+	}
+	for i, w := range want {
+		event := response.Events[i]
+		if event.Action != w.action || event.ActorID != w.actorID || event.Outcome != w.outcome {
+			t.Errorf("event %d = %s by %q (%s), want %s by %q (%s)",
+				i, event.Action, event.ActorID, event.Outcome, w.action, w.actorID, w.outcome)
+		}
+		if event.Tenant != "acme" || event.IP != "192.0.2.10" || event.UserAgent != "test-agent" || event.RequestID != "req-1" {
+			t.Errorf("event %d metadata = %+v", i, event)
+		}
+	}
+	if failed := response.Events[4]; failed.Reason != services.ErrInvalidCredentials.Error() || failed.Details["username"] != "testuser" {
+		t.Errorf("failed login event = %+v", failed)
+	}
+
+	// The query itself was audited
+	response, err = auditService.Query(ctx, "admin-id", repository.AuditFilter{ActorID: "admin-id"})
+	if err != nil {
+		t.Fatalf("Query() unexpected error: %v", err)
+	}
+	if len(response.Events) != 1 || response.Events[0].Action != models.AuditActionAuditQuery {
+		t.Errorf("Query() by actor = %+v, want one audit.query event", response.Events)
+	}
+}
+
+func TestAuditService_AdminEvents(t *testing.T) {
+	auditService, authService, adminService := setupAuditService(t)
+	ctx := context.Background()
+
+	user, err := authService.SignUp(ctx, &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("SignUp() unexpected error: %v", err)
+	}
+
+	if _, err := adminService.SetUserRole(ctx, "admin-id", user.ID, models.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole() unexpected error: %v", err)
+	}
+	adminService.SetUserRole(ctx, "admin-id", user.ID, "root")
+
+	response, err := auditService.Query(ctx, "admin-id", repository.AuditFilter{
+		Action:   models.AuditActionUserRoleChange,
+		TargetID: user.ID,
+	})
+	if err != nil {
+		t.Fatalf("Query() unexpected error: %v", err)
+	}
+	if len(response.Events) != 2 {
+		t.Fatalf("Query() returned %d events, want 2", len(response.Events))
+	}
+
+	failed, succeeded := response.Events[0], response.Events[1]
+	if succeeded.Outcome != models.AuditOutcomeSuccess || succeeded.ActorID != "admin-id" ||
+		succeeded.Details["role"] != models.RoleAdmin || succeeded.Details["previous_role"] != models.RoleUser {
+		t.Errorf("role change event = %+v", succeeded)
+	}
+	if failed.Outcome != models.AuditOutcomeFailure || failed.Reason != services.ErrInvalidRole.Error() {
+		t.Errorf("invalid role change event = %+v", failed)
+	}
+}
+
+func TestAuditService_Export(t *testing.T) {
+	auditService, authService, _ := setupAuditService(t)
+	ctx := reqctx.WithInfo(context.Background(), reqctx.Info{UserAgent: "=HYPERLINK(\"http://evil.example\")"})
+
+	for _, username := range []string{"alice", "bob"} {
+		_, err := authService.SignUp(ctx, &models.SignUpRequest{
+			Username: username,
+			Email:    username + "@example.com",
+			Password: "password123",
+		})
+		if err != nil {
+			t.Fatalf("SignUp() unexpected error: %v", err)
+		}
+	}
+	filter := repository.AuditFilter{Action: models.AuditActionSignup}
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := auditService.Export(ctx, "admin-id", filter, services.AuditFormatCSV, &buf); err != nil {
+			t.Fatalf("Export() unexpected error: %v", err)
+		}
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("export is not valid CSV: %v", err)
+		}
+		if len(records) != 3 || records[0][0] != "id" {
+			t.Fatalf("CSV = %v, want a header and 2 events", records)
+		}
+		if ua := records[1][9]; ua[0] != '\'' {
+			t.Errorf("user agent %q was not escaped", ua)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := auditService.Export(ctx, "admin-id", filter, services.AuditFormatJSON, &buf); err != nil {
+			t.Fatalf("Export() unexpected error: %v", err)
+		}
+		var events []models.AuditEvent
+		if err := json.Unmarshal(buf.Bytes(), &events); err != nil {
+			t.Fatalf("export is not valid JSON: %v", err)
+		}
+		if len(events) != 2 || events[0].Action != models.AuditActionSignup {
+			t.Errorf("JSON export = %+v", events)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := auditService.Export(ctx, "admin-id", filter, "xml", &buf)
+		var validationErr models.ValidationErrors
+		if !errors.As(err, &validationErr) || buf.Len() != 0 {
+			t.Errorf("Export() error = %v, wrote %d bytes, want ValidationErrors and no output", err, buf.Len())
+		}
+	})
+
+	response, err := auditService.Query(ctx, "admin-id", repository.AuditFilter{Action: models.AuditActionAuditExport})
+	if err != nil {
+		t.Fatalf("Query() unexpected error: %v", err)
+	}
+	if len(response.Events) != 2 {
+		t.Errorf("Query() returned %d export events, want 2", len(response.Events))
+	}
+}
@@ -2,55 +2,92 @@ package services
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"strings"
 	"time"
 
 	"auth/internal/auth"
 	"auth/internal/config"
 	"auth/internal/logger"
 	"auth/internal/models"
+	"auth/internal/notify"
 	"auth/internal/repository"
+	"auth/internal/reqctx"
+	"auth/internal/risk"
 	"github.com/google/uuid"
 )
 
+var (
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrAccountDisabled    = errors.New("account disabled")
+	ErrNoPendingDeletion  = errors.New("no pending account deletion")
+	ErrAccountLocked      = errors.New("account locked")
+	ErrInvalidReportToken = errors.New("invalid or expired report token")
+	ErrInternal           = errors.New("internal server error")
+)
+
+var (
+	ErrLoginDenied         = errors.New("login denied by risk policy")
+	ErrInvalidMFAToken     = errors.New("invalid or expired mfa token")
+	ErrInvalidMFACode      = errors.New("invalid mfa code")
+	ErrMFAAlreadyEnabled   = errors.New("mfa already enabled")
+	ErrMFANotEnrolled      = errors.New("mfa not enrolled")
+	ErrMFAAttemptsExceeded = errors.New("too many mfa attempts")
+)
+
 type AuthService struct {
-	repo   *repository.Repository
-	config *config.Config
-	logger *logger.Logger
+	repo     *repository.Repository
+	audit    *AuditService
+	sessions *SessionService
+	risk     *RiskService
+	notifier notify.Notifier
+	config   *config.Config
+	logger   *logger.Logger
 }
 
 type AuthTokenResponse struct {
-	Token     string                 `json:"token"`
+	Token     string                 `json:"token,omitempty"`
 	ExpiresAt time.Time              `json:"expires_at"`
 	User      *models.UserResponse   `json:"user"`
+	// DeviceID is the device cookie the client should keep, so later logins
+	// from it are recognized
+	DeviceID string `json:"-"`
+	// SessionID is the login session the token was issued for
+	SessionID string `json:"-"`
+	// CSRFToken is set when the token is kept in a session cookie; the
+	// client sends it with state-changing requests
+	CSRFToken string `json:"csrf_token,omitempty"`
 }
 
-func NewAuthService(repo *repository.Repository, cfg *config.Config, logger *logger.Logger) *AuthService {
+func NewAuthService(repo *repository.Repository, audit *AuditService, sessions *SessionService, riskService *RiskService, notifier notify.Notifier, cfg *config.Config, logger *logger.Logger) *AuthService {
 	return &AuthService{
-		repo:   repo,
-		config: cfg,
-		logger: logger,
+		repo:     repo,
+		audit:    audit,
+		sessions: sessions,
+		risk:     riskService,
+		notifier: notifier,
+		config:   cfg,
+		logger:   logger,
 	}
 }
 
-func (s *AuthService) SignUp(ctx context.Context, req *models.SignUpRequest) (*models.UserResponse, error) {
+func (s *AuthService) SignUp(ctx context.Context, req *models.SignUpRequest) (_ *models.UserResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.SignUp")
+	defer func() { endSpan(span, err) }()
+
 	// Validate input
 	if err := req.Validate(); err != nil {
-		s.logger.Warn("validation failed", "error", err)
+		logger.FromContext(ctx).Warn("validation failed", "error", err)
 		return nil, err
 	}
 
-	// Check if user already exists
-	existingUser, _ := s.repo.User.GetByUsername(ctx, req.Username)
-	if existingUser != nil {
-		return nil, fmt.Errorf("user already exists")
-	}
-
 	// Hash password
-	hashedPassword, err := auth.HashPassword(req.Password)
+	hashedPassword, err := auth.HashPassword(ctx, req.Password)
 	if err != nil {
-		s.logger.Error("failed to hash password", "error", err)
-		return nil, fmt.Errorf("internal server error")
+		logger.FromContext(ctx).Error("failed to hash password", "error", err)
+		return nil, ErrInternal
 	}
 
 	// Create user
@@ -61,57 +98,393 @@ func (s *AuthService) SignUp(ctx context.Context, req *models.SignUpRequest) (*m
 		Password: hashedPassword,
 	}
 
-	if err := s.repo.User.Create(ctx, user); err != nil {
-		s.logger.Error("failed to create user", "error", err, "username", req.Username)
-		return nil, fmt.Errorf("failed to create user")
+	// The existence check and insert run in one transaction so concurrent
+	// signups for the same username can't both pass the check
+	err = s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		if _, err := tx.User.GetByUsername(ctx, req.Username); err == nil {
+			return ErrUserExists
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		return tx.User.Create(ctx, user)
+	})
+	if err != nil {
+		// The username or email was taken concurrently or by another account
+		if errors.Is(err, ErrUserExists) || errors.Is(err, repository.ErrConflict) {
+			err = ErrUserExists
+		} else {
+			logger.FromContext(ctx).Error("failed to create user", "error", err, "user_id", user.ID)
+			err = ErrInternal
+		}
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:  models.AuditActionSignup,
+			Details: map[string]string{"username": req.Username},
+		}, err)
+		return nil, err
 	}
 
-	s.logger.Info("user created successfully", "user_id", user.ID, "username", user.Username)
+	s.audit.Record(ctx, models.AuditEvent{ActorID: user.ID, Action: models.AuditActionSignup}, nil)
+	logger.FromContext(ctx).Info("user created successfully", "user_id", user.ID)
 	return user.ToResponse(), nil
 }
 
-func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest) (*AuthTokenResponse, error) {
+func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest) (_ *AuthTokenResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.Login")
+	defer func() { endSpan(span, err) }()
+
 	// Validate input
 	if err := req.Validate(); err != nil {
-		s.logger.Warn("validation failed", "error", err)
+		logger.FromContext(ctx).Warn("validation failed", "error", err)
 		return nil, err
 	}
 
 	// Get user
 	user, err := s.repo.User.GetByUsername(ctx, req.Username)
 	if err != nil {
-		s.logger.Warn("user not found", "username", req.Username)
-		return nil, fmt.Errorf("invalid credentials")
+		if !errors.Is(err, repository.ErrNotFound) {
+			logger.FromContext(ctx).Error("failed to look up user", "error", err)
+			return nil, ErrInternal
+		}
+		logger.FromContext(ctx).Warn("user not found")
+		return nil, s.loginFailed(ctx, "", req.Username, ErrInvalidCredentials)
 	}
 
 	// Check password
-	if !auth.CheckPasswordHash(req.Password, user.Password) {
-		s.logger.Warn("invalid password", "username", req.Username)
-		return nil, fmt.Errorf("invalid credentials")
+	if !auth.CheckPasswordHash(ctx, req.Password, user.Password) {
+		logger.FromContext(ctx).Warn("invalid password", "user_id", user.ID)
+		return nil, s.loginFailed(ctx, user.ID, req.Username, ErrInvalidCredentials)
+	}
+
+	if !user.CanLogin() {
+		logger.FromContext(ctx).Warn("login to inactive account", "user_id", user.ID, "status", user.Status)
+		return nil, s.loginFailed(ctx, user.ID, req.Username, inactiveAccountError(user))
+	}
+
+	return s.completeLogin(ctx, user, []string{auth.AMRPassword})
+}
+
+// completeLogin finishes a login whose factors in amr were verified: it
+// weighs the risk of the login and either refuses it, asks for a second
+// factor the user hasn't presented yet, or issues a token
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User, amr []string) (*AuthTokenResponse, error) {
+	// Browsers without a device cookie are given one, so the next login
+	// from them is recognized
+	info := reqctx.FromContext(ctx)
+	deviceID := info.DeviceID
+	if deviceID == "" {
+		deviceID = uuid.New().String()
+	}
+	signals, err := s.sessions.loginSignals(ctx, user.ID, deviceID, info.IP, time.Now())
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to compare login with earlier sessions", "error", err, "user_id", user.ID)
+		return nil, s.loginFailed(ctx, user.ID, user.Username, ErrInternal)
+	}
+
+	assessment, err := s.risk.assessLogin(ctx, user.ID, signals)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to assess login risk", "error", err, "user_id", user.ID)
+		return nil, s.loginFailed(ctx, user.ID, user.Username, ErrInternal)
+	}
+	switch assessment.Decision {
+	case risk.Deny:
+		// A second factor answers a risky login but not one the policy refuses
+		logger.FromContext(ctx).Warn("login denied by risk policy", "user_id", user.ID, "score", assessment.Score, "reasons", assessment.Reasons)
+		s.audit.Record(ctx, models.AuditEvent{
+			ActorID: user.ID,
+			Action:  models.AuditActionLogin,
+			Details: riskDetails(map[string]string{"username": user.Username}, assessment),
+		}, ErrLoginDenied)
+		return nil, ErrLoginDenied
+	case risk.RequireMFA:
+		if acrFor(amr) == auth.ACRMFA {
+			break
+		}
+		if user.MFAEnabled() {
+			return nil, s.mfaChallenge(ctx, user, amr, assessment)
+		}
+		// Refusing would lock out users who never enrolled a second factor
+		logger.FromContext(ctx).Warn("risky login to account without MFA", "user_id", user.ID, "score", assessment.Score, "reasons", assessment.Reasons)
+	}
+
+	return s.issueToken(ctx, user, deviceID, signals, assessment, amr)
+}
+
+// issueToken starts a session for a login that passed every check and signs
+// its token. amr lists the methods the user authenticated with.
+func (s *AuthService) issueToken(ctx context.Context, user *models.User, deviceID string, signals LoginSignals, assessment risk.Assessment, amr []string) (*AuthTokenResponse, error) {
+	// The session lives as long as the token issued for it
+	expiresAt := time.Now().Add(s.config.JWT.Expiration)
+	session, err := s.sessions.Create(ctx, user.ID, deviceID, expiresAt)
+	if err != nil {
+		return nil, s.loginFailed(ctx, user.ID, user.Username, err)
 	}
 
 	// Generate token
-	token, err := auth.GenerateJWT(user.Username, s.config.JWT.Secret, s.config.JWT.Expiration)
+	token, err := auth.GenerateJWT(&auth.Claims{
+		Username:              user.Username,
+		UserID:                user.ID,
+		Role:                  user.Role,
+		Plan:                  user.Plan,
+		PasswordResetRequired: user.PasswordResetRequired,
+		SessionID:             session.ID,
+		ACR:                   acrFor(amr),
+		AMR:                   amr,
+	}, s.config.JWT.Secret, s.config.JWT.Expiration)
 	if err != nil {
-		s.logger.Error("failed to generate token", "error", err, "user_id", user.ID)
-		return nil, fmt.Errorf("internal server error")
+		logger.FromContext(ctx).Error("failed to generate token", "error", err, "user_id", user.ID)
+		return nil, s.loginFailed(ctx, user.ID, user.Username, ErrInternal)
+	}
+
+	details := map[string]string{"session_id": session.ID, "amr": strings.Join(amr, ",")}
+	if names := signals.Names(); len(names) > 0 {
+		details["signals"] = strings.Join(names, ",")
 	}
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID: user.ID,
+		Action:  models.AuditActionLogin,
+		Details: riskDetails(details, assessment),
+	}, nil)
+	logger.FromContext(ctx).Info("user logged in successfully", "user_id", user.ID, "session_id", session.ID)
+	s.notifyLogin(ctx, user, session, signals)
 
-	s.logger.Info("user logged in successfully", "user_id", user.ID, "username", user.Username)
-	
 	return &AuthTokenResponse{
 		Token:     token,
-		ExpiresAt: time.Now().Add(s.config.JWT.Expiration),
+		ExpiresAt: expiresAt,
 		User:      user.ToResponse(),
+		DeviceID:  deviceID,
+		SessionID: session.ID,
 	}, nil
 }
 
-func (s *AuthService) GetUserByID(ctx context.Context, userID string) (*models.UserResponse, error) {
+// inactiveAccountError returns the error a login to an account that can't log
+// in fails with
+func inactiveAccountError(user *models.User) error {
+	switch user.Status {
+	case models.StatusDisabled:
+		return ErrAccountDisabled
+	case models.StatusLocked:
+		return ErrAccountLocked
+	}
+	// Deleted accounts look the same as accounts that never existed
+	return ErrInvalidCredentials
+}
+
+// loginFailed records a failed login attempt and returns err. userID is empty
+// when the username doesn't match an account.
+func (s *AuthService) loginFailed(ctx context.Context, userID, username string, err error) error {
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID: userID,
+		Action:  models.AuditActionLogin,
+		Details: map[string]string{"username": username},
+	}, err)
+	return err
+}
+
+// Logout ends the session the user's token was issued for, so the token
+// stops working
+func (s *AuthService) Logout(ctx context.Context, userID, sessionID string) (err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.Logout")
+	defer func() { endSpan(span, err) }()
+
+	err = s.sessions.revoke(ctx, userID, sessionID)
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID: userID,
+		Action:  models.AuditActionLogout,
+		Details: map[string]string{"session_id": sessionID},
+	}, err)
+	if err != nil {
+		return err
+	}
+	logger.FromContext(ctx).Info("user logged out", "user_id", userID, "session_id", sessionID)
+	return nil
+}
+
+func (s *AuthService) GetUserByID(ctx context.Context, userID string) (_ *models.UserResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.GetUserByID")
+	defer func() { endSpan(span, err) }()
+
 	user, err := s.repo.User.GetByID(ctx, userID)
 	if err != nil {
-		s.logger.Warn("user not found", "user_id", userID)
-		return nil, fmt.Errorf("user not found")
+		if !errors.Is(err, repository.ErrNotFound) {
+			logger.FromContext(ctx).Error("failed to get user", "error", err, "user_id", userID)
+			return nil, ErrInternal
+		}
+		logger.FromContext(ctx).Warn("user not found", "user_id", userID)
+		return nil, ErrUserNotFound
 	}
 
 	return user.ToResponse(), nil
-}
\ No newline at end of file
+}
+
+// AccountStatus returns the current status of the account. Accounts that no
+// longer exist are reported as deleted.
+func (s *AuthService) AccountStatus(ctx context.Context, userID string) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.AccountStatus")
+	defer func() { endSpan(span, err) }()
+
+	user, err := s.repo.User.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return models.StatusDeleted, nil
+		}
+		return "", err
+	}
+	return user.Status, nil
+}
+
+// ChangePassword replaces the user's password after checking the current one.
+// It also clears an administrator-forced password reset.
+func (s *AuthService) ChangePassword(ctx context.Context, userID string, req *models.ChangePasswordRequest) (err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.ChangePassword")
+	defer func() { endSpan(span, err) }()
+
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	err = s.changePassword(ctx, userID, req)
+	s.audit.Record(ctx, models.AuditEvent{ActorID: userID, Action: models.AuditActionPasswordChange}, err)
+	return err
+}
+
+func (s *AuthService) changePassword(ctx context.Context, userID string, req *models.ChangePasswordRequest) error {
+	user, err := s.repo.User.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		logger.FromContext(ctx).Error("failed to get user", "error", err, "user_id", userID)
+		return ErrInternal
+	}
+
+	if !auth.CheckPasswordHash(ctx, req.CurrentPassword, user.Password) {
+		logger.FromContext(ctx).Warn("invalid current password on password change", "user_id", userID)
+		return ErrInvalidCredentials
+	}
+
+	hashedPassword, err := auth.HashPassword(ctx, req.NewPassword)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to hash password", "error", err)
+		return ErrInternal
+	}
+
+	user.Password = hashedPassword
+	user.PasswordResetRequired = false
+	if err := s.repo.User.Update(ctx, user); err != nil {
+		logger.FromContext(ctx).Error("failed to update password", "error", err, "user_id", userID)
+		return ErrInternal
+	}
+
+	logger.FromContext(ctx).Info("password changed", "user_id", userID)
+	return nil
+}
+
+// RequestDeletion schedules the user's account for deletion once the grace
+// period has passed. Until then the user can cancel with CancelDeletion.
+func (s *AuthService) RequestDeletion(ctx context.Context, userID string, req *models.DeleteAccountRequest) (_ *models.UserResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.RequestDeletion")
+	defer func() { endSpan(span, err) }()
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.scheduleDeletion(ctx, userID, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidCredentials):
+			logger.FromContext(ctx).Warn("invalid password on account deletion", "user_id", userID)
+			err = ErrInvalidCredentials
+		case errors.Is(err, repository.ErrNotFound):
+			err = ErrUserNotFound
+		default:
+			logger.FromContext(ctx).Error("failed to schedule account deletion", "error", err, "user_id", userID)
+			err = ErrInternal
+		}
+		s.audit.Record(ctx, models.AuditEvent{ActorID: userID, Action: models.AuditActionDeletionRequest}, err)
+		return nil, err
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{ActorID: userID, Action: models.AuditActionDeletionRequest}, nil)
+	logger.FromContext(ctx).Info("account deletion scheduled", "user_id", userID, "scheduled_at", updated.DeletionScheduledAt)
+	return updated.ToResponse(), nil
+}
+
+// scheduleDeletion checks password and marks the user pending deletion. The
+// password is checked before the transaction so the slow hash comparison
+// does not hold it open.
+func (s *AuthService) scheduleDeletion(ctx context.Context, userID, password string) (*models.User, error) {
+	user, err := s.repo.User.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !auth.CheckPasswordHash(ctx, password, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+	checked := user.Password
+
+	var updated *models.User
+	err = s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		user, err := tx.User.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// The password changed after it was checked
+		if user.Password != checked {
+			return ErrInvalidCredentials
+		}
+		// Repeating the request keeps the original schedule
+		if user.Status != models.StatusPendingDeletion {
+			scheduledAt := time.Now().Add(s.config.Account.DeletionGracePeriod)
+			user.Status = models.StatusPendingDeletion
+			user.DeletionScheduledAt = &scheduledAt
+			if err := tx.User.Update(ctx, user); err != nil {
+				return err
+			}
+		}
+		updated = user
+		return nil
+	})
+	return updated, err
+}
+
+// CancelDeletion restores an account that is pending deletion
+func (s *AuthService) CancelDeletion(ctx context.Context, userID string) (_ *models.UserResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.CancelDeletion")
+	defer func() { endSpan(span, err) }()
+
+	var updated *models.User
+	err = s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		user, err := tx.User.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.Status != models.StatusPendingDeletion {
+			return ErrNoPendingDeletion
+		}
+		user.Status = models.StatusActive
+		user.DeletionScheduledAt = nil
+		if err := tx.User.Update(ctx, user); err != nil {
+			return err
+		}
+		updated = user
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoPendingDeletion):
+			err = ErrNoPendingDeletion
+		case errors.Is(err, repository.ErrNotFound):
+			err = ErrUserNotFound
+		default:
+			logger.FromContext(ctx).Error("failed to cancel account deletion", "error", err, "user_id", userID)
+			err = ErrInternal
+		}
+		s.audit.Record(ctx, models.AuditEvent{ActorID: userID, Action: models.AuditActionDeletionCancel}, err)
+		return nil, err
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{ActorID: userID, Action: models.AuditActionDeletionCancel}, nil)
+	logger.FromContext(ctx).Info("account deletion cancelled", "user_id", userID)
+	return updated.ToResponse(), nil
+}
@@ -2,72 +2,18 @@ package services_test
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"testing"
 	"time"
 
 	"auth/internal/config"
 	"auth/internal/logger"
 	"auth/internal/models"
-	"auth/internal/repository"
+	"auth/internal/notify"
+	"auth/internal/repository/memory"
 	"auth/internal/services"
 )
 
-type mockUserRepository struct {
-	users map[string]*models.User
-}
-
-func newMockUserRepository() *mockUserRepository {
-	return &mockUserRepository{
-		users: make(map[string]*models.User),
-	}
-}
-
-func (m *mockUserRepository) Create(ctx context.Context, user *models.User) error {
-	if _, exists := m.users[user.Username]; exists {
-		return fmt.Errorf("user already exists")
-	}
-	user.CreatedAt = time.Now()
-	user.UpdatedAt = time.Now()
-	m.users[user.Username] = user
-	return nil
-}
-
-func (m *mockUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
-	if user, exists := m.users[username]; exists {
-		return user, nil
-	}
-	return nil, fmt.Errorf("user not found")
-}
-
-func (m *mockUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
-	for _, user := range m.users {
-		if user.ID == id {
-			return user, nil
-		}
-	}
-	return nil, fmt.Errorf("user not found")
-}
-
-func (m *mockUserRepository) Update(ctx context.Context, user *models.User) error {
-	if _, exists := m.users[user.Username]; !exists {
-		return fmt.Errorf("user not found")
-	}
-	user.UpdatedAt = time.Now()
-	m.users[user.Username] = user
-	return nil
-}
-
-func (m *mockUserRepository) Delete(ctx context.Context, id string) error {
-	for username, user := range m.users {
-		if user.ID == id {
-			delete(m.users, username)
-			return nil
-		}
-	}
-	return fmt.Errorf("user not found")
-}
-
 func setupAuthService() *services.AuthService {
 	cfg := &config.Config{
 		JWT: config.JWTConfig{
@@ -76,10 +22,11 @@ func setupAuthService() *services.AuthService {
 		},
 	}
 	log := logger.New("error") // Suppress logs during tests
-	mockRepo := newMockUserRepository()
-	repo := repository.New(mockRepo)
+	repo := memory.NewRepository()
 	
-	return services.NewAuthService(repo, cfg, log)
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, nil, cfg, log)
+	return services.NewAuthService(repo, auditService, sessionService, services.NewRiskService(repo, auditService, nil, cfg, log), notify.NewLog(log), cfg, log)
 }
 
 func TestAuthService_SignUp(t *testing.T) {
@@ -218,4 +165,46 @@ func TestAuthService_Login(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestAuthService_AccountDeletion(t *testing.T) {
+	authService := setupAuthService()
+	ctx := context.Background()
+
+	user, err := authService.SignUp(ctx, &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if _, err := authService.RequestDeletion(ctx, user.ID, &models.DeleteAccountRequest{Password: "wrongpassword"}); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("RequestDeletion() with wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+
+	pending, err := authService.RequestDeletion(ctx, user.ID, &models.DeleteAccountRequest{Password: "password123"})
+	if err != nil {
+		t.Fatalf("RequestDeletion() unexpected error: %v", err)
+	}
+	if pending.Status != models.StatusPendingDeletion || pending.DeletionScheduledAt == nil {
+		t.Errorf("RequestDeletion() status = %s, scheduled = %v", pending.Status, pending.DeletionScheduledAt)
+	}
+
+	// Users can still log in during the grace period to cancel
+	if _, err := authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "password123"}); err != nil {
+		t.Errorf("Login() during grace period unexpected error: %v", err)
+	}
+
+	restored, err := authService.CancelDeletion(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("CancelDeletion() unexpected error: %v", err)
+	}
+	if restored.Status != models.StatusActive || restored.DeletionScheduledAt != nil {
+		t.Errorf("CancelDeletion() status = %s, scheduled = %v", restored.Status, restored.DeletionScheduledAt)
+	}
+
+	if _, err := authService.CancelDeletion(ctx, user.ID); !errors.Is(err, services.ErrNoPendingDeletion) {
+		t.Errorf("CancelDeletion() twice error = %v, want ErrNoPendingDeletion", err)
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository"
+)
+
+// notifyLogin tells the user about a login from a new device or one that
+// looks suspicious. The notification carries a link to report the login if
+// it wasn't them.
+func (s *AuthService) notifyLogin(ctx context.Context, user *models.User, session *models.Session, signals LoginSignals) {
+	var kind, subject, reason string
+	switch {
+	case signals.FirstLogin:
+		return
+	case signals.ImpossibleTravel:
+		kind, subject = notify.KindSuspiciousLogin, "Suspicious sign-in to your account"
+		reason = "It came from a place you could not have travelled to since your last sign-in."
+	case signals.NewCountry:
+		kind, subject = notify.KindSuspiciousLogin, "Suspicious sign-in to your account"
+		reason = "It came from a country you haven't signed in from before."
+	case signals.NewDevice:
+		kind, subject = notify.KindNewDevice, "New sign-in to your account"
+		reason = "It came from a device you haven't signed in with before."
+	default:
+		return
+	}
+
+	token, err := auth.GenerateReportToken(user.ID, session.ID, s.config.JWT.Secret, s.config.Notify.ReportTTL)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to generate login report token", "error", err, "user_id", user.ID)
+		return
+	}
+	reportURL := s.config.Notify.ReportURL + url.QueryEscape(token)
+
+	location := session.Location
+	if location == "" {
+		location = "Unknown"
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "Hi %s,\n\n", user.Username)
+	fmt.Fprintf(&text, "Your account was just signed in to. %s\n\n", reason)
+	fmt.Fprintf(&text, "Device:   %s\n", session.Device)
+	fmt.Fprintf(&text, "Location: %s\n", location)
+	fmt.Fprintf(&text, "IP:       %s\n", session.IP)
+	fmt.Fprintf(&text, "Time:     %s\n\n", session.CreatedAt.UTC().Format(time.RFC1123))
+	fmt.Fprintf(&text, "If this was you, there is nothing to do. If it wasn't, lock your account now:\n\n%s\n\n", reportURL)
+	text.WriteString("Locking signs out every device. An administrator can unlock the account once it is secure again.\n")
+
+	err = s.notifier.Notify(ctx, &notify.Notification{
+		Kind:     kind,
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Subject:  subject,
+		Text:     text.String(),
+		Data: map[string]string{
+			"session_id": session.ID,
+			"device":     session.Device,
+			"location":   session.Location,
+			"ip":         session.IP,
+			"signals":    strings.Join(signals.Names(), ","),
+			"report_url": reportURL,
+		},
+		Time: session.CreatedAt,
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to send login notification", "error", err, "user_id", user.ID, "kind", kind)
+		return
+	}
+	logger.FromContext(ctx).Info("login notification sent", "user_id", user.ID, "kind", kind, "session_id", session.ID)
+}
+
+// ReportLogin handles a "this wasn't me" report from a login notification.
+// The account is locked and every session signed out; an administrator
+// unlocks it by enabling the user. Accounts that are already locked or
+// disabled only have their sessions signed out.
+func (s *AuthService) ReportLogin(ctx context.Context, req *models.ReportLoginRequest) (err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.ReportLogin")
+	defer func() { endSpan(span, err) }()
+
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	claims, err := auth.ValidateReportToken(req.Token, s.config.JWT.Secret)
+	if err != nil {
+		logger.FromContext(ctx).Warn("invalid login report token", "error", err)
+		return ErrInvalidReportToken
+	}
+
+	err = s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		user, err := tx.User.GetByID(ctx, claims.UserID)
+		if err != nil {
+			return err
+		}
+		if user.Status == models.StatusDeleted {
+			return repository.ErrNotFound
+		}
+		if user.CanLogin() {
+			user.Status = models.StatusLocked
+			if err := tx.User.Update(ctx, user); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Session.RevokeByUser(ctx, user.ID, "", time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			err = ErrInvalidReportToken
+		} else {
+			logger.FromContext(ctx).Error("failed to lock reported account", "error", err, "user_id", claims.UserID)
+			err = ErrInternal
+		}
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID:  claims.UserID,
+		Action:   models.AuditActionLoginReport,
+		TargetID: claims.UserID,
+		Details:  map[string]string{"session_id": claims.SessionID},
+	}, err)
+	if err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Warn("account locked after a login was reported", "user_id", claims.UserID, "session_id", claims.SessionID)
+	return nil
+}
@@ -0,0 +1,185 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/geoip"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository/memory"
+	"auth/internal/reqctx"
+	"auth/internal/services"
+)
+
+const alertsGeoIP = `
+192.0.2.0/24,Germany,Berlin,Berlin,52.52,13.405,3320
+198.51.100.0/24,Germany,Hamburg,Hamburg,53.551,9.994,3320
+203.0.113.0/24,United States,New York,New York,40.7128,-74.006,7018
+`
+
+type notificationRecorder struct {
+	mu   sync.Mutex
+	sent []*notify.Notification
+}
+
+func (r *notificationRecorder) Notify(ctx context.Context, n *notify.Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+// take returns the notifications sent since the last call
+func (r *notificationRecorder) take() []*notify.Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sent := r.sent
+	r.sent = nil
+	return sent
+}
+
+func setupLoginAlerts(t *testing.T) (*services.AuthService, *services.SessionService, *notificationRecorder) {
+	t.Helper()
+	cfg := &config.Config{
+		JWT:     config.JWTConfig{Secret: "test-secret", Expiration: time.Hour},
+		Session: config.SessionConfig{TouchInterval: time.Minute, HistoryRetention: time.Hour, MaxTravelSpeed: 1000},
+		Notify:  config.NotifyConfig{ReportURL: "https://example.com/report?token=", ReportTTL: time.Hour},
+	}
+	log := logger.New("error")
+	repo := memory.NewRepository()
+	geo, err := geoip.Parse(strings.NewReader(alertsGeoIP))
+	if err != nil {
+		t.Fatalf("geoip.Parse() unexpected error: %v", err)
+	}
+
+	recorder := &notificationRecorder{}
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, geo, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, services.NewRiskService(repo, auditService, nil, cfg, log), recorder, cfg, log)
+	_, err = authService.SignUp(context.Background(), &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("SignUp() unexpected error: %v", err)
+	}
+	return authService, sessionService, recorder
+}
+
+func loginFrom(t *testing.T, authService *services.AuthService, ip, deviceID string) *services.AuthTokenResponse {
+	t.Helper()
+	ctx := reqctx.WithInfo(context.Background(), reqctx.Info{IP: ip, UserAgent: "Mozilla/5.0 Firefox/120.0", DeviceID: deviceID})
+	response, err := authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Login() from %s unexpected error: %v", ip, err)
+	}
+	return response
+}
+
+func TestLoginAlerts(t *testing.T) {
+	authService, _, recorder := setupLoginAlerts(t)
+
+	// The first login has nothing to compare with and hands out a device cookie
+	first := loginFrom(t, authService, "192.0.2.1", "")
+	if first.DeviceID == "" {
+		t.Fatal("Login() did not issue a device ID")
+	}
+	if sent := recorder.take(); len(sent) != 0 {
+		t.Errorf("first login sent %d notifications, want 0", len(sent))
+	}
+
+	// The same browser keeps its device ID and is recognized
+	again := loginFrom(t, authService, "192.0.2.2", first.DeviceID)
+	if again.DeviceID != first.DeviceID {
+		t.Errorf("DeviceID = %q, want %q", again.DeviceID, first.DeviceID)
+	}
+	if sent := recorder.take(); len(sent) != 0 {
+		t.Errorf("login from a known device sent %d notifications, want 0", len(sent))
+	}
+
+	// A new browser nearby is a new device, not a suspicious login
+	loginFrom(t, authService, "198.51.100.1", "")
+	sent := recorder.take()
+	if len(sent) != 1 || sent[0].Kind != notify.KindNewDevice {
+		t.Fatalf("login from a new device sent %+v, want one new_device notification", sent)
+	}
+	if sent[0].Email != "test@example.com" || !strings.Contains(sent[0].Text, "Hamburg, Germany") ||
+		!strings.Contains(sent[0].Text, "https://example.com/report?token=") {
+		t.Errorf("notification = %+v", sent[0])
+	}
+
+	// Crossing the Atlantic within seconds on a known device is still suspicious
+	loginFrom(t, authService, "203.0.113.1", first.DeviceID)
+	sent = recorder.take()
+	if len(sent) != 1 || sent[0].Kind != notify.KindSuspiciousLogin {
+		t.Fatalf("login from another continent sent %+v, want one suspicious_login notification", sent)
+	}
+	for _, signal := range []string{"new_country", "asn_changed", "impossible_travel"} {
+		if !strings.Contains(sent[0].Data["signals"], signal) {
+			t.Errorf("signals = %q, want %s", sent[0].Data["signals"], signal)
+		}
+	}
+	if strings.Contains(sent[0].Data["signals"], "new_device") {
+		t.Errorf("signals = %q, the device is known", sent[0].Data["signals"])
+	}
+}
+
+func TestReportLogin(t *testing.T) {
+	authService, sessionService, recorder := setupLoginAlerts(t)
+	ctx := context.Background()
+
+	first := loginFrom(t, authService, "192.0.2.1", "")
+	loginFrom(t, authService, "203.0.113.1", "")
+	sent := recorder.take()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d notifications, want 1", len(sent))
+	}
+	reportURL, err := url.Parse(sent[0].Data["report_url"])
+	if err != nil {
+		t.Fatalf("invalid report URL: %v", err)
+	}
+	token := reportURL.Query().Get("token")
+
+	for _, invalid := range []string{"not-a-token", first.Token, token + "x"} {
+		err := authService.ReportLogin(ctx, &models.ReportLoginRequest{Token: invalid})
+		if !errors.Is(err, services.ErrInvalidReportToken) {
+			t.Errorf("ReportLogin(%.20q) error = %v, want ErrInvalidReportToken", invalid, err)
+		}
+	}
+	var validationErr models.ValidationErrors
+	if err := authService.ReportLogin(ctx, &models.ReportLoginRequest{}); !errors.As(err, &validationErr) {
+		t.Errorf("ReportLogin() without a token error = %v, want a validation error", err)
+	}
+
+	if err := authService.ReportLogin(ctx, &models.ReportLoginRequest{Token: token}); err != nil {
+		t.Fatalf("ReportLogin() unexpected error: %v", err)
+	}
+	status, err := authService.AccountStatus(ctx, first.User.ID)
+	if err != nil || status != models.StatusLocked {
+		t.Errorf("AccountStatus() = %q, %v, want locked", status, err)
+	}
+	sessions, err := sessionService.List(ctx, first.User.ID, "", false)
+	if err != nil || len(sessions.Sessions) != 0 {
+		t.Errorf("List() after report = %+v, %v, want no active sessions", sessions, err)
+	}
+
+	loginCtx := reqctx.WithInfo(ctx, reqctx.Info{IP: "192.0.2.1"})
+	_, err = authService.Login(loginCtx, &models.LoginRequest{Username: "testuser", Password: "password123"})
+	if !errors.Is(err, services.ErrAccountLocked) {
+		t.Errorf("Login() to a locked account error = %v, want ErrAccountLocked", err)
+	}
+
+	// Following the link twice is harmless
+	if err := authService.ReportLogin(ctx, &models.ReportLoginRequest{Token: token}); err != nil {
+		t.Errorf("second ReportLogin() unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"auth/internal/geoip"
+	"auth/internal/models"
+)
+
+// minTravelDistance is the shortest move checked for impossible travel.
+// GeoIP locations can be off by a few hundred kilometres, so closer logins
+// are never flagged.
+const minTravelDistance = 500.0
+
+// LoginSignals describe how a login differs from the user's earlier logins,
+// as recorded in their sessions
+type LoginSignals struct {
+	// FirstLogin is set when the user has no earlier sessions to compare
+	// with. No other signal is set then.
+	FirstLogin bool
+	// NewDevice is set when no earlier session was created with the device
+	// cookie of this login
+	NewDevice bool
+	// NewCountry is set when none of the earlier sessions with a known
+	// location came from this login's country
+	NewCountry bool
+	// IPChanged and ASNChanged compare the login with the most recently used
+	// session. ASNChanged needs both ASNs to be known.
+	IPChanged  bool
+	ASNChanged bool
+	// ImpossibleTravel is set when getting from the most recently used
+	// session's location to this one would take travelling faster than the
+	// configured maximum speed
+	ImpossibleTravel bool
+	// TravelSpeed is the speed in km/h ImpossibleTravel was judged on
+	TravelSpeed float64
+}
+
+// Suspicious reports whether the login looks like it was made by someone
+// else, rather than just from a device the user hasn't used before
+func (s LoginSignals) Suspicious() bool {
+	return s.NewCountry || s.ImpossibleTravel
+}
+
+// Names lists the signals that are set, for audit events and notifications
+func (s LoginSignals) Names() []string {
+	var names []string
+	for _, signal := range []struct {
+		set  bool
+		name string
+	}{
+		{s.FirstLogin, "first_login"},
+		{s.NewDevice, "new_device"},
+		{s.NewCountry, "new_country"},
+		{s.IPChanged, "ip_changed"},
+		{s.ASNChanged, "asn_changed"},
+		{s.ImpossibleTravel, "impossible_travel"},
+	} {
+		if signal.set {
+			names = append(names, signal.name)
+		}
+	}
+	return names
+}
+
+// loginSignals compares a login from deviceID and ip at now with the user's
+// earlier sessions. It must run before the login's own session is created.
+func (s *SessionService) loginSignals(ctx context.Context, userID, deviceID, ip string, now time.Time) (LoginSignals, error) {
+	var signals LoginSignals
+	previous, err := s.repo.Session.ListByUser(ctx, userID)
+	if err != nil {
+		return signals, err
+	}
+	if len(previous) == 0 {
+		signals.FirstLogin = true
+		return signals, nil
+	}
+
+	current, located := s.geoip.Lookup(ip)
+	signals.NewDevice = true
+	knownCountry, anyLocated := false, false
+	var last *models.Session
+	for _, session := range previous {
+		if session.DeviceID != "" && session.DeviceID == deviceID {
+			signals.NewDevice = false
+		}
+		if location, ok := s.geoip.Lookup(session.IP); ok && location.Country != "" {
+			anyLocated = true
+			knownCountry = knownCountry || location.Country == current.Country
+		}
+		if last == nil || session.LastSeenAt.After(last.LastSeenAt) {
+			last = session
+		}
+	}
+	signals.NewCountry = located && current.Country != "" && anyLocated && !knownCountry
+	signals.IPChanged = ip != last.IP
+
+	lastLocation, ok := s.geoip.Lookup(last.IP)
+	if !located || !ok {
+		return signals, nil
+	}
+	signals.ASNChanged = current.ASN != 0 && lastLocation.ASN != 0 && current.ASN != lastLocation.ASN
+
+	if km, ok := geoip.Distance(lastLocation, current); ok && km >= minTravelDistance && s.config.MaxTravelSpeed > 0 {
+		// A login right after the last request would otherwise divide by
+		// zero; nobody covers the minimum distance in under a minute anyway
+		hours := max(now.Sub(last.LastSeenAt).Hours(), 1.0/60)
+		signals.TravelSpeed = km / hours
+		signals.ImpossibleTravel = signals.TravelSpeed > float64(s.config.MaxTravelSpeed)
+	}
+	return signals, nil
+}
@@ -0,0 +1,14 @@
+package services_test
+
+import (
+	"os"
+	"testing"
+
+	"auth/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	// Services called without a request context log with the default logger
+	logger.SetDefault(logger.New("error"))
+	os.Exit(m.Run())
+}
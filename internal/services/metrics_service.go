@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/observability/metrics"
+	"auth/internal/repository"
+)
+
+// MetricsService updates the gauges that are counted from storage rather
+// than tracked as requests happen. Sessions end by expiring as well as by
+// being revoked, and every instance shares them, so they are counted.
+type MetricsService struct {
+	repo   *repository.Repository
+	config config.MetricsConfig
+	logger *logger.Logger
+}
+
+func NewMetricsService(repo *repository.Repository, cfg config.MetricsConfig, logger *logger.Logger) *MetricsService {
+	return &MetricsService{
+		repo:   repo,
+		config: cfg,
+		logger: logger,
+	}
+}
+
+// Run refreshes the gauges on every RefreshInterval until ctx is cancelled
+func (s *MetricsService) Run(ctx context.Context) {
+	ctx = logger.NewContext(ctx, s.logger)
+	if !s.config.Enabled || s.config.RefreshInterval <= 0 {
+		logger.FromContext(ctx).Info("metrics refresh disabled")
+		return
+	}
+
+	ticker := time.NewTicker(s.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RefreshOnce(ctx); err != nil && ctx.Err() == nil {
+			logger.FromContext(ctx).Error("metrics refresh failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshOnce updates the active session count and the system gauges
+func (s *MetricsService) RefreshOnce(ctx context.Context) error {
+	metrics.UpdateSystemMetrics()
+
+	active, err := s.repo.Session.CountActive(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	metrics.UpdateActiveSessions(float64(active))
+	return nil
+}
@@ -0,0 +1,120 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/observability/metrics"
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"auth/internal/services"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsService_RefreshOnce(t *testing.T) {
+	cfg := &config.Config{
+		JWT:     config.JWTConfig{Secret: "test-secret", Expiration: time.Hour},
+		Metrics: config.MetricsConfig{Enabled: true, RefreshInterval: time.Minute},
+	}
+	log := logger.New("error")
+	repo := memory.NewRepository()
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, nil, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, services.NewRiskService(repo, auditService, nil, cfg, log), notify.NewLog(log), cfg, log)
+	metricsService := services.NewMetricsService(repo, cfg.Metrics, log)
+	ctx := context.Background()
+
+	attempts := func(authType, result string) float64 {
+		return testutil.ToFloat64(metrics.AuthenticationAttempts.WithLabelValues(authType, result))
+	}
+	signups, duplicates := attempts("signup", "success"), attempts("signup", "user_exists")
+	logins, failures := attempts("login", "success"), attempts("login", "invalid_credentials")
+
+	signup := &models.SignUpRequest{Username: "testuser", Email: "test@example.com", Password: "password123"}
+	if _, err := authService.SignUp(ctx, signup); err != nil {
+		t.Fatalf("SignUp() unexpected error: %v", err)
+	}
+	if _, err := authService.SignUp(ctx, signup); !errors.Is(err, services.ErrUserExists) {
+		t.Fatalf("SignUp() duplicate error = %v, want ErrUserExists", err)
+	}
+	var responses []*services.AuthTokenResponse
+	for range 3 {
+		response, err := authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "password123"})
+		if err != nil {
+			t.Fatalf("Login() unexpected error: %v", err)
+		}
+		responses = append(responses, response)
+	}
+	if _, err := authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "wrong-password"}); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Fatalf("Login() with a wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+
+	for _, c := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"successful signups", attempts("signup", "success") - signups, 1},
+		{"duplicate signups", attempts("signup", "user_exists") - duplicates, 1},
+		{"successful logins", attempts("login", "success") - logins, 3},
+		{"failed logins", attempts("login", "invalid_credentials") - failures, 1},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	if err := metricsService.RefreshOnce(ctx); err != nil {
+		t.Fatalf("RefreshOnce() unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.ActiveSessions); got != 3 {
+		t.Errorf("active sessions = %v, want 3", got)
+	}
+
+	if err := authService.Logout(ctx, responses[0].User.ID, responses[0].SessionID); err != nil {
+		t.Fatalf("Logout() unexpected error: %v", err)
+	}
+	if err := metricsService.RefreshOnce(ctx); err != nil {
+		t.Fatalf("RefreshOnce() unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.ActiveSessions); got != 2 {
+		t.Errorf("active sessions after logout = %v, want 2", got)
+	}
+}
+
+func TestAuditService_RecordTxAttempts(t *testing.T) {
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: "test-secret", Expiration: time.Hour}}
+	repo := memory.NewRepository()
+	auditService := services.NewAuditService(repo, cfg, logger.New("error"))
+	ctx := context.Background()
+	failures := func() float64 {
+		return testutil.ToFloat64(metrics.AuthenticationAttempts.WithLabelValues("login", "invalid_credentials"))
+	}
+	before := failures()
+
+	// A transaction that is rolled back, and possibly retried, counts no
+	// attempt of its own
+	rollback := errors.New("rollback")
+	err := repo.WithTx(ctx, func(tx *repository.Repository) error {
+		if err := auditService.RecordTx(ctx, tx, models.AuditEvent{Action: models.AuditActionLogin}, services.ErrInvalidCredentials); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("WithTx() error = %v, want the rollback", err)
+	}
+	if got := failures() - before; got != 0 {
+		t.Errorf("failed logins after RecordTx = %v, want 0", got)
+	}
+
+	auditService.Record(ctx, models.AuditEvent{Action: models.AuditActionLogin}, services.ErrInvalidCredentials)
+	if got := failures() - before; got != 1 {
+		t.Errorf("failed logins after Record = %v, want 1", got)
+	}
+}
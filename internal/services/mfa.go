@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"auth/internal/risk"
+	"github.com/google/uuid"
+)
+
+// mfaTokenTTL is how long the user has to enter a code after a login asked
+// for one
+const mfaTokenTTL = 5 * time.Minute
+
+// maxMFAAttempts is how many codes a login challenge accepts before the user
+// has to log in with their password again. A token accepts as many step-up
+// codes.
+const maxMFAAttempts = 5
+
+// stepUpNamespace derives the ID of the challenge that counts the step-up
+// codes tried with a token
+var stepUpNamespace = uuid.MustParse("5d6c2f0e-8f3b-4c1a-9a47-2b1e7d4c8a90")
+
+// MFARequiredError is returned by Login when the password was right but the
+// risk of the login calls for a second factor. The client completes the login
+// by presenting Token and a code to CompleteMFA.
+type MFARequiredError struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (e *MFARequiredError) Error() string {
+	return "mfa required"
+}
+
+// MFAEnrollResponse holds the secret of an authenticator app being enrolled
+type MFAEnrollResponse struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI to show as a QR code
+	URI string `json:"uri"`
+}
+
+// acrFor returns the authentication context class reached with amr
+func acrFor(amr []string) string {
+	if slices.Contains(amr, auth.AMROTP) {
+		return auth.ACRMFA
+	}
+	return auth.ACRPassword
+}
+
+// mfaChallenge asks the user for a second factor to finish a login. amr
+// lists the methods the user authenticated with so far.
+func (s *AuthService) mfaChallenge(ctx context.Context, user *models.User, amr []string, assessment risk.Assessment) error {
+	// The challenge counts the codes tried with the token; the token itself
+	// carries everything else
+	now := time.Now()
+	challenge := &models.LoginChallenge{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Method:    models.LoginMethodMFA,
+		CreatedAt: now,
+		ExpiresAt: now.Add(mfaTokenTTL),
+	}
+	token, err := auth.GenerateMFAToken(challenge.ID, user.ID, amr, s.config.JWT.Secret, mfaTokenTTL)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to generate mfa token", "error", err, "user_id", user.ID)
+		return s.loginFailed(ctx, user.ID, user.Username, ErrInternal)
+	}
+	if err := s.repo.LoginChallenge.Create(ctx, challenge); err != nil {
+		logger.FromContext(ctx).Error("failed to store mfa challenge", "error", err, "user_id", user.ID)
+		return s.loginFailed(ctx, user.ID, user.Username, ErrInternal)
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID: user.ID,
+		Action:  models.AuditActionMFAChallenge,
+		Details: riskDetails(map[string]string{}, assessment),
+	}, nil)
+	logger.FromContext(ctx).Info("login needs a second factor", "user_id", user.ID, "score", assessment.Score, "reasons", assessment.Reasons)
+	return &MFARequiredError{Token: token, ExpiresAt: challenge.ExpiresAt}
+}
+
+// CompleteMFA finishes a login Login answered with an MFARequiredError
+func (s *AuthService) CompleteMFA(ctx context.Context, req *models.MFALoginRequest) (_ *AuthTokenResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.CompleteMFA")
+	defer func() { endSpan(span, err) }()
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	claims, err := auth.ValidateMFAToken(req.MFAToken, s.config.JWT.Secret)
+	if err != nil {
+		logger.FromContext(ctx).Warn("invalid mfa token", "error", err)
+		return nil, ErrInvalidMFAToken
+	}
+
+	// The attempt is counted before the code is checked, so a token that has
+	// run out of attempts can't be tried again even if recording the failed
+	// login goes wrong
+	_, err = s.repo.LoginChallenge.IncrementAttempts(ctx, claims.ID, maxMFAAttempts)
+	switch {
+	case errors.Is(err, repository.ErrConflict), errors.Is(err, repository.ErrNotFound):
+		logger.FromContext(ctx).Warn("too many mfa attempts", "user_id", claims.UserID)
+		return nil, ErrInvalidMFAToken
+	case err != nil:
+		logger.FromContext(ctx).Error("failed to count mfa attempt", "error", err, "user_id", claims.UserID)
+		return nil, ErrInternal
+	}
+
+	user, err := s.verifyTOTP(ctx, claims.UserID, req.Code)
+	if err != nil {
+		username := ""
+		if user != nil {
+			username = user.Username
+		}
+		if errors.Is(err, ErrMFANotEnrolled) || errors.Is(err, ErrUserNotFound) {
+			err = ErrInvalidMFAToken
+		}
+		return nil, s.loginFailed(ctx, claims.UserID, username, err)
+	}
+	if !user.CanLogin() {
+		logger.FromContext(ctx).Warn("login to inactive account", "user_id", user.ID, "status", user.Status)
+		return nil, s.loginFailed(ctx, user.ID, user.Username, inactiveAccountError(user))
+	}
+
+	// Tokens issued before they recorded the first factor were all for
+	// password logins
+	amr := claims.AMR
+	if len(amr) == 0 {
+		amr = []string{auth.AMRPassword}
+	}
+	return s.completeLogin(ctx, user, append(amr, auth.AMROTP, auth.AMRMFA))
+}
+
+// verifyTOTP checks code against the user's authenticator app and uses it
+// up, so it can't be replayed. The user is returned whenever it was found.
+func (s *AuthService) verifyTOTP(ctx context.Context, userID, code string) (*models.User, error) {
+	var user *models.User
+	err := s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		var err error
+		user, err = tx.User.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !user.MFAEnabled() {
+			return ErrMFANotEnrolled
+		}
+		step, ok := auth.ValidateTOTP(user.MFASecret, code, time.Now(), user.MFALastStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		user.MFALastStep = step
+		return tx.User.Update(ctx, user)
+	})
+	switch {
+	case err == nil:
+		return user, nil
+	case errors.Is(err, repository.ErrNotFound):
+		return nil, ErrUserNotFound
+	case errors.Is(err, ErrMFANotEnrolled), errors.Is(err, ErrInvalidMFACode):
+		logger.FromContext(ctx).Warn("invalid mfa code", "user_id", userID)
+		return user, err
+	}
+	logger.FromContext(ctx).Error("failed to verify mfa code", "error", err, "user_id", userID)
+	return user, ErrInternal
+}
+
+// StepUp re-authenticates the user within their current session and returns
+// a fresh token for it, as sensitive operations require. With a code the
+// token is raised to MFA. authTime is when the user last authenticated for
+// the token making the request; the codes tried since are limited.
+func (s *AuthService) StepUp(ctx context.Context, userID, sessionID string, authTime time.Time, req *models.StepUpRequest) (_ *AuthTokenResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.StepUp")
+	defer func() { endSpan(span, err) }()
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	amr := []string{auth.AMRPassword}
+	response, err := s.stepUp(ctx, userID, sessionID, authTime, req, &amr)
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID: userID,
+		Action:  models.AuditActionStepUp,
+		Details: map[string]string{"session_id": sessionID, "amr": strings.Join(amr, ",")},
+	}, err)
+	if err != nil {
+		return nil, err
+	}
+	logger.FromContext(ctx).Info("user stepped up authentication", "user_id", userID, "session_id", sessionID, "amr", amr)
+	return response, nil
+}
+
+func (s *AuthService) stepUp(ctx context.Context, userID, sessionID string, authTime time.Time, req *models.StepUpRequest, amr *[]string) (*AuthTokenResponse, error) {
+	user, err := s.repo.User.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		logger.FromContext(ctx).Error("failed to get user", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+	if !auth.CheckPasswordHash(ctx, req.Password, user.Password) {
+		logger.FromContext(ctx).Warn("invalid password on step-up", "user_id", userID)
+		// A wrong password counts as a failed login, as it does in Login
+		return nil, s.loginFailed(ctx, user.ID, user.Username, ErrInvalidCredentials)
+	}
+
+	// The new token replaces the old one and ends with the same session
+	session, err := s.repo.Session.GetByID(ctx, sessionID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logger.FromContext(ctx).Error("failed to get session", "error", err, "session_id", sessionID)
+		return nil, ErrInternal
+	}
+	if err != nil || session.UserID != userID || !session.Active(time.Now()) {
+		return nil, ErrSessionNotFound
+	}
+
+	if req.Code != "" {
+		if err := s.countStepUpAttempt(ctx, session, authTime); err != nil {
+			return nil, err
+		}
+		verified, err := s.verifyTOTP(ctx, userID, req.Code)
+		if err != nil {
+			// A wrong code counts as a failed login, as it does in CompleteMFA
+			return nil, s.loginFailed(ctx, user.ID, user.Username, err)
+		}
+		user = verified
+		*amr = append(*amr, auth.AMROTP, auth.AMRMFA)
+	}
+
+	token, err := auth.GenerateJWT(&auth.Claims{
+		Username:              user.Username,
+		UserID:                user.ID,
+		Role:                  user.Role,
+		Plan:                  user.Plan,
+		PasswordResetRequired: user.PasswordResetRequired,
+		SessionID:             session.ID,
+		ACR:                   acrFor(*amr),
+		AMR:                   *amr,
+	}, s.config.JWT.Secret, time.Until(session.ExpiresAt))
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to generate token", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+	return &AuthTokenResponse{
+		Token:     token,
+		ExpiresAt: session.ExpiresAt,
+		User:      user.ToResponse(),
+		SessionID: session.ID,
+	}, nil
+}
+
+// countStepUpAttempt counts a step-up code tried in session since authTime
+// against maxMFAAttempts. The attempts are kept in a challenge created with
+// the first code, which lasts as long as the session.
+func (s *AuthService) countStepUpAttempt(ctx context.Context, session *models.Session, authTime time.Time) error {
+	id := uuid.NewSHA1(stepUpNamespace, []byte(fmt.Sprintf("%s:%d", session.ID, authTime.Unix()))).String()
+	err := s.repo.LoginChallenge.Create(ctx, &models.LoginChallenge{
+		ID:        id,
+		UserID:    session.UserID,
+		Method:    models.LoginMethodMFA,
+		CreatedAt: authTime,
+		ExpiresAt: session.ExpiresAt,
+	})
+	if err != nil && !errors.Is(err, repository.ErrConflict) {
+		logger.FromContext(ctx).Error("failed to store step-up challenge", "error", err, "session_id", session.ID)
+		return ErrInternal
+	}
+
+	_, err = s.repo.LoginChallenge.IncrementAttempts(ctx, id, maxMFAAttempts)
+	switch {
+	case errors.Is(err, repository.ErrConflict), errors.Is(err, repository.ErrNotFound):
+		logger.FromContext(ctx).Warn("too many step-up attempts", "user_id", session.UserID, "session_id", session.ID)
+		return ErrMFAAttemptsExceeded
+	case err != nil:
+		logger.FromContext(ctx).Error("failed to count step-up attempt", "error", err, "session_id", session.ID)
+		return ErrInternal
+	}
+	return nil
+}
+
+// EnrollTOTP starts enrolling an authenticator app. The user confirms it with
+// a code from the app before it is required at login. Enrolling again before
+// confirming replaces the secret.
+func (s *AuthService) EnrollTOTP(ctx context.Context, userID string) (_ *MFAEnrollResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.EnrollTOTP")
+	defer func() { endSpan(span, err) }()
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to generate totp secret", "error", err)
+		return nil, ErrInternal
+	}
+
+	var user *models.User
+	err = s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		user, err = tx.User.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.MFAEnabled() {
+			return ErrMFAAlreadyEnabled
+		}
+		user.MFASecret = secret
+		user.MFALastStep = 0
+		return tx.User.Update(ctx, user)
+	})
+	switch {
+	case errors.Is(err, ErrMFAAlreadyEnabled):
+		return nil, err
+	case errors.Is(err, repository.ErrNotFound):
+		return nil, ErrUserNotFound
+	case err != nil:
+		logger.FromContext(ctx).Error("failed to enroll totp", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+
+	return &MFAEnrollResponse{
+		Secret: secret,
+		URI:    auth.TOTPURI(s.config.Risk.MFAIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the authenticator app being enrolled once the user
+// enters a code from it
+func (s *AuthService) ConfirmTOTP(ctx context.Context, userID string, req *models.MFACodeRequest) (_ *models.UserResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.ConfirmTOTP")
+	defer func() { endSpan(span, err) }()
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	err = s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		var err error
+		user, err = tx.User.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.MFAEnabled() {
+			return ErrMFAAlreadyEnabled
+		}
+		if user.MFASecret == "" {
+			return ErrMFANotEnrolled
+		}
+		step, ok := auth.ValidateTOTP(user.MFASecret, req.Code, time.Now(), user.MFALastStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		now := time.Now()
+		user.MFAEnabledAt = &now
+		user.MFALastStep = step
+		return tx.User.Update(ctx, user)
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrMFAAlreadyEnabled), errors.Is(err, ErrMFANotEnrolled), errors.Is(err, ErrInvalidMFACode):
+	case errors.Is(err, repository.ErrNotFound):
+		err = ErrUserNotFound
+	default:
+		logger.FromContext(ctx).Error("failed to confirm totp", "error", err, "user_id", userID)
+		err = ErrInternal
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID: userID,
+		Action:  models.AuditActionMFAEnroll,
+		Details: map[string]string{"method": "totp"},
+	}, err)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.FromContext(ctx).Info("mfa enabled", "user_id", userID)
+	return user.ToResponse(), nil
+}
+
+// DisableTOTP removes the user's authenticator app after checking a code
+// from it
+func (s *AuthService) DisableTOTP(ctx context.Context, userID string, req *models.MFACodeRequest) (_ *models.UserResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.DisableTOTP")
+	defer func() { endSpan(span, err) }()
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	user, err := s.verifyTOTP(ctx, userID, req.Code)
+	if err == nil {
+		user.MFASecret = ""
+		user.MFAEnabledAt = nil
+		user.MFALastStep = 0
+		if err = s.repo.User.Update(ctx, user); err != nil {
+			logger.FromContext(ctx).Error("failed to disable totp", "error", err, "user_id", userID)
+			err = ErrInternal
+		}
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID: userID,
+		Action:  models.AuditActionMFADisable,
+		Details: map[string]string{"method": "totp"},
+	}, err)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.FromContext(ctx).Info("mfa disabled", "user_id", userID)
+	return user.ToResponse(), nil
+}
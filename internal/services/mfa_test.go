@@ -0,0 +1,358 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/geoip"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"auth/internal/reqctx"
+	"auth/internal/risk"
+	"auth/internal/services"
+)
+
+// torExit is on the Tor exit list of the MFA tests
+const torExit = "198.51.100.66"
+
+type mfaFixture struct {
+	repo     *repository.Repository
+	auth     *services.AuthService
+	risk     *services.RiskService
+	userID   string
+	secret   string
+	deviceID string
+}
+
+// setupMFA signs a user up, logs in once from Berlin and enrolls an
+// authenticator app
+func setupMFA(t *testing.T) *mfaFixture {
+	t.Helper()
+	cfg := &config.Config{
+		JWT:     config.JWTConfig{Secret: "test-secret", Expiration: time.Hour},
+		Session: config.SessionConfig{TouchInterval: time.Minute, HistoryRetention: time.Hour, MaxTravelSpeed: 1000},
+		Risk: config.RiskConfig{
+			VelocityWindow:    time.Hour,
+			VelocityThreshold: 3,
+			MFAIssuer:         "Auth API",
+		},
+	}
+	log := logger.New("error")
+	repo := memory.NewRepository()
+	geo, err := geoip.Parse(strings.NewReader(alertsGeoIP))
+	if err != nil {
+		t.Fatalf("geoip.Parse() unexpected error: %v", err)
+	}
+	tor, err := risk.ParseIPList(strings.NewReader(torExit + "\n"))
+	if err != nil {
+		t.Fatalf("risk.ParseIPList() unexpected error: %v", err)
+	}
+	engine := risk.New(risk.Rules{
+		MFAThreshold:          30,
+		DenyThreshold:         80,
+		VelocityThreshold:     3,
+		VelocityScore:         40,
+		NewDeviceScore:        20,
+		NewCountryScore:       30,
+		ImpossibleTravelScore: 60,
+		TorExits:              tor,
+		TorExitScore:          40,
+	})
+
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, geo, cfg, log)
+	riskService := services.NewRiskService(repo, auditService, engine, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, riskService, notify.NewLog(log), cfg, log)
+	user, err := authService.SignUp(context.Background(), &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("SignUp() unexpected error: %v", err)
+	}
+
+	f := &mfaFixture{repo: repo, auth: authService, risk: riskService, userID: user.ID}
+	f.deviceID = loginFrom(t, authService, "192.0.2.1", "").DeviceID
+
+	enrollment, err := authService.EnrollTOTP(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("EnrollTOTP() unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") {
+		t.Errorf("URI = %q", enrollment.URI)
+	}
+	f.secret = enrollment.Secret
+	confirmed, err := authService.ConfirmTOTP(context.Background(), user.ID, &models.MFACodeRequest{Code: f.code(t)})
+	if err != nil {
+		t.Fatalf("ConfirmTOTP() unexpected error: %v", err)
+	}
+	if !confirmed.MFAEnabled {
+		t.Fatal("ConfirmTOTP() did not enable MFA")
+	}
+	return f
+}
+
+// code returns a current code from the authenticator app. The record of used
+// codes is cleared first, so each call can be accepted once.
+func (f *mfaFixture) code(t *testing.T) string {
+	t.Helper()
+	user, err := f.repo.User.GetByID(context.Background(), f.userID)
+	if err != nil {
+		t.Fatalf("GetByID() unexpected error: %v", err)
+	}
+	user.MFALastStep = 0
+	if err := f.repo.User.Update(context.Background(), user); err != nil {
+		t.Fatalf("Update() unexpected error: %v", err)
+	}
+	code, err := auth.TOTPCode(f.secret, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode() unexpected error: %v", err)
+	}
+	return code
+}
+
+// requestFrom returns the context of a request from the user's browser at ip
+func (f *mfaFixture) requestFrom(ip string) context.Context {
+	return reqctx.WithInfo(context.Background(), reqctx.Info{IP: ip, UserAgent: "Mozilla/5.0 Firefox/120.0", DeviceID: f.deviceID})
+}
+
+func (f *mfaFixture) login(ip string) (*services.AuthTokenResponse, error) {
+	return f.auth.Login(f.requestFrom(ip), &models.LoginRequest{Username: "testuser", Password: "password123"})
+}
+
+func tokenClaims(t *testing.T, token string) *auth.Claims {
+	t.Helper()
+	claims, err := auth.ValidateJWT(token, "test-secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT() unexpected error: %v", err)
+	}
+	return claims
+}
+
+func TestAdaptiveLogin(t *testing.T) {
+	f := setupMFA(t)
+	ctx := f.requestFrom(torExit)
+
+	// A login from a known device and place needs nothing more
+	response, err := f.login("192.0.2.2")
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	if claims := tokenClaims(t, response.Token); claims.ACR != auth.ACRPassword || !slices.Equal(claims.AMR, []string{auth.AMRPassword}) {
+		t.Errorf("claims acr = %q, amr = %v, want password login", claims.ACR, claims.AMR)
+	}
+
+	// A Tor exit node calls for the second factor
+	_, err = f.login(torExit)
+	var mfaErr *services.MFARequiredError
+	if !errors.As(err, &mfaErr) || mfaErr.Token == "" {
+		t.Fatalf("Login() from a Tor exit error = %v, want MFARequiredError", err)
+	}
+
+	_, err = f.auth.CompleteMFA(ctx, &models.MFALoginRequest{MFAToken: mfaErr.Token, Code: "000000"})
+	if !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Errorf("CompleteMFA() with a wrong code error = %v, want ErrInvalidMFACode", err)
+	}
+	_, err = f.auth.CompleteMFA(ctx, &models.MFALoginRequest{MFAToken: "not-a-token", Code: f.code(t)})
+	if !errors.Is(err, services.ErrInvalidMFAToken) {
+		t.Errorf("CompleteMFA() with an invalid token error = %v, want ErrInvalidMFAToken", err)
+	}
+
+	code := f.code(t)
+	response, err = f.auth.CompleteMFA(ctx, &models.MFALoginRequest{MFAToken: mfaErr.Token, Code: code})
+	if err != nil {
+		t.Fatalf("CompleteMFA() unexpected error: %v", err)
+	}
+	claims := tokenClaims(t, response.Token)
+	if claims.ACR != auth.ACRMFA || !slices.Contains(claims.AMR, auth.AMROTP) || claims.SessionID == "" {
+		t.Errorf("claims = %+v, want an MFA login with a session", claims)
+	}
+
+	// Codes can't be replayed
+	_, err = f.auth.CompleteMFA(ctx, &models.MFALoginRequest{MFAToken: mfaErr.Token, Code: code})
+	if !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Errorf("CompleteMFA() with a used code error = %v, want ErrInvalidMFACode", err)
+	}
+
+	// Another continent within seconds is refused, second factor or not
+	if _, err := f.login("203.0.113.1"); !errors.Is(err, services.ErrLoginDenied) {
+		t.Errorf("Login() with impossible travel error = %v, want ErrLoginDenied", err)
+	}
+}
+
+func TestCompleteMFAAttemptLimit(t *testing.T) {
+	f := setupMFA(t)
+	ctx := f.requestFrom(torExit)
+
+	_, err := f.login(torExit)
+	var mfaErr *services.MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		t.Fatalf("Login() error = %v, want MFARequiredError", err)
+	}
+	for range 5 {
+		f.auth.CompleteMFA(ctx, &models.MFALoginRequest{MFAToken: mfaErr.Token, Code: "000000"})
+	}
+	_, err = f.auth.CompleteMFA(ctx, &models.MFALoginRequest{MFAToken: mfaErr.Token, Code: f.code(t)})
+	if !errors.Is(err, services.ErrInvalidMFAToken) {
+		t.Errorf("CompleteMFA() after too many attempts error = %v, want ErrInvalidMFAToken", err)
+	}
+
+	// The attempts are counted by the token's challenge, not the audit log
+	claims, err := auth.ValidateMFAToken(mfaErr.Token, "test-secret")
+	if err != nil {
+		t.Fatalf("ValidateMFAToken() unexpected error: %v", err)
+	}
+	challenge, err := f.repo.LoginChallenge.GetByID(context.Background(), claims.ID)
+	if err != nil {
+		t.Fatalf("GetByID() unexpected error: %v", err)
+	}
+	if challenge.Method != models.LoginMethodMFA || challenge.Attempts != 5 {
+		t.Errorf("challenge method = %q, attempts = %d, want mfa with 5 attempts", challenge.Method, challenge.Attempts)
+	}
+}
+
+func TestRequiredACR(t *testing.T) {
+	f := setupMFA(t)
+	ctx := reqctx.WithInfo(context.Background(), reqctx.Info{IP: "192.0.2.1"})
+
+	acr, err := f.risk.RequiredACR(ctx, f.userID, risk.OperationPasswordChange)
+	if err != nil || acr != auth.ACRPassword {
+		t.Errorf("RequiredACR() = %q, %v, want %q", acr, err, auth.ACRPassword)
+	}
+
+	// Failed logins raise the risk of the account's sensitive operations
+	for range 3 {
+		f.auth.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "wrong-password"})
+	}
+	acr, err = f.risk.RequiredACR(ctx, f.userID, risk.OperationPasswordChange)
+	if err != nil || acr != auth.ACRMFA {
+		t.Errorf("RequiredACR() after failed logins = %q, %v, want %q", acr, err, auth.ACRMFA)
+	}
+
+	// The Tor exit adds enough to refuse the operation
+	torCtx := reqctx.WithInfo(context.Background(), reqctx.Info{IP: torExit})
+	if _, err := f.risk.RequiredACR(torCtx, f.userID, risk.OperationAccountDelete); !errors.Is(err, risk.ErrDenied) {
+		t.Errorf("RequiredACR() from a Tor exit error = %v, want risk.ErrDenied", err)
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	f := setupMFA(t)
+	ctx := context.Background()
+
+	login, err := f.login("192.0.2.2")
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	original := tokenClaims(t, login.Token)
+
+	authTime := original.AuthTime.Time
+
+	_, err = f.auth.StepUp(ctx, f.userID, original.SessionID, authTime, &models.StepUpRequest{Password: "wrong-password"})
+	if !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("StepUp() with a wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+	_, err = f.auth.StepUp(ctx, f.userID, original.SessionID, authTime, &models.StepUpRequest{Password: "password123", Code: "000000"})
+	if !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Errorf("StepUp() with a wrong code error = %v, want ErrInvalidMFACode", err)
+	}
+	// The wrong password and code count towards the failed logins risk rules
+	// see
+	failed, err := f.repo.Audit.List(ctx, repository.AuditFilter{
+		ActorID: f.userID,
+		Action:  models.AuditActionLogin,
+		Outcome: models.AuditOutcomeFailure,
+	})
+	if err != nil {
+		t.Fatalf("List() unexpected error: %v", err)
+	}
+	if len(failed.Events) != 2 {
+		t.Errorf("recorded %d failed logins, want 2", len(failed.Events))
+	}
+
+	response, err := f.auth.StepUp(ctx, f.userID, original.SessionID, authTime, &models.StepUpRequest{Password: "password123", Code: f.code(t)})
+	if err != nil {
+		t.Fatalf("StepUp() unexpected error: %v", err)
+	}
+	claims := tokenClaims(t, response.Token)
+	if claims.ACR != auth.ACRMFA || claims.SessionID != original.SessionID {
+		t.Errorf("claims acr = %q, session = %q, want %q in the same session", claims.ACR, claims.SessionID, auth.ACRMFA)
+	}
+	if claims.AuthTime == nil || claims.AuthTime.Before(original.AuthTime.Time) {
+		t.Errorf("auth_time = %v, want a fresh time", claims.AuthTime)
+	}
+	if !response.ExpiresAt.Equal(login.ExpiresAt) && response.ExpiresAt.Sub(login.ExpiresAt).Abs() > time.Second {
+		t.Errorf("ExpiresAt = %v, want the session's %v", response.ExpiresAt, login.ExpiresAt)
+	}
+
+	// Steps up are only possible within a session of the user
+	_, err = f.auth.StepUp(ctx, f.userID, "unknown-session", authTime, &models.StepUpRequest{Password: "password123"})
+	if !errors.Is(err, services.ErrSessionNotFound) {
+		t.Errorf("StepUp() for an unknown session error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestStepUpAttemptLimit(t *testing.T) {
+	f := setupMFA(t)
+	ctx := context.Background()
+
+	login, err := f.login("192.0.2.2")
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	claims := tokenClaims(t, login.Token)
+	authTime := claims.AuthTime.Time
+
+	for range 5 {
+		f.auth.StepUp(ctx, f.userID, claims.SessionID, authTime, &models.StepUpRequest{Password: "password123", Code: "000000"})
+	}
+	_, err = f.auth.StepUp(ctx, f.userID, claims.SessionID, authTime, &models.StepUpRequest{Password: "password123", Code: f.code(t)})
+	if !errors.Is(err, services.ErrMFAAttemptsExceeded) {
+		t.Errorf("StepUp() after too many attempts error = %v, want ErrMFAAttemptsExceeded", err)
+	}
+
+	// A token from a later authentication has attempts of its own
+	_, err = f.auth.StepUp(ctx, f.userID, claims.SessionID, authTime.Add(time.Minute), &models.StepUpRequest{Password: "password123", Code: f.code(t)})
+	if err != nil {
+		t.Errorf("StepUp() with a later token unexpected error: %v", err)
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	f := setupMFA(t)
+	ctx := context.Background()
+
+	if _, err := f.auth.EnrollTOTP(ctx, f.userID); !errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		t.Errorf("EnrollTOTP() while enabled error = %v, want ErrMFAAlreadyEnabled", err)
+	}
+	if _, err := f.auth.DisableTOTP(ctx, f.userID, &models.MFACodeRequest{Code: "000000"}); !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Errorf("DisableTOTP() with a wrong code error = %v, want ErrInvalidMFACode", err)
+	}
+
+	user, err := f.auth.DisableTOTP(ctx, f.userID, &models.MFACodeRequest{Code: f.code(t)})
+	if err != nil {
+		t.Fatalf("DisableTOTP() unexpected error: %v", err)
+	}
+	if user.MFAEnabled {
+		t.Error("DisableTOTP() left MFA enabled")
+	}
+
+	// Without MFA a risky login goes ahead rather than locking the user out
+	response, err := f.login(torExit)
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	if claims := tokenClaims(t, response.Token); claims.ACR != auth.ACRPassword {
+		t.Errorf("acr = %q, want %q", claims.ACR, auth.ACRPassword)
+	}
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository"
+	"auth/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPasswordlessDisabled  = errors.New("passwordless login disabled")
+	ErrInvalidLoginChallenge = errors.New("invalid or expired login code")
+)
+
+// errEmailLoginLimited is recorded when an account was sent too many links
+// or codes. The client is not told, so it can't probe for accounts.
+var errEmailLoginLimited = errors.New("too many email logins requested")
+
+// PasswordlessService logs users in with a magic link or a 6-digit code sent
+// to their email address. A login can only be redeemed once, before it
+// expires, from the browser that requested it.
+type PasswordlessService struct {
+	repo   *repository.Repository
+	auth   *AuthService
+	audit  *AuditService
+	mailer notify.Notifier
+	config config.PasswordlessConfig
+	// enabled is keyed by tenant; requests outside the configured tenants
+	// use config.Enabled
+	enabled map[string]bool
+	logger  *logger.Logger
+}
+
+// EmailLoginResponse is returned whether or not the address belongs to an
+// account, so it can't be used to find out which addresses do
+type EmailLoginResponse struct {
+	// ChallengeID is sent back with the code
+	ChallengeID string    `json:"challenge_id"`
+	Method      string    `json:"method"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Binding is the cookie the requesting browser must present to redeem
+	// the login
+	Binding string `json:"-"`
+}
+
+// NewPasswordlessService creates the service. mailer must only deliver to
+// the user's inbox, since the notifications it is given carry the secret.
+func NewPasswordlessService(repo *repository.Repository, authService *AuthService, audit *AuditService, mailer notify.Notifier, cfg *config.Config, logger *logger.Logger) *PasswordlessService {
+	enabled := make(map[string]bool, len(cfg.Tenants))
+	for _, tenant := range cfg.Tenants {
+		enabled[tenant.Name] = tenant.Passwordless
+	}
+	return &PasswordlessService{
+		repo:    repo,
+		auth:    authService,
+		audit:   audit,
+		mailer:  mailer,
+		config:  cfg.Passwordless,
+		enabled: enabled,
+		logger:  logger,
+	}
+}
+
+// isEnabled reports whether passwordless login is enabled for the tenant of
+// the request
+func (s *PasswordlessService) isEnabled(ctx context.Context) bool {
+	if enabled, ok := s.enabled[reqctx.FromContext(ctx).Tenant]; ok {
+		return enabled
+	}
+	return s.config.Enabled
+}
+
+// RequestEmailLogin sends a magic link or code to the account with the
+// given address. binding is the browser's binding cookie; a new one is
+// generated when it is empty.
+func (s *PasswordlessService) RequestEmailLogin(ctx context.Context, req *models.EmailLoginRequest, binding string) (*EmailLoginResponse, error) {
+	if !s.isEnabled(ctx) {
+		return nil, ErrPasswordlessDisabled
+	}
+	if err := req.Validate(); err != nil {
+		logger.FromContext(ctx).Warn("validation failed", "error", err)
+		return nil, err
+	}
+	if req.Method == "" {
+		req.Method = models.LoginMethodLink
+	}
+	if binding == "" {
+		var err error
+		if binding, err = randomToken(); err != nil {
+			logger.FromContext(ctx).Error("failed to generate login binding", "error", err)
+			return nil, ErrInternal
+		}
+	}
+
+	now := time.Now()
+	response := &EmailLoginResponse{
+		ChallengeID: uuid.New().String(),
+		Method:      req.Method,
+		ExpiresAt:   now.Add(s.config.TTL),
+		Binding:     binding,
+	}
+
+	user, err := s.repo.User.GetByEmail(ctx, req.Email)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			logger.FromContext(ctx).Error("failed to look up user", "error", err)
+			return nil, ErrInternal
+		}
+		logger.FromContext(ctx).Warn("email login requested for unknown address")
+		s.recordRequest(ctx, "", req.Method, ErrUserNotFound)
+		return response, nil
+	}
+	if !user.CanLogin() {
+		logger.FromContext(ctx).Warn("email login requested for inactive account", "user_id", user.ID, "status", user.Status)
+		s.recordRequest(ctx, user.ID, req.Method, inactiveAccountError(user))
+		return response, nil
+	}
+
+	sent, err := s.repo.LoginChallenge.CountByUserSince(ctx, user.ID, now.Add(-s.config.SendWindow), models.LoginMethodLink, models.LoginMethodCode)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to count email logins", "error", err, "user_id", user.ID)
+		return nil, ErrInternal
+	}
+	if sent >= s.config.MaxSends {
+		logger.FromContext(ctx).Warn("too many email logins requested", "user_id", user.ID)
+		s.recordRequest(ctx, user.ID, req.Method, errEmailLoginLimited)
+		return response, nil
+	}
+
+	secret, err := newLoginSecret(req.Method)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to generate login secret", "error", err, "user_id", user.ID)
+		return nil, ErrInternal
+	}
+	challenge := &models.LoginChallenge{
+		ID:          response.ChallengeID,
+		UserID:      user.ID,
+		Method:      req.Method,
+		SecretHash:  hashSecret(secret),
+		BindingHash: hashSecret(binding),
+		CreatedAt:   now,
+		ExpiresAt:   response.ExpiresAt,
+	}
+	if err := s.repo.LoginChallenge.Create(ctx, challenge); err != nil {
+		logger.FromContext(ctx).Error("failed to create login challenge", "error", err, "user_id", user.ID)
+		return nil, ErrInternal
+	}
+
+	if err := s.send(ctx, user, challenge, secret); err != nil {
+		logger.FromContext(ctx).Error("failed to send email login", "error", err, "user_id", user.ID)
+		s.recordRequest(ctx, user.ID, req.Method, ErrInternal)
+		return nil, ErrInternal
+	}
+	s.recordRequest(ctx, user.ID, req.Method, nil)
+	logger.FromContext(ctx).Info("email login sent", "user_id", user.ID, "method", req.Method, "challenge_id", challenge.ID)
+	return response, nil
+}
+
+// send mails the link or code to the user
+func (s *PasswordlessService) send(ctx context.Context, user *models.User, challenge *models.LoginChallenge, secret string) error {
+	minutes := int(s.config.TTL.Round(time.Minute) / time.Minute)
+
+	var subject string
+	var text strings.Builder
+	fmt.Fprintf(&text, "Hi %s,\n\n", user.Username)
+	if challenge.Method == models.LoginMethodCode {
+		subject = "Your sign-in code"
+		fmt.Fprintf(&text, "Enter this code to sign in:\n\n    %s\n\n", secret)
+	} else {
+		subject = "Your sign-in link"
+		link := s.config.LinkURL + url.QueryEscape(challenge.ID+"."+secret)
+		fmt.Fprintf(&text, "Open this link to sign in:\n\n%s\n\n", link)
+	}
+	fmt.Fprintf(&text, "It works once, for %d minutes, in the browser you requested it from.\n", minutes)
+	text.WriteString("If you didn't ask to sign in, you can ignore this email.\n")
+
+	return s.mailer.Notify(ctx, &notify.Notification{
+		Kind:     notify.KindEmailLogin,
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Subject:  subject,
+		Text:     text.String(),
+		// Never the secret: Data is meant for machine consumers
+		Data: map[string]string{
+			"challenge_id": challenge.ID,
+			"method":       challenge.Method,
+			"expires_at":   challenge.ExpiresAt.UTC().Format(time.RFC3339),
+		},
+		Time: challenge.CreatedAt,
+	})
+}
+
+// VerifyEmailLogin redeems a magic link or code and logs the user in.
+// binding is the cookie of the browser presenting it.
+func (s *PasswordlessService) VerifyEmailLogin(ctx context.Context, req *models.VerifyEmailLoginRequest, binding string) (*AuthTokenResponse, error) {
+	if !s.isEnabled(ctx) {
+		return nil, ErrPasswordlessDisabled
+	}
+	if err := req.Validate(); err != nil {
+		logger.FromContext(ctx).Warn("validation failed", "error", err)
+		return nil, err
+	}
+
+	method, id, secret := models.LoginMethodCode, req.ChallengeID, req.Code
+	if req.Token != "" {
+		method = models.LoginMethodLink
+		var ok bool
+		if id, secret, ok = strings.Cut(req.Token, "."); !ok {
+			logger.FromContext(ctx).Warn("malformed email login token")
+			return nil, ErrInvalidLoginChallenge
+		}
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		logger.FromContext(ctx).Warn("malformed login challenge id")
+		return nil, ErrInvalidLoginChallenge
+	}
+
+	challenge, err := s.repo.LoginChallenge.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			logger.FromContext(ctx).Warn("login challenge not found", "challenge_id", id)
+			return nil, ErrInvalidLoginChallenge
+		}
+		logger.FromContext(ctx).Error("failed to get login challenge", "error", err, "challenge_id", id)
+		return nil, ErrInternal
+	}
+
+	now := time.Now()
+	switch {
+	case challenge.Method != method, !challenge.Usable(now), challenge.Attempts >= s.config.MaxAttempts:
+		logger.FromContext(ctx).Warn("login challenge can't be redeemed", "challenge_id", id, "user_id", challenge.UserID)
+		return nil, ErrInvalidLoginChallenge
+	case binding == "" || !secretMatches(binding, challenge.BindingHash):
+		// A link opened in another browser is not a guess, so it doesn't
+		// use up an attempt
+		logger.FromContext(ctx).Warn("login challenge redeemed from another browser", "challenge_id", id, "user_id", challenge.UserID)
+		return nil, ErrInvalidLoginChallenge
+	}
+
+	user, err := s.repo.User.GetByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrInvalidLoginChallenge
+		}
+		logger.FromContext(ctx).Error("failed to get user", "error", err, "user_id", challenge.UserID)
+		return nil, ErrInternal
+	}
+
+	// Every attempt is counted before the secret is compared, and the count
+	// can't pass MaxAttempts, so concurrent guesses can't get past the limit
+	// that was checked above
+	if _, err := s.repo.LoginChallenge.IncrementAttempts(ctx, id, s.config.MaxAttempts); err != nil {
+		if errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrNotFound) {
+			logger.FromContext(ctx).Warn("login challenge attempts used up", "challenge_id", id, "user_id", user.ID)
+			return nil, s.auth.loginFailed(ctx, user.ID, user.Username, ErrInvalidLoginChallenge)
+		}
+		logger.FromContext(ctx).Error("failed to count login challenge attempt", "error", err, "challenge_id", id)
+		return nil, ErrInternal
+	}
+	if !secretMatches(secret, challenge.SecretHash) {
+		logger.FromContext(ctx).Warn("invalid email login code", "challenge_id", id, "user_id", user.ID)
+		return nil, s.auth.loginFailed(ctx, user.ID, user.Username, ErrInvalidLoginChallenge)
+	}
+
+	if err := s.repo.LoginChallenge.Consume(ctx, id, now); err != nil {
+		if errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrNotFound) {
+			logger.FromContext(ctx).Warn("login challenge already used", "challenge_id", id, "user_id", user.ID)
+			return nil, s.auth.loginFailed(ctx, user.ID, user.Username, ErrInvalidLoginChallenge)
+		}
+		logger.FromContext(ctx).Error("failed to consume login challenge", "error", err, "challenge_id", id)
+		return nil, ErrInternal
+	}
+
+	if !user.CanLogin() {
+		logger.FromContext(ctx).Warn("login to inactive account", "user_id", user.ID, "status", user.Status)
+		return nil, s.auth.loginFailed(ctx, user.ID, user.Username, inactiveAccountError(user))
+	}
+
+	return s.auth.completeLogin(ctx, user, []string{auth.AMREmail})
+}
+
+// recordRequest writes the audit event of a link or code request
+func (s *PasswordlessService) recordRequest(ctx context.Context, userID, method string, err error) {
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID: userID,
+		Action:  models.AuditActionEmailLoginRequest,
+		Details: map[string]string{"method": method},
+	}, err)
+}
+
+// newLoginSecret returns the secret of a new login: a 6-digit code, or a
+// random token for a magic link
+func newLoginSecret(method string) (string, error) {
+	if method == models.LoginMethodCode {
+		n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%06d", n.Int64()), nil
+	}
+	return randomToken()
+}
+
+// randomToken returns 256 random bits, URL-safe encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// secretMatches compares secret with a stored hash in constant time
+func secretMatches(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(hash)) == 1
+}
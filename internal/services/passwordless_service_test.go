@@ -0,0 +1,355 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"auth/internal/reqctx"
+	"auth/internal/services"
+)
+
+var emailCodePattern = regexp.MustCompile(`\b\d{6}\b`)
+
+func setupPasswordless(t *testing.T, passwordless config.PasswordlessConfig) (*services.PasswordlessService, *notificationRecorder) {
+	t.Helper()
+	return setupPasswordlessRepo(t, passwordless, nil, memory.NewRepository())
+}
+
+func setupPasswordlessRepo(t *testing.T, passwordless config.PasswordlessConfig, tenants []config.TenantConfig, repo *repository.Repository) (*services.PasswordlessService, *notificationRecorder) {
+	t.Helper()
+	cfg := &config.Config{
+		JWT:          config.JWTConfig{Secret: "test-secret", Expiration: time.Hour},
+		Session:      config.SessionConfig{TouchInterval: time.Minute, HistoryRetention: time.Hour, MaxTravelSpeed: 1000},
+		Passwordless: passwordless,
+		Tenants:      tenants,
+	}
+	log := logger.New("error")
+
+	mailer := &notificationRecorder{}
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, nil, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, services.NewRiskService(repo, auditService, nil, cfg, log), &notificationRecorder{}, cfg, log)
+	_, err := authService.SignUp(context.Background(), &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("SignUp() unexpected error: %v", err)
+	}
+	return services.NewPasswordlessService(repo, authService, auditService, mailer, cfg, log), mailer
+}
+
+func passwordlessConfig() config.PasswordlessConfig {
+	return config.PasswordlessConfig{
+		Enabled:     true,
+		TTL:         10 * time.Minute,
+		LinkURL:     "https://example.com/login/email?token=",
+		MaxAttempts: 3,
+		MaxSends:    3,
+		SendWindow:  time.Hour,
+	}
+}
+
+// sentSecret returns the code or link token of the only login email sent
+// since the last call
+func sentSecret(t *testing.T, mailer *notificationRecorder) string {
+	t.Helper()
+	sent := mailer.take()
+	if len(sent) != 1 || sent[0].Kind != notify.KindEmailLogin || sent[0].Email != "test@example.com" {
+		t.Fatalf("sent %+v, want one email_login notification", sent)
+	}
+	secret := emailCodePattern.FindString(sent[0].Text)
+	if i := strings.Index(sent[0].Text, "https://example.com/login/email?token="); i >= 0 {
+		link, err := url.Parse(strings.Fields(sent[0].Text[i:])[0])
+		if err != nil {
+			t.Fatalf("invalid login link: %v", err)
+		}
+		secret = link.Query().Get("token")
+	}
+	if secret == "" {
+		t.Fatalf("no code or link in %q", sent[0].Text)
+	}
+	// Data goes to machine consumers and must not leak the secret
+	for key, value := range sent[0].Data {
+		if strings.Contains(value, secret) {
+			t.Errorf("notification data %s = %q contains the secret", key, value)
+		}
+	}
+	return secret
+}
+
+func TestEmailLoginCode(t *testing.T) {
+	service, mailer := setupPasswordless(t, passwordlessConfig())
+	ctx := reqctx.WithInfo(context.Background(), reqctx.Info{IP: "192.0.2.1"})
+
+	requested, err := service.RequestEmailLogin(ctx, &models.EmailLoginRequest{Email: "test@example.com", Method: models.LoginMethodCode}, "")
+	if err != nil {
+		t.Fatalf("RequestEmailLogin() unexpected error: %v", err)
+	}
+	if requested.Binding == "" || requested.Method != models.LoginMethodCode {
+		t.Fatalf("RequestEmailLogin() = %+v", requested)
+	}
+	code := sentSecret(t, mailer)
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	verify := func(code, binding string) (*services.AuthTokenResponse, error) {
+		return service.VerifyEmailLogin(ctx, &models.VerifyEmailLoginRequest{ChallengeID: requested.ChallengeID, Code: code}, binding)
+	}
+
+	// Another browser can't redeem the code, and doesn't use up attempts
+	for i := 0; i < 5; i++ {
+		if _, err := verify(code, "other-browser"); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+			t.Fatalf("VerifyEmailLogin() from another browser error = %v, want ErrInvalidLoginChallenge", err)
+		}
+	}
+	if _, err := verify(wrong, requested.Binding); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+		t.Fatalf("VerifyEmailLogin() with a wrong code error = %v, want ErrInvalidLoginChallenge", err)
+	}
+
+	response, err := verify(code, requested.Binding)
+	if err != nil {
+		t.Fatalf("VerifyEmailLogin() unexpected error: %v", err)
+	}
+	if response.User.Username != "testuser" || response.DeviceID == "" {
+		t.Errorf("VerifyEmailLogin() = %+v", response)
+	}
+	claims := tokenClaims(t, response.Token)
+	if len(claims.AMR) != 1 || claims.AMR[0] != auth.AMREmail || claims.ACR != auth.ACRPassword {
+		t.Errorf("token amr = %v, acr = %q, want [email] and aal1", claims.AMR, claims.ACR)
+	}
+
+	// A code works once
+	if _, err := verify(code, requested.Binding); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+		t.Errorf("second VerifyEmailLogin() error = %v, want ErrInvalidLoginChallenge", err)
+	}
+}
+
+func TestEmailLoginLink(t *testing.T) {
+	service, mailer := setupPasswordless(t, passwordlessConfig())
+	ctx := context.Background()
+
+	requested, err := service.RequestEmailLogin(ctx, &models.EmailLoginRequest{Email: "test@example.com"}, "browser")
+	if err != nil {
+		t.Fatalf("RequestEmailLogin() unexpected error: %v", err)
+	}
+	if requested.Binding != "browser" || requested.Method != models.LoginMethodLink {
+		t.Fatalf("RequestEmailLogin() = %+v, want a link bound to the existing cookie", requested)
+	}
+	token := sentSecret(t, mailer)
+	if !strings.HasPrefix(token, requested.ChallengeID+".") {
+		t.Fatalf("link token %q does not name challenge %s", token, requested.ChallengeID)
+	}
+
+	// The challenge ID alone is not enough, and a link is not a code
+	code := &models.VerifyEmailLoginRequest{ChallengeID: requested.ChallengeID, Code: strings.TrimPrefix(token, requested.ChallengeID+".")}
+	if _, err := service.VerifyEmailLogin(ctx, code, "browser"); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+		t.Errorf("VerifyEmailLogin() of a link as a code error = %v, want ErrInvalidLoginChallenge", err)
+	}
+	for _, invalid := range []string{"not-a-token", requested.ChallengeID + ".wrong", "x." + token} {
+		_, err := service.VerifyEmailLogin(ctx, &models.VerifyEmailLoginRequest{Token: invalid}, "browser")
+		if !errors.Is(err, services.ErrInvalidLoginChallenge) {
+			t.Errorf("VerifyEmailLogin(%.20q) error = %v, want ErrInvalidLoginChallenge", invalid, err)
+		}
+	}
+
+	response, err := service.VerifyEmailLogin(ctx, &models.VerifyEmailLoginRequest{Token: token}, "browser")
+	if err != nil {
+		t.Fatalf("VerifyEmailLogin() unexpected error: %v", err)
+	}
+	if response.User.Username != "testuser" {
+		t.Errorf("VerifyEmailLogin() user = %+v", response.User)
+	}
+}
+
+func TestEmailLoginLimits(t *testing.T) {
+	cfg := passwordlessConfig()
+	cfg.MaxAttempts = 2
+	cfg.MaxSends = 2
+	service, mailer := setupPasswordless(t, cfg)
+	ctx := context.Background()
+	request := &models.EmailLoginRequest{Email: "test@example.com", Method: models.LoginMethodCode}
+
+	first, err := service.RequestEmailLogin(ctx, request, "browser")
+	if err != nil {
+		t.Fatalf("RequestEmailLogin() unexpected error: %v", err)
+	}
+	code := sentSecret(t, mailer)
+
+	// Wrong codes lock the challenge, even for the right one
+	for i := 0; i < cfg.MaxAttempts; i++ {
+		wrong := &models.VerifyEmailLoginRequest{ChallengeID: first.ChallengeID, Code: "12345" + string(rune('a'+i))}
+		if _, err := service.VerifyEmailLogin(ctx, wrong, "browser"); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+			t.Fatalf("VerifyEmailLogin() with a wrong code error = %v", err)
+		}
+	}
+	right := &models.VerifyEmailLoginRequest{ChallengeID: first.ChallengeID, Code: code}
+	if _, err := service.VerifyEmailLogin(ctx, right, "browser"); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+		t.Errorf("VerifyEmailLogin() after too many attempts error = %v, want ErrInvalidLoginChallenge", err)
+	}
+
+	if _, err := service.RequestEmailLogin(ctx, request, "browser"); err != nil {
+		t.Fatalf("RequestEmailLogin() unexpected error: %v", err)
+	}
+	sentSecret(t, mailer)
+
+	// Past the limit the response looks the same but nothing is sent
+	limited, err := service.RequestEmailLogin(ctx, request, "browser")
+	if err != nil || limited.ChallengeID == "" {
+		t.Fatalf("RequestEmailLogin() over the limit = %+v, %v", limited, err)
+	}
+	if sent := mailer.take(); len(sent) != 0 {
+		t.Errorf("RequestEmailLogin() over the limit sent %d emails", len(sent))
+	}
+	fake := &models.VerifyEmailLoginRequest{ChallengeID: limited.ChallengeID, Code: code}
+	if _, err := service.VerifyEmailLogin(ctx, fake, "browser"); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+		t.Errorf("VerifyEmailLogin() of an unsent challenge error = %v, want ErrInvalidLoginChallenge", err)
+	}
+}
+
+func TestEmailLoginConcurrentAttempts(t *testing.T) {
+	cfg := passwordlessConfig()
+	repo := memory.NewRepository()
+	service, mailer := setupPasswordlessRepo(t, cfg, nil, repo)
+	ctx := context.Background()
+
+	challenge, err := service.RequestEmailLogin(ctx, &models.EmailLoginRequest{Email: "test@example.com", Method: models.LoginMethodCode}, "browser")
+	if err != nil {
+		t.Fatalf("RequestEmailLogin() unexpected error: %v", err)
+	}
+	code := sentSecret(t, mailer)
+
+	// Guesses made at once all see a challenge under the limit, but only
+	// MaxAttempts of them may be counted and compared
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wrong := &models.VerifyEmailLoginRequest{ChallengeID: challenge.ChallengeID, Code: fmt.Sprintf("x%05d", i)}
+			if _, err := service.VerifyEmailLogin(ctx, wrong, "browser"); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+				t.Errorf("VerifyEmailLogin() with a wrong code error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stored, err := repo.LoginChallenge.GetByID(ctx, challenge.ChallengeID)
+	if err != nil {
+		t.Fatalf("GetByID() unexpected error: %v", err)
+	}
+	if stored.Attempts != cfg.MaxAttempts {
+		t.Errorf("challenge attempts = %d, want %d", stored.Attempts, cfg.MaxAttempts)
+	}
+	right := &models.VerifyEmailLoginRequest{ChallengeID: challenge.ChallengeID, Code: code}
+	if _, err := service.VerifyEmailLogin(ctx, right, "browser"); !errors.Is(err, services.ErrInvalidLoginChallenge) {
+		t.Errorf("VerifyEmailLogin() after concurrent guesses error = %v, want ErrInvalidLoginChallenge", err)
+	}
+}
+
+func TestEmailLoginExpired(t *testing.T) {
+	cfg := passwordlessConfig()
+	cfg.TTL = time.Millisecond
+	service, mailer := setupPasswordless(t, cfg)
+	ctx := context.Background()
+
+	requested, err := service.RequestEmailLogin(ctx, &models.EmailLoginRequest{Email: "test@example.com", Method: models.LoginMethodCode}, "browser")
+	if err != nil {
+		t.Fatalf("RequestEmailLogin() unexpected error: %v", err)
+	}
+	code := sentSecret(t, mailer)
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = service.VerifyEmailLogin(ctx, &models.VerifyEmailLoginRequest{ChallengeID: requested.ChallengeID, Code: code}, "browser")
+	if !errors.Is(err, services.ErrInvalidLoginChallenge) {
+		t.Errorf("VerifyEmailLogin() after expiry error = %v, want ErrInvalidLoginChallenge", err)
+	}
+}
+
+func TestEmailLoginUnknownAddress(t *testing.T) {
+	service, mailer := setupPasswordless(t, passwordlessConfig())
+	ctx := context.Background()
+
+	response, err := service.RequestEmailLogin(ctx, &models.EmailLoginRequest{Email: "nobody@example.com"}, "")
+	if err != nil {
+		t.Fatalf("RequestEmailLogin() unexpected error: %v", err)
+	}
+	if response.ChallengeID == "" || response.Binding == "" {
+		t.Errorf("RequestEmailLogin() = %+v, want a response like for a known address", response)
+	}
+	if sent := mailer.take(); len(sent) != 0 {
+		t.Errorf("RequestEmailLogin() for an unknown address sent %d emails", len(sent))
+	}
+
+	var validationErr models.ValidationErrors
+	_, err = service.RequestEmailLogin(ctx, &models.EmailLoginRequest{Email: "test@example.com", Method: "sms"}, "")
+	if !errors.As(err, &validationErr) || validationErr["method"] == "" {
+		t.Errorf("RequestEmailLogin() with an unknown method error = %v, want a validation error", err)
+	}
+}
+
+func TestEmailLoginDisabled(t *testing.T) {
+	service, mailer := setupPasswordless(t, config.PasswordlessConfig{})
+	ctx := context.Background()
+
+	if _, err := service.RequestEmailLogin(ctx, &models.EmailLoginRequest{Email: "test@example.com"}, ""); !errors.Is(err, services.ErrPasswordlessDisabled) {
+		t.Errorf("RequestEmailLogin() error = %v, want ErrPasswordlessDisabled", err)
+	}
+	if _, err := service.VerifyEmailLogin(ctx, &models.VerifyEmailLoginRequest{Token: "a.b"}, ""); !errors.Is(err, services.ErrPasswordlessDisabled) {
+		t.Errorf("VerifyEmailLogin() error = %v, want ErrPasswordlessDisabled", err)
+	}
+	if sent := mailer.take(); len(sent) != 0 {
+		t.Errorf("sent %d emails while disabled", len(sent))
+	}
+}
+
+func TestEmailLoginPerTenant(t *testing.T) {
+	// Disabled outside the tenants, and enabled for acme only
+	cfg := passwordlessConfig()
+	cfg.Enabled = false
+	service, mailer := setupPasswordlessRepo(t, cfg, []config.TenantConfig{
+		{Name: "acme", Passwordless: true},
+		{Name: "globex"},
+	}, memory.NewRepository())
+
+	for _, tenant := range []string{"", "globex", "initech"} {
+		ctx := reqctx.WithInfo(context.Background(), reqctx.Info{Tenant: tenant})
+		if _, err := service.RequestEmailLogin(ctx, &models.EmailLoginRequest{Email: "test@example.com"}, ""); !errors.Is(err, services.ErrPasswordlessDisabled) {
+			t.Errorf("RequestEmailLogin() for tenant %q error = %v, want ErrPasswordlessDisabled", tenant, err)
+		}
+	}
+
+	acme := reqctx.WithInfo(context.Background(), reqctx.Info{Tenant: "acme"})
+	requested, err := service.RequestEmailLogin(acme, &models.EmailLoginRequest{Email: "test@example.com", Method: models.LoginMethodCode}, "")
+	if err != nil {
+		t.Fatalf("RequestEmailLogin() for acme unexpected error: %v", err)
+	}
+	code := sentSecret(t, mailer)
+
+	// The login can't be finished on a tenant where it is disabled
+	globex := reqctx.WithInfo(context.Background(), reqctx.Info{Tenant: "globex"})
+	verify := &models.VerifyEmailLoginRequest{ChallengeID: requested.ChallengeID, Code: code}
+	if _, err := service.VerifyEmailLogin(globex, verify, requested.Binding); !errors.Is(err, services.ErrPasswordlessDisabled) {
+		t.Errorf("VerifyEmailLogin() for globex error = %v, want ErrPasswordlessDisabled", err)
+	}
+	if _, err := service.VerifyEmailLogin(acme, verify, requested.Binding); err != nil {
+		t.Errorf("VerifyEmailLogin() for acme unexpected error: %v", err)
+	}
+}
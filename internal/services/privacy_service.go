@@ -0,0 +1,410 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export is not ready")
+	ErrAlreadyErased  = errors.New("user data already erased")
+)
+
+// exportTimeout bounds how long building one export bundle may take
+const exportTimeout = 5 * time.Minute
+
+// DataSection is one category of personal data held about a user. Every
+// store that keeps data tied to a user registers a section, so that exports
+// include it and erasure removes it.
+type DataSection struct {
+	Name string
+	// Export returns the section's contents for the user's bundle. Sections
+	// without exportable data leave it nil.
+	Export func(ctx context.Context, repo *repository.Repository, userID string) (interface{}, error)
+	// Erase removes the user's data inside the erasure transaction
+	Erase func(ctx context.Context, tx *repository.Repository, userID string) error
+	// Retain marks data that must be kept after erasure, such as the audit
+	// log. Retained sections are exported but left out of the tombstone.
+	Retain bool
+}
+
+// ExportBundle is the JSON form of a data export
+type ExportBundle struct {
+	UserID      string                 `json:"user_id"`
+	GeneratedAt time.Time              `json:"generated_at"`
+	Sections    map[string]interface{} `json:"sections"`
+}
+
+// PrivacyService implements data subject access requests (exports) and the
+// right to erasure
+type PrivacyService struct {
+	repo     *repository.Repository
+	config   config.PrivacyConfig
+	account  config.AccountConfig
+	logger   *logger.Logger
+	sections []DataSection
+	// exports tracks the exports being built in the background
+	exports sync.WaitGroup
+}
+
+func NewPrivacyService(repo *repository.Repository, cfg *config.Config, logger *logger.Logger) *PrivacyService {
+	return &PrivacyService{
+		repo:     repo,
+		config:   cfg.Privacy,
+		account:  cfg.Account,
+		logger:   logger,
+		sections: defaultSections(),
+	}
+}
+
+// defaultSections lists the personal data held by the service. The user row
+// itself is erased last by Erase, after every section.
+func defaultSections() []DataSection {
+	return []DataSection{
+		{
+			Name: "profile",
+			Export: func(ctx context.Context, repo *repository.Repository, userID string) (interface{}, error) {
+				user, err := repo.User.GetByID(ctx, userID)
+				if err != nil {
+					return nil, err
+				}
+				return user, nil
+			},
+		},
+		{
+			Name: "exports",
+			Export: func(ctx context.Context, repo *repository.Repository, userID string) (interface{}, error) {
+				return repo.Export.ListByUser(ctx, userID)
+			},
+			Erase: func(ctx context.Context, tx *repository.Repository, userID string) error {
+				return tx.Export.DeleteByUser(ctx, userID)
+			},
+		},
+		{
+			Name: "sessions",
+			Export: func(ctx context.Context, repo *repository.Repository, userID string) (interface{}, error) {
+				return repo.Session.ListByUser(ctx, userID)
+			},
+			Erase: func(ctx context.Context, tx *repository.Repository, userID string) error {
+				return tx.Session.DeleteByUser(ctx, userID)
+			},
+		},
+		{
+			// Pending passwordless logins hold nothing but hashes and expire
+			// within minutes, so they are not exported
+			Name: "login_challenges",
+			Erase: func(ctx context.Context, tx *repository.Repository, userID string) error {
+				return tx.LoginChallenge.DeleteByUser(ctx, userID)
+			},
+		},
+		{
+			Name: "audit",
+			Export: func(ctx context.Context, repo *repository.Repository, userID string) (interface{}, error) {
+				events := []*models.AuditEvent{}
+				filter := repository.AuditFilter{UserID: userID, Limit: repository.MaxPageSize}
+				for {
+					page, err := repo.Audit.List(ctx, filter)
+					if err != nil {
+						return nil, err
+					}
+					events = append(events, page.Events...)
+					if page.NextCursor == "" {
+						return events, nil
+					}
+					filter.Cursor = page.NextCursor
+				}
+			},
+			Retain: true,
+		},
+	}
+}
+
+// RequestExport starts building a bundle of the user's data in the
+// background. A request made while another export is pending returns that
+// export instead of starting a new one.
+func (s *PrivacyService) RequestExport(ctx context.Context, userID string, req *models.ExportRequest) (*models.DataExport, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.Export.ListByUser(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to list exports", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+	for _, export := range existing {
+		if export.Status == models.ExportStatusPending {
+			return export, nil
+		}
+	}
+
+	now := time.Now()
+	export := &models.DataExport{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Format:    req.Format,
+		Status:    models.ExportStatusPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.config.ExportTTL),
+	}
+	if err := s.repo.Export.Create(ctx, export); err != nil {
+		logger.FromContext(ctx).Error("failed to create export", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+
+	s.exports.Add(1)
+	go func() {
+		defer s.exports.Done()
+		s.buildExport(export)
+	}()
+
+	logger.FromContext(ctx).Info("data export requested", "user_id", userID, "export_id", export.ID, "format", export.Format)
+	return export, nil
+}
+
+// ListExports returns the user's exports, newest first
+func (s *PrivacyService) ListExports(ctx context.Context, userID string) ([]*models.DataExport, error) {
+	exports, err := s.repo.Export.ListByUser(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to list exports", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+	if exports == nil {
+		exports = []*models.DataExport{}
+	}
+	return exports, nil
+}
+
+// GetExport returns one of the user's exports, including its bundle once ready
+func (s *PrivacyService) GetExport(ctx context.Context, userID, exportID string) (*models.DataExport, error) {
+	export, err := s.repo.Export.GetByID(ctx, exportID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrExportNotFound
+		}
+		logger.FromContext(ctx).Error("failed to get export", "error", err, "export_id", exportID)
+		return nil, ErrInternal
+	}
+	// Other users' and expired exports are indistinguishable from missing ones
+	if export.UserID != userID || time.Now().After(export.ExpiresAt) {
+		return nil, ErrExportNotFound
+	}
+	return export, nil
+}
+
+// DownloadExport returns the bundle of a finished export
+func (s *PrivacyService) DownloadExport(ctx context.Context, userID, exportID string) (*models.DataExport, error) {
+	export, err := s.GetExport(ctx, userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export.Status != models.ExportStatusReady {
+		return nil, ErrExportNotReady
+	}
+	return export, nil
+}
+
+// Wait blocks until the exports already requested have been built
+func (s *PrivacyService) Wait() {
+	s.exports.Wait()
+}
+
+// CleanupExports removes expired export bundles
+func (s *PrivacyService) CleanupExports(ctx context.Context) (int, error) {
+	return s.repo.Export.DeleteExpired(ctx, time.Now())
+}
+
+func (s *PrivacyService) buildExport(export *models.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	data, err := s.bundle(ctx, export.UserID, export.Format)
+	now := time.Now()
+	export.CompletedAt = &now
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to build export", "error", err, "export_id", export.ID)
+		export.Status = models.ExportStatusFailed
+		export.Error = "failed to build export"
+	} else {
+		export.Status = models.ExportStatusReady
+		export.Data = data
+	}
+
+	if err := s.repo.Export.Update(ctx, export); err != nil {
+		logger.FromContext(ctx).Error("failed to save export", "error", err, "export_id", export.ID)
+		return
+	}
+	logger.FromContext(ctx).Info("data export completed", "export_id", export.ID, "status", export.Status, "size", len(export.Data))
+}
+
+// bundle collects every section and encodes them in the requested format
+func (s *PrivacyService) bundle(ctx context.Context, userID, format string) ([]byte, error) {
+	bundle := ExportBundle{
+		UserID:      userID,
+		GeneratedAt: time.Now().UTC(),
+		Sections:    make(map[string]interface{}),
+	}
+	var names []string
+	for _, section := range s.sections {
+		if section.Export == nil {
+			continue
+		}
+		data, err := section.Export(ctx, s.repo, userID)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Sections[section.Name] = data
+		names = append(names, section.Name)
+	}
+
+	if format == models.ExportFormatJSON {
+		return json.MarshalIndent(bundle, "", "  ")
+	}
+
+	// The ZIP holds a manifest plus one JSON file per section
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]interface{}{
+		"manifest.json": map[string]interface{}{
+			"user_id":      bundle.UserID,
+			"generated_at": bundle.GeneratedAt,
+			"sections":     names,
+		},
+	}
+	for name, data := range bundle.Sections {
+		files[name+".json"] = data
+	}
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Erase immediately erases all of the user's personal data and records a
+// tombstone. requestedBy is the ID of the administrator, or "system" for the
+// purge job.
+func (s *PrivacyService) Erase(ctx context.Context, userID, requestedBy, reason string) (*models.ErasureTombstone, error) {
+	var tombstone *models.ErasureTombstone
+	err := s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		user, err := tx.User.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		tombstone, err = s.erase(ctx, tx, user, requestedBy, reason)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			return nil, ErrUserNotFound
+		case errors.Is(err, ErrAlreadyErased):
+			return nil, ErrAlreadyErased
+		}
+		logger.FromContext(ctx).Error("failed to erase user", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+	return tombstone, nil
+}
+
+// GetTombstone returns the proof of erasure for a user
+func (s *PrivacyService) GetTombstone(ctx context.Context, userID string) (*models.ErasureTombstone, error) {
+	tombstone, err := s.repo.Tombstone.GetByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		logger.FromContext(ctx).Error("failed to get tombstone", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+	return tombstone, nil
+}
+
+// erase cascades through every section, then anonymizes or removes the user
+// row depending on the purge mode. It must run inside tx.
+func (s *PrivacyService) erase(ctx context.Context, tx *repository.Repository, user *models.User, requestedBy, reason string) (*models.ErasureTombstone, error) {
+	if user.PurgedAt != nil {
+		return nil, ErrAlreadyErased
+	}
+
+	now := time.Now()
+	tombstone := &models.ErasureTombstone{
+		ID:          uuid.New().String(),
+		UserID:      user.ID,
+		RequestedBy: requestedBy,
+		Reason:      reason,
+		ErasedAt:    now,
+	}
+	for _, section := range s.sections {
+		if section.Retain {
+			continue
+		}
+		if section.Erase != nil {
+			if err := section.Erase(ctx, tx, user.ID); err != nil {
+				return nil, err
+			}
+		}
+		tombstone.Sections = append(tombstone.Sections, section.Name)
+	}
+
+	if s.account.PurgeMode == config.PurgeModeRemove {
+		if err := tx.User.Delete(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	} else {
+		anonymize(user, now)
+		if err := tx.User.Update(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Tombstone.Create(ctx, tombstone); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, ErrAlreadyErased
+		}
+		return nil, err
+	}
+
+	logger.FromContext(ctx).Info("user data erased", "user_id", user.ID, "requested_by", requestedBy, "sections", tombstone.Sections)
+	return tombstone, nil
+}
+
+// anonymize erases the personal data kept on the user row, leaving only the
+// ID and timestamps
+func anonymize(user *models.User, now time.Time) {
+	if user.Status != models.StatusDeleted {
+		user.Status = models.StatusDeleted
+		user.DeletedAt = &now
+	}
+	user.Username = "deleted-" + user.ID
+	user.Email = user.ID + "@deleted.invalid"
+	user.Password = ""
+	user.Role = models.RoleUser
+	user.PasswordResetRequired = false
+	user.MFASecret = ""
+	user.MFAEnabledAt = nil
+	user.DeletionScheduledAt = nil
+	user.PurgedAt = &now
+}
@@ -0,0 +1,179 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"auth/internal/services"
+)
+
+func setupPrivacyService(t *testing.T) (*services.PrivacyService, *services.AuthService, *repository.Repository, *models.UserResponse) {
+	t.Helper()
+	cfg := &config.Config{
+		JWT:     config.JWTConfig{Secret: "test-secret", Expiration: time.Hour},
+		Account: config.AccountConfig{PurgeMode: config.PurgeModeAnonymize},
+		Privacy: config.PrivacyConfig{ExportTTL: time.Hour},
+	}
+	log := logger.New("error")
+	repo := memory.NewRepository()
+
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, nil, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, services.NewRiskService(repo, auditService, nil, cfg, log), notify.NewLog(log), cfg, log)
+	user, err := authService.SignUp(context.Background(), &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	return services.NewPrivacyService(repo, cfg, log), authService, repo, user
+}
+
+// waitForExport waits for the background export to finish and returns it
+func waitForExport(t *testing.T, privacyService *services.PrivacyService, userID, exportID string) *models.DataExport {
+	t.Helper()
+	privacyService.Wait()
+	export, err := privacyService.GetExport(context.Background(), userID, exportID)
+	if err != nil {
+		t.Fatalf("GetExport() unexpected error: %v", err)
+	}
+	if export.Status == models.ExportStatusPending {
+		t.Fatalf("export %s is still pending after Wait()", exportID)
+	}
+	return export
+}
+
+func TestPrivacyService_Export(t *testing.T) {
+	privacyService, _, _, user := setupPrivacyService(t)
+	ctx := context.Background()
+
+	t.Run("json", func(t *testing.T) {
+		export, err := privacyService.RequestExport(ctx, user.ID, &models.ExportRequest{Format: models.ExportFormatJSON})
+		if err != nil {
+			t.Fatalf("RequestExport() unexpected error: %v", err)
+		}
+		waitForExport(t, privacyService, user.ID, export.ID)
+
+		download, err := privacyService.DownloadExport(ctx, user.ID, export.ID)
+		if err != nil {
+			t.Fatalf("DownloadExport() unexpected error: %v", err)
+		}
+
+		var bundle struct {
+			UserID   string `json:"user_id"`
+			Sections map[string]json.RawMessage
+		}
+		if err := json.Unmarshal(download.Data, &bundle); err != nil {
+			t.Fatalf("bundle is not valid JSON: %v", err)
+		}
+		if bundle.UserID != user.ID || bundle.Sections["profile"] == nil {
+			t.Errorf("bundle = %s", download.Data)
+		}
+		if bytes.Contains(download.Data, []byte("$2a$")) {
+			t.Errorf("bundle contains the password hash")
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		export, err := privacyService.RequestExport(ctx, user.ID, &models.ExportRequest{})
+		if err != nil {
+			t.Fatalf("RequestExport() unexpected error: %v", err)
+		}
+		if export.Format != models.ExportFormatZIP {
+			t.Errorf("RequestExport() format = %s, want zip by default", export.Format)
+		}
+		waitForExport(t, privacyService, user.ID, export.ID)
+
+		download, err := privacyService.DownloadExport(ctx, user.ID, export.ID)
+		if err != nil {
+			t.Fatalf("DownloadExport() unexpected error: %v", err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(download.Data), int64(len(download.Data)))
+		if err != nil {
+			t.Fatalf("bundle is not a valid ZIP: %v", err)
+		}
+		files := make(map[string]bool)
+		for _, f := range zr.File {
+			files[f.Name] = true
+		}
+		if !files["manifest.json"] || !files["profile.json"] {
+			t.Errorf("ZIP files = %v, want manifest.json and profile.json", files)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := privacyService.RequestExport(ctx, user.ID, &models.ExportRequest{Format: "xml"})
+		var validationErr models.ValidationErrors
+		if !errors.As(err, &validationErr) {
+			t.Errorf("RequestExport() error = %v, want ValidationErrors", err)
+		}
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		exports, err := privacyService.ListExports(ctx, user.ID)
+		if err != nil || len(exports) == 0 {
+			t.Fatalf("ListExports() = %v, %v", exports, err)
+		}
+		if _, err := privacyService.GetExport(ctx, "someone-else", exports[0].ID); !errors.Is(err, services.ErrExportNotFound) {
+			t.Errorf("GetExport() by another user error = %v, want ErrExportNotFound", err)
+		}
+	})
+}
+
+func TestPrivacyService_Erase(t *testing.T) {
+	privacyService, authService, repo, user := setupPrivacyService(t)
+	ctx := context.Background()
+
+	export, err := privacyService.RequestExport(ctx, user.ID, &models.ExportRequest{})
+	if err != nil {
+		t.Fatalf("RequestExport() unexpected error: %v", err)
+	}
+	waitForExport(t, privacyService, user.ID, export.ID)
+
+	tombstone, err := privacyService.Erase(ctx, user.ID, "admin-id", "verified erasure request")
+	if err != nil {
+		t.Fatalf("Erase() unexpected error: %v", err)
+	}
+	if tombstone.UserID != user.ID || tombstone.RequestedBy != "admin-id" {
+		t.Errorf("Erase() tombstone = %+v", tombstone)
+	}
+
+	// The cascade removed the user's exports
+	if exports, _ := repo.Export.ListByUser(ctx, user.ID); len(exports) != 0 {
+		t.Errorf("exports remain after erasure: %d", len(exports))
+	}
+
+	erased, err := repo.User.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() unexpected error: %v", err)
+	}
+	if erased.Status != models.StatusDeleted || erased.Email == user.Email || erased.Username == user.Username {
+		t.Errorf("user was not anonymized: %+v", erased)
+	}
+
+	if _, err := authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "password123"}); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("Login() after erasure error = %v, want ErrInvalidCredentials", err)
+	}
+
+	if _, err := privacyService.Erase(ctx, user.ID, "admin-id", "again"); !errors.Is(err, services.ErrAlreadyErased) {
+		t.Errorf("Erase() twice error = %v, want ErrAlreadyErased", err)
+	}
+
+	if got, err := privacyService.GetTombstone(ctx, user.ID); err != nil || got.ID != tombstone.ID {
+		t.Errorf("GetTombstone() = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+)
+
+// purgeBatchSize is how many accounts PurgeOnce loads per query
+const purgeBatchSize = 100
+
+// PurgeService carries out scheduled account deletions. Accounts pending
+// deletion become deleted when their grace period ends, and deleted accounts
+// have their personal data erased once the retention period has passed.
+type PurgeService struct {
+	repo     *repository.Repository
+	privacy  *PrivacyService
+	sessions *SessionService
+	config   config.AccountConfig
+	logger   *logger.Logger
+}
+
+// PurgeResult counts the accounts processed by one purge run
+type PurgeResult struct {
+	Deleted int `json:"deleted"`
+	Purged  int `json:"purged"`
+	// Exports is the number of expired data exports removed
+	Exports int `json:"exports"`
+	// Sessions is the number of ended sessions removed from login history
+	Sessions int `json:"sessions"`
+	// LoginChallenges is the number of expired passwordless logins removed
+	LoginChallenges int `json:"login_challenges"`
+}
+
+func NewPurgeService(repo *repository.Repository, privacy *PrivacyService, sessions *SessionService, cfg config.AccountConfig, logger *logger.Logger) *PurgeService {
+	return &PurgeService{
+		repo:     repo,
+		privacy:  privacy,
+		sessions: sessions,
+		config:   cfg,
+		logger:   logger,
+	}
+}
+
+// Run purges on every PurgeInterval until ctx is cancelled
+func (s *PurgeService) Run(ctx context.Context) {
+	ctx = logger.NewContext(ctx, s.logger)
+	if s.config.PurgeInterval <= 0 {
+		logger.FromContext(ctx).Info("account purge job disabled")
+		return
+	}
+
+	ticker := time.NewTicker(s.config.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PurgeOnce(ctx); err != nil && ctx.Err() == nil {
+			logger.FromContext(ctx).Error("account purge failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce processes every account whose deletion or purge is due
+func (s *PurgeService) PurgeOnce(ctx context.Context) (PurgeResult, error) {
+	var result PurgeResult
+	now := time.Now()
+	deletedBefore := now.Add(-s.config.RetentionPeriod)
+
+	for {
+		users, err := s.repo.User.ListDeletionsDue(ctx, now, deletedBefore, purgeBatchSize)
+		if err != nil {
+			return result, err
+		}
+
+		processed := 0
+		for _, user := range users {
+			action, err := s.process(ctx, user.ID, now, deletedBefore)
+			if err != nil {
+				return result, err
+			}
+			switch action {
+			case actionDeleted:
+				result.Deleted++
+				processed++
+			case actionPurged:
+				result.Purged++
+				processed++
+			}
+		}
+
+		// Stop when nothing changed so skipped accounts can't loop forever
+		if len(users) < purgeBatchSize || processed == 0 {
+			break
+		}
+	}
+
+	exports, err := s.privacy.CleanupExports(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Exports = exports
+
+	sessions, err := s.sessions.CleanupSessions(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Sessions = sessions
+
+	challenges, err := s.repo.LoginChallenge.DeleteExpired(ctx, now)
+	if err != nil {
+		return result, err
+	}
+	result.LoginChallenges = challenges
+
+	if result.Deleted > 0 || result.Purged > 0 || result.Exports > 0 || result.Sessions > 0 || result.LoginChallenges > 0 {
+		logger.FromContext(ctx).Info("account purge completed",
+			"deleted", result.Deleted,
+			"purged", result.Purged,
+			"exports", result.Exports,
+			"sessions", result.Sessions,
+			"login_challenges", result.LoginChallenges,
+		)
+	}
+	return result, nil
+}
+
+// purgeAction is the step process applied to an account
+type purgeAction string
+
+const (
+	actionNone    purgeAction = ""
+	actionDeleted purgeAction = "deleted"
+	actionPurged  purgeAction = "purged"
+)
+
+// process re-reads the account in a transaction, since the user may have
+// cancelled the deletion since it was listed, and applies the step that is due
+func (s *PurgeService) process(ctx context.Context, userID string, now, deletedBefore time.Time) (purgeAction, error) {
+	var action purgeAction
+	err := s.repo.WithTx(ctx, func(tx *repository.Repository) error {
+		action = actionNone
+		user, err := tx.User.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case user.Status == models.StatusPendingDeletion && user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(now):
+			user.Status = models.StatusDeleted
+			user.DeletedAt = &now
+			user.DeletionScheduledAt = nil
+			action = actionDeleted
+			return tx.User.Update(ctx, user)
+
+		case user.Status == models.StatusDeleted && user.PurgedAt == nil && user.DeletedAt != nil && !user.DeletedAt.After(deletedBefore):
+			action = actionPurged
+			_, err := s.privacy.erase(ctx, tx, user, "system", "retention period expired")
+			return err
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		return actionNone, nil
+	}
+	if err != nil {
+		return actionNone, err
+	}
+
+	if action != actionNone {
+		logger.FromContext(ctx).Info("account deletion processed", "user_id", userID, "action", action)
+	}
+	return action, nil
+}
@@ -0,0 +1,109 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"auth/internal/repository/memory"
+	"auth/internal/services"
+)
+
+func TestPurgeService_PurgeOnce(t *testing.T) {
+	for _, mode := range []string{config.PurgeModeAnonymize, config.PurgeModeRemove} {
+		t.Run(mode, func(t *testing.T) {
+			repo := memory.NewRepository()
+			ctx := context.Background()
+			past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+			longAgo := time.Now().Add(-48 * time.Hour)
+
+			create := func(id, status string, scheduled, deleted *time.Time) {
+				user := &models.User{
+					ID:                  id,
+					Username:            id,
+					Email:               id + "@example.com",
+					Password:            "hashed-password",
+					Status:              status,
+					DeletionScheduledAt: scheduled,
+					DeletedAt:           deleted,
+				}
+				if err := repo.User.Create(ctx, user); err != nil {
+					t.Fatalf("Create(%s) unexpected error: %v", id, err)
+				}
+			}
+			create("active", models.StatusActive, nil, nil)
+			create("grace-over", models.StatusPendingDeletion, &past, nil)
+			create("grace-running", models.StatusPendingDeletion, &future, nil)
+			create("retention-over", models.StatusDeleted, nil, &longAgo)
+			create("retention-running", models.StatusDeleted, nil, &past)
+
+			cfg := &config.Config{Account: config.AccountConfig{
+				RetentionPeriod: 24 * time.Hour,
+				PurgeMode:       mode,
+			}}
+			log := logger.New("error")
+			sessions := services.NewSessionService(repo, services.NewAuditService(repo, cfg, log), nil, cfg, log)
+			purger := services.NewPurgeService(repo, services.NewPrivacyService(repo, cfg, log), sessions, cfg.Account, log)
+
+			result, err := purger.PurgeOnce(ctx)
+			if err != nil {
+				t.Fatalf("PurgeOnce() unexpected error: %v", err)
+			}
+			if result.Deleted != 1 || result.Purged != 1 {
+				t.Errorf("PurgeOnce() = %+v, want 1 deleted and 1 purged", result)
+			}
+
+			wantStatus := map[string]string{
+				"active":            models.StatusActive,
+				"grace-over":        models.StatusDeleted,
+				"grace-running":     models.StatusPendingDeletion,
+				"retention-running": models.StatusDeleted,
+			}
+			for id, want := range wantStatus {
+				user, err := repo.User.GetByID(ctx, id)
+				if err != nil {
+					t.Fatalf("GetByID(%s) unexpected error: %v", id, err)
+				}
+				if user.Status != want {
+					t.Errorf("%s status = %s, want %s", id, user.Status, want)
+				}
+			}
+
+			purged, err := repo.User.GetByID(ctx, "retention-over")
+			if mode == config.PurgeModeRemove {
+				if !errors.Is(err, repository.ErrNotFound) {
+					t.Errorf("GetByID() after remove error = %v, want ErrNotFound", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("GetByID() unexpected error: %v", err)
+				}
+				if purged.PurgedAt == nil || purged.Email == "retention-over@example.com" || purged.Password != "" {
+					t.Errorf("account was not anonymized: %+v", purged)
+				}
+			}
+
+			tombstone, err := repo.Tombstone.GetByUserID(ctx, "retention-over")
+			if err != nil {
+				t.Fatalf("GetByUserID() unexpected error: %v", err)
+			}
+			if tombstone.RequestedBy != "system" || len(tombstone.Sections) == 0 {
+				t.Errorf("tombstone = %+v", tombstone)
+			}
+
+			// Nothing is left to do on a second run
+			result, err = purger.PurgeOnce(ctx)
+			if err != nil {
+				t.Fatalf("PurgeOnce() unexpected error: %v", err)
+			}
+			if result.Deleted != 0 || result.Purged != 0 {
+				t.Errorf("second PurgeOnce() = %+v, want nothing", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"auth/internal/reqctx"
+	"auth/internal/risk"
+)
+
+// RiskService gathers what the risk engine needs to know about a login or
+// sensitive operation and turns its decision into the authentication the
+// user has to present
+type RiskService struct {
+	repo   *repository.Repository
+	audit  *AuditService
+	engine *risk.Engine
+	config config.RiskConfig
+	logger *logger.Logger
+}
+
+// NewRiskService creates the service. engine may be nil, in which case
+// everything is allowed.
+func NewRiskService(repo *repository.Repository, audit *AuditService, engine *risk.Engine, cfg *config.Config, logger *logger.Logger) *RiskService {
+	return &RiskService{
+		repo:   repo,
+		audit:  audit,
+		engine: engine,
+		config: cfg.Risk,
+		logger: logger,
+	}
+}
+
+// assessLogin scores a login that passed the password check. It must run
+// before the login itself is recorded.
+func (s *RiskService) assessLogin(ctx context.Context, userID string, signals LoginSignals) (risk.Assessment, error) {
+	return s.assess(ctx, userID, risk.Input{
+		Operation:        risk.OperationLogin,
+		NewDevice:        signals.NewDevice,
+		NewCountry:       signals.NewCountry,
+		ImpossibleTravel: signals.ImpossibleTravel,
+	})
+}
+
+func (s *RiskService) assess(ctx context.Context, userID string, in risk.Input) (risk.Assessment, error) {
+	if s.engine == nil {
+		return risk.Assessment{Decision: risk.Allow}, nil
+	}
+	in.IP = reqctx.FromContext(ctx).IP
+	in.Time = time.Now()
+	failed, err := s.failedAttempts(ctx, userID, in.Time)
+	if err != nil {
+		return risk.Assessment{}, err
+	}
+	in.FailedAttempts = failed
+	return s.engine.Assess(in), nil
+}
+
+// failedAttempts counts the failed logins to the account in the velocity
+// window, up to the velocity threshold
+func (s *RiskService) failedAttempts(ctx context.Context, userID string, now time.Time) (int, error) {
+	if s.config.VelocityThreshold <= 0 {
+		return 0, nil
+	}
+	return s.countFailedLogins(ctx, userID, now.Add(-s.config.VelocityWindow), s.config.VelocityThreshold)
+}
+
+// countFailedLogins counts the failed logins to the account since since, up
+// to limit. Accounts are shared by the tenants, so logins through any of
+// them count.
+func (s *RiskService) countFailedLogins(ctx context.Context, userID string, since time.Time, limit int) (int, error) {
+	page, err := s.repo.Audit.List(ctx, repository.AuditFilter{
+		ActorID: userID,
+		Action:  models.AuditActionLogin,
+		Outcome: models.AuditOutcomeFailure,
+		Since:   since,
+		Limit:   limit,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(page.Events), nil
+}
+
+// RequiredACR returns the authentication context class the user's token must
+// have for operation. An operation the risk policy refuses returns
+// risk.ErrDenied. Risky operations need a second factor from users who have
+// one enrolled.
+func (s *RiskService) RequiredACR(ctx context.Context, userID, operation string) (string, error) {
+	assessment, err := s.assess(ctx, userID, risk.Input{Operation: operation})
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to assess operation risk", "error", err, "user_id", userID, "operation", operation)
+		return "", ErrInternal
+	}
+
+	switch assessment.Decision {
+	case risk.Deny:
+		logger.FromContext(ctx).Warn("operation denied by risk policy", "user_id", userID, "operation", operation, "score", assessment.Score)
+		s.audit.Record(ctx, models.AuditEvent{
+			ActorID: userID,
+			Action:  models.AuditActionRiskDenied,
+			Details: riskDetails(map[string]string{"operation": operation}, assessment),
+		}, risk.ErrDenied)
+		return "", risk.ErrDenied
+	case risk.RequireMFA:
+		user, err := s.repo.User.GetByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return "", ErrUserNotFound
+			}
+			logger.FromContext(ctx).Error("failed to get user", "error", err, "user_id", userID)
+			return "", ErrInternal
+		}
+		if user.MFAEnabled() {
+			return auth.ACRMFA, nil
+		}
+	}
+	return auth.ACRPassword, nil
+}
+
+// riskDetails adds an assessment to the details of an audit event
+func riskDetails(details map[string]string, assessment risk.Assessment) map[string]string {
+	details["risk_score"] = strconv.Itoa(assessment.Score)
+	details["risk_decision"] = string(assessment.Decision)
+	if len(assessment.Reasons) > 0 {
+		details["risk_reasons"] = strings.Join(assessment.Reasons, ",")
+	}
+	return details
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/geoip"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/repository"
+	"auth/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionService keeps a record of every login so users can see where they
+// are signed in and revoke sessions they don't recognize
+type SessionService struct {
+	repo   *repository.Repository
+	audit  *AuditService
+	geoip  *geoip.DB
+	config config.SessionConfig
+	logger *logger.Logger
+}
+
+// SessionResponse is a session as shown to its user
+type SessionResponse struct {
+	*models.Session
+	// Current marks the session of the token making the request
+	Current bool `json:"current"`
+	Active  bool `json:"active"`
+}
+
+// ListSessionsResponse lists a user's sessions, newest first
+type ListSessionsResponse struct {
+	Sessions []*SessionResponse `json:"sessions"`
+}
+
+// RevokeSessionsResponse reports how many sessions were revoked
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// NewSessionService creates the service. geo may be nil, in which case
+// sessions have no location.
+func NewSessionService(repo *repository.Repository, audit *AuditService, geo *geoip.DB, cfg *config.Config, logger *logger.Logger) *SessionService {
+	return &SessionService{
+		repo:   repo,
+		audit:  audit,
+		geoip:  geo,
+		config: cfg.Session,
+		logger: logger,
+	}
+}
+
+// Create starts a session for the client making the request in ctx, which
+// presented the device cookie deviceID. The session ends at expiresAt, when
+// the token issued for it expires.
+func (s *SessionService) Create(ctx context.Context, userID, deviceID string, expiresAt time.Time) (*models.Session, error) {
+	info := reqctx.FromContext(ctx)
+	now := time.Now().UTC()
+	session := &models.Session{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		DeviceID:   deviceID,
+		Device:     describeDevice(info.UserAgent),
+		UserAgent:  info.UserAgent,
+		IP:         info.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt.UTC(),
+	}
+	if location, ok := s.geoip.Lookup(info.IP); ok {
+		session.Location = location.String()
+	}
+
+	if err := s.repo.Session.Create(ctx, session); err != nil {
+		logger.FromContext(ctx).Error("failed to create session", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+	return session, nil
+}
+
+// SessionActive reports whether the user's session is still active, and
+// records that it was used. Middleware calls it on every authenticated
+// request.
+func (s *SessionService) SessionActive(ctx context.Context, userID, sessionID string) (bool, error) {
+	session, err := s.repo.Session.GetByID(ctx, sessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if session.UserID != userID || !session.Active(now) {
+		return false, nil
+	}
+
+	// Writing on every request would turn reads into writes, so the last-seen
+	// time is only as precise as the touch interval
+	if now.Sub(session.LastSeenAt) >= s.config.TouchInterval {
+		if err := s.repo.Session.Touch(ctx, sessionID, now.UTC()); err != nil {
+			logger.FromContext(ctx).Warn("failed to update session last seen time", "error", err, "session_id", sessionID)
+		}
+	}
+	return true, nil
+}
+
+// List returns the user's sessions. Ended sessions, which make up the login
+// history, are only included when includeEnded is set.
+func (s *SessionService) List(ctx context.Context, userID, currentID string, includeEnded bool) (*ListSessionsResponse, error) {
+	sessions, err := s.repo.Session.ListByUser(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to list sessions", "error", err, "user_id", userID)
+		return nil, ErrInternal
+	}
+
+	now := time.Now()
+	response := &ListSessionsResponse{Sessions: []*SessionResponse{}}
+	for _, session := range sessions {
+		active := session.Active(now)
+		if !active && !includeEnded {
+			continue
+		}
+		response.Sessions = append(response.Sessions, &SessionResponse{
+			Session: session,
+			Current: session.ID == currentID,
+			Active:  active,
+		})
+	}
+	return response, nil
+}
+
+// Revoke ends one of the user's sessions. The token issued for it stops
+// working immediately.
+func (s *SessionService) Revoke(ctx context.Context, userID, sessionID string) error {
+	err := s.revoke(ctx, userID, sessionID)
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID:  userID,
+		Action:   models.AuditActionSessionRevoke,
+		TargetID: userID,
+		Details:  map[string]string{"session_id": sessionID},
+	}, err)
+	return err
+}
+
+func (s *SessionService) revoke(ctx context.Context, userID, sessionID string) error {
+	session, err := s.repo.Session.GetByID(ctx, sessionID)
+	if err == nil && session.UserID != userID {
+		// Other users' sessions look the same as sessions that don't exist
+		err = repository.ErrNotFound
+	}
+	if err == nil {
+		err = s.repo.Session.Revoke(ctx, sessionID, time.Now().UTC())
+	}
+
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to revoke session", "error", err, "session_id", sessionID)
+		return ErrInternal
+	}
+	logger.FromContext(ctx).Info("session revoked", "user_id", userID, "session_id", sessionID)
+	return nil
+}
+
+// RevokeOthers ends every session of the user except currentID
+func (s *SessionService) RevokeOthers(ctx context.Context, userID, currentID string) (*RevokeSessionsResponse, error) {
+	revoked, err := s.repo.Session.RevokeByUser(ctx, userID, currentID, time.Now().UTC())
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to revoke sessions", "error", err, "user_id", userID)
+		err = ErrInternal
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		ActorID:  userID,
+		Action:   models.AuditActionSessionRevokeOthers,
+		TargetID: userID,
+		Details:  map[string]string{"session_id": currentID},
+	}, err)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.FromContext(ctx).Info("other sessions revoked", "user_id", userID, "revoked", revoked)
+	return &RevokeSessionsResponse{Revoked: revoked}, nil
+}
+
+// CleanupSessions removes sessions that ended longer ago than the history
+// retention period and returns how many were removed
+func (s *SessionService) CleanupSessions(ctx context.Context) (int, error) {
+	return s.repo.Session.DeleteEnded(ctx, time.Now().Add(-s.config.HistoryRetention))
+}
+
+// Browsers and operating systems recognized in user agents. Order matters:
+// Edge and Opera user agents also mention Chrome and Safari, and mobile user
+// agents also mention the desktop systems they derive from.
+var (
+	browsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	systems = []struct{ token, name string }{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// describeDevice summarizes a user agent as "Browser on System"
+func describeDevice(userAgent string) string {
+	var browser, system string
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return "Unknown device"
+}
@@ -0,0 +1,171 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/internal/auth"
+	"auth/internal/config"
+	"auth/internal/geoip"
+	"auth/internal/logger"
+	"auth/internal/models"
+	"auth/internal/notify"
+	"auth/internal/repository/memory"
+	"auth/internal/reqctx"
+	"auth/internal/services"
+)
+
+func setupSessionService(t *testing.T) (*services.SessionService, *services.AuthService, *models.UserResponse) {
+	t.Helper()
+	cfg := &config.Config{
+		JWT:     config.JWTConfig{Secret: "test-secret", Expiration: time.Hour},
+		Session: config.SessionConfig{TouchInterval: time.Minute, HistoryRetention: time.Hour},
+	}
+	log := logger.New("error")
+	repo := memory.NewRepository()
+
+	geo, err := geoip.Parse(strings.NewReader("192.0.2.0/24,Germany,Berlin,Berlin\n"))
+	if err != nil {
+		t.Fatalf("geoip.Parse() unexpected error: %v", err)
+	}
+
+	auditService := services.NewAuditService(repo, cfg, log)
+	sessionService := services.NewSessionService(repo, auditService, geo, cfg, log)
+	authService := services.NewAuthService(repo, auditService, sessionService, services.NewRiskService(repo, auditService, nil, cfg, log), notify.NewLog(log), cfg, log)
+	user, err := authService.SignUp(context.Background(), &models.SignUpRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("SignUp() unexpected error: %v", err)
+	}
+	return sessionService, authService, user
+}
+
+// login signs in from the given client and returns the token's session ID
+func login(t *testing.T, authService *services.AuthService, ip, userAgent string) string {
+	t.Helper()
+	ctx := reqctx.WithInfo(context.Background(), reqctx.Info{IP: ip, UserAgent: userAgent})
+	response, err := authService.Login(ctx, &models.LoginRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	claims, err := auth.ValidateJWT(response.Token, "test-secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT() unexpected error: %v", err)
+	}
+	if claims.SessionID == "" {
+		t.Fatal("token has no session ID")
+	}
+	return claims.SessionID
+}
+
+func TestSessionService_Login(t *testing.T) {
+	sessionService, authService, user := setupSessionService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		ip, userAgent    string
+		device, location string
+	}{
+		{
+			"192.0.2.10",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			"Safari on macOS", "Berlin, Germany",
+		},
+		{
+			"198.51.100.1",
+			"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			"Chrome on Android", "",
+		},
+		{
+			"203.0.113.5",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+			"Edge on Windows", "",
+		},
+		{"203.0.113.6", "", "Unknown device", ""},
+	}
+	ids := make(map[string]int)
+	for i, tt := range tests {
+		ids[login(t, authService, tt.ip, tt.userAgent)] = i
+	}
+
+	response, err := sessionService.List(ctx, user.ID, "", false)
+	if err != nil {
+		t.Fatalf("List() unexpected error: %v", err)
+	}
+	if len(response.Sessions) != len(tests) {
+		t.Fatalf("List() returned %d sessions, want %d", len(response.Sessions), len(tests))
+	}
+	for _, session := range response.Sessions {
+		tt := tests[ids[session.ID]]
+		if session.Device != tt.device || session.Location != tt.location || session.IP != tt.ip || session.UserAgent != tt.userAgent {
+			t.Errorf("session = %+v, want device %q and location %q", session.Session, tt.device, tt.location)
+		}
+		if !session.Active || session.ExpiresAt.Before(time.Now().Add(59*time.Minute)) {
+			t.Errorf("session = %+v, want active for an hour", session.Session)
+		}
+	}
+}
+
+func TestSessionService_Revoke(t *testing.T) {
+	sessionService, authService, user := setupSessionService(t)
+	ctx := context.Background()
+
+	current := login(t, authService, "192.0.2.1", "current")
+	other := login(t, authService, "192.0.2.2", "other")
+	third := login(t, authService, "192.0.2.3", "third")
+
+	if err := sessionService.Revoke(ctx, "someone-else", other); !errors.Is(err, services.ErrSessionNotFound) {
+		t.Errorf("Revoke() of another user's session error = %v, want ErrSessionNotFound", err)
+	}
+	if err := sessionService.Revoke(ctx, user.ID, other); err != nil {
+		t.Fatalf("Revoke() unexpected error: %v", err)
+	}
+	if active, err := sessionService.SessionActive(ctx, user.ID, other); err != nil || active {
+		t.Errorf("SessionActive() after Revoke = %v, %v, want false", active, err)
+	}
+	if active, err := sessionService.SessionActive(ctx, user.ID, current); err != nil || !active {
+		t.Errorf("SessionActive() = %v, %v, want true", active, err)
+	}
+	if active, _ := sessionService.SessionActive(ctx, "someone-else", current); active {
+		t.Error("SessionActive() accepted another user's session")
+	}
+
+	revoked, err := sessionService.RevokeOthers(ctx, user.ID, current)
+	if err != nil {
+		t.Fatalf("RevokeOthers() unexpected error: %v", err)
+	}
+	if revoked.Revoked != 1 {
+		t.Errorf("RevokeOthers() revoked %d sessions, want 1", revoked.Revoked)
+	}
+	if active, _ := sessionService.SessionActive(ctx, user.ID, third); active {
+		t.Error("SessionActive() after RevokeOthers = true, want false")
+	}
+
+	response, err := sessionService.List(ctx, user.ID, current, false)
+	if err != nil {
+		t.Fatalf("List() unexpected error: %v", err)
+	}
+	if len(response.Sessions) != 1 || response.Sessions[0].ID != current || !response.Sessions[0].Current {
+		t.Errorf("List() = %+v, want only the current session", response.Sessions)
+	}
+	history, err := sessionService.List(ctx, user.ID, current, true)
+	if err != nil {
+		t.Fatalf("List() unexpected error: %v", err)
+	}
+	if len(history.Sessions) != 3 {
+		t.Errorf("List() with ended sessions returned %d, want 3", len(history.Sessions))
+	}
+
+	if err := authService.Logout(ctx, user.ID, current); err != nil {
+		t.Fatalf("Logout() unexpected error: %v", err)
+	}
+	if active, _ := sessionService.SessionActive(ctx, user.ID, current); active {
+		t.Error("SessionActive() after Logout = true, want false")
+	}
+}
@@ -0,0 +1,23 @@
+package services
+
+import (
+	"errors"
+
+	"auth/internal/observability/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("auth/internal/services")
+
+// endSpan ends the span of a service method that returned err. A login asking
+// for a second factor is flagged on the span rather than failing it.
+func endSpan(span trace.Span, err error) {
+	var mfaErr *MFARequiredError
+	if errors.As(err, &mfaErr) {
+		span.SetAttributes(attribute.Bool("auth.mfa_required", true))
+		err = nil
+	}
+	tracing.End(span, err)
+}
@@ -0,0 +1,62 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"auth/internal/models"
+	"auth/internal/observability/tracing"
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestAuthServiceSpans(t *testing.T) {
+	authService := setupAuthService()
+	ctx := context.Background()
+	if _, err := authService.SignUp(ctx, &models.SignUpRequest{
+		Username: "traced",
+		Email:    "traced@example.com",
+		Password: "password123",
+	}); err != nil {
+		t.Fatalf("SignUp() unexpected error: %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	tracing.Install(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)))
+
+	if _, err := authService.Login(ctx, &models.LoginRequest{Username: "traced", Password: "password123"}); err != nil {
+		t.Fatalf("Login() unexpected error: %v", err)
+	}
+	if _, err := authService.Login(ctx, &models.LoginRequest{Username: "traced", Password: "wrong-password"}); err == nil {
+		t.Fatal("Login() with a wrong password expected an error")
+	}
+
+	var logins, compares []tracesdk.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "AuthService.Login":
+			logins = append(logins, span)
+		case "bcrypt.compare":
+			compares = append(compares, span)
+		}
+	}
+	if len(logins) != 2 || len(compares) != 2 {
+		t.Fatalf("ended %d login and %d bcrypt spans, want 2 of each", len(logins), len(compares))
+	}
+	for i, login := range logins {
+		if compares[i].Parent().SpanID() != login.SpanContext().SpanID() {
+			t.Errorf("bcrypt span %d is not a child of its login span", i)
+		}
+		// A wrong password fails the login, not the comparison
+		if compares[i].Status().Code != codes.Unset {
+			t.Errorf("bcrypt span %d status = %v, want unset", i, compares[i].Status().Code)
+		}
+	}
+	if logins[0].Status().Code != codes.Unset {
+		t.Errorf("successful login span status = %v, want unset", logins[0].Status().Code)
+	}
+	if logins[1].Status().Code != codes.Error || logins[1].Status().Description != "invalid credentials" {
+		t.Errorf("failed login span status = %v %q, want an invalid credentials error", logins[1].Status().Code, logins[1].Status().Description)
+	}
+}